@@ -100,6 +100,27 @@ func GetDBPath() string {
 	return fmt.Sprintf("%s/%s.db", GetDBFolderPath(), GetName())
 }
 
+// dbDSN overrides the database DSN when set with SetDBDSN.
+var dbDSN string
+
+// SetDBDSN sets the database DSN for this process, taking precedence over XUI_DB_DSN.
+func SetDBDSN(dsn string) {
+	dbDSN = dsn
+}
+
+// GetDBDSN returns the database to use: the DSN set with SetDBDSN, the XUI_DB_DSN environment
+// variable, or else the SQLite file at GetDBPath. PostgreSQL and MySQL are used through
+// "postgres://" and "mysql://" URLs.
+func GetDBDSN() string {
+	if dbDSN != "" {
+		return dbDSN
+	}
+	if dsn := os.Getenv("XUI_DB_DSN"); dsn != "" {
+		return dsn
+	}
+	return GetDBPath()
+}
+
 // GetLogFolder returns the path to the log folder based on environment variables or platform defaults.
 func GetLogFolder() string {
 	logFolderPath := os.Getenv("XUI_LOG_FOLDER")
@@ -0,0 +1,54 @@
+// Package core defines the interface the panel uses to drive proxy cores.
+// Xray serves most protocols, sing-box serves the protocols Xray lacks.
+package core
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/singbox"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Core names
+const (
+	Xray    = "xray"
+	SingBox = "sing-box"
+)
+
+// Core is a proxy core process the panel runs inbounds on.
+type Core interface {
+	// Name returns the name of the core.
+	Name() string
+	// Start launches the core with its configuration.
+	Start() error
+	// Stop terminates the running core.
+	Stop() error
+	// IsRunning reports whether the core process is running.
+	IsRunning() bool
+	// GetErr returns the error the core exited with, if any.
+	GetErr() error
+	// GetResult returns the last log line or error of the core.
+	GetResult() string
+	// GetVersion returns the version of the core binary.
+	GetVersion() string
+	// GetUptime returns the uptime of the core in seconds.
+	GetUptime() uint64
+	// AddUser adds a user to a running inbound without a restart.
+	AddUser(protocol string, inboundTag string, user map[string]any) error
+	// RemoveUser removes a user from a running inbound without a restart.
+	RemoveUser(inboundTag string, email string) error
+	// GetTraffic returns the inbound, outbound and user traffic counted since the last reset.
+	GetTraffic(reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error)
+}
+
+var (
+	_ Core = (*xray.Process)(nil)
+	_ Core = (*singbox.Process)(nil)
+)
+
+// ForProtocol returns the name of the core that serves inbounds of the given protocol.
+func ForProtocol(protocol model.Protocol) string {
+	if singbox.IsSupportedProtocol(protocol) {
+		return SingBox
+	}
+	return Xray
+}
@@ -1,24 +1,17 @@
 // Package database provides database initialization, migration, and management utilities
-// for the 3x-ui panel using GORM with SQLite.
+// for the 3x-ui panel using GORM with SQLite, PostgreSQL or MySQL.
 package database
 
 import (
 	"bytes"
 	"io"
-	"io/fs"
 	"log"
-	"os"
-	"path"
-	"slices"
 
-	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 var db *gorm.DB
@@ -28,17 +21,40 @@ const (
 	defaultPassword = "admin"
 )
 
-func initModels() error {
-	models := []any{
+// models returns the models of all panel tables, parents before the tables referring to them.
+func models() []any {
+	return []any{
 		&model.User{},
 		&model.Inbound{},
+		&model.InboundClient{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
 		&model.InboundClientIps{},
+		&model.ClientIpHistory{},
+		&model.IpBan{},
+		&model.LoginAttempt{},
+		&model.NotifyChannel{},
+		&model.NotifyFailure{},
+		&model.AlertRule{},
+		&model.SignupPlan{},
+		&model.SignupRequest{},
+		&model.TgUser{},
+		&model.ClientWarning{},
+		&model.Voucher{},
+		&model.VoucherRedemption{},
+		&model.Broadcast{},
+		&model.Backup{},
+		&model.Node{},
+		&model.NodeTraffic{},
+		&model.OutboundSubscription{},
 		&xray.ClientTraffic{},
 		&model.HistoryOfSeeders{},
 	}
-	for _, model := range models {
+}
+
+// migrateModels creates the tables of all models and adds the columns and indexes they lack.
+func migrateModels(db *gorm.DB) error {
+	for _, model := range models() {
 		if err := db.AutoMigrate(model); err != nil {
 			log.Printf("Error auto migrating model: %v", err)
 			return err
@@ -71,46 +87,6 @@ func initUser() error {
 	return nil
 }
 
-// runSeeders migrates user passwords to bcrypt and records seeder execution to prevent re-running.
-func runSeeders(isUsersEmpty bool) error {
-	empty, err := isTableEmpty("history_of_seeders")
-	if err != nil {
-		log.Printf("Error checking if users table is empty: %v", err)
-		return err
-	}
-
-	if empty && isUsersEmpty {
-		hashSeeder := &model.HistoryOfSeeders{
-			SeederName: "UserPasswordHash",
-		}
-		return db.Create(hashSeeder).Error
-	} else {
-		var seedersHistory []string
-		db.Model(&model.HistoryOfSeeders{}).Pluck("seeder_name", &seedersHistory)
-
-		if !slices.Contains(seedersHistory, "UserPasswordHash") && !isUsersEmpty {
-			var users []model.User
-			db.Find(&users)
-
-			for _, user := range users {
-				hashedPassword, err := crypto.HashPasswordAsBcrypt(user.Password)
-				if err != nil {
-					log.Printf("Error hashing password for user '%s': %v", user.Username, err)
-					return err
-				}
-				db.Model(&user).Update("password", hashedPassword)
-			}
-
-			hashSeeder := &model.HistoryOfSeeders{
-				SeederName: "UserPasswordHash",
-			}
-			return db.Create(hashSeeder).Error
-		}
-	}
-
-	return nil
-}
-
 // isTableEmpty returns true if the named table contains zero rows.
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
@@ -118,43 +94,20 @@ func isTableEmpty(tableName string) (bool, error) {
 	return count == 0, err
 }
 
-// InitDB sets up the database connection, migrates models, and runs seeders.
-func InitDB(dbPath string) error {
-	dir := path.Dir(dbPath)
-	err := os.MkdirAll(dir, fs.ModePerm)
-	if err != nil {
-		return err
-	}
-
-	var gormLogger logger.Interface
-
-	if config.IsDebug() {
-		gormLogger = logger.Default
-	} else {
-		gormLogger = logger.Discard
-	}
-
-	c := &gorm.Config{
-		Logger: gormLogger,
-	}
-	db, err = gorm.Open(sqlite.Open(dbPath), c)
-	if err != nil {
-		return err
-	}
-
-	if err := initModels(); err != nil {
-		return err
-	}
-
-	isUsersEmpty, err := isTableEmpty("users")
+// InitDB sets up the database connection, applies the pending migrations and creates the
+// default user. The DSN is either a SQLite file path or a PostgreSQL or MySQL URL, see Open.
+// The current connection is kept if the database can't be used.
+func InitDB(dsn string) error {
+	conn, err := Open(dsn)
 	if err != nil {
 		return err
 	}
-
-	if err := initUser(); err != nil {
+	if err := migrate(conn); err != nil {
+		closeDB(conn)
 		return err
 	}
-	return runSeeders(isUsersEmpty)
+	db = conn
+	return initUser()
 }
 
 // CloseDB closes the database connection if it exists.
@@ -191,7 +144,11 @@ func IsSQLiteDB(file io.ReaderAt) (bool, error) {
 }
 
 // Checkpoint performs a WAL checkpoint on the SQLite database to ensure data consistency.
+// Other engines don't need it.
 func Checkpoint() error {
+	if !IsSQLite() {
+		return nil
+	}
 	// Update WAL
 	err := db.Exec("PRAGMA wal_checkpoint;").Error
 	if err != nil {
@@ -199,3 +156,15 @@ func Checkpoint() error {
 	}
 	return nil
 }
+
+// Snapshot writes a consistent copy of the database to a new SQLite file, after checkpointing
+// the WAL. Other engines are copied table by table into the file.
+func Snapshot(path string) error {
+	if !IsSQLite() {
+		return copyToSQLite(db, path)
+	}
+	if err := Checkpoint(); err != nil {
+		return err
+	}
+	return db.Exec("VACUUM INTO ?", path).Error
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Contents is what a database holds, to compare databases before one replaces another.
+type Contents struct {
+	Version  int               // Schema version, 0 for databases from before versioned migrations
+	Inbounds []string          // Tags of the inbounds
+	Clients  []string          // Emails of the clients
+	Settings map[string]string // Values of the settings by key
+}
+
+// requiredTables are the tables and columns of the first schema that the migrations build on.
+var requiredTables = []struct {
+	table   string
+	columns []string
+}{
+	{"users", []string{"id", "username", "password"}},
+	{"inbounds", []string{"id", "port", "protocol", "settings", "stream_settings", "tag"}},
+	{"settings", []string{"id", "key", "value"}},
+	{"client_traffics", []string{"id", "inbound_id", "email"}},
+}
+
+// Check opens the SQLite file at path read-only and makes sure it can replace the database: it
+// has to pass an integrity check, have the tables the migrations build on and not come from a
+// newer version. It returns the contents of the file.
+func Check(path string) (*Contents, error) {
+	// The file doesn't change while it is checked, so SQLite needn't look for a WAL or locks
+	uri := "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path) + "?mode=ro&immutable=1"
+	conn, err := gorm.Open(sqlite.Open(uri), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB(conn)
+
+	var problems []string
+	if err := conn.Raw("PRAGMA integrity_check").Scan(&problems).Error; err != nil {
+		return nil, fmt.Errorf("the integrity check failed: %w", err)
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return nil, fmt.Errorf("the database is damaged: %s", strings.Join(problems, "; "))
+	}
+	for _, required := range requiredTables {
+		if !conn.Migrator().HasTable(required.table) {
+			return nil, fmt.Errorf("the database has no %s table", required.table)
+		}
+		for _, column := range required.columns {
+			if !conn.Migrator().HasColumn(required.table, column) {
+				return nil, fmt.Errorf("the %s table has no %s column", required.table, column)
+			}
+		}
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(applied); err != nil {
+		return nil, err
+	}
+	return readContents(conn, applied)
+}
+
+// CurrentContents returns the contents of the open database.
+func CurrentContents() (*Contents, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	return readContents(db, applied)
+}
+
+// readContents reads the contents of a database as they are stored, so databases that have not
+// been migrated yet can be read too.
+func readContents(conn *gorm.DB, applied map[int]model.SchemaMigration) (*Contents, error) {
+	conn = conn.Session(&gorm.Session{SkipHooks: true})
+	contents := &Contents{Settings: map[string]string{}}
+	for version := range applied {
+		contents.Version = max(contents.Version, version)
+	}
+
+	var inbounds []*model.Inbound
+	if err := conn.Table("inbounds").Select("tag", "settings").Order("id").Find(&inbounds).Error; err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		contents.Inbounds = append(contents.Inbounds, inbound.Tag)
+		// Clients are in the settings until they get their own table
+		_, clients, _ := model.SplitClients(inbound.Settings)
+		for _, raw := range clients {
+			var client struct {
+				Email string `json:"email"`
+			}
+			if json.Unmarshal(raw, &client) == nil {
+				contents.Clients = append(contents.Clients, client.Email)
+			}
+		}
+	}
+	if conn.Migrator().HasTable("inbound_clients") {
+		var emails []string
+		if err := conn.Table("inbound_clients").Order("id").Pluck("email", &emails).Error; err != nil {
+			return nil, err
+		}
+		contents.Clients = append(contents.Clients, emails...)
+	}
+
+	var settings []*model.Setting
+	if err := conn.Table("settings").Select("key", "value").Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	for _, setting := range settings {
+		contents.Settings[setting.Key] = setting.Value
+	}
+	return contents, nil
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+
+	"gorm.io/gorm"
+)
+
+// migration is a numbered step that brings the database up to its version. Every step runs in
+// a transaction together with its record in the version table, so a failing step leaves the
+// database as it was. MySQL commits schema changes implicitly, there only data changes are
+// rolled back. Steps run without model hooks and see the rows as they are stored.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// MigrationState describes a migration and when it was applied to a database.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt int64 // Unix time, 0 while the migration is pending
+}
+
+// LatestVersion returns the schema version this binary migrates databases to.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// appliedMigrations returns the migrations recorded in the version table by version. Databases
+// from before versioned migrations have no version table and no migrations applied.
+func appliedMigrations(db *gorm.DB) (map[int]model.SchemaMigration, error) {
+	applied := map[int]model.SchemaMigration{}
+	if !db.Migrator().HasTable(&model.SchemaMigration{}) {
+		return applied, nil
+	}
+	var rows []model.SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// checkVersion refuses databases that were migrated by a newer binary.
+func checkVersion(applied map[int]model.SchemaMigration) error {
+	for version := range applied {
+		if version > LatestVersion() {
+			return fmt.Errorf("the database has schema version %d, but %s %s only knows up to version %d; update %s to use it",
+				version, config.GetName(), config.GetVersion(), LatestVersion(), config.GetName())
+		}
+	}
+	return nil
+}
+
+// migrate applies the pending migrations in order.
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&model.SchemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(applied); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx.Session(&gorm.Session{SkipHooks: true})); err != nil {
+				return err
+			}
+			return tx.Create(&model.SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().Unix(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// createSchema creates the tables of the current models and marks all migrations as applied,
+// for databases that are about to receive the data of a migrated database.
+func createSchema(db *gorm.DB) error {
+	if err := db.AutoMigrate(&model.SchemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(applied); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := migrateModels(tx); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := tx.Create(&model.SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().Unix(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrationStatus returns the schema version of the database at a DSN and the state of all
+// migrations, including ones only known to newer binaries, without changing the database.
+func MigrationStatus(dsn string) (int, []MigrationState, error) {
+	conn, err := Open(dsn)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer closeDB(conn)
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version := 0
+	var states []MigrationState
+	for _, m := range migrations {
+		states = append(states, MigrationState{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: applied[m.Version].AppliedAt,
+		})
+	}
+	for _, row := range applied {
+		version = max(version, row.Version)
+		if row.Version > LatestVersion() {
+			states = append(states, MigrationState{
+				Version:   row.Version,
+				Name:      row.Name,
+				AppliedAt: row.AppliedAt,
+			})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+	return version, states, nil
+}
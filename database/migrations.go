@@ -0,0 +1,364 @@
+package database
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// migrations are the steps of the database, in order. Released steps must not be changed or
+// renumbered; changes to the models need a new step. Steps see the models of this binary, not
+// of their version, so schema steps have to check the database before changing it.
+var migrations = []migration{
+	{Version: 1, Name: "initial_schema", Up: migrateModels},
+	{Version: 2, Name: "hash_user_passwords", Up: hashUserPasswords},
+	{Version: 3, Name: "telegram_notify_channel", Up: addTelegramNotifyChannel},
+	{Version: 4, Name: "cpu_alert_rule", Up: addCpuAlertRule},
+	{Version: 5, Name: "fix_inbound_clients", Up: fixInboundClients},
+	{Version: 6, Name: "multi_domain_to_external_proxy", Up: migrateExternalProxy},
+	{Version: 7, Name: "remove_orphaned_traffics", Up: removeOrphanedTraffics},
+	{Version: 8, Name: "inbound_clients_table", Up: moveClientsToTable},
+	{Version: 9, Name: "nodes", Up: addNodes},
+	{Version: 10, Name: "outbound_subscriptions", Up: addOutboundSubscriptions},
+	{Version: 11, Name: "unique_voucher_redemptions", Up: uniqueVoucherRedemptions},
+}
+
+// seeded reports whether a seeder ran on the database before versioned migrations replaced
+// them, so the migration taking its place has nothing left to do.
+func seeded(tx *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := tx.Model(&model.HistoryOfSeeders{}).Where("seeder_name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+// hashUserPasswords hashes the plain text passwords of databases from before bcrypt was used.
+func hashUserPasswords(tx *gorm.DB) error {
+	if done, err := seeded(tx, "UserPasswordHash"); err != nil || done {
+		return err
+	}
+	var users []model.User
+	if err := tx.Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		hashedPassword, err := crypto.HashPasswordAsBcrypt(user.Password)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTelegramNotifyChannel creates the Telegram notification channel, so alerts keep reaching
+// the bot admins until other channels are set up.
+func addTelegramNotifyChannel(tx *gorm.DB) error {
+	if done, err := seeded(tx, "NotifyTelegramChannel"); err != nil || done {
+		return err
+	}
+	return tx.Create(&model.NotifyChannel{
+		Name:   "Telegram",
+		Type:   "telegram",
+		Enable: true,
+	}).Error
+}
+
+// addCpuAlertRule turns the former CPU threshold setting into an alert rule.
+func addCpuAlertRule(tx *gorm.DB) error {
+	if done, err := seeded(tx, "AlertCpuRule"); err != nil || done {
+		return err
+	}
+	threshold := 80
+	setting := &model.Setting{}
+	if tx.Model(model.Setting{}).Where(map[string]any{"key": "tgCpu"}).First(setting).Error == nil {
+		if value, err := strconv.Atoi(setting.Value); err == nil {
+			threshold = value
+		}
+	}
+	rule := &model.AlertRule{
+		Name:           "CPU",
+		Enable:         threshold > 0,
+		Metric:         "cpu",
+		Operator:       ">",
+		Threshold:      float64(max(threshold, 1)),
+		Duration:       60,
+		Cooldown:       1800,
+		NotifyRecovery: true,
+	}
+	if err := tx.Create(rule).Error; err != nil {
+		return err
+	}
+	if err := tx.Where(map[string]any{"key": "tgCpu"}).Delete(model.Setting{}).Error; err != nil {
+		return err
+	}
+	// Channels that subscribed to CPU alerts now receive all alerts
+	var channels []*model.NotifyChannel
+	if err := tx.Where("events <> ''").Find(&channels).Error; err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		events := strings.Split(channel.Events, ",")
+		if i := slices.Index(events, "cpu"); i >= 0 {
+			events[i] = "alert"
+			if err := tx.Model(channel).Update("events", strings.Join(events, ",")).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fixInboundClients backfills all-time traffic, repairs clients written by older versions and
+// adds the missing traffic rows of clients.
+func fixInboundClients(tx *gorm.DB) error {
+	// Calculate and backfill all_time from up+down for inbounds and clients
+	for _, table := range []string{"inbounds", "client_traffics"} {
+		err := tx.Exec(`
+			UPDATE ` + table + `
+			SET all_time = COALESCE(up, 0) + COALESCE(down, 0)
+			WHERE COALESCE(all_time, 0) = 0 AND (COALESCE(up, 0) + COALESCE(down, 0)) > 0
+		`).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).Where("protocol IN (?)", []string{"vmess", "vless", "trojan"}).Find(&inbounds).Error
+	if err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		settings := map[string]any{}
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		clients, ok := settings["clients"].([]any)
+		if ok {
+			var newClients []any
+			for _, client := range clients {
+				c, ok := client.(map[string]any)
+				if !ok {
+					continue
+				}
+
+				// Add email='' if it is not exists
+				if _, ok := c["email"]; !ok {
+					c["email"] = ""
+				}
+
+				// Convert string tgId to int64
+				if tgIdStr, ok := c["tgId"].(string); ok {
+					tgIdInt64, err := strconv.ParseInt(strings.ReplaceAll(tgIdStr, " ", ""), 10, 64)
+					if err == nil {
+						c["tgId"] = tgIdInt64
+					}
+				}
+
+				// Remove "flow": "xtls-rprx-direct"
+				if c["flow"] == "xtls-rprx-direct" {
+					c["flow"] = ""
+				}
+
+				// Backfill created_at and updated_at
+				if _, ok := c["created_at"]; !ok {
+					c["created_at"] = time.Now().Unix() * 1000
+				}
+				c["updated_at"] = time.Now().Unix() * 1000
+				newClients = append(newClients, c)
+			}
+			settings["clients"] = newClients
+			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+			if err != nil {
+				return err
+			}
+			inbound.Settings = string(modifiedSettings)
+			if err := tx.Model(inbound).Update("settings", inbound.Settings).Error; err != nil {
+				return err
+			}
+		}
+
+		// Add client traffic row for all clients which has email
+		var parsed struct {
+			Clients []model.Client `json:"clients"`
+		}
+		json.Unmarshal([]byte(inbound.Settings), &parsed)
+		for _, client := range parsed.Clients {
+			if client.Email == "" {
+				continue
+			}
+			var count int64
+			if err := tx.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			err := tx.Create(&xray.ClientTraffic{
+				InboundId:  inbound.Id,
+				Email:      client.Email,
+				Total:      client.TotalGB,
+				ExpiryTime: client.ExpiryTime,
+				Enable:     client.Enable,
+				Reset:      client.Reset,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove orphaned traffics
+	return tx.Where("inbound_id = 0").Delete(xray.ClientTraffic{}).Error
+}
+
+// migrateExternalProxy moves the domains of the old MultiDomain TLS option to external proxies.
+func migrateExternalProxy(tx *gorm.DB) error {
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).
+		Where("protocol IN (?)", []string{"vmess", "vless", "trojan"}).
+		Where("stream_settings LIKE ?", "%domains%").
+		Find(&inbounds).Error
+	if err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		var stream map[string]any
+		json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		settings, _ := tlsSettings["settings"].(map[string]any)
+		if stream["security"] != "tls" || settings["domains"] == nil {
+			continue
+		}
+		if domains, ok := settings["domains"].([]any); ok {
+			for _, domain := range domains {
+				if domainMap, ok := domain.(map[string]any); ok {
+					domainMap["forceTls"] = "same"
+					domainMap["port"] = inbound.Port
+					domainMap["dest"], _ = domainMap["domain"].(string)
+					delete(domainMap, "domain")
+				}
+			}
+		}
+		stream["externalProxy"] = settings["domains"]
+		delete(settings, "domains")
+		newStream, _ := json.MarshalIndent(stream, " ", "  ")
+		if err := tx.Model(inbound).Update("stream_settings", string(newStream)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphanedTraffics deletes the traffic rows of clients that no inbound has anymore.
+func removeOrphanedTraffics(tx *gorm.DB) error {
+	var inbounds []*model.Inbound
+	if err := tx.Model(model.Inbound{}).Select("settings").Find(&inbounds).Error; err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, inbound := range inbounds {
+		var settings struct {
+			Clients []model.Client `json:"clients"`
+		}
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		for _, client := range settings.Clients {
+			known[client.Email] = true
+		}
+	}
+	var emails []string
+	if err := tx.Model(xray.ClientTraffic{}).Pluck("email", &emails).Error; err != nil {
+		return err
+	}
+	var orphaned []string
+	for _, email := range emails {
+		if !known[email] {
+			orphaned = append(orphaned, email)
+		}
+	}
+	if len(orphaned) == 0 {
+		return nil
+	}
+	return tx.Where("email IN ?", orphaned).Delete(xray.ClientTraffic{}).Error
+}
+
+// moveClientsToTable moves the clients of all inbounds from their settings into their own
+// table.
+func moveClientsToTable(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&model.InboundClient{}); err != nil {
+		return err
+	}
+	var inbounds []*model.Inbound
+	if err := tx.Find(&inbounds).Error; err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		settings, clients, ok := model.SplitClients(inbound.Settings)
+		if !ok {
+			continue
+		}
+		if err := model.SaveInboundClients(tx, inbound.Id, clients); err != nil {
+			return err
+		}
+		if err := tx.Model(inbound).Update("settings", settings).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNodes creates the tables of the nodes and the traffic they reported.
+func addNodes(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.Node{}, &model.NodeTraffic{})
+}
+
+// addOutboundSubscriptions creates the table of the outbound groups built from share links.
+func addOutboundSubscriptions(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.OutboundSubscription{})
+}
+
+// uniqueVoucherRedemptions merges the active redemptions a client got more than once for the
+// same voucher, so the index that prevents them can be created. The merged redemption keeps
+// everything they added, so revoking it takes all of it back.
+func uniqueVoucherRedemptions(tx *gorm.DB) error {
+	var redemptions []*model.VoucherRedemption
+	if err := tx.Where("revoked = ?", false).Order("id").Find(&redemptions).Error; err != nil {
+		return err
+	}
+	type key struct {
+		voucherId int
+		email     string
+	}
+	kept := map[key]*model.VoucherRedemption{}
+	for _, redemption := range redemptions {
+		first, ok := kept[key{redemption.VoucherId, redemption.Email}]
+		if !ok {
+			kept[key{redemption.VoucherId, redemption.Email}] = redemption
+			continue
+		}
+		err := tx.Model(first).Updates(map[string]any{
+			"expiry_added":  gorm.Expr("expiry_added + ?", redemption.ExpiryAdded),
+			"traffic_added": gorm.Expr("traffic_added + ?", redemption.TrafficAdded),
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(redemption).Error; err != nil {
+			return err
+		}
+		err = tx.Model(model.Voucher{}).Where("id = ? AND uses > 0", redemption.VoucherId).
+			Update("uses", gorm.Expr("uses - 1")).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.AutoMigrate(&model.VoucherRedemption{})
+}
@@ -0,0 +1,270 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// InboundClient stores a client of an inbound. Inbounds keep their clients in this table rather
+// than in their settings: saving an inbound moves the clients of its settings into rows, and
+// loading it puts them back, so the settings the rest of the panel sees are complete.
+type InboundClient struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"` // Also the order of the clients in the inbound
+	InboundId int    `json:"inboundId" gorm:"index"`
+	Email     string `json:"email" gorm:"size:191;index"`
+	SubId     string `json:"subId" gorm:"size:191;index"`
+	TgId      int64  `json:"tgId" gorm:"index"`
+	Enable    bool   `json:"enable"`
+	Settings  string `json:"settings"` // The client object of the inbound settings, compact JSON
+}
+
+// newInboundClient turns a client object of inbound settings into a row.
+func newInboundClient(inboundId int, raw json.RawMessage) (*InboundClient, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, err
+	}
+	var fields struct {
+		Email  string `json:"email"`
+		SubId  string `json:"subId"`
+		TgId   any    `json:"tgId"`
+		Enable bool   `json:"enable"`
+	}
+	if err := json.Unmarshal(compact.Bytes(), &fields); err != nil {
+		return nil, err
+	}
+	row := &InboundClient{
+		InboundId: inboundId,
+		Email:     fields.Email,
+		SubId:     fields.SubId,
+		Enable:    fields.Enable,
+		Settings:  compact.String(),
+	}
+	// Older versions stored the Telegram ID as a string
+	switch tgId := fields.TgId.(type) {
+	case float64:
+		row.TgId = int64(tgId)
+	case string:
+		row.TgId, _ = strconv.ParseInt(strings.ReplaceAll(tgId, " ", ""), 10, 64)
+	}
+	return row, nil
+}
+
+// SplitClients takes the clients out of inbound settings. The settings keep an empty "clients"
+// array to mark where the clients go back. ok is false for settings without a clients array.
+func SplitClients(settings string) (rest string, clients []json.RawMessage, ok bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(settings), &fields); err != nil {
+		return settings, nil, false
+	}
+	raw, found := fields["clients"]
+	if !found || json.Unmarshal(raw, &clients) != nil {
+		return settings, nil, false
+	}
+	fields["clients"] = json.RawMessage("[]")
+	restBytes, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return settings, nil, false
+	}
+	return string(restBytes), clients, true
+}
+
+// JoinClients puts client objects back into settings taken apart by SplitClients.
+func JoinClients(settings string, clients []string) string {
+	if len(clients) == 0 {
+		return settings
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(settings), &fields); err != nil {
+		return settings
+	}
+	if _, found := fields["clients"]; !found {
+		return settings
+	}
+	fields["clients"] = json.RawMessage("[" + strings.Join(clients, ",") + "]")
+	joined, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return settings
+	}
+	return string(joined)
+}
+
+// BeforeSave takes the clients out of the settings, AfterSave stores them.
+func (i *Inbound) BeforeSave(tx *gorm.DB) error {
+	rest, clients, ok := SplitClients(i.Settings)
+	i.clientsSplit = ok
+	if !ok {
+		return nil
+	}
+	i.fullSettings = i.Settings
+	i.splitClients = clients
+	i.Settings = rest
+	return nil
+}
+
+// AfterSave stores the clients taken out of the settings and gives the inbound back its
+// complete settings.
+func (i *Inbound) AfterSave(tx *gorm.DB) error {
+	if !i.clientsSplit {
+		return nil
+	}
+	i.clientsSplit = false
+	i.Settings = i.fullSettings
+	return SaveInboundClients(tx, i.Id, i.splitClients)
+}
+
+// skipClientsKey marks the queries of FindInbounds, whose clients are loaded for all inbounds at once.
+const skipClientsKey = "inbound:skip_clients"
+
+// AfterFind puts the clients of the inbound back into its settings.
+func (i *Inbound) AfterFind(tx *gorm.DB) error {
+	if i.Id == 0 || !strings.Contains(i.Settings, `"clients"`) {
+		return nil
+	}
+	if skip, _ := tx.Get(skipClientsKey); skip == true {
+		return nil
+	}
+	var clients []string
+	err := tx.Session(&gorm.Session{NewDB: true}).
+		Model(&InboundClient{}).
+		Where("inbound_id = ?", i.Id).
+		Order("id").
+		Pluck("settings", &clients).Error
+	if err != nil {
+		return err
+	}
+	i.Settings = JoinClients(i.Settings, clients)
+	return nil
+}
+
+// FindInbounds runs a query of inbounds and puts the clients of all of them back into their
+// settings with one query, rather than the query per inbound AfterFind makes.
+func FindInbounds(query *gorm.DB, inbounds *[]*Inbound) error {
+	if err := query.Set(skipClientsKey, true).Find(inbounds).Error; err != nil {
+		return err
+	}
+	return LoadClients(query.Session(&gorm.Session{NewDB: true}), *inbounds)
+}
+
+// LoadClients puts the clients of the inbounds back into their settings. The client rows are
+// queried in chunks, to stay within the parameter limits of the databases.
+func LoadClients(tx *gorm.DB, inbounds []*Inbound) error {
+	var ids []int
+	for _, inbound := range inbounds {
+		if inbound.Id != 0 && strings.Contains(inbound.Settings, `"clients"`) {
+			ids = append(ids, inbound.Id)
+		}
+	}
+	clients := make(map[int][]string, len(ids))
+	for chunk := range slices.Chunk(ids, 500) {
+		var rows []*InboundClient
+		err := tx.Model(&InboundClient{}).
+			Select("inbound_id", "settings").
+			Where("inbound_id IN ?", chunk).
+			Order("id").
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			clients[row.InboundId] = append(clients[row.InboundId], row.Settings)
+		}
+	}
+	for _, inbound := range inbounds {
+		if len(clients[inbound.Id]) > 0 {
+			inbound.Settings = JoinClients(inbound.Settings, clients[inbound.Id])
+		}
+	}
+	return nil
+}
+
+// SaveInboundClients makes the client rows of an inbound match its clients. The rows of
+// unchanged clients are kept and those of changed clients updated in place, so the rows only
+// get rewritten when the order of the clients changes.
+func SaveInboundClients(tx *gorm.DB, inboundId int, clients []json.RawMessage) error {
+	db := tx.Session(&gorm.Session{NewDB: true})
+	var existing []*InboundClient
+	if err := db.Where("inbound_id = ?", inboundId).Order("id").Find(&existing).Error; err != nil {
+		return err
+	}
+	rows := make([]*InboundClient, len(clients))
+	for i, raw := range clients {
+		row, err := newInboundClient(inboundId, raw)
+		if err != nil {
+			return err
+		}
+		rows[i] = row
+	}
+
+	// Keep the rows of unchanged clients
+	unchanged := map[string][]*InboundClient{}
+	for _, row := range existing {
+		unchanged[row.Settings] = append(unchanged[row.Settings], row)
+	}
+	kept := map[int]bool{}
+	for _, row := range rows {
+		if same := unchanged[row.Settings]; len(same) > 0 {
+			row.Id = same[0].Id
+			unchanged[row.Settings] = same[1:]
+			kept[row.Id] = true
+		}
+	}
+	// Changed clients take over the remaining rows in order
+	var free []*InboundClient
+	for _, row := range existing {
+		if !kept[row.Id] {
+			free = append(free, row)
+		}
+	}
+	var changed []*InboundClient
+	for _, row := range rows {
+		if row.Id == 0 && len(free) > 0 {
+			row.Id = free[0].Id
+			free = free[1:]
+			changed = append(changed, row)
+		}
+	}
+	// The row IDs give the order of the clients, new rows come last
+	ordered := true
+	for i := 1; i < len(rows); i++ {
+		if rows[i].Id != 0 && (rows[i-1].Id == 0 || rows[i].Id < rows[i-1].Id) {
+			ordered = false
+			break
+		}
+	}
+	if !ordered {
+		if err := db.Where("inbound_id = ?", inboundId).Delete(&InboundClient{}).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			row.Id = 0
+		}
+		changed, free = nil, nil
+	}
+
+	for _, row := range free {
+		if err := db.Delete(row).Error; err != nil {
+			return err
+		}
+	}
+	for _, row := range changed {
+		if err := db.Save(row).Error; err != nil {
+			return err
+		}
+	}
+	var added []*InboundClient
+	for _, row := range rows {
+		if row.Id == 0 {
+			added = append(added, row)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(added, 500).Error
+}
@@ -2,6 +2,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
@@ -21,6 +22,10 @@ const (
 	Shadowsocks Protocol = "shadowsocks"
 	Mixed       Protocol = "mixed"
 	WireGuard   Protocol = "wireguard"
+
+	// Protocols served by the sing-box core
+	Hysteria2 Protocol = "hysteria2"
+	TUIC      Protocol = "tuic"
 )
 
 // User represents a user account in the 3x-ui panel.
@@ -53,6 +58,11 @@ type Inbound struct {
 	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
+
+	// Clients taken out of the settings while saving, see InboundClient
+	clientsSplit bool
+	fullSettings string
+	splitClients []json.RawMessage
 }
 
 // OutboundTraffics tracks traffic statistics for Xray outbound connections.
@@ -62,6 +72,13 @@ type OutboundTraffics struct {
 	Up    int64  `json:"up" form:"up" gorm:"default:0"`
 	Down  int64  `json:"down" form:"down" gorm:"default:0"`
 	Total int64  `json:"total" form:"total" gorm:"default:0"`
+
+	// Quota enforcement
+	Quota                int64  `json:"quota" form:"quota" gorm:"default:0"`                               // Traffic quota in bytes (0 = unlimited)
+	FallbackTag          string `json:"fallbackTag" form:"fallbackTag"`                                    // Outbound that takes over the rules once the quota is exceeded (empty = blackhole)
+	TrafficReset         string `json:"trafficReset" form:"trafficReset" gorm:"default:never"`             // Traffic reset schedule
+	LastTrafficResetTime int64  `json:"lastTrafficResetTime" form:"lastTrafficResetTime" gorm:"default:0"` // Last traffic reset timestamp
+	Exceeded             bool   `json:"exceeded" form:"exceeded" gorm:"default:false"`                     // Whether the rules are currently rerouted
 }
 
 // InboundClientIps stores IP addresses associated with inbound clients for access control.
@@ -71,12 +88,243 @@ type InboundClientIps struct {
 	Ips         string `json:"ips" form:"ips"`
 }
 
-// HistoryOfSeeders tracks which database seeders have been executed to prevent re-running.
+// ClientIpHistory records when a client was first and last seen connecting from an IP address.
+type ClientIpHistory struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientEmail string `json:"clientEmail" gorm:"size:191;uniqueIndex:idx_client_ip"`
+	Ip          string `json:"ip" gorm:"size:191;uniqueIndex:idx_client_ip"`
+	FirstSeen   int64  `json:"firstSeen"`
+	LastSeen    int64  `json:"lastSeen" gorm:"index"`
+
+	// Resolved from the local GeoIP databases when queried
+	Country string `json:"country,omitempty" gorm:"-"`
+	ASN     uint   `json:"asn,omitempty" gorm:"-"`
+	ASOrg   string `json:"asOrg,omitempty" gorm:"-"`
+}
+
+// IpBan represents a temporary ban of a client that exceeded its IP limit.
+// Depending on the mode, either the client is removed from Xray or the surplus IP is blocked by the firewall.
+type IpBan struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientEmail string `json:"clientEmail" gorm:"index"`
+	InboundId   int    `json:"inboundId"`
+	Ip          string `json:"ip"`
+	Mode        string `json:"mode"`
+	CreatedAt   int64  `json:"createdAt"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+// LoginAttempt tracks the failed panel logins of an IP address or a username.
+// Once too many attempts fail, the IP or username is locked out for a growing duration.
+type LoginAttempt struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Kind        string `json:"kind" gorm:"size:191;uniqueIndex:idx_login_attempt"` // "ip" or "username"
+	Value       string `json:"value" gorm:"size:191;uniqueIndex:idx_login_attempt"`
+	Failures    int    `json:"failures"`
+	Lockouts    int    `json:"lockouts"`
+	LastFailure int64  `json:"lastFailure"`
+	LockedUntil int64  `json:"lockedUntil"`
+}
+
+// NotifyChannel is a destination for panel notifications, such as the Telegram bot, an email address or a webhook.
+type NotifyChannel struct {
+	Id     int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name   string `json:"name" form:"name"`
+	Type   string `json:"type" form:"type"` // telegram, smtp, webhook, discord, slack or matrix
+	Enable bool   `json:"enable" form:"enable"`
+	Events string `json:"events" form:"events"` // Comma separated event types routed to the channel, empty for all
+	Config string `json:"config" form:"config"` // JSON settings of the channel type
+}
+
+// NotifyFailure records a notification that could not be delivered to a channel.
+type NotifyFailure struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ChannelId int    `json:"channelId" gorm:"index"`
+	Channel   string `json:"channel"`
+	Event     string `json:"event"`
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"createdAt" gorm:"index"`
+}
+
+// AlertRule raises a notification when a server or traffic metric stays beyond a threshold for a while.
+type AlertRule struct {
+	Id             int     `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name           string  `json:"name" form:"name"`
+	Enable         bool    `json:"enable" form:"enable"`
+	Metric         string  `json:"metric" form:"metric"`
+	Target         string  `json:"target" form:"target"`       // Inbound or outbound tag of traffic metrics, empty for all
+	Operator       string  `json:"operator" form:"operator"`   // ">" or "<"
+	Threshold      float64 `json:"threshold" form:"threshold"` // In the unit of the metric
+	Duration       int     `json:"duration" form:"duration"`   // Seconds the condition must hold before the rule fires
+	Cooldown       int     `json:"cooldown" form:"cooldown"`   // Seconds between repeated notifications while firing, 0 to notify once
+	NotifyRecovery bool    `json:"notifyRecovery" form:"notifyRecovery"`
+
+	// State kept by the alert job
+	Firing       bool    `json:"firing"`
+	PendingSince int64   `json:"pendingSince"`
+	LastNotified int64   `json:"lastNotified"`
+	LastValue    float64 `json:"lastValue"`
+}
+
+// SignupPlan is an offer Telegram users can pick when they request an account through the bot.
+type SignupPlan struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name"`
+	Enable     bool   `json:"enable" form:"enable"`
+	InboundId  int    `json:"inboundId" form:"inboundId"`   // Inbound the client is created in
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Traffic limit in bytes, 0 for unlimited
+	ExpiryDays int    `json:"expiryDays" form:"expiryDays"` // Days the client is valid after approval, 0 for unlimited
+	LimitIP    int    `json:"limitIp" form:"limitIp"`
+}
+
+// SignupRequest is an account request of a Telegram user waiting for or decided by an admin.
+type SignupRequest struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TgId      int64  `json:"tgId" gorm:"index"`
+	Username  string `json:"username"`
+	FirstName string `json:"firstName"`
+	PlanId    int    `json:"planId"`
+	Plan      string `json:"plan"`
+	Status    string `json:"status"` // "pending", "approved" or "denied"
+	Email     string `json:"email"`  // Email of the created client
+	CreatedAt int64  `json:"createdAt"`
+	DecidedAt int64  `json:"decidedAt"`
+}
+
+// TgUser holds the preferences of a Telegram user talking to the bot.
+type TgUser struct {
+	Id   int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TgId int64  `json:"tgId" gorm:"uniqueIndex"`
+	Lang string `json:"lang"` // Empty follows the bot language setting
+}
+
+// ClientWarning records an expiry or quota warning sent to a client, so each threshold is
+// only warned about once. It is removed when the client is no longer past the threshold.
+type ClientWarning struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" gorm:"size:191;uniqueIndex:idx_client_warning"`
+	Kind      string `json:"kind" gorm:"size:191;uniqueIndex:idx_client_warning"` // "expiry" or "traffic"
+	Threshold int    `json:"threshold" gorm:"uniqueIndex:idx_client_warning"`     // Days left or percent of the quota used
+	SentAt    int64  `json:"sentAt"`
+}
+
+// Voucher is a redeem code that extends a client's expiry and/or traffic limit.
+type Voucher struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Code      string `json:"code" gorm:"size:191;uniqueIndex"`
+	Batch     string `json:"batch" gorm:"index"`
+	Enable    bool   `json:"enable"`
+	Days      int    `json:"days"`      // Days added to the client's expiry
+	Traffic   int64  `json:"traffic"`   // Bytes added to the client's traffic limit
+	MaxUses   int    `json:"maxUses"`   // Number of redemptions allowed, 0 for unlimited
+	Uses      int    `json:"uses"`      // Number of active redemptions
+	ExpiresAt int64  `json:"expiresAt"` // Time after which the code can't be redeemed, 0 for never
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// VoucherRedemption logs a voucher redeemed for a client, with the changes applied to it. A
+// client has at most one active redemption of a voucher: active ones share a RevokedAt of 0.
+type VoucherRedemption struct {
+	Id           int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	VoucherId    int    `json:"voucherId" gorm:"index;uniqueIndex:idx_voucher_redemption"`
+	Code         string `json:"code"`
+	Email        string `json:"email" gorm:"size:191;index;uniqueIndex:idx_voucher_redemption"`
+	Source       string `json:"source"`       // Where the code was redeemed: "tgbot", "sub" or "panel"
+	ExpiryAdded  int64  `json:"expiryAdded"`  // Milliseconds the expiry moved
+	TrafficAdded int64  `json:"trafficAdded"` // Bytes added to the traffic limit
+	RedeemedAt   int64  `json:"redeemedAt"`
+	Revoked      bool   `json:"revoked"`
+	RevokedAt    int64  `json:"revokedAt" gorm:"uniqueIndex:idx_voucher_redemption"`
+}
+
+// Broadcast is a message sent by the bot to the Telegram users of all or filtered clients.
+type Broadcast struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Text       string `json:"text"`
+	Filter     string `json:"filter"` // JSON encoded filter of the recipients
+	Source     string `json:"source"` // Where the broadcast was sent from: "panel" or "tgbot"
+	Status     string `json:"status"` // "queued", "sending", "done" or "interrupted"
+	Total      int    `json:"total"`
+	Delivered  int    `json:"delivered"`
+	Failed     int    `json:"failed"`
+	Blocked    int    `json:"blocked"` // Users that blocked the bot or deleted their account
+	CreatedAt  int64  `json:"createdAt"`
+	FinishedAt int64  `json:"finishedAt"`
+	ReportChat int64  `json:"-"` // Chat the outcome is reported to, 0 for none
+}
+
+// Backup records a database backup written to a target, or the failure to write it.
+type Backup struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" gorm:"index"` // File name of the backup
+	Target    string `json:"target"`            // "local" or "s3"
+	Trigger   string `json:"trigger"`           // "schedule" or "manual"
+	Status    string `json:"status"`            // "success", "failed" or "pruned" once removed by the retention
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum"` // SHA-256 of the file, hex encoded
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Node is a server running x-ui as a node, which the panel pushes inbounds to and collects
+// traffic and status from.
+type Node struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name"`
+	Address    string `json:"address" form:"address"`       // URL of the node API, such as https://node.example.com:2054
+	Token      string `json:"token" form:"token"`           // Token the node API authenticates the panel with
+	CertSha256 string `json:"certSha256" form:"certSha256"` // Pinned SHA-256 of the node certificate, empty to verify it against the system CAs
+	Host       string `json:"host" form:"host"`             // Address clients connect to, empty to leave the node out of subscriptions
+	Enable     bool   `json:"enable" form:"enable"`
+	InboundIds string `json:"inboundIds" form:"inboundIds"` // Comma separated IDs of the inbounds the node serves
+	LastSeen   int64  `json:"lastSeen"`                     // Unix time in milliseconds of the last successful sync
+	Status     string `json:"status"`                       // JSON status the node reported last
+	Error      string `json:"error"`                        // Error of the last sync, empty if it succeeded
+}
+
+// NodeTraffic is the traffic counter of an inbound or client that a node reported last, to add
+// only what the node counted since then.
+type NodeTraffic struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId    int    `json:"nodeId" gorm:"uniqueIndex:idx_node_traffic"`
+	IsInbound bool   `json:"isInbound" gorm:"uniqueIndex:idx_node_traffic"`
+	Name      string `json:"name" gorm:"size:191;uniqueIndex:idx_node_traffic"` // Tag of the inbound or email of the client
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+}
+
+// OutboundSubscription is a group of outbounds built from share links, either pasted or fetched
+// from a remote subscription that is refreshed on a schedule. The tags of its outbounds start with
+// its prefix, and the group can be routed to as a balancer tagged with the prefix.
+type OutboundSubscription struct {
+	Id              int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name            string `json:"name" form:"name"`
+	Prefix          string `json:"prefix" form:"prefix" gorm:"unique"` // Tags of the outbounds are the prefix, a dash and a hash of the server
+	Url             string `json:"url" form:"url"`                     // Remote subscription, empty for a group of pasted links
+	Links           string `json:"links" form:"links"`                 // Share links of a group without URL, one per line
+	Enable          bool   `json:"enable" form:"enable"`
+	RefreshInterval int    `json:"refreshInterval" form:"refreshInterval"` // Minutes between refreshes of the remote subscription, 0 to refresh by hand
+	Balancer        bool   `json:"balancer" form:"balancer"`               // Add a balancer over the outbounds
+	Strategy        string `json:"strategy" form:"strategy"`               // Strategy of the balancer: random, roundRobin, leastPing or leastLoad
+	Outbounds       string `json:"outbounds"`                              // JSON outbounds of the last refresh
+	LastUpdate      int64  `json:"lastUpdate"`                             // Unix time in milliseconds of the last refresh attempt
+	Error           string `json:"error"`                                  // Error of the last refresh, empty if it succeeded
+}
+
+// HistoryOfSeeders tracks which database seeders were executed before versioned migrations
+// replaced them, so the migrations taking their place don't run them again.
 type HistoryOfSeeders struct {
 	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	SeederName string `json:"seederName"`
 }
 
+// SchemaMigration records a database migration that has been applied.
+type SchemaMigration struct {
+	Version   int    `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string `json:"name"`
+	AppliedAt int64  `json:"appliedAt"` // Unix time
+}
+
 // GenXrayInboundConfig generates an Xray inbound configuration from the Inbound model.
 func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	listen := i.Listen
@@ -103,19 +351,21 @@ type Setting struct {
 
 // Client represents a client configuration for Xray inbounds with traffic limits and settings.
 type Client struct {
-	ID         string `json:"id"`                           // Unique client identifier
-	Security   string `json:"security"`                     // Security method (e.g., "auto", "aes-128-gcm")
-	Password   string `json:"password"`                     // Client password
-	Flow       string `json:"flow"`                         // Flow control (XTLS)
-	Email      string `json:"email"`                        // Client email identifier
-	LimitIP    int    `json:"limitIp"`                      // IP limit for this client
-	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Total traffic limit in GB
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp
-	Enable     bool   `json:"enable" form:"enable"`         // Whether the client is enabled
-	TgID       int64  `json:"tgId" form:"tgId"`             // Telegram user ID for notifications
-	SubID      string `json:"subId" form:"subId"`           // Subscription identifier
-	Comment    string `json:"comment" form:"comment"`       // Client comment
-	Reset      int    `json:"reset" form:"reset"`           // Reset period in days
-	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
-	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
+	ID          string `json:"id"`                                       // Unique client identifier
+	Security    string `json:"security"`                                 // Security method (e.g., "auto", "aes-128-gcm")
+	Password    string `json:"password"`                                 // Client password
+	Flow        string `json:"flow"`                                     // Flow control (XTLS)
+	Email       string `json:"email"`                                    // Client email identifier
+	LimitIP     int    `json:"limitIp"`                                  // IP limit for this client
+	TotalGB     int64  `json:"totalGB" form:"totalGB"`                   // Total traffic limit in GB
+	ExpiryTime  int64  `json:"expiryTime" form:"expiryTime"`             // Expiration timestamp
+	Enable      bool   `json:"enable" form:"enable"`                     // Whether the client is enabled
+	TgID        int64  `json:"tgId" form:"tgId"`                         // Telegram user ID for notifications
+	SubID       string `json:"subId" form:"subId"`                       // Subscription identifier
+	Comment     string `json:"comment" form:"comment"`                   // Client comment
+	Reset       int    `json:"reset" form:"reset"`                       // Reset period in days
+	OutboundTag string `json:"outboundTag,omitempty" form:"outboundTag"` // Outbound the client's traffic is routed to
+	BalancerTag string `json:"balancerTag,omitempty" form:"balancerTag"` // Balancer the client's traffic is routed to
+	CreatedAt   int64  `json:"created_at,omitempty"`                     // Creation timestamp
+	UpdatedAt   int64  `json:"updated_at,omitempty"`                     // Last update timestamp
 }
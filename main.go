@@ -3,17 +3,20 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	_ "unsafe"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/node"
 	"github.com/mhsanaei/3x-ui/v2/sub"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	"github.com/mhsanaei/3x-ui/v2/web"
@@ -28,24 +31,10 @@ import (
 func runWebServer() {
 	log.Printf("Starting %v %v", config.GetName(), config.GetVersion())
 
-	switch config.GetLogLevel() {
-	case config.Debug:
-		logger.InitLogger(logging.DEBUG)
-	case config.Info:
-		logger.InitLogger(logging.INFO)
-	case config.Notice:
-		logger.InitLogger(logging.NOTICE)
-	case config.Warning:
-		logger.InitLogger(logging.WARNING)
-	case config.Error:
-		logger.InitLogger(logging.ERROR)
-	default:
-		log.Fatalf("Unknown log level: %v", config.GetLogLevel())
-	}
-
+	initLogger()
 	godotenv.Load()
 
-	err := database.InitDB(config.GetDBPath())
+	err := database.InitDB(config.GetDBDSN())
 	if err != nil {
 		log.Fatalf("Error initializing database: %v", err)
 	}
@@ -114,9 +103,55 @@ func runWebServer() {
 	}
 }
 
+// initLogger sets up the logger with the configured log level.
+func initLogger() {
+	switch config.GetLogLevel() {
+	case config.Debug:
+		logger.InitLogger(logging.DEBUG)
+	case config.Info:
+		logger.InitLogger(logging.INFO)
+	case config.Notice:
+		logger.InitLogger(logging.NOTICE)
+	case config.Warning:
+		logger.InitLogger(logging.WARNING)
+	case config.Error:
+		logger.InitLogger(logging.ERROR)
+	default:
+		log.Fatalf("Unknown log level: %v", config.GetLogLevel())
+	}
+}
+
+// runNode runs x-ui as a node, serving the inbounds a panel pushes through the node API.
+func runNode(listen, token, certFile, keyFile string) {
+	log.Printf("Starting %v %v as a node", config.GetName(), config.GetVersion())
+
+	initLogger()
+	godotenv.Load()
+	if token == "" {
+		token = os.Getenv("XUI_NODE_TOKEN")
+	}
+
+	err := database.InitDB(config.GetDBDSN())
+	if err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
+
+	server := node.NewServer(listen, token, certFile, keyFile)
+	err = server.Start()
+	if err != nil {
+		log.Fatalf("Error starting node: %v", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	<-sigCh
+	server.Stop()
+	log.Println("Shutting down node.")
+}
+
 // resetSetting resets all panel settings to their default values.
 func resetSetting() {
-	err := database.InitDB(config.GetDBPath())
+	err := database.InitDB(config.GetDBDSN())
 	if err != nil {
 		fmt.Println("Failed to initialize database:", err)
 		return
@@ -203,7 +238,7 @@ func updateTgbotEnableSts(status bool) {
 
 // updateTgbotSetting updates Telegram bot settings including token, chat ID, and runtime schedule.
 func updateTgbotSetting(tgBotToken string, tgBotChatid string, tgBotRuntime string) {
-	err := database.InitDB(config.GetDBPath())
+	err := database.InitDB(config.GetDBDSN())
 	if err != nil {
 		fmt.Println("Error initializing database:", err)
 		return
@@ -241,7 +276,7 @@ func updateTgbotSetting(tgBotToken string, tgBotChatid string, tgBotRuntime stri
 
 // updateSetting updates various panel settings including port, credentials, base path, listen IP, and two-factor authentication.
 func updateSetting(port int, username string, password string, webBasePath string, listenIP string, resetTwoFactor bool) {
-	err := database.InitDB(config.GetDBPath())
+	err := database.InitDB(config.GetDBDSN())
 	if err != nil {
 		fmt.Println("Database initialization failed:", err)
 		return
@@ -300,7 +335,7 @@ func updateSetting(port int, username string, password string, webBasePath strin
 
 // updateCert updates the SSL certificate files for the panel.
 func updateCert(publicKey string, privateKey string) {
-	err := database.InitDB(config.GetDBPath())
+	err := database.InitDB(config.GetDBDSN())
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -359,29 +394,246 @@ func GetListenIP(getListen bool) {
 	}
 }
 
-// migrateDb performs database migration operations for the 3x-ui panel.
-func migrateDb() {
-	inboundService := service.InboundService{}
+// resetAllowedIps removes the panel IP allowlist so the panel can be reached from any IP again.
+func resetAllowedIps() {
+	err := database.InitDB(config.GetDBDSN())
+	if err != nil {
+		fmt.Println("Database initialization failed:", err)
+		return
+	}
+
+	settingService := service.SettingService{}
+	err = settingService.SetPanelAllowedIps("")
+	if err != nil {
+		fmt.Println("Failed to reset panel allowed IPs:", err)
+	} else {
+		fmt.Println("Panel allowed IPs reset successfully")
+	}
+}
+
+// manageLoginLockouts lists or lifts the lockouts of IPs and usernames after failed panel logins.
+func manageLoginLockouts(list bool, clear string, clearAll bool) {
+	err := database.InitDB(config.GetDBDSN())
+	if err != nil {
+		fmt.Println("Database initialization failed:", err)
+		return
+	}
+
+	loginLimitService := service.LoginLimitService{}
+	if clearAll {
+		if err := loginLimitService.ClearAllLockouts(); err != nil {
+			fmt.Println("Failed to clear login lockouts:", err)
+		} else {
+			fmt.Println("All login lockouts cleared")
+		}
+	}
+	if clear != "" {
+		count, err := loginLimitService.ClearLockoutOf(clear)
+		if err != nil {
+			fmt.Println("Failed to clear login lockout:", err)
+		} else if count == 0 {
+			fmt.Println("No login lockout found for", clear)
+		} else {
+			fmt.Println("Login lockout cleared for", clear)
+		}
+	}
+	if list {
+		lockouts, err := loginLimitService.GetLockouts()
+		if err != nil {
+			fmt.Println("Failed to get login lockouts:", err)
+			return
+		}
+		if len(lockouts) == 0 {
+			fmt.Println("No login lockouts")
+			return
+		}
+		for _, lockout := range lockouts {
+			fmt.Printf("%-8s %-40s locked until %s\n", lockout.Kind, lockout.Value, time.UnixMilli(lockout.LockedUntil).Format("2006-01-02 15:04:05"))
+		}
+	}
+}
+
+// restoreDb restores the database from a backup, decrypting it first if it is encrypted. Without
+// a key the backup passphrase configured in the current database is tried. With an output path
+// the decrypted backup is only written there.
+func restoreDb(file, key, keyFile, out string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Println("Failed to read backup:", err)
+		return
+	}
+	if keyFile != "" {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			fmt.Println("Failed to read key file:", err)
+			return
+		}
+		key = string(keyData)
+	}
+	if key == "" && crypto.IsAgeEncrypted(data) {
+		if err := database.InitDB(config.GetDBDSN()); err != nil {
+			fmt.Println("Database initialization failed:", err)
+			return
+		}
+	}
+	backupService := service.BackupService{}
+	data, err = backupService.Open(data, key)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if ok, err := database.IsSQLiteDB(bytes.NewReader(data)); err != nil || !ok {
+		fmt.Println("Backup is not a valid database")
+		return
+	}
+	if out != "" {
+		if err := os.WriteFile(out, data, 0o600); err != nil {
+			fmt.Println("Failed to write database:", err)
+			return
+		}
+		fmt.Println("Database written to", out)
+		return
+	}
+
+	dbPath := config.GetDBDSN()
+	if database.DialectOf(dbPath) != database.DialectSQLite {
+		restoreIntoDB(dbPath, data)
+		return
+	}
+	tempPath := dbPath + ".temp"
+	defer os.Remove(tempPath)
+	if err := os.WriteFile(tempPath, data, 0o600); err != nil {
+		fmt.Println("Failed to write database:", err)
+		return
+	}
+	if err := database.InitDB(tempPath); err != nil {
+		fmt.Println("Backup can't be opened as the database:", err)
+		return
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".backup"); err != nil {
+			fmt.Println("Failed to keep the current database:", err)
+			return
+		}
+		fmt.Println("Current database kept as", dbPath+".backup")
+	}
+	if err := os.Rename(tempPath, dbPath); err != nil {
+		fmt.Println("Failed to replace the database:", err)
+		return
+	}
+	fmt.Println("Database restored, restart the panel to use it")
+}
+
+// restoreIntoDB replaces the data of a PostgreSQL or MySQL database with the data of a backup.
+func restoreIntoDB(dsn string, data []byte) {
+	tempFile, err := os.CreateTemp("", "x-ui-restore-*.db")
+	if err != nil {
+		fmt.Println("Failed to write database:", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(data)
+	tempFile.Close()
+	if err != nil {
+		fmt.Println("Failed to write database:", err)
+		return
+	}
+	if err := database.InitDB(dsn); err != nil {
+		fmt.Println("Database initialization failed:", err)
+		return
+	}
+	if err := database.Import(tempFile.Name()); err != nil {
+		fmt.Println("Failed to restore the database:", err)
+		return
+	}
+	fmt.Println("Database restored, restart the panel to use it")
+}
+
+// copyDb copies all data of one database into another, such as the SQLite file of an existing
+// panel into a new PostgreSQL or MySQL database.
+func copyDb(from, to string, wipe bool) {
+	if to == "" {
+		to = config.GetDBDSN()
+	}
+	if from == to {
+		fmt.Println("The source and the target database are the same")
+		return
+	}
+	if database.DialectOf(from) == database.DialectSQLite {
+		if _, err := os.Stat(from); err != nil {
+			fmt.Println("Failed to open the source database:", err)
+			return
+		}
+	}
+	if err := database.CopyDB(from, to, wipe); err != nil {
+		fmt.Println("Failed to copy the database:", err)
+		return
+	}
+	fmt.Println("Database copied, point XUI_DB_DSN or -dsn at it to use it")
+}
 
-	err := database.InitDB(config.GetDBPath())
+// migrateDb applies the pending database migrations. With status it lists all migrations and
+// with dryRun the pending ones, without changing the database.
+func migrateDb(status bool, dryRun bool) {
+	dsn := config.GetDBDSN()
+	version, states, err := database.MigrationStatus(dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	var pending []database.MigrationState
+	for _, state := range states {
+		if state.AppliedAt == 0 {
+			pending = append(pending, state)
+		}
+	}
+
+	if status {
+		fmt.Printf("Database schema version: %d (latest: %d)\n", version, database.LatestVersion())
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt > 0 {
+				applied = "applied " + time.Unix(state.AppliedAt, 0).Format("2006-01-02 15:04:05")
+			}
+			if state.Version > database.LatestVersion() {
+				applied += " (unknown to this version)"
+			}
+			fmt.Printf("%4d  %-32s %s\n", state.Version, state.Name, applied)
+		}
+		return
+	}
+	if version > database.LatestVersion() {
+		fmt.Printf("The database has schema version %d, newer than the latest version %d of this binary\n", version, database.LatestVersion())
+		return
+	}
+	if dryRun {
+		if len(pending) == 0 {
+			fmt.Println("The database is up to date")
+			return
+		}
+		fmt.Println("Migrations that would be applied:")
+		for _, state := range pending {
+			fmt.Printf("%4d  %s\n", state.Version, state.Name)
+		}
+		return
+	}
+
 	fmt.Println("Start migrating database...")
-	inboundService.MigrateDB()
+	if err := database.InitDB(dsn); err != nil {
+		log.Fatal(err)
+	}
+	for _, state := range pending {
+		fmt.Printf("Applied %d %s\n", state.Version, state.Name)
+	}
 	fmt.Println("Migration done!")
 }
 
 // main is the entry point of the 3x-ui application.
 // It parses command-line arguments to run the web server, migrate database, or update settings.
 func main() {
-	if len(os.Args) < 2 {
-		runWebServer()
-		return
-	}
-
 	var showVersion bool
+	var dsn string
 	flag.BoolVar(&showVersion, "v", false, "show version")
+	flag.StringVar(&dsn, "dsn", "", "Database to use: a SQLite file path, or a postgres:// or mysql:// URL (default XUI_DB_DSN or the SQLite file)")
 
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 
@@ -418,6 +670,50 @@ func main() {
 	settingCmd.StringVar(&tgbotRuntime, "tgbotRuntime", "", "Set cron time for Telegram bot notifications")
 	settingCmd.StringVar(&tgbotchatid, "tgbotchatid", "", "Set chat ID for Telegram bot notifications")
 	settingCmd.BoolVar(&enabletgbot, "enabletgbot", false, "Enable notifications via Telegram bot")
+	var resetAllowedIpsFlag bool
+	settingCmd.BoolVar(&resetAllowedIpsFlag, "resetAllowedIps", false, "Allow panel access from any IP again")
+
+	lockoutCmd := flag.NewFlagSet("lockout", flag.ExitOnError)
+	var listLockouts bool
+	var clearLockout string
+	var clearAllLockouts bool
+	lockoutCmd.BoolVar(&listLockouts, "list", false, "List IPs and usernames locked out after failed logins")
+	lockoutCmd.StringVar(&clearLockout, "clear", "", "Lift the login lockout of an IP or username")
+	lockoutCmd.BoolVar(&clearAllLockouts, "clearAll", false, "Lift all login lockouts")
+
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	var restoreFile string
+	var restoreKey string
+	var restoreKeyFile string
+	var restoreOut string
+	restoreCmd.StringVar(&restoreFile, "file", "", "Backup to restore the database from")
+	restoreCmd.StringVar(&restoreKey, "key", "", "Passphrase or age identity an encrypted backup is decrypted with")
+	restoreCmd.StringVar(&restoreKeyFile, "keyFile", "", "File with the age identity or SSH private key an encrypted backup is decrypted with")
+	restoreCmd.StringVar(&restoreOut, "out", "", "Only write the decrypted database to this path")
+
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var migrateStatus bool
+	var migrateDryRun bool
+	migrateCmd.BoolVar(&migrateStatus, "status", false, "Show the schema version and the state of all migrations")
+	migrateCmd.BoolVar(&migrateDryRun, "dryRun", false, "Show the migrations that would be applied without applying them")
+
+	copydbCmd := flag.NewFlagSet("copydb", flag.ExitOnError)
+	var copyFrom string
+	var copyTo string
+	var copyWipe bool
+	copydbCmd.StringVar(&copyFrom, "from", config.GetDBPath(), "Database to copy the data from")
+	copydbCmd.StringVar(&copyTo, "to", "", "Database to copy the data into (default -dsn or XUI_DB_DSN)")
+	copydbCmd.BoolVar(&copyWipe, "wipe", false, "Replace the data of a target database that is not empty")
+
+	nodeCmd := flag.NewFlagSet("node", flag.ExitOnError)
+	var nodeListen string
+	var nodeToken string
+	var nodeCert string
+	var nodeKey string
+	nodeCmd.StringVar(&nodeListen, "listen", ":2054", "Address the node API listens on")
+	nodeCmd.StringVar(&nodeToken, "token", "", "Token the panel authenticates with, at least 16 characters (default XUI_NODE_TOKEN)")
+	nodeCmd.StringVar(&nodeCert, "cert", "", "Certificate file to serve the node API over HTTPS")
+	nodeCmd.StringVar(&nodeKey, "key", "", "Private key file of the certificate")
 
 	oldUsage := flag.Usage
 	flag.Usage = func() {
@@ -425,8 +721,12 @@ func main() {
 		fmt.Println()
 		fmt.Println("Commands:")
 		fmt.Println("    run            run web panel")
-		fmt.Println("    migrate        migrate form other/old x-ui")
+		fmt.Println("    node           run as a node of another panel")
+		fmt.Println("    migrate        migrate the database, or show its migrations")
 		fmt.Println("    setting        set settings")
+		fmt.Println("    lockout        manage login lockouts")
+		fmt.Println("    restore        restore the database from a backup")
+		fmt.Println("    copydb         copy the database into another one, such as PostgreSQL or MySQL")
 	}
 
 	flag.Parse()
@@ -434,19 +734,39 @@ func main() {
 		fmt.Println(config.GetVersion())
 		return
 	}
+	if dsn != "" {
+		config.SetDBDSN(dsn)
+	}
+	if flag.NArg() == 0 {
+		runWebServer()
+		return
+	}
 
-	switch os.Args[1] {
+	args := flag.Args()
+	switch args[0] {
 	case "run":
-		err := runCmd.Parse(os.Args[2:])
+		err := runCmd.Parse(args[1:])
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 		runWebServer()
+	case "node":
+		err := nodeCmd.Parse(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		runNode(nodeListen, nodeToken, nodeCert, nodeKey)
 	case "migrate":
-		migrateDb()
+		err := migrateCmd.Parse(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		migrateDb(migrateStatus, migrateDryRun)
 	case "setting":
-		err := settingCmd.Parse(os.Args[2:])
+		err := settingCmd.Parse(args[1:])
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -471,8 +791,39 @@ func main() {
 		if enabletgbot {
 			updateTgbotEnableSts(enabletgbot)
 		}
+		if resetAllowedIpsFlag {
+			resetAllowedIps()
+		}
+	case "lockout":
+		err := lockoutCmd.Parse(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !listLockouts && clearLockout == "" && !clearAllLockouts {
+			listLockouts = true
+		}
+		manageLoginLockouts(listLockouts, clearLockout, clearAllLockouts)
+	case "restore":
+		err := restoreCmd.Parse(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if restoreFile == "" {
+			restoreCmd.Usage()
+			return
+		}
+		restoreDb(restoreFile, restoreKey, restoreKeyFile, restoreOut)
+	case "copydb":
+		err := copydbCmd.Parse(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		copyDb(copyFrom, copyTo, copyWipe)
 	case "cert":
-		err := settingCmd.Parse(os.Args[2:])
+		err := settingCmd.Parse(args[1:])
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -487,6 +838,16 @@ func main() {
 		fmt.Println()
 		runCmd.Usage()
 		fmt.Println()
+		nodeCmd.Usage()
+		fmt.Println()
+		migrateCmd.Usage()
+		fmt.Println()
 		settingCmd.Usage()
+		fmt.Println()
+		lockoutCmd.Usage()
+		fmt.Println()
+		restoreCmd.Usage()
+		fmt.Println()
+		copydbCmd.Usage()
 	}
 }
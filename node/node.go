@@ -0,0 +1,197 @@
+// Package node runs x-ui as a node of another panel: Xray serves the inbounds the panel pushes,
+// and an API authenticated by a token lets the panel push them and collect traffic and status.
+// The web panel, subscriptions and bots don't run on a node.
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/job"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// minTokenLength is the shortest token the node API accepts, as it is the only thing guarding it.
+const minTokenLength = 16
+
+// Server is the node API together with Xray and the jobs that keep it running and counting.
+type Server struct {
+	httpServer *http.Server
+	listener   net.Listener
+	cron       *cron.Cron
+
+	listen   string
+	token    string
+	certFile string
+	keyFile  string
+
+	xrayService  service.XrayService
+	agentService service.NodeAgentService
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewServer creates a node server listening on the address, which the panel authenticates with
+// the token. With a certificate and key the API is served over HTTPS.
+func NewServer(listen, token, certFile, keyFile string) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		listen:   listen,
+		token:    token,
+		certFile: certFile,
+		keyFile:  keyFile,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// initRouter sets up the routes of the node API behind the token check.
+func (s *Server) initRouter() *gin.Engine {
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+	gin.SetMode(gin.ReleaseMode)
+
+	engine := gin.New()
+	engine.Use(gin.Recovery(), s.checkToken)
+	engine.GET(service.NodeReportPath, s.report)
+	engine.POST(service.NodeConfigPath, s.applyConfig)
+	return engine
+}
+
+// checkToken rejects requests without the bearer token of the node.
+func (s *Server) checkToken(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		logger.Warning("Node API request with an invalid token from", c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, entity.Msg{Msg: "invalid token"})
+		return
+	}
+	c.Next()
+}
+
+// report returns the traffic counters and status of the node.
+func (s *Server) report(c *gin.Context) {
+	report, err := s.agentService.Report()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, entity.Msg{Msg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entity.Msg{Success: true, Obj: report})
+}
+
+// applyConfig replaces the inbounds of the node with the ones the panel pushed.
+func (s *Server) applyConfig(c *gin.Context) {
+	config := &service.NodeConfig{}
+	if err := c.ShouldBindJSON(config); err != nil {
+		c.JSON(http.StatusBadRequest, entity.Msg{Msg: err.Error()})
+		return
+	}
+	if err := s.agentService.ApplyConfig(config); err != nil {
+		logger.Warning("Unable to apply the config of the panel:", err)
+		c.JSON(http.StatusOK, entity.Msg{Msg: err.Error()})
+		return
+	}
+	logger.Infof("Applied %d inbounds pushed by the panel", len(config.Inbounds))
+	c.JSON(http.StatusOK, entity.Msg{Success: true})
+}
+
+// startTask starts Xray and the jobs that restart it and count its traffic. The limits of the
+// clients are up to the panel, so the other jobs of the panel don't run on a node.
+func (s *Server) startTask() {
+	if err := s.xrayService.RestartXray(true); err != nil {
+		logger.Warning("start xray failed:", err)
+	}
+	s.cron.AddJob("@every 1s", job.NewCheckXrayRunningJob())
+	s.cron.AddFunc("@every 30s", func() {
+		if s.xrayService.IsNeedRestartAndSetFalse() {
+			if err := s.xrayService.RestartXray(false); err != nil {
+				logger.Error("restart xray failed:", err)
+			}
+		} else if err := s.xrayService.SyncSingBox(); err != nil {
+			logger.Warning("sync sing-box failed:", err)
+		}
+	})
+	s.cron.AddJob("@every 10s", job.NewXrayTrafficJob())
+}
+
+// Start starts Xray, the jobs and the node API.
+func (s *Server) Start() (err error) {
+	defer func() {
+		if err != nil {
+			s.Stop()
+		}
+	}()
+
+	if len(s.token) < minTokenLength {
+		return common.NewErrorf("the node needs a token of at least %d characters", minTokenLength)
+	}
+	if (s.certFile == "") != (s.keyFile == "") {
+		return common.NewError("the node needs both a certificate and a key for HTTPS")
+	}
+
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	if s.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+		// Panels can pin the certificate by this fingerprint instead of trusting a CA
+		sum := sha256.Sum256(cert.Certificate[0])
+		logger.Info("Node API running HTTPS on", listener.Addr())
+		logger.Info("Node certificate SHA-256:", hex.EncodeToString(sum[:]))
+	} else {
+		logger.Warning("Node API running HTTP on", listener.Addr(), "- the token is sent in plain text")
+	}
+	s.listener = listener
+
+	s.cron = cron.New(cron.WithSeconds())
+	s.cron.Start()
+	s.startTask()
+
+	s.httpServer = &http.Server{
+		Handler:           s.initRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		s.httpServer.Serve(listener)
+	}()
+	return nil
+}
+
+// Stop shuts down the node API, the jobs and Xray.
+func (s *Server) Stop() error {
+	s.cancel()
+	s.xrayService.StopXray()
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	var err1 error
+	var err2 error
+	if s.httpServer != nil {
+		err1 = s.httpServer.Shutdown(s.ctx)
+	}
+	if s.listener != nil {
+		err2 = s.listener.Close()
+	}
+	return common.Combine(err1, err2)
+}
@@ -0,0 +1,84 @@
+package singbox
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// queryStatsMethod is the V2Ray stats API method served by sing-box. Its messages
+// are wire compatible with the Xray stats API.
+const queryStatsMethod = "/v2ray.core.app.stats.command.StatsService/QueryStats"
+
+var (
+	trafficRegex       = regexp.MustCompile(`(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
+	clientTrafficRegex = regexp.MustCompile(`user>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
+)
+
+// queryTraffic queries the traffic statistics from the sing-box V2Ray API, optionally resetting counters.
+func queryTraffic(addr string, reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp := &statsService.QueryStatsResponse{}
+	err = conn.Invoke(ctx, queryStatsMethod, &statsService.QueryStatsRequest{Reset_: reset}, resp)
+	if err != nil {
+		logger.Debug("Failed to query sing-box stats:", err)
+		return nil, nil, err
+	}
+
+	tagTraffics := map[string]*xray.Traffic{}
+	emailTraffics := map[string]*xray.ClientTraffic{}
+	for _, stat := range resp.GetStat() {
+		if matches := trafficRegex.FindStringSubmatch(stat.Name); len(matches) == 4 {
+			traffic, ok := tagTraffics[matches[2]]
+			if !ok {
+				traffic = &xray.Traffic{
+					IsInbound:  matches[1] == "inbound",
+					IsOutbound: matches[1] == "outbound",
+					Tag:        matches[2],
+				}
+				tagTraffics[matches[2]] = traffic
+			}
+			if matches[3] == "downlink" {
+				traffic.Down = stat.Value
+			} else {
+				traffic.Up = stat.Value
+			}
+		} else if matches := clientTrafficRegex.FindStringSubmatch(stat.Name); len(matches) == 3 {
+			traffic, ok := emailTraffics[matches[1]]
+			if !ok {
+				traffic = &xray.ClientTraffic{Email: matches[1]}
+				emailTraffics[matches[1]] = traffic
+			}
+			if matches[2] == "downlink" {
+				traffic.Down = stat.Value
+			} else {
+				traffic.Up = stat.Value
+			}
+		}
+	}
+
+	traffics := make([]*xray.Traffic, 0, len(tagTraffics))
+	for _, traffic := range tagTraffics {
+		traffics = append(traffics, traffic)
+	}
+	clientTraffics := make([]*xray.ClientTraffic, 0, len(emailTraffics))
+	for _, traffic := range emailTraffics {
+		clientTraffics = append(clientTraffics, traffic)
+	}
+	return traffics, clientTraffics, nil
+}
@@ -0,0 +1,226 @@
+// Package singbox provides integration with the sing-box proxy core.
+// It serves the inbound protocols Xray lacks, such as hysteria2 and tuic,
+// from the same inbound and client model.
+package singbox
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// Config represents the part of the sing-box configuration generated by the panel.
+type Config struct {
+	Log          map[string]any   `json:"log"`
+	Inbounds     []map[string]any `json:"inbounds"`
+	Outbounds    []map[string]any `json:"outbounds"`
+	Experimental map[string]any   `json:"experimental,omitempty"`
+}
+
+// Equals compares two Config instances for deep equality.
+func (c *Config) Equals(other *Config) bool {
+	if other == nil {
+		return false
+	}
+	a, err1 := json.Marshal(c)
+	b, err2 := json.Marshal(other)
+	return err1 == nil && err2 == nil && bytes.Equal(a, b)
+}
+
+// IsSupportedProtocol reports whether inbounds of the given protocol are served by sing-box.
+func IsSupportedProtocol(protocol model.Protocol) bool {
+	return protocol == model.Hysteria2 || protocol == model.TUIC
+}
+
+// GenConfig builds the sing-box configuration for the given inbounds.
+// Disabled or invalid inbounds and disabled or depleted clients are left out.
+func GenConfig(inbounds []*model.Inbound) *Config {
+	config := &Config{
+		Log: map[string]any{
+			"level":     "warn",
+			"timestamp": true,
+		},
+		Inbounds: []map[string]any{},
+		Outbounds: []map[string]any{
+			{"type": "direct", "tag": "direct"},
+		},
+	}
+	for _, inbound := range inbounds {
+		if !inbound.Enable || !IsSupportedProtocol(inbound.Protocol) {
+			continue
+		}
+		inboundConfig, err := genInboundConfig(inbound)
+		if err != nil {
+			logger.Warningf("Skipping sing-box inbound %s: %v", inbound.Tag, err)
+			continue
+		}
+		config.Inbounds = append(config.Inbounds, inboundConfig)
+	}
+	return config
+}
+
+// genInboundConfig converts a panel inbound into a sing-box inbound.
+func genInboundConfig(inbound *model.Inbound) (map[string]any, error) {
+	settings := map[string]any{}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, err
+	}
+
+	listen := inbound.Listen
+	if listen == "" || listen == "0.0.0.0" {
+		listen = "::"
+	}
+	config := map[string]any{
+		"type":        string(inbound.Protocol),
+		"tag":         inbound.Tag,
+		"listen":      listen,
+		"listen_port": inbound.Port,
+		"users":       genUsers(inbound, settings),
+	}
+
+	tls, err := genTLSConfig(inbound.StreamSettings)
+	if err != nil {
+		return nil, err
+	}
+	config["tls"] = tls
+
+	switch inbound.Protocol {
+	case model.Hysteria2:
+		if v, ok := settings["upMbps"].(float64); ok && v > 0 {
+			config["up_mbps"] = int(v)
+		}
+		if v, ok := settings["downMbps"].(float64); ok && v > 0 {
+			config["down_mbps"] = int(v)
+		}
+		if v, _ := settings["ignoreClientBandwidth"].(bool); v {
+			config["ignore_client_bandwidth"] = true
+		}
+		if v, _ := settings["obfsPassword"].(string); v != "" {
+			config["obfs"] = map[string]any{
+				"type":     "salamander",
+				"password": v,
+			}
+		}
+		if v, _ := settings["masquerade"].(string); v != "" {
+			config["masquerade"] = v
+		}
+	case model.TUIC:
+		if v, _ := settings["congestionControl"].(string); v != "" {
+			config["congestion_control"] = v
+		}
+		if v, _ := settings["zeroRttHandshake"].(bool); v {
+			config["zero_rtt_handshake"] = true
+		}
+	}
+	return config, nil
+}
+
+// genUsers returns the sing-box users of an inbound, skipping disabled and depleted clients.
+func genUsers(inbound *model.Inbound, settings map[string]any) []map[string]any {
+	depleted := map[string]bool{}
+	for _, stat := range inbound.ClientStats {
+		if !stat.Enable {
+			depleted[stat.Email] = true
+		}
+	}
+
+	users := []map[string]any{}
+	clients, _ := settings["clients"].([]any)
+	for _, c := range clients {
+		client, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		email, _ := client["email"].(string)
+		if enable, ok := client["enable"].(bool); (ok && !enable) || depleted[email] {
+			continue
+		}
+		password, _ := client["password"].(string)
+		user := map[string]any{
+			"name":     email,
+			"password": password,
+		}
+		if inbound.Protocol == model.TUIC {
+			user["uuid"], _ = client["id"].(string)
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// genTLSConfig converts the TLS part of the inbound stream settings. Both hysteria2 and tuic run over QUIC and require TLS.
+func genTLSConfig(streamSettings string) (map[string]any, error) {
+	var stream struct {
+		Security    string `json:"security"`
+		TLSSettings struct {
+			ServerName   string   `json:"serverName"`
+			ALPN         []string `json:"alpn"`
+			Certificates []struct {
+				CertificateFile string   `json:"certificateFile"`
+				KeyFile         string   `json:"keyFile"`
+				Certificate     []string `json:"certificate"`
+				Key             []string `json:"key"`
+			} `json:"certificates"`
+		} `json:"tlsSettings"`
+	}
+	if streamSettings != "" {
+		if err := json.Unmarshal([]byte(streamSettings), &stream); err != nil {
+			return nil, err
+		}
+	}
+	if stream.Security != "tls" || len(stream.TLSSettings.Certificates) == 0 {
+		return nil, common.NewError("a TLS certificate is required")
+	}
+
+	tls := map[string]any{
+		"enabled": true,
+		"alpn":    []string{"h3"},
+	}
+	if stream.TLSSettings.ServerName != "" {
+		tls["server_name"] = stream.TLSSettings.ServerName
+	}
+	if len(stream.TLSSettings.ALPN) > 0 {
+		tls["alpn"] = stream.TLSSettings.ALPN
+	}
+	cert := stream.TLSSettings.Certificates[0]
+	if cert.CertificateFile != "" && cert.KeyFile != "" {
+		tls["certificate_path"] = cert.CertificateFile
+		tls["key_path"] = cert.KeyFile
+	} else if len(cert.Certificate) > 0 && len(cert.Key) > 0 {
+		tls["certificate"] = cert.Certificate
+		tls["key"] = cert.Key
+	} else {
+		return nil, common.NewError("a TLS certificate is required")
+	}
+	return tls, nil
+}
+
+// enableStats adds the V2Ray API stats service for all inbounds and users of the config.
+func (c *Config) enableStats(listen string) {
+	inbounds := []string{}
+	users := []string{}
+	for _, inbound := range c.Inbounds {
+		if tag, _ := inbound["tag"].(string); tag != "" {
+			inbounds = append(inbounds, tag)
+		}
+		inboundUsers, _ := inbound["users"].([]map[string]any)
+		for _, user := range inboundUsers {
+			if name, _ := user["name"].(string); name != "" {
+				users = append(users, name)
+			}
+		}
+	}
+	c.Experimental = map[string]any{
+		"v2ray_api": map[string]any{
+			"listen": listen,
+			"stats": map[string]any{
+				"enabled":  true,
+				"inbounds": inbounds,
+				"users":    users,
+			},
+		},
+	}
+}
@@ -0,0 +1,262 @@
+package singbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// stopTimeout is how long a stopped sing-box process gets to exit before it is killed.
+const stopTimeout = 10 * time.Second
+
+// GetBinaryName returns the sing-box binary filename for the current OS and architecture.
+func GetBinaryName() string {
+	return fmt.Sprintf("sing-box-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// GetBinaryPath returns the full path to the sing-box binary executable.
+func GetBinaryPath() string {
+	return config.GetBinFolderPath() + "/" + GetBinaryName()
+}
+
+// GetConfigPath returns the path to the sing-box configuration file in the binary folder.
+func GetConfigPath() string {
+	return config.GetBinFolderPath() + "/sing-box.json"
+}
+
+// IsBinaryAvailable reports whether the sing-box binary is installed.
+func IsBinaryAvailable() bool {
+	_, err := os.Stat(GetBinaryPath())
+	return err == nil
+}
+
+// Process wraps a sing-box process instance and provides management methods.
+type Process struct {
+	cmd *exec.Cmd
+
+	version  string
+	tags     []string
+	apiAddr  string
+	config   *Config
+	exitErr  error
+	lastLine string
+	exited   chan struct{} // Closed when the process exits
+
+	startTime time.Time
+}
+
+// NewProcess creates a new sing-box process for the given configuration.
+func NewProcess(config *Config) *Process {
+	return &Process{
+		version:   "Unknown",
+		config:    config,
+		startTime: time.Now(),
+	}
+}
+
+// Name returns the name of the proxy core.
+func (p *Process) Name() string {
+	return "sing-box"
+}
+
+// IsRunning returns true if the sing-box process is currently running.
+func (p *Process) IsRunning() bool {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return false
+	}
+	return p.cmd.ProcessState == nil
+}
+
+// GetErr returns the last error encountered by the sing-box process.
+func (p *Process) GetErr() error {
+	return p.exitErr
+}
+
+// GetResult returns the last log line or error from the sing-box process.
+func (p *Process) GetResult() string {
+	if len(p.lastLine) == 0 && p.exitErr != nil {
+		return p.exitErr.Error()
+	}
+	return p.lastLine
+}
+
+// GetVersion returns the version string of the sing-box binary.
+func (p *Process) GetVersion() string {
+	return p.version
+}
+
+// GetConfig returns the configuration used by the sing-box process.
+func (p *Process) GetConfig() *Config {
+	return p.config
+}
+
+// GetUptime returns the uptime of the sing-box process in seconds.
+func (p *Process) GetUptime() uint64 {
+	return uint64(time.Since(p.startTime).Seconds())
+}
+
+// refreshVersion reads the version and build tags from the sing-box binary.
+func (p *Process) refreshVersion() {
+	p.version = "Unknown"
+	p.tags = nil
+	data, err := exec.Command(GetBinaryPath(), "version").Output()
+	if err != nil {
+		return
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if version, ok := strings.CutPrefix(line, "sing-box version "); ok {
+			p.version = version
+		} else if tags, ok := strings.CutPrefix(line, "Tags: "); ok {
+			p.tags = strings.Split(tags, ",")
+		}
+	}
+}
+
+// Start launches the sing-box process with the current configuration.
+func (p *Process) Start() (err error) {
+	if p.IsRunning() {
+		return errors.New("sing-box is already running")
+	}
+
+	defer func() {
+		if err != nil {
+			logger.Error("Failure in running sing-box process: ", err)
+			p.exitErr = err
+		}
+	}()
+
+	if !IsBinaryAvailable() {
+		return common.NewErrorf("sing-box binary not found: %s", GetBinaryPath())
+	}
+	p.refreshVersion()
+
+	// Traffic statistics need a binary built with the V2Ray API
+	runConfig := *p.config
+	p.apiAddr = ""
+	if slices.Contains(p.tags, "with_v2ray_api") {
+		port, err := getFreePort()
+		if err == nil {
+			p.apiAddr = fmt.Sprintf("127.0.0.1:%d", port)
+			runConfig.enableStats(p.apiAddr)
+		}
+	} else {
+		logger.Warning("sing-box is built without with_v2ray_api, traffic of its inbounds is not counted")
+	}
+
+	data, err := json.MarshalIndent(&runConfig, "", "  ")
+	if err != nil {
+		return common.NewErrorf("Failed to generate sing-box configuration file: %v", err)
+	}
+	configPath := GetConfigPath()
+	err = os.WriteFile(configPath, data, fs.ModePerm)
+	if err != nil {
+		return common.NewErrorf("Failed to write configuration file: %v", err)
+	}
+
+	cmd := exec.Command(GetBinaryPath(), "run", "-c", configPath)
+	p.cmd = cmd
+	cmd.Stdout = p
+	cmd.Stderr = p
+	exited := make(chan struct{})
+	p.exited = exited
+
+	go func() {
+		defer close(exited)
+		err := cmd.Run()
+		if err != nil {
+			logger.Error("Failure in running sing-box:", err)
+			p.exitErr = err
+		}
+	}()
+
+	return nil
+}
+
+// Stop terminates the running sing-box process.
+func (p *Process) Stop() error {
+	if !p.IsRunning() {
+		return errors.New("sing-box is not running")
+	}
+	if runtime.GOOS == "windows" {
+		return p.cmd.Process.Kill()
+	}
+	return p.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// StopAndWait stops the running sing-box process and waits for it to exit, so that a new
+// process can listen on its ports. A process that doesn't exit in time is killed.
+func (p *Process) StopAndWait() error {
+	if err := p.Stop(); err != nil {
+		return err
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+		logger.Warning("sing-box did not stop in time, killing it")
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return nil
+}
+
+// AddUser is not supported by sing-box, user changes need a restart.
+func (p *Process) AddUser(protocol string, inboundTag string, user map[string]any) error {
+	return errors.ErrUnsupported
+}
+
+// RemoveUser is not supported by sing-box, user changes need a restart.
+func (p *Process) RemoveUser(inboundTag string, email string) error {
+	return errors.ErrUnsupported
+}
+
+// GetTraffic queries the traffic statistics of the running sing-box process.
+func (p *Process) GetTraffic(reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	if p.apiAddr == "" {
+		return nil, nil, nil
+	}
+	return queryTraffic(p.apiAddr, reset)
+}
+
+// Write logs the output of the sing-box process and keeps its last line.
+func (p *Process) Write(m []byte) (n int, err error) {
+	for line := range strings.SplitSeq(strings.TrimSpace(string(m)), "\n") {
+		if line == "" {
+			continue
+		}
+		switch {
+		case strings.Contains(line, "FATAL"), strings.Contains(line, "ERROR"):
+			logger.Error("SING-BOX: " + line)
+		case strings.Contains(line, "WARN"):
+			logger.Warning("SING-BOX: " + line)
+		default:
+			logger.Debug("SING-BOX: " + line)
+		}
+		p.lastLine = line
+	}
+	return len(m), nil
+}
+
+// getFreePort returns a free local TCP port.
+func getFreePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
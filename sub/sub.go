@@ -48,6 +48,7 @@ type Server struct {
 
 	sub            *SUBController
 	settingService service.SettingService
+	tgbotService   service.Tgbot
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -81,6 +82,9 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		engine.Use(middleware.DomainValidatorMiddleware(subDomain))
 	}
 
+	// Telegram posts the bot updates here when the webhook is served on this server
+	engine.POST("/"+service.TgWebhookPath+":secret", gin.WrapH(s.tgbotService.WebhookHandler(service.TgWebhookSub)))
+
 	LinksPath, err := s.settingService.GetSubPath()
 	if err != nil {
 		return nil, err
@@ -153,6 +157,11 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		SubTitle = ""
 	}
 
+	SubRedeem, err := s.settingService.GetSubRedeem()
+	if err != nil {
+		SubRedeem = false
+	}
+
 	// set per-request localizer from headers/cookies
 	engine.Use(locale.LocalizerMiddleware())
 
@@ -231,7 +240,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 
 	s.sub = NewSUBController(
 		g, LinksPath, JsonPath, subJsonEnable, Encrypt, ShowInfo, RemarkModel, SubUpdates,
-		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle)
+		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle, SubRedeem)
 
 	return engine, nil
 }
@@ -2,10 +2,13 @@ package sub
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,10 +20,13 @@ type SUBController struct {
 	subJsonPath    string
 	jsonEnabled    bool
 	subEncrypt     bool
+	subRedeem      bool
 	updateInterval string
 
 	subService     *SubService
 	subJsonService *SubJsonService
+	voucherService service.VoucherService
+	xrayService    service.XrayService
 }
 
 // NewSUBController creates a new subscription controller with the given configuration.
@@ -38,6 +44,7 @@ func NewSUBController(
 	jsonMux string,
 	jsonRules string,
 	subTitle string,
+	redeem bool,
 ) *SUBController {
 	sub := NewSubService(showInfo, rModel)
 	a := &SUBController{
@@ -46,6 +53,7 @@ func NewSUBController(
 		subJsonPath:    jsonPath,
 		jsonEnabled:    jsonEnabled,
 		subEncrypt:     encrypt,
+		subRedeem:      redeem,
 		updateInterval: update,
 
 		subService:     sub,
@@ -60,6 +68,9 @@ func NewSUBController(
 func (a *SUBController) initRouter(g *gin.RouterGroup) {
 	gLink := g.Group(a.subPath)
 	gLink.GET(":subid", a.subs)
+	if a.subRedeem {
+		gLink.POST(":subid/redeem", a.redeem)
+	}
 	if a.jsonEnabled {
 		gJson := g.Group(a.subJsonPath)
 		gJson.GET(":subid", a.subJsons)
@@ -101,6 +112,11 @@ func (a *SUBController) subs(c *gin.Context) {
 				basePathStr = strings.TrimRight(basePathStr, "/") + "/" + subId + "/"
 			}
 			page := a.subService.BuildPageData(subId, hostHeader, traffic, lastOnline, subs, subURL, subJsonURL, basePathStr)
+			var emails []string
+			if a.subRedeem {
+				emails, _ = a.subService.GetEmails(subId)
+			}
+			emailsJson, _ := json.Marshal(emails)
 			c.HTML(200, "subpage.html", gin.H{
 				"title":        "subscription.title",
 				"cur_ver":      config.GetVersion(),
@@ -120,7 +136,10 @@ func (a *SUBController) subs(c *gin.Context) {
 				"totalByte":    page.TotalByte,
 				"subUrl":       page.SubUrl,
 				"subJsonUrl":   page.SubJsonUrl,
+				"exits":        page.Exits,
 				"result":       page.Result,
+				"redeem":       a.subRedeem,
+				"emails":       string(emailsJson),
 			})
 			return
 		}
@@ -137,6 +156,27 @@ func (a *SUBController) subs(c *gin.Context) {
 	}
 }
 
+// redeem applies a voucher code to one of the subscription's clients.
+func (a *SUBController) redeem(c *gin.Context) {
+	subId := c.Param("subid")
+	code := c.PostForm("code")
+	email := c.PostForm("email")
+	emails, err := a.subService.GetEmails(subId)
+	if err != nil || !slices.Contains(emails, email) {
+		c.JSON(400, gin.H{"success": false, "msg": "client not found"})
+		return
+	}
+	_, needRestart, err := a.voucherService.Redeem(code, email, service.VoucherSourceSub)
+	if err != nil {
+		c.JSON(400, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	c.JSON(200, gin.H{"success": true})
+}
+
 // subJsons handles HTTP requests for JSON subscription configurations.
 func (a *SUBController) subJsons(c *gin.Context) {
 	subId := c.Param("subid")
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/mhsanaei/3x-ui/v2/core"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
@@ -26,6 +27,7 @@ type SubJsonService struct {
 	mux              string
 
 	inboundService service.InboundService
+	nodeService    service.NodeService
 	SubService     *SubService
 }
 
@@ -81,6 +83,11 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 	var clientTraffics []xray.ClientTraffic
 	var configArray []json_util.RawMessage
 
+	inboundNodes, err := s.nodeService.GetInboundNodes()
+	if err != nil {
+		logger.Warning("SubJsonService - GetInboundNodes: Unable to get nodes:", err)
+	}
+
 	// Prepare Inbounds
 	for _, inbound := range inbounds {
 		clients, err := s.inboundService.GetClients(inbound)
@@ -98,12 +105,24 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		// Copy the inbound for its nodes before getConfig changes its address
+		var nodeInbounds []*model.Inbound
+		for _, node := range inboundNodes[inbound.Id] {
+			nodeInbounds = append(nodeInbounds, s.SubService.nodeInbound(inbound, node))
+		}
 
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+				// Xray clients can't connect to inbounds served by other cores
+				if core.ForProtocol(inbound.Protocol) != core.Xray {
+					continue
+				}
 				newConfigs := s.getConfig(inbound, client, host)
 				configArray = append(configArray, newConfigs...)
+				for i, node := range inboundNodes[inbound.Id] {
+					configArray = append(configArray, s.getConfig(nodeInbounds[i], client, node.Host)...)
+				}
 			}
 		}
 	}
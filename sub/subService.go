@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -26,8 +27,10 @@ type SubService struct {
 	showInfo       bool
 	remarkModel    string
 	datepicker     string
+	exits          []string
 	inboundService service.InboundService
 	settingService service.SettingService
+	nodeService    service.NodeService
 }
 
 // NewSubService creates a new subscription service with the given configuration.
@@ -41,6 +44,7 @@ func NewSubService(showInfo bool, remarkModel string) *SubService {
 // GetSubs retrieves subscription links for a given subscription ID and host.
 func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.ClientTraffic, error) {
 	s.address = host
+	s.exits = nil
 	var result []string
 	var traffic xray.ClientTraffic
 	var lastOnline int64
@@ -58,6 +62,10 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 	if err != nil {
 		s.datepicker = "gregorian"
 	}
+	inboundNodes, err := s.nodeService.GetInboundNodes()
+	if err != nil {
+		logger.Warning("SubService - GetInboundNodes: Unable to get nodes:", err)
+	}
 	for _, inbound := range inbounds {
 		clients, err := s.inboundService.GetClients(inbound)
 		if err != nil {
@@ -78,11 +86,15 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 			if client.Enable && client.SubID == subId {
 				link := s.getLink(inbound, client.Email)
 				result = append(result, link)
+				for _, node := range inboundNodes[inbound.Id] {
+					result = append(result, s.getNodeLink(inbound, node, client.Email))
+				}
 				ct := s.getClientTraffics(inbound.ClientStats, client.Email)
 				clientTraffics = append(clientTraffics, ct)
 				if ct.LastOnline > lastOnline {
 					lastOnline = ct.LastOnline
 				}
+				s.addExit(client)
 			}
 		}
 	}
@@ -112,17 +124,71 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 	return result, lastOnline, traffic, nil
 }
 
+// getNodeLink returns the link of a client to an inbound served by a node.
+func (s *SubService) getNodeLink(inbound *model.Inbound, node *model.Node, email string) string {
+	address := s.address
+	defer func() { s.address = address }()
+	s.address = node.Host
+	return s.getLink(s.nodeInbound(inbound, node), email)
+}
+
+// nodeInbound returns a copy of the inbound as a node serves it, with the name of the node in
+// the remark. External proxies of the inbound lead to this server, so the copy has none.
+func (s *SubService) nodeInbound(inbound *model.Inbound, node *model.Node) *model.Inbound {
+	served := *inbound
+	served.Remark = node.Name
+	if inbound.Remark != "" {
+		served.Remark = inbound.Remark + string(s.remarkModel[0]) + node.Name
+	}
+	var stream map[string]any
+	if json.Unmarshal([]byte(inbound.StreamSettings), &stream) == nil {
+		delete(stream, "externalProxy")
+		if streamSettings, err := json.MarshalIndent(stream, "", "  "); err == nil {
+			served.StreamSettings = string(streamSettings)
+		}
+	}
+	return &served
+}
+
+// addExit records the outbound or balancer the client is routed to for the info page.
+func (s *SubService) addExit(client model.Client) {
+	exit := client.OutboundTag
+	if exit == "" {
+		exit = client.BalancerTag
+	}
+	if exit != "" && !slices.Contains(s.exits, exit) {
+		s.exits = append(s.exits, exit)
+	}
+}
+
+// GetEmails returns the emails of the clients that belong to the subscription.
+func (s *SubService) GetEmails(subId string) ([]string, error) {
+	inbounds, err := s.getInboundsBySubId(subId)
+	if err != nil {
+		return nil, err
+	}
+	var emails []string
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			if client.SubID == subId && !slices.Contains(emails, client.Email) {
+				emails = append(emails, client.Email)
+			}
+		}
+	}
+	return emails, nil
+}
+
 func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where(`id in (
-		SELECT DISTINCT inbounds.id
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client 
-		WHERE
-			protocol in ('vmess','vless','trojan','shadowsocks')
-			AND JSON_EXTRACT(client.value, '$.subId') = ? AND enable = ?
-	)`, subId, true).Find(&inbounds).Error
+	err := db.Model(model.Inbound{}).Preload("ClientStats").
+		Where("protocol in ?", []string{"vmess", "vless", "trojan", "shadowsocks", "hysteria2", "tuic"}).
+		Where("enable = ? AND id IN (?)", true, db.Model(model.InboundClient{}).Select("inbound_id").Where("sub_id = ?", subId)).
+		Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
@@ -140,14 +206,31 @@ func (s *SubService) getClientTraffics(traffics []xray.ClientTraffic, email stri
 
 func (s *SubService) getFallbackMaster(dest string, streamSettings string) (string, int, string, error) {
 	db := database.GetDB()
-	var inbound *model.Inbound
-	err := db.Model(model.Inbound{}).
-		Where("JSON_TYPE(settings, '$.fallbacks') = 'array'").
-		Where("EXISTS (SELECT * FROM json_each(settings, '$.fallbacks') WHERE json_extract(value, '$.dest') = ?)", dest).
-		Find(&inbound).Error
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Where("settings LIKE ?", "%fallbacks%").Find(&inbounds).Error
 	if err != nil {
 		return "", 0, "", err
 	}
+	var inbound *model.Inbound
+	for _, candidate := range inbounds {
+		var settings struct {
+			Fallbacks []struct {
+				Dest any `json:"dest"`
+			} `json:"fallbacks"`
+		}
+		json.Unmarshal([]byte(candidate.Settings), &settings)
+		for _, fallback := range settings.Fallbacks {
+			if fallback.Dest == dest {
+				inbound = candidate
+			}
+		}
+		if inbound != nil {
+			break
+		}
+	}
+	if inbound == nil {
+		return "", 0, "", common.NewError("fallback master not found")
+	}
 
 	var stream map[string]any
 	json.Unmarshal([]byte(streamSettings), &stream)
@@ -171,6 +254,8 @@ func (s *SubService) getLink(inbound *model.Inbound, email string) string {
 		return s.genTrojanLink(inbound, email)
 	case "shadowsocks":
 		return s.genShadowsocksLink(inbound, email)
+	case "hysteria2", "tuic":
+		return s.genQuicLink(inbound, email)
 	}
 	return ""
 }
@@ -884,6 +969,72 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 	return url.String()
 }
 
+// genQuicLink generates a share link for the QUIC based hysteria2 and tuic protocols served by sing-box.
+func (s *SubService) genQuicLink(inbound *model.Inbound, email string) string {
+	var client *model.Client
+	clients, _ := s.inboundService.GetClients(inbound)
+	for i := range clients {
+		if clients[i].Email == email {
+			client = &clients[i]
+			break
+		}
+	}
+	if client == nil {
+		return ""
+	}
+	var settings map[string]any
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	var stream map[string]any
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+
+	params := make(map[string]string)
+	tlsSetting, _ := stream["tlsSettings"].(map[string]any)
+	if sniValue, ok := searchKey(tlsSetting, "serverName"); ok {
+		params["sni"], _ = sniValue.(string)
+	}
+	alpns, _ := tlsSetting["alpn"].([]any)
+	var alpn []string
+	for _, a := range alpns {
+		alpn = append(alpn, a.(string))
+	}
+	if len(alpn) == 0 {
+		alpn = []string{"h3"}
+	}
+	params["alpn"] = strings.Join(alpn, ",")
+	if tlsSettings, ok := searchKey(tlsSetting, "settings"); ok {
+		if insecure, ok := searchKey(tlsSettings, "allowInsecure"); ok && insecure.(bool) {
+			params["insecure"] = "1"
+		}
+	}
+
+	var userInfo *url.Userinfo
+	if inbound.Protocol == model.TUIC {
+		userInfo = url.UserPassword(client.ID, client.Password)
+		if cc, _ := settings["congestionControl"].(string); cc != "" {
+			params["congestion_control"] = cc
+		}
+	} else {
+		userInfo = url.User(client.Password)
+		if obfsPassword, _ := settings["obfsPassword"].(string); obfsPassword != "" {
+			params["obfs"] = "salamander"
+			params["obfs-password"] = obfsPassword
+		}
+	}
+
+	link := &url.URL{
+		Scheme: string(inbound.Protocol),
+		User:   userInfo,
+		Host:   fmt.Sprintf("%s:%d", s.address, inbound.Port),
+	}
+	q := link.Query()
+	for k, v := range params {
+		q.Add(k, v)
+	}
+	link.RawQuery = q.Encode()
+	link.Fragment = s.genRemark(inbound, email, "")
+	return link.String()
+}
+
 func (s *SubService) genRemark(inbound *model.Inbound, email string, extra string) string {
 	separationChar := string(s.remarkModel[0])
 	orderChars := s.remarkModel[1:]
@@ -1030,6 +1181,7 @@ type PageData struct {
 	TotalByte    int64
 	SubUrl       string
 	SubJsonUrl   string
+	Exits        string
 	Result       []string
 }
 
@@ -1182,6 +1334,7 @@ func (s *SubService) BuildPageData(subId string, hostHeader string, traffic xray
 		TotalByte:    traffic.Total,
 		SubUrl:       subURL,
 		SubJsonUrl:   subJsonURL,
+		Exits:        strings.Join(s.exits, ", "),
 		Result:       subs,
 	}
 }
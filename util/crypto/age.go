@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// AgeExt is the extension of files encrypted with age.
+const AgeExt = ".age"
+
+// ageHeader starts every binary age file.
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// IsAgeEncrypted reports whether data is an age encrypted file.
+func IsAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, ageHeader)
+}
+
+// ParseAgeRecipients parses public keys, one per line or comma separated. It accepts age X25519
+// keys ("age1...") and SSH ed25519 and RSA keys. Empty lines and lines starting with "#" are
+// skipped.
+func ParseAgeRecipients(keys string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	scanner := bufio.NewScanner(strings.NewReader(keys))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var items []string
+		if strings.HasPrefix(line, "ssh-") {
+			items = []string{line}
+		} else {
+			items = strings.Split(line, ",")
+		}
+		for _, item := range items {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			var recipient age.Recipient
+			var err error
+			if strings.HasPrefix(item, "ssh-") {
+				recipient, err = agessh.ParseRecipient(item)
+			} else {
+				recipient, err = age.ParseX25519Recipient(item)
+			}
+			if err != nil {
+				return nil, common.NewErrorf("invalid recipient %q: %v", item, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, common.NewError("no recipients given")
+	}
+	return recipients, nil
+}
+
+// AgeEncrypt encrypts data with age for a passphrase, or for public keys if the passphrase is
+// empty.
+func AgeEncrypt(data []byte, passphrase string, recipientKeys string) ([]byte, error) {
+	var recipients []age.Recipient
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	} else {
+		var err error
+		if recipients, err = ParseAgeRecipients(recipientKeys); err != nil {
+			return nil, err
+		}
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// AgeDecrypt decrypts an age encrypted file with a key, which is either an age identity
+// ("AGE-SECRET-KEY-1..."), possibly in a file of several, an SSH private key or a passphrase.
+func AgeDecrypt(data []byte, key string) ([]byte, error) {
+	identities, err := parseAgeIdentities(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func parseAgeIdentities(key string) ([]age.Identity, error) {
+	trimmed := strings.TrimSpace(key)
+	switch {
+	case trimmed == "":
+		return nil, common.NewError("the file is encrypted, a key is required")
+	case strings.Contains(trimmed, "AGE-SECRET-KEY-"):
+		return age.ParseIdentities(strings.NewReader(trimmed))
+	case strings.HasPrefix(trimmed, "-----BEGIN"):
+		identity, err := agessh.ParseIdentity([]byte(trimmed + "\n"))
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	default:
+		// Passphrases are taken as they are, surrounding spaces included
+		identity, err := age.NewScryptIdentity(key)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+}
@@ -0,0 +1,174 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ipRange is a range of addresses in 16 byte form that belongs to a country.
+type ipRange struct {
+	start   [16]byte
+	end     [16]byte
+	country string
+}
+
+// datReader resolves countries from an Xray geoip.dat file.
+type datReader struct {
+	ranges []ipRange
+}
+
+// openDat loads the country ranges of an Xray geoip.dat file. Entries that are not
+// countries, such as "private" or provider lists, are left out.
+func openDat(path string) (*datReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &datReader{}
+	// GeoIPList: repeated GeoIP entry = 1
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, common.NewError("invalid geoip data:", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if num != 1 || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, common.NewError("invalid geoip data:", protowire.ParseError(n))
+			}
+			data = data[n:]
+			continue
+		}
+		entry, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, common.NewError("invalid geoip data:", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if err := r.addEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(r.ranges, func(i, j int) bool {
+		return bytes.Compare(r.ranges[i].start[:], r.ranges[j].start[:]) < 0
+	})
+	return r, nil
+}
+
+// addEntry parses a GeoIP message: string country_code = 1, repeated CIDR cidr = 2.
+func (r *datReader) addEntry(data []byte) error {
+	country := ""
+	var cidrs [][]byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == 1 {
+			country = string(value)
+		} else {
+			cidrs = append(cidrs, value)
+		}
+	}
+	if len(country) != 2 {
+		return nil
+	}
+	country = strings.ToUpper(country)
+
+	for _, cidr := range cidrs {
+		ip, prefix, err := parseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		bits := 128
+		if len(ip) == net.IPv4len {
+			prefix += 96
+		}
+		ip16 := ip.To16()
+		mask := net.CIDRMask(int(prefix), bits)
+		var rng ipRange
+		for i := range 16 {
+			rng.start[i] = ip16[i] & mask[i]
+			rng.end[i] = ip16[i] | ^mask[i]
+		}
+		rng.country = country
+		r.ranges = append(r.ranges, rng)
+	}
+	return nil
+}
+
+// parseCIDR parses a CIDR message: bytes ip = 1, uint32 prefix = 2.
+func parseCIDR(data []byte) (net.IP, uint64, error) {
+	var ip net.IP
+	var prefix uint64
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, 0, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, 0, protowire.ParseError(n)
+			}
+			ip = net.IP(value)
+			data = data[n:]
+		case num == 2 && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, 0, protowire.ParseError(n)
+			}
+			prefix = value
+			data = data[n:]
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, 0, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return nil, 0, common.NewError("invalid geoip CIDR")
+	}
+	return ip, prefix, nil
+}
+
+// country returns the country of the IP, or an empty string if it is unknown.
+func (r *datReader) country(ip net.IP) string {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	// Find the last range starting at or before the IP
+	i := sort.Search(len(r.ranges), func(i int) bool {
+		return bytes.Compare(r.ranges[i].start[:], ip16) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(r.ranges[i].end[:], ip16) < 0 {
+		return ""
+	}
+	return r.ranges[i].country
+}
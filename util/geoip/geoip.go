@@ -0,0 +1,158 @@
+// Package geoip resolves the country and autonomous system of IP addresses from local
+// databases: the geoip.dat file Xray downloads or MaxMind DB (MMDB) files.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Info holds what is known about an IP address. Unknown fields are left empty.
+type Info struct {
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"asOrg,omitempty"`
+}
+
+// String formats the info for display, e.g. "1.2.3.4 (DE, AS3320 Deutsche Telekom AG)".
+func (i Info) String() string {
+	details := []string{}
+	if i.Country != "" {
+		details = append(details, i.Country)
+	}
+	if i.ASN != 0 {
+		details = append(details, strings.TrimSpace(fmt.Sprintf("AS%d %s", i.ASN, i.ASOrg)))
+	}
+	if len(details) == 0 {
+		return i.IP
+	}
+	return i.IP + " (" + strings.Join(details, ", ") + ")"
+}
+
+// source is a loaded database file together with the modification time it was loaded at.
+type source[T any] struct {
+	path    string
+	modTime time.Time
+	reader  T
+}
+
+// Resolver looks up IPs in a country database and an optional ASN database.
+// Databases are loaded on first use and reloaded when their files change.
+type Resolver struct {
+	mu      sync.Mutex
+	dat     source[*datReader]
+	country source[*mmdbReader]
+	asn     source[*mmdbReader]
+}
+
+var defaultResolver = &Resolver{}
+
+// Lookup resolves the IP with the default resolver. countryPath is either a geoip.dat or
+// an MMDB file, asnPath an optional MMDB file. Combined MMDB files may serve both.
+func Lookup(ip string, countryPath string, asnPath string) Info {
+	return defaultResolver.Lookup(ip, countryPath, asnPath)
+}
+
+// Lookup resolves the country and autonomous system of the IP.
+func (r *Resolver) Lookup(ip string, countryPath string, asnPath string) Info {
+	info := Info{IP: ip}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return info
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if strings.HasSuffix(strings.ToLower(countryPath), ".dat") {
+		if reader := load(&r.dat, countryPath, openDat); reader != nil {
+			info.Country = reader.country(parsed)
+		}
+	} else if reader := load(&r.country, countryPath, openMMDB); reader != nil {
+		if record, err := reader.lookup(parsed); err == nil {
+			fillInfo(&info, record)
+		}
+	}
+	if reader := load(&r.asn, asnPath, openMMDB); reader != nil {
+		if record, err := reader.lookup(parsed); err == nil {
+			fillInfo(&info, record)
+		}
+	}
+	return info
+}
+
+// load returns the reader of the source, (re)opening the file if the path or its modification time changed.
+func load[T any](s *source[T], path string, open func(string) (T, error)) T {
+	var zero T
+	if path == "" {
+		s.reader, s.path = zero, ""
+		return zero
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		s.reader, s.path = zero, ""
+		return zero
+	}
+	if s.path != path || !s.modTime.Equal(stat.ModTime()) {
+		// A file that fails to open is not retried until it changes
+		reader, err := open(path)
+		if err != nil {
+			reader = zero
+		}
+		s.path, s.modTime, s.reader = path, stat.ModTime(), reader
+	}
+	return s.reader
+}
+
+// fillInfo copies the known fields of a GeoLite2 or ipinfo style record into the info.
+func fillInfo(info *Info, record map[string]any) {
+	if record == nil {
+		return
+	}
+	if info.Country == "" {
+		if country, ok := record["country"].(map[string]any); ok {
+			info.Country, _ = country["iso_code"].(string)
+		} else if code, ok := record["country_code"].(string); ok {
+			info.Country = code
+		}
+	}
+	if info.ASN == 0 {
+		if asn, ok := record["autonomous_system_number"].(uint64); ok {
+			info.ASN = uint(asn)
+			info.ASOrg, _ = record["autonomous_system_organization"].(string)
+		} else if asn, ok := record["asn"].(string); ok {
+			if n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32); err == nil {
+				info.ASN = uint(n)
+				info.ASOrg, _ = record["as_name"].(string)
+			}
+		}
+	}
+}
+
+// GroupKey returns the key under which the IP is counted for IP limits. With the "subnet"
+// grouping, IPs of the same /24 (IPv4) or /64 (IPv6) share a key; with "asn", IPs of the same
+// autonomous system do. Other groupings, and IPs whose ASN is unknown, are counted individually.
+func GroupKey(info Info, grouping string) string {
+	switch grouping {
+	case "subnet":
+		ip := net.ParseIP(info.IP)
+		if ip == nil {
+			break
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	case "asn":
+		if info.ASN != 0 {
+			return "AS" + strconv.FormatUint(uint64(info.ASN), 10)
+		}
+	}
+	return info.IP
+}
@@ -0,0 +1,280 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth bounds how deeply maps and arrays may nest, so a crafted file can't exhaust the stack.
+const mmdbMaxDepth = 512
+
+// mmdbReader is a minimal reader for MaxMind DB files, such as GeoLite2 or ipinfo databases.
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+// openMMDB loads a MaxMind DB file into memory.
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, common.NewError("invalid MaxMind DB file:", path)
+	}
+	metaStart := uint(idx + len(mmdbMetadataMarker))
+	meta, _, err := (&mmdbReader{buf: buf}).decode(metaStart, metaStart, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := meta.(map[string]any)
+	if !ok {
+		return nil, common.NewError("invalid MaxMind DB metadata:", path)
+	}
+
+	r := &mmdbReader{
+		buf:        buf,
+		nodeCount:  uint(toUint(metadata["node_count"])),
+		recordSize: uint(toUint(metadata["record_size"])),
+		ipVersion:  uint(toUint(metadata["ip_version"])),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, common.NewError("unsupported MaxMind DB record size:", r.recordSize)
+	}
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	r.dataStart = treeSize + 16
+	if r.dataStart > uint(len(buf)) {
+		return nil, common.NewError("invalid MaxMind DB search tree:", path)
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// lookup returns the record of the IP, or nil if the database has none.
+func (r *mmdbReader) lookup(ip net.IP) (map[string]any, error) {
+	var addr net.IP
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 6 {
+		addr = ip.To16()
+	} else {
+		return nil, nil
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+		node = r.readRecord(node, uint(bit))
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	offset := node - r.nodeCount - 16 + r.dataStart
+	value, _, err := r.decode(offset, r.dataStart, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (r *mmdbReader) readRecord(node uint, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		o := bit * 3
+		return uint(b[o])<<16 | uint(b[o+1])<<8 | uint(b[o+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		o := bit * 4
+		return uint(binary.BigEndian.Uint32(b[o : o+4]))
+	}
+}
+
+// decode decodes the data field at offset and returns it with the offset after it.
+// Pointers are resolved relative to base, depth is the nesting level of the field.
+func (r *mmdbReader) decode(offset uint, base uint, depth int) (any, uint, error) {
+	if offset >= uint(len(r.buf)) {
+		return nil, 0, common.NewError("MaxMind DB offset out of range")
+	}
+	if depth > mmdbMaxDepth {
+		return nil, 0, common.NewError("MaxMind DB data nested too deeply")
+	}
+	ctrl := r.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == 1 {
+		// Pointer
+		ss := uint(ctrl>>3) & 0x3
+		vvv := uint(ctrl & 0x7)
+		if offset+ss+1 > uint(len(r.buf)) {
+			return nil, 0, common.NewError("MaxMind DB pointer out of range")
+		}
+		b := r.buf[offset : offset+ss+1]
+		var ptr uint
+		switch ss {
+		case 0:
+			ptr = vvv<<8 | uint(b[0])
+		case 1:
+			ptr = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			ptr = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			ptr = uint(binary.BigEndian.Uint32(b))
+		}
+		target := base + ptr
+		if target >= uint(len(r.buf)) {
+			return nil, 0, common.NewError("MaxMind DB pointer out of range")
+		}
+		// The format forbids pointers to pointers, following them could loop forever
+		if r.buf[target]>>5 == 1 {
+			return nil, 0, common.NewError("MaxMind DB pointer points to a pointer")
+		}
+		value, _, err := r.decode(target, base, depth)
+		return value, offset + ss + 1, err
+	}
+
+	if typ == 0 {
+		if offset >= uint(len(r.buf)) {
+			return nil, 0, common.NewError("MaxMind DB offset out of range")
+		}
+		typ = 7 + uint(r.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(r.buf)) {
+			return nil, 0, common.NewError("MaxMind DB size out of range")
+		}
+		b := r.buf[offset : offset+n]
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+		offset += n
+	}
+	// Every map entry and array element takes at least one byte
+	if (typ == 7 || typ == 11) && size > uint(len(r.buf))-offset {
+		return nil, 0, common.NewError("MaxMind DB container size out of range")
+	}
+
+	switch typ {
+	case 7:
+		// Map
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := r.decode(offset, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := r.decode(next, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if k, ok := key.(string); ok {
+				m[k] = value
+			}
+			offset = next
+		}
+		return m, offset, nil
+	case 11:
+		// Array
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := r.decode(offset, base, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14:
+		// Boolean, the value is the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(r.buf)) {
+		return nil, 0, common.NewError("MaxMind DB value out of range")
+	}
+	b := r.buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, common.NewError("invalid MaxMind DB double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, common.NewError("invalid MaxMind DB float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 9, 10:
+		// Unsigned integers, uint128 values are truncated to 64 bits
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	default:
+		// Bytes and the container types the reader doesn't need
+		return b, offset, nil
+	}
+}
+
+func toUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		return uint64(n)
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+// Package netutil provides helpers for matching IP addresses against lists of networks.
+package netutil
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// ParseCIDRList parses a comma or newline separated list of networks in CIDR notation.
+// Plain IP addresses are accepted as single-address networks.
+func ParseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' '
+	}) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, common.NewError("invalid IP address:", entry)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, common.NewError("invalid CIDR:", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Contains reports whether the IP belongs to one of the networks.
+func Contains(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
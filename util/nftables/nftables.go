@@ -0,0 +1,100 @@
+// Package nftables manages the nftables sets the panel uses to block client IPs.
+// It drives the nft command line tool, so nftables must be installed on the host.
+package nftables
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// TableName is the nftables table owned by the panel.
+	TableName = "x-ui"
+
+	bannedSetV4 = "banned_ipv4"
+	bannedSetV6 = "banned_ipv6"
+)
+
+// tableScript creates the table with one timeout set per address family and an input chain dropping their members.
+var tableScript = fmt.Sprintf(`table inet %[1]s {
+	set %[2]s {
+		type ipv4_addr
+		flags timeout
+	}
+	set %[3]s {
+		type ipv6_addr
+		flags timeout
+	}
+	chain input {
+		type filter hook input priority filter - 5; policy accept;
+		ip saddr @%[2]s drop
+		ip6 saddr @%[3]s drop
+	}
+}
+`, TableName, bannedSetV4, bannedSetV6)
+
+// IsAvailable reports whether the nft tool is installed.
+func IsAvailable() bool {
+	_, err := exec.LookPath("nft")
+	return err == nil
+}
+
+// EnsureTable creates the panel table if it doesn't exist yet.
+func EnsureTable() error {
+	if exec.Command("nft", "list", "table", "inet", TableName).Run() == nil {
+		return nil
+	}
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(tableScript)
+	return run(cmd)
+}
+
+// BanIP adds the IP to the banned set. nftables drops the element by itself once the timeout elapses.
+func BanIP(ip string, timeout time.Duration) error {
+	set, ip, err := setFor(ip)
+	if err != nil {
+		return err
+	}
+	if err = EnsureTable(); err != nil {
+		return err
+	}
+	seconds := max(int(timeout.Seconds()), 1)
+	element := fmt.Sprintf("{ %s timeout %ds }", ip, seconds)
+	return run(exec.Command("nft", "add", "element", "inet", TableName, set, element))
+}
+
+// UnbanIP removes the IP from the banned set. Removing an IP that is not banned is not an error.
+func UnbanIP(ip string) error {
+	set, ip, err := setFor(ip)
+	if err != nil {
+		return err
+	}
+	if exec.Command("nft", "get", "element", "inet", TableName, set, "{ "+ip+" }").Run() != nil {
+		return nil
+	}
+	return run(exec.Command("nft", "delete", "element", "inet", TableName, set, "{ "+ip+" }"))
+}
+
+// setFor returns the set for the IP's address family and the IP in canonical form.
+// IPv4-mapped IPv6 addresses go to the IPv4 set, as that is how their packets arrive.
+func setFor(ip string) (string, string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", fmt.Errorf("invalid IP address: %s", ip)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return bannedSetV4, v4.String(), nil
+	}
+	return bannedSetV6, parsed.String(), nil
+}
+
+func run(cmd *exec.Cmd) error {
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package notify delivers panel notifications to external channels such as email,
+// generic webhooks, Discord and Slack compatible webhooks and Matrix rooms.
+package notify
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// Channel types.
+const (
+	Telegram = "telegram"
+	SMTP     = "smtp"
+	Webhook  = "webhook"
+	Discord  = "discord"
+	Slack    = "slack"
+	Matrix   = "matrix"
+)
+
+// Message is a notification sent to a channel.
+type Message struct {
+	Event       string       // Event type, such as "login" or "backup"
+	Title       string       // Short subject line
+	Text        string       // Body, may contain the Telegram HTML subset
+	Attachments []Attachment // Files, dropped by channels that can't carry them
+	ReplyMarkup any          // Keyboard shown by Telegram channels, ignored by others
+	To          []string     // Recipients replacing those of email channels, ignored by others
+
+	// Localize renders the text and keyboard in another language, for channels that know
+	// the language of their recipients. Optional.
+	Localize func(lang string) (text string, replyMarkup any)
+}
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Sender delivers messages to a channel.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// httpClient is used by all HTTP based channels.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// New creates the sender of a channel type from its JSON config. Telegram
+// channels are not handled here, as they go through the panel's bot.
+func New(kind string, config string) (Sender, error) {
+	var sender Sender
+	switch kind {
+	case SMTP:
+		sender = &SMTPSender{}
+	case Webhook:
+		sender = &WebhookSender{}
+	case Discord:
+		sender = &DiscordSender{}
+	case Slack:
+		sender = &SlackSender{}
+	case Matrix:
+		sender = &MatrixSender{}
+	default:
+		return nil, common.NewError("unknown notification channel type:", kind)
+	}
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), sender); err != nil {
+			return nil, common.NewError("invalid notification channel config:", err)
+		}
+	}
+	if v, ok := sender.(interface{ validate() error }); ok {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return sender, nil
+}
+
+var (
+	breakTagRegex = regexp.MustCompile(`(?i)<br\s*/?>`)
+	tagRegex      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// PlainText strips the HTML markup of a message and normalizes its line breaks.
+func PlainText(text string) string {
+	text = breakTagRegex.ReplaceAllString(text, "\n")
+	text = tagRegex.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// SMTPSender sends messages as email.
+type SMTPSender struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Security string `json:"security"` // "starttls" (default), "tls" or "none"
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	To       string `json:"to"` // Comma separated recipients
+}
+
+func (s *SMTPSender) validate() error {
+	if s.Host == "" || s.From == "" || len(s.recipients()) == 0 {
+		return common.NewError("SMTP host, sender and recipients are required")
+	}
+	return nil
+}
+
+func (s *SMTPSender) recipients() []string {
+	var to []string
+	for _, addr := range strings.Split(s.To, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// Send sends the message to all recipients, with its attachments.
+func (s *SMTPSender) Send(msg Message) error {
+	port := s.Port
+	if port == 0 {
+		port = 587
+		if s.Security == "tls" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if s.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.Security != "tls" && s.Security != "none" {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(s.From); err != nil {
+		return err
+	}
+	to := s.recipients()
+	if len(msg.To) > 0 {
+		to = msg.To
+	}
+	for _, rcpt := range to {
+		if err = client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(s.buildMail(msg, to)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMail builds a MIME multipart mail with a plain text body and the attachments.
+func (s *SMTPSender) buildMail(msg Message, to []string) []byte {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	part, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	writeBase64(part, []byte(PlainText(msg.Text)))
+
+	for _, attachment := range msg.Attachments {
+		part, _ = writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		})
+		writeBase64(part, attachment.Data)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+// writeBase64 writes the data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// WebhookSender posts messages as JSON to an arbitrary URL.
+type WebhookSender struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (s *WebhookSender) validate() error {
+	return validateURL(s.URL)
+}
+
+// Send posts the event, title, plain text and time of the message. Attachments are left out.
+func (s *WebhookSender) Send(msg Message) error {
+	return postJSON(s.URL, s.Headers, map[string]any{
+		"event": msg.Event,
+		"title": msg.Title,
+		"text":  PlainText(msg.Text),
+		"time":  time.Now().Unix(),
+	})
+}
+
+// DiscordSender posts messages to a Discord webhook.
+type DiscordSender struct {
+	URL string `json:"url"`
+}
+
+func (s *DiscordSender) validate() error {
+	return validateURL(s.URL)
+}
+
+// discordMaxContent is the maximum message length accepted by Discord.
+const discordMaxContent = 2000
+
+// Send posts the message, uploading its attachments as files.
+func (s *DiscordSender) Send(msg Message) error {
+	content := "**" + msg.Title + "**\n" + PlainText(msg.Text)
+	if len(content) > discordMaxContent {
+		content = content[:discordMaxContent-3] + "..."
+	}
+	if len(msg.Attachments) == 0 {
+		return postJSON(s.URL, nil, map[string]any{"content": content})
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	payload, _ := json.Marshal(map[string]any{"content": content})
+	writer.WriteField("payload_json", string(payload))
+	for i, attachment := range msg.Attachments {
+		part, err := writer.CreateFormFile("files["+strconv.Itoa(i)+"]", attachment.Name)
+		if err != nil {
+			return err
+		}
+		part.Write(attachment.Data)
+	}
+	writer.Close()
+	return post(s.URL, writer.FormDataContentType(), nil, &body)
+}
+
+// SlackSender posts messages to a Slack compatible incoming webhook, such as Slack or Mattermost.
+type SlackSender struct {
+	URL string `json:"url"`
+}
+
+func (s *SlackSender) validate() error {
+	return validateURL(s.URL)
+}
+
+// Send posts the message. Incoming webhooks can't carry files, so attachments are left out.
+func (s *SlackSender) Send(msg Message) error {
+	return postJSON(s.URL, nil, map[string]any{"text": "*" + msg.Title + "*\n" + PlainText(msg.Text)})
+}
+
+// MatrixSender sends messages to a Matrix room.
+type MatrixSender struct {
+	Homeserver  string `json:"homeserver"`
+	AccessToken string `json:"accessToken"`
+	RoomId      string `json:"roomId"`
+}
+
+func (s *MatrixSender) validate() error {
+	if s.AccessToken == "" || s.RoomId == "" {
+		return common.NewError("Matrix access token and room are required")
+	}
+	return validateURL(s.Homeserver)
+}
+
+// Send sends the message as a text event. Attachments are left out.
+func (s *MatrixSender) Send(msg Message) error {
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%d",
+		strings.TrimRight(s.Homeserver, "/"), url.PathEscape(s.RoomId), time.Now().UnixNano())
+	payload, err := json.Marshal(map[string]any{
+		"msgtype": "m.text",
+		"body":    msg.Title + "\n" + PlainText(msg.Text),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	return do(req)
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return common.NewError("invalid notification URL:", rawURL)
+	}
+	return nil
+}
+
+func postJSON(url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(url, "application/json", headers, bytes.NewReader(body))
+}
+
+func post(url string, contentType string, headers map[string]string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return do(req)
+}
+
+// do sends the request and turns non-2xx responses into errors.
+func do(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ const Protocols = {
     MIXED: 'mixed',
     HTTP: 'http',
     WIREGUARD: 'wireguard',
+    HYSTERIA2: 'hysteria2',
+    TUIC: 'tuic',
 };
 
 const SSMethods = {
@@ -1071,6 +1073,8 @@ class Inbound extends XrayCommonClass {
             case Protocols.VLESS: return this.settings.vlesses;
             case Protocols.TROJAN: return this.settings.trojans;
             case Protocols.SHADOWSOCKS: return this.isSSMultiUser ? this.settings.shadowsockses : null;
+            case Protocols.HYSTERIA2: return this.settings.hysterias;
+            case Protocols.TUIC: return this.settings.tuics;
             default: return null;
         }
     }
@@ -1085,6 +1089,14 @@ class Inbound extends XrayCommonClass {
         if (protocol === Protocols.TROJAN) {
             this.tls = false;
         }
+        if (this.isSingBox) {
+            this.stream.security = 'tls';
+        }
+    }
+
+    // Inbounds served by sing-box run over QUIC and only use the TLS part of the stream settings
+    get isSingBox() {
+        return [Protocols.HYSTERIA2, Protocols.TUIC].includes(this.protocol);
     }
 
     get network() {
@@ -1194,6 +1206,7 @@ class Inbound extends XrayCommonClass {
     }
 
     canEnableTls() {
+        if (this.isSingBox) return true;
         if (![Protocols.VMESS, Protocols.VLESS, Protocols.TROJAN, Protocols.SHADOWSOCKS].includes(this.protocol)) return false;
         return ["tcp", "ws", "http", "grpc", "httpupgrade", "xhttp"].includes(this.network);
     }
@@ -1601,6 +1614,44 @@ class Inbound extends XrayCommonClass {
         return txt;
     }
 
+    genQuicLink(address = '', port = this.port, remark = '', client) {
+        if (!this.isSingBox) {
+            return '';
+        }
+        const params = new Map();
+        if (this.stream.isTls) {
+            if (!ObjectUtil.isEmpty(this.stream.tls.sni)) {
+                params.set("sni", this.stream.tls.sni);
+            }
+            params.set("alpn", this.stream.tls.alpn.length > 0 ? this.stream.tls.alpn : "h3");
+            if (this.stream.tls.settings.allowInsecure) {
+                params.set("insecure", "1");
+            }
+        }
+
+        let userInfo;
+        if (this.protocol === Protocols.TUIC) {
+            userInfo = `${encodeURIComponent(client.id)}:${encodeURIComponent(client.password)}`;
+            if (!ObjectUtil.isEmpty(this.settings.congestionControl)) {
+                params.set("congestion_control", this.settings.congestionControl);
+            }
+        } else {
+            userInfo = encodeURIComponent(client.password);
+            if (!ObjectUtil.isEmpty(this.settings.obfsPassword)) {
+                params.set("obfs", "salamander");
+                params.set("obfs-password", this.settings.obfsPassword);
+            }
+        }
+
+        const link = `${this.protocol}://${userInfo}@${address}:${port}`;
+        const url = new URL(link);
+        for (const [key, value] of params) {
+            url.searchParams.set(key, value)
+        }
+        url.hash = encodeURIComponent(remark);
+        return url.toString();
+    }
+
     genLink(address = '', port = this.port, forceTls = 'same', remark = '', client) {
         switch (this.protocol) {
             case Protocols.VMESS:
@@ -1611,6 +1662,9 @@ class Inbound extends XrayCommonClass {
                 return this.genSSLink(address, port, forceTls, remark, this.isSSMultiUser ? client.password : '');
             case Protocols.TROJAN:
                 return this.genTrojanLink(address, port, forceTls, remark, client.password);
+            case Protocols.HYSTERIA2:
+            case Protocols.TUIC:
+                return this.genQuicLink(address, port, remark, client);
             default: return '';
         }
     }
@@ -1684,7 +1738,7 @@ class Inbound extends XrayCommonClass {
 
     toJson() {
         let streamSettings;
-        if (this.canEnableStream() || this.stream?.sockopt) {
+        if (this.canEnableStream() || this.isSingBox || this.stream?.sockopt) {
             streamSettings = this.stream.toJson();
         }
         return {
@@ -1716,6 +1770,8 @@ Inbound.Settings = class extends XrayCommonClass {
             case Protocols.MIXED: return new Inbound.MixedSettings(protocol);
             case Protocols.HTTP: return new Inbound.HttpSettings(protocol);
             case Protocols.WIREGUARD: return new Inbound.WireguardSettings(protocol);
+            case Protocols.HYSTERIA2: return new Inbound.Hysteria2Settings(protocol);
+            case Protocols.TUIC: return new Inbound.TuicSettings(protocol);
             default: return null;
         }
     }
@@ -1730,6 +1786,8 @@ Inbound.Settings = class extends XrayCommonClass {
             case Protocols.MIXED: return Inbound.MixedSettings.fromJson(json);
             case Protocols.HTTP: return Inbound.HttpSettings.fromJson(json);
             case Protocols.WIREGUARD: return Inbound.WireguardSettings.fromJson(json);
+            case Protocols.HYSTERIA2: return Inbound.Hysteria2Settings.fromJson(json);
+            case Protocols.TUIC: return Inbound.TuicSettings.fromJson(json);
             default: return null;
         }
     }
@@ -1792,7 +1850,9 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        outboundTag = '',
+        balancerTag = ''
     ) {
         super();
         this.id = id;
@@ -1808,6 +1868,8 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.outboundTag = outboundTag;
+        this.balancerTag = balancerTag;
     }
 
     static fromJson(json = {}) {
@@ -1825,6 +1887,8 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.outboundTag,
+            json.balancerTag,
         );
     }
     get _expiryTime() {
@@ -1932,7 +1996,9 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        outboundTag = '',
+        balancerTag = ''
     ) {
         super();
         this.id = id;
@@ -1948,6 +2014,8 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.outboundTag = outboundTag;
+        this.balancerTag = balancerTag;
     }
 
     static fromJson(json = {}) {
@@ -1965,6 +2033,8 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.outboundTag,
+            json.balancerTag,
         );
     }
 
@@ -2077,7 +2147,9 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        outboundTag = '',
+        balancerTag = ''
     ) {
         super();
         this.password = password;
@@ -2092,6 +2164,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.outboundTag = outboundTag;
+        this.balancerTag = balancerTag;
     }
 
     toJson() {
@@ -2108,6 +2182,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            outboundTag: this.outboundTag,
+            balancerTag: this.balancerTag,
         };
     }
 
@@ -2125,6 +2201,8 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.outboundTag,
+            json.balancerTag,
         );
     }
 
@@ -2194,6 +2272,106 @@ Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
     }
 };
 
+Inbound.Hysteria2Settings = class extends Inbound.Settings {
+    constructor(protocol,
+        hysterias = [new Inbound.Hysteria2Settings.Hysteria2()],
+        upMbps = 0,
+        downMbps = 0,
+        ignoreClientBandwidth = false,
+        obfsPassword = '',
+        masquerade = '',
+    ) {
+        super(protocol);
+        this.hysterias = hysterias;
+        this.upMbps = upMbps;
+        this.downMbps = downMbps;
+        this.ignoreClientBandwidth = ignoreClientBandwidth;
+        this.obfsPassword = obfsPassword;
+        this.masquerade = masquerade;
+    }
+
+    static fromJson(json = {}) {
+        return new Inbound.Hysteria2Settings(
+            Protocols.HYSTERIA2,
+            json.clients.map(client => Inbound.Hysteria2Settings.Hysteria2.fromJson(client)),
+            json.upMbps,
+            json.downMbps,
+            json.ignoreClientBandwidth,
+            json.obfsPassword,
+            json.masquerade,
+        );
+    }
+
+    toJson() {
+        return {
+            clients: Inbound.Hysteria2Settings.toJsonArray(this.hysterias),
+            upMbps: this.upMbps,
+            downMbps: this.downMbps,
+            ignoreClientBandwidth: this.ignoreClientBandwidth,
+            obfsPassword: this.obfsPassword,
+            masquerade: this.masquerade,
+        };
+    }
+};
+
+// Hysteria2 clients authenticate by password like Trojan clients
+Inbound.Hysteria2Settings.Hysteria2 = class extends Inbound.TrojanSettings.Trojan {
+    static fromJson(json = {}) {
+        return Object.assign(new Inbound.Hysteria2Settings.Hysteria2(), Inbound.TrojanSettings.Trojan.fromJson(json));
+    }
+};
+
+Inbound.TuicSettings = class extends Inbound.Settings {
+    constructor(protocol,
+        tuics = [new Inbound.TuicSettings.TUIC()],
+        congestionControl = 'bbr',
+        zeroRttHandshake = false,
+    ) {
+        super(protocol);
+        this.tuics = tuics;
+        this.congestionControl = congestionControl;
+        this.zeroRttHandshake = zeroRttHandshake;
+    }
+
+    static fromJson(json = {}) {
+        return new Inbound.TuicSettings(
+            Protocols.TUIC,
+            json.clients.map(client => Inbound.TuicSettings.TUIC.fromJson(client)),
+            json.congestionControl,
+            json.zeroRttHandshake,
+        );
+    }
+
+    toJson() {
+        return {
+            clients: Inbound.TuicSettings.toJsonArray(this.tuics),
+            congestionControl: this.congestionControl,
+            zeroRttHandshake: this.zeroRttHandshake,
+        };
+    }
+};
+
+// TUIC clients authenticate by UUID and password
+Inbound.TuicSettings.TUIC = class extends Inbound.TrojanSettings.Trojan {
+    constructor(id = RandomUtil.randomUUID(), ...args) {
+        super(...args);
+        this.id = id;
+    }
+
+    toJson() {
+        return {
+            id: this.id,
+            ...super.toJson(),
+        };
+    }
+
+    static fromJson(json = {}) {
+        const client = Object.assign(new Inbound.TuicSettings.TUIC(), Inbound.TrojanSettings.Trojan.fromJson(json));
+        client.id = json.id;
+        return client;
+    }
+};
+
 Inbound.ShadowsocksSettings = class extends Inbound.Settings {
     constructor(protocol,
         method = SSMethods.BLAKE3_AES_256_GCM,
@@ -2246,7 +2424,9 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        outboundTag = '',
+        balancerTag = ''
     ) {
         super();
         this.method = method;
@@ -2262,6 +2442,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.outboundTag = outboundTag;
+        this.balancerTag = balancerTag;
     }
 
     toJson() {
@@ -2279,6 +2461,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            outboundTag: this.outboundTag,
+            balancerTag: this.balancerTag,
         };
     }
 
@@ -2297,6 +2481,8 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.outboundTag,
+            json.balancerTag,
         );
     }
 
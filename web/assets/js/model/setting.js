@@ -21,10 +21,43 @@ class AllSetting {
         this.tgRunTime = "@daily";
         this.tgBotBackup = false;
         this.tgBotLoginNotify = true;
-        this.tgCpu = 80;
+        this.tgBotSignup = false;
+        this.tgBotWebhook = false;
+        this.tgBotWebhookServer = "panel";
+        this.tgBotWebhookUrl = "";
+        this.clientWarnDays = "7,3,1";
+        this.clientWarnTraffic = "80,90,100";
+        this.clientWarnContact = "";
+        this.backupEnable = false;
+        this.backupCron = "@daily";
+        this.backupDir = "";
+        this.backupS3Endpoint = "";
+        this.backupS3Region = "us-east-1";
+        this.backupS3Bucket = "";
+        this.backupS3Prefix = "x-ui/";
+        this.backupS3AccessKey = "";
+        this.backupS3SecretKey = "";
+        this.backupS3PathStyle = true;
+        this.backupKeepLast = 7;
+        this.backupKeepDaily = 7;
+        this.backupKeepWeekly = 4;
+        this.backupEncryption = "none";
+        this.backupPassphrase = "";
+        this.backupRecipients = "";
         this.tgLang = "en-US";
         this.twoFactorEnable = false;
         this.twoFactorToken = "";
+        this.ipLimitMode = "fail2ban";
+        this.ipLimitBanDuration = 30;
+        this.ipLimitWindow = 5;
+        this.ipHistoryRetention = 24;
+        this.ipLimitGrouping = "ip";
+        this.ipGeoCountryDb = "";
+        this.ipGeoAsnDb = "";
+        this.loginMaxAttempts = 5;
+        this.loginLockDuration = 5;
+        this.panelAllowedIps = "";
+        this.trustedProxies = "127.0.0.1,::1";
         this.xrayTemplateConfig = "";
         this.subEnable = true;
         this.subJsonEnable = false;
@@ -41,6 +74,7 @@ class AllSetting {
         this.subUpdates = 12;
         this.subEncrypt = true;
         this.subShowInfo = true;
+        this.subRedeem = false;
         this.subURI = "";
         this.subJsonURI = "";
         this.subJsonFragment = "";
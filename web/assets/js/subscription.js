@@ -20,8 +20,17 @@
     uploadByte: parseInt(el.getAttribute('data-uploadbyte') || '0', 10) || 0,
     totalByte: parseInt(el.getAttribute('data-totalbyte') || '0', 10) || 0,
     datepicker: el.getAttribute('data-datepicker') || 'gregorian',
+    exits: el.getAttribute('data-exits') || '',
+    redeem: el.getAttribute('data-redeem') === 'true',
+    redeemSuccess: el.getAttribute('data-redeem-success') || 'Voucher redeemed',
+    redeemFailed: el.getAttribute('data-redeem-failed') || 'Unable to redeem the voucher',
+    emails: [],
   };
 
+  try {
+    data.emails = JSON.parse(el.getAttribute('data-emails') || '[]') || [];
+  } catch (e) { /* ignore */ }
+
   // Normalize lastOnline to milliseconds if it looks like seconds
   if (data.lastOnlineMs && data.lastOnlineMs < 10_000_000_000) {
     data.lastOnlineMs *= 1000;
@@ -93,6 +102,11 @@
       links: rawLinks,
       lang: '',
       viewportWidth: (typeof window !== 'undefined' ? window.innerWidth : 1024),
+      redeem: {
+        code: '',
+        email: data.emails[0] || '',
+        loading: false,
+      },
     },
     async mounted() {
       this.lang = LanguageManager.getLanguage();
@@ -152,6 +166,26 @@
       copy,
       open,
       linkName,
+      async redeemVoucher() {
+        if (!this.redeem.code || !this.redeem.email) return;
+        this.redeem.loading = true;
+        try {
+          const body = new URLSearchParams({ code: this.redeem.code, email: this.redeem.email });
+          const url = window.location.pathname.replace(/\/+$/, '') + '/redeem';
+          const resp = await fetch(url, { method: 'POST', body });
+          const msg = await resp.json();
+          if (msg.success) {
+            Vue.prototype.$message.success(this.app.redeemSuccess);
+            setTimeout(() => window.location.reload(), 1000);
+          } else {
+            Vue.prototype.$message.error(msg.msg || this.app.redeemFailed);
+          }
+        } catch (e) {
+          Vue.prototype.$message.error(this.app.redeemFailed);
+        } finally {
+          this.redeem.loading = false;
+        }
+      },
       i18nLabel(key) {
         return '{{ i18n "' + key + '" }}';
       },
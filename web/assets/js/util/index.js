@@ -635,6 +635,17 @@ class Utils {
             timeoutID = setTimeout(() => fn.apply(that, args), delay);
         };
     }
+
+    // Formats a client IP with its country and autonomous system, e.g. "1.2.3.4 (DE, AS3320 Deutsche Telekom AG)"
+    static formatIpInfo(info) {
+        if (typeof info === 'string') {
+            return info;
+        }
+        const details = [];
+        if (info.country) details.push(info.country);
+        if (info.asn) details.push(`AS${info.asn} ${info.asOrg || ''}`.trim());
+        return details.length > 0 ? `${info.ip} (${details.join(', ')})` : info.ip;
+    }
 }
 
 class CookieManager {
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AlertController handles the alert rules.
+type AlertController struct {
+	alertService service.AlertService
+}
+
+// NewAlertController creates a new AlertController and sets up its routes.
+func NewAlertController(g *gin.RouterGroup) *AlertController {
+	a := &AlertController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for alert rule operations.
+func (a *AlertController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getRules)
+	g.GET("/metrics", a.getMetrics)
+
+	g.POST("/add", a.addRule)
+	g.POST("/update/:id", a.updateRule)
+	g.POST("/del/:id", a.delRule)
+}
+
+// getRules retrieves all alert rules with their current state.
+func (a *AlertController) getRules(c *gin.Context) {
+	rules, err := a.alertService.GetRules()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+// getMetrics retrieves the metrics alert rules can watch.
+func (a *AlertController) getMetrics(c *gin.Context) {
+	jsonObj(c, a.alertService.GetMetrics(), nil)
+}
+
+// addRule creates a new alert rule.
+func (a *AlertController) addRule(c *gin.Context) {
+	rule := &model.AlertRule{}
+	if err := c.ShouldBind(rule); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.ruleSaved"), err)
+		return
+	}
+	err := a.alertService.AddRule(rule)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.alert.toasts.ruleSaved"), rule, err)
+}
+
+// updateRule updates an alert rule by its ID.
+func (a *AlertController) updateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.ruleSaved"), err)
+		return
+	}
+	rule := &model.AlertRule{}
+	if err := c.ShouldBind(rule); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.ruleSaved"), err)
+		return
+	}
+	rule.Id = id
+	err = a.alertService.UpdateRule(rule)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.alert.toasts.ruleSaved"), rule, err)
+}
+
+// delRule deletes an alert rule by its ID.
+func (a *AlertController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.ruleDeleted"), err)
+		return
+	}
+	err = a.alertService.DelRule(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.alert.toasts.ruleDeleted"), err)
+}
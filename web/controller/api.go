@@ -12,9 +12,18 @@ import (
 // APIController handles the main API routes for the 3x-ui panel, including inbounds and server management.
 type APIController struct {
 	BaseController
-	inboundController *InboundController
-	serverController  *ServerController
-	Tgbot             service.Tgbot
+	inboundController     *InboundController
+	serverController      *ServerController
+	notifyController      *NotifyController
+	alertController       *AlertController
+	signupController      *SignupController
+	voucherController     *VoucherController
+	broadcastController   *BroadcastController
+	backupController      *BackupController
+	transferController    *TransferController
+	nodeController        *NodeController
+	outboundSubController *OutboundSubscriptionController
+	Tgbot                 service.Tgbot
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -48,11 +57,47 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
 
+	// Notification channels API
+	notify := api.Group("/notify")
+	a.notifyController = NewNotifyController(notify)
+
+	// Alert rules API
+	alerts := api.Group("/alerts")
+	a.alertController = NewAlertController(alerts)
+
+	// Telegram signup API
+	signup := api.Group("/signup")
+	a.signupController = NewSignupController(signup)
+
+	// Vouchers API
+	vouchers := api.Group("/vouchers")
+	a.voucherController = NewVoucherController(vouchers)
+
+	// Broadcast API
+	broadcast := api.Group("/broadcast")
+	a.broadcastController = NewBroadcastController(broadcast)
+
+	// Backups API
+	backups := api.Group("/backups")
+	a.backupController = NewBackupController(backups)
+
+	// Export and import API
+	transfer := api.Group("/transfer")
+	a.transferController = NewTransferController(transfer)
+
+	// Nodes API
+	nodes := api.Group("/nodes")
+	a.nodeController = NewNodeController(nodes)
+
+	// Outbound subscriptions API
+	outboundSubs := api.Group("/outboundSubs")
+	a.outboundSubController = NewOutboundSubscriptionController(outboundSubs)
+
 	// Extra routes
 	api.GET("/backuptotgbot", a.BackuptoTgbot)
 }
 
-// BackuptoTgbot sends a backup of the panel data to Telegram bot admins.
+// BackuptoTgbot sends a backup of the panel data to the notification channels.
 func (a *APIController) BackuptoTgbot(c *gin.Context) {
 	a.Tgbot.SendBackupToAdmins()
 }
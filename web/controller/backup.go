@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BackupController handles the scheduled database backups and their history.
+type BackupController struct {
+	backupService service.BackupService
+}
+
+// NewBackupController creates a new BackupController and sets up its routes.
+func NewBackupController(g *gin.RouterGroup) *BackupController {
+	a := &BackupController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for backup operations.
+func (a *BackupController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getBackups)
+
+	g.POST("/run", a.run)
+}
+
+// getBackups retrieves the backup history.
+func (a *BackupController) getBackups(c *gin.Context) {
+	backups, err := a.backupService.GetBackups()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.backup.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, backups, nil)
+}
+
+// run writes a backup to the configured targets right away.
+func (a *BackupController) run(c *gin.Context) {
+	backups, err := a.backupService.Run(service.BackupTriggerManual)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.backup.toasts.written"), backups, err)
+}
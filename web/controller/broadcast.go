@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BroadcastController handles messages broadcast by the Telegram bot to the users of clients.
+type BroadcastController struct {
+	broadcastService service.BroadcastService
+}
+
+// NewBroadcastController creates a new BroadcastController and sets up its routes.
+func NewBroadcastController(g *gin.RouterGroup) *BroadcastController {
+	a := &BroadcastController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for broadcast operations.
+func (a *BroadcastController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getBroadcasts)
+
+	g.POST("/preview", a.preview)
+	g.POST("/send", a.send)
+}
+
+// getBroadcasts retrieves the latest broadcasts with their delivery counts.
+func (a *BroadcastController) getBroadcasts(c *gin.Context) {
+	broadcasts, err := a.broadcastService.GetBroadcasts()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.broadcast.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, broadcasts, nil)
+}
+
+// preview returns the number of Telegram users a broadcast would reach.
+func (a *BroadcastController) preview(c *gin.Context) {
+	req := &service.BroadcastRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.broadcast.toasts.obtain"), err)
+		return
+	}
+	recipients, err := a.broadcastService.GetRecipients(&req.BroadcastFilter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.broadcast.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, len(recipients), nil)
+}
+
+// send queues a broadcast to the Telegram users of the clients matching its filter.
+func (a *BroadcastController) send(c *gin.Context) {
+	req := &service.BroadcastRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.broadcast.toasts.queued"), err)
+		return
+	}
+	broadcast, err := a.broadcastService.Queue(req, service.BroadcastSourcePanel, 0)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.broadcast.toasts.queued"), broadcast, err)
+}
@@ -16,6 +16,7 @@ import (
 type InboundController struct {
 	inboundService service.InboundService
 	xrayService    service.XrayService
+	ipBanService   service.IpBanService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -37,7 +38,12 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/del/:id", a.delInbound)
 	g.POST("/update/:id", a.updateInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
+	g.POST("/clientIpInfos/:email", a.getClientIpInfos)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
+	g.POST("/clientIpHistory/:email", a.getClientIpHistory)
+	g.GET("/ipBans", a.getIpBans)
+	g.POST("/clearIpBan/:id", a.clearIpBan)
+	g.POST("/clearAllIpBans", a.clearAllIpBans)
 	g.POST("/addClient", a.addInboundClient)
 	g.POST("/:id/delClient/:clientId", a.delInboundClient)
 	g.POST("/updateClient/:clientId", a.updateInboundClient)
@@ -184,6 +190,31 @@ func (a *InboundController) getClientIps(c *gin.Context) {
 	jsonObj(c, ips, nil)
 }
 
+// getClientIpInfos retrieves the IP addresses of a client with their country and autonomous system.
+func (a *InboundController) getClientIpInfos(c *gin.Context) {
+	email := c.Param("email")
+
+	ips, err := a.inboundService.GetClientIpInfos(email)
+	if err != nil || len(ips) == 0 {
+		jsonObj(c, "No IP Record", nil)
+		return
+	}
+
+	jsonObj(c, ips, nil)
+}
+
+// getClientIpHistory retrieves the IP history of a client with first and last seen times.
+func (a *InboundController) getClientIpHistory(c *gin.Context) {
+	email := c.Param("email")
+
+	history, err := a.inboundService.GetClientIpHistory(email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
 // clearClientIps clears the IP addresses for a client by email.
 func (a *InboundController) clearClientIps(c *gin.Context) {
 	email := c.Param("email")
@@ -196,6 +227,47 @@ func (a *InboundController) clearClientIps(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.logCleanSuccess"), nil)
 }
 
+// getIpBans retrieves the clients and IPs currently banned for exceeding their IP limit.
+func (a *InboundController) getIpBans(c *gin.Context) {
+	bans, err := a.ipBanService.GetBans()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, bans, nil)
+}
+
+// clearIpBan lifts an IP limit ban by its ID.
+func (a *InboundController) clearIpBan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.ipLimit.banCleared"), err)
+		return
+	}
+	needRestart, err := a.ipBanService.ClearBan(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.ipLimit.banCleared"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// clearAllIpBans lifts all IP limit bans.
+func (a *InboundController) clearAllIpBans(c *gin.Context) {
+	needRestart, err := a.ipBanService.ClearAllBans()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.ipLimit.banCleared"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
 // addInboundClient adds a new client to an existing inbound.
 func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"text/template"
 	"time"
 
@@ -24,9 +26,10 @@ type LoginForm struct {
 type IndexController struct {
 	BaseController
 
-	settingService service.SettingService
-	userService    service.UserService
-	tgbot          service.Tgbot
+	settingService    service.SettingService
+	userService       service.UserService
+	loginLimitService service.LoginLimitService
+	tgbot             service.Tgbot
 }
 
 // NewIndexController creates a new IndexController and initializes its routes.
@@ -71,20 +74,30 @@ func (a *IndexController) login(c *gin.Context) {
 		return
 	}
 
+	remoteIp := getRemoteIp(c)
+	if lockedFor := a.loginLimitService.GetLockedFor(remoteIp, form.Username); lockedFor > 0 {
+		minutes := strconv.Itoa(int(math.Ceil(lockedFor.Minutes())))
+		logger.Warningf("login of \"%s\" from IP \"%s\" rejected, locked out for %s", template.HTMLEscapeString(form.Username), remoteIp, lockedFor.Round(time.Second))
+		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.lockedOut", "Minutes=="+minutes))
+		return
+	}
+
 	user := a.userService.CheckUser(form.Username, form.Password, form.TwoFactorCode)
 	timeStr := time.Now().Format("2006-01-02 15:04:05")
 	safeUser := template.HTMLEscapeString(form.Username)
 	safePass := template.HTMLEscapeString(form.Password)
 
 	if user == nil {
-		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
-		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
+		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, remoteIp)
+		a.loginLimitService.RecordFailure(remoteIp, form.Username)
+		a.tgbot.UserLoginNotify(safeUser, safePass, remoteIp, timeStr, 0)
 		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
 		return
 	}
 
-	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, getRemoteIp(c))
-	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+	a.loginLimitService.Reset(remoteIp, form.Username)
+	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, remoteIp)
+	a.tgbot.UserLoginNotify(safeUser, ``, remoteIp, timeStr, 1)
 
 	sessionMaxAge, err := a.settingService.GetSessionMaxAge()
 	if err != nil {
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeController handles the nodes the panel pushes its inbounds to.
+type NodeController struct {
+	nodeService service.NodeService
+}
+
+// NewNodeController creates a new NodeController and sets up its routes.
+func NewNodeController(g *gin.RouterGroup) *NodeController {
+	a := &NodeController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for node operations.
+func (a *NodeController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getNodes)
+
+	g.POST("/add", a.addNode)
+	g.POST("/update/:id", a.updateNode)
+	g.POST("/del/:id", a.delNode)
+	g.POST("/sync/:id", a.syncNode)
+}
+
+// getNodes retrieves all nodes with the status of their last sync.
+func (a *NodeController) getNodes(c *gin.Context) {
+	nodes, err := a.nodeService.GetNodes()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, nodes, nil)
+}
+
+// addNode creates a new node.
+func (a *NodeController) addNode(c *gin.Context) {
+	node := &model.Node{}
+	if err := c.ShouldBind(node); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeSaved"), err)
+		return
+	}
+	err := a.nodeService.AddNode(node)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.node.toasts.nodeSaved"), node, err)
+}
+
+// updateNode updates a node by its ID.
+func (a *NodeController) updateNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeSaved"), err)
+		return
+	}
+	node := &model.Node{}
+	if err := c.ShouldBind(node); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeSaved"), err)
+		return
+	}
+	node.Id = id
+	err = a.nodeService.UpdateNode(node)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.node.toasts.nodeSaved"), node, err)
+}
+
+// delNode deletes a node by its ID.
+func (a *NodeController) delNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeDeleted"), err)
+		return
+	}
+	err = a.nodeService.DelNode(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeDeleted"), err)
+}
+
+// syncNode syncs a node by its ID right away.
+func (a *NodeController) syncNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeSynced"), err)
+		return
+	}
+	err = a.nodeService.SyncNode(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.node.toasts.nodeSynced"), err)
+}
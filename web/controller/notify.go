@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotifyController handles the notification channels and their delivery failure log.
+type NotifyController struct {
+	notificationService service.NotificationService
+}
+
+// NewNotifyController creates a new NotifyController and sets up its routes.
+func NewNotifyController(g *gin.RouterGroup) *NotifyController {
+	a := &NotifyController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for notification channel operations.
+func (a *NotifyController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getChannels)
+	g.GET("/events", a.getEvents)
+	g.GET("/failures", a.getFailures)
+
+	g.POST("/add", a.addChannel)
+	g.POST("/update/:id", a.updateChannel)
+	g.POST("/del/:id", a.delChannel)
+	g.POST("/test/:id", a.testChannel)
+	g.POST("/clearFailures", a.clearFailures)
+}
+
+// getChannels retrieves all notification channels.
+func (a *NotifyController) getChannels(c *gin.Context) {
+	channels, err := a.notificationService.GetChannels()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, channels, nil)
+}
+
+// getEvents retrieves the event types channels can subscribe to.
+func (a *NotifyController) getEvents(c *gin.Context) {
+	jsonObj(c, service.NotifyEvents, nil)
+}
+
+// getFailures retrieves the notification delivery failure log.
+func (a *NotifyController) getFailures(c *gin.Context) {
+	failures, err := a.notificationService.GetFailures()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, failures, nil)
+}
+
+// addChannel creates a new notification channel.
+func (a *NotifyController) addChannel(c *gin.Context) {
+	channel := &model.NotifyChannel{}
+	if err := c.ShouldBind(channel); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.channelSaved"), err)
+		return
+	}
+	err := a.notificationService.AddChannel(channel)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.notify.toasts.channelSaved"), channel, err)
+}
+
+// updateChannel updates a notification channel by its ID.
+func (a *NotifyController) updateChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.channelSaved"), err)
+		return
+	}
+	channel := &model.NotifyChannel{}
+	if err := c.ShouldBind(channel); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.channelSaved"), err)
+		return
+	}
+	channel.Id = id
+	err = a.notificationService.UpdateChannel(channel)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.notify.toasts.channelSaved"), channel, err)
+}
+
+// delChannel deletes a notification channel by its ID.
+func (a *NotifyController) delChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.channelDeleted"), err)
+		return
+	}
+	err = a.notificationService.DelChannel(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.channelDeleted"), err)
+}
+
+// testChannel sends a test notification to a channel by its ID.
+func (a *NotifyController) testChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.testSent"), err)
+		return
+	}
+	err = a.notificationService.TestChannel(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.testSent"), err)
+}
+
+// clearFailures empties the notification delivery failure log.
+func (a *NotifyController) clearFailures(c *gin.Context) {
+	err := a.notificationService.ClearFailures()
+	jsonMsg(c, I18nWeb(c, "pages.settings.notify.toasts.failuresCleared"), err)
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutboundSubscriptionController handles the groups of outbounds built from share links and
+// remote subscriptions.
+type OutboundSubscriptionController struct {
+	outboundSubscriptionService service.OutboundSubscriptionService
+	xrayService                 service.XrayService
+}
+
+// NewOutboundSubscriptionController creates a new OutboundSubscriptionController and sets up its routes.
+func NewOutboundSubscriptionController(g *gin.RouterGroup) *OutboundSubscriptionController {
+	a := &OutboundSubscriptionController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for outbound subscription operations.
+func (a *OutboundSubscriptionController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getSubscriptions)
+
+	g.POST("/parse", a.parseLinks)
+	g.POST("/add", a.addSubscription)
+	g.POST("/update/:id", a.updateSubscription)
+	g.POST("/del/:id", a.delSubscription)
+	g.POST("/refresh/:id", a.refreshSubscription)
+}
+
+// getSubscriptions retrieves all outbound subscriptions with their outbounds.
+func (a *OutboundSubscriptionController) getSubscriptions(c *gin.Context) {
+	subs, err := a.outboundSubscriptionService.GetSubscriptions()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, subs, nil)
+}
+
+// parseLinks builds the outbounds of share links or of a remote subscription without storing
+// them, to preview them or to add them to the Xray configuration by hand.
+func (a *OutboundSubscriptionController) parseLinks(c *gin.Context) {
+	result, err := a.outboundSubscriptionService.ParseLinks(c.PostForm("url"), c.PostForm("links"), c.PostForm("prefix"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.parse"), err)
+		return
+	}
+	jsonObj(c, result, nil)
+}
+
+// addSubscription creates a new outbound subscription and builds its outbounds.
+func (a *OutboundSubscriptionController) addSubscription(c *gin.Context) {
+	sub := &model.OutboundSubscription{}
+	if err := c.ShouldBind(sub); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.saved"), err)
+		return
+	}
+	needRestart, err := a.outboundSubscriptionService.AddSubscription(sub)
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outboundSub.toasts.saved"), sub, err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// updateSubscription updates an outbound subscription by its ID.
+func (a *OutboundSubscriptionController) updateSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.saved"), err)
+		return
+	}
+	sub := &model.OutboundSubscription{}
+	if err := c.ShouldBind(sub); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.saved"), err)
+		return
+	}
+	sub.Id = id
+	needRestart, err := a.outboundSubscriptionService.UpdateSubscription(sub)
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outboundSub.toasts.saved"), sub, err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delSubscription deletes an outbound subscription by its ID.
+func (a *OutboundSubscriptionController) delSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.deleted"), err)
+		return
+	}
+	needRestart, err := a.outboundSubscriptionService.DelSubscription(id)
+	jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.deleted"), err)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// refreshSubscription builds the outbounds of a subscription again right away.
+func (a *OutboundSubscriptionController) refreshSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.refreshed"), err)
+		return
+	}
+	changed, err := a.outboundSubscriptionService.RefreshSubscription(id)
+	jsonMsg(c, I18nWeb(c, "pages.xray.outboundSub.toasts.refreshed"), err)
+	if err == nil && changed {
+		a.xrayService.SetToNeedRestart()
+	}
+}
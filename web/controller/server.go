@@ -19,8 +19,10 @@ var filenameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-.]+$`)
 type ServerController struct {
 	BaseController
 
-	serverService  service.ServerService
-	settingService service.SettingService
+	serverService     service.ServerService
+	settingService    service.SettingService
+	backupService     service.BackupService
+	loginLimitService service.LoginLimitService
 
 	lastStatus *service.Status
 
@@ -58,7 +60,12 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 	g.POST("/logs/:count", a.getLogs)
 	g.POST("/xraylogs/:count", a.getXrayLogs)
 	g.POST("/importDB", a.importDB)
+	g.POST("/revertImportDB", a.revertImportDB)
 	g.POST("/getNewEchCert", a.getNewEchCert)
+
+	g.GET("/loginLockouts", a.getLoginLockouts)
+	g.POST("/clearLoginLockout/:id", a.clearLoginLockout)
+	g.POST("/clearAllLoginLockouts", a.clearAllLoginLockouts)
 }
 
 // refreshStatus updates the cached server status and collects CPU history.
@@ -242,7 +249,11 @@ func (a *ServerController) getDb(c *gin.Context) {
 		return
 	}
 
-	filename := "x-ui.db"
+	filename, db, err := a.backupService.Seal("x-ui.db", db)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.index.getDatabaseError"), err)
+		return
+	}
 
 	if !isValidFilename(filename) {
 		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid filename"))
@@ -262,7 +273,8 @@ func isValidFilename(filename string) bool {
 	return filenameRegex.MatchString(filename)
 }
 
-// importDB imports a database file and restarts the Xray service.
+// importDB checks an uploaded database file and reports how it differs from the current data.
+// Unless it is a dry run, the database is then replaced and the Xray service restarted.
 func (a *ServerController) importDB(c *gin.Context) {
 	// Get the file from the request body
 	file, _, err := c.Request.FormFile("db")
@@ -271,16 +283,32 @@ func (a *ServerController) importDB(c *gin.Context) {
 		return
 	}
 	defer file.Close()
-	// Always restart Xray before return
-	defer a.serverService.RestartXrayService()
-	// lastGetStatusTime removed; no longer needed
+	dryRun := c.PostForm("dryRun") == "true"
+	if !dryRun {
+		// Always restart Xray before return
+		defer a.serverService.RestartXrayService()
+	}
 	// Import it
-	err = a.serverService.ImportDB(file)
+	report, err := a.serverService.ImportDB(file, c.PostForm("key"), dryRun)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.index.importDatabaseError"), err)
 		return
 	}
-	jsonObj(c, I18nWeb(c, "pages.index.importDatabaseSuccess"), nil)
+	if dryRun {
+		jsonObj(c, report, nil)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.index.importDatabaseSuccess"), report, nil)
+}
+
+// revertImportDB puts back the database replaced by the last import.
+func (a *ServerController) revertImportDB(c *gin.Context) {
+	err := a.serverService.RevertDBImport()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.index.revertDatabaseError"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.index.revertDatabaseSuccess"), nil)
 }
 
 // getNewX25519Cert generates a new X25519 certificate.
@@ -344,3 +372,30 @@ func (a *ServerController) getNewmlkem768(c *gin.Context) {
 	}
 	jsonObj(c, out, nil)
 }
+
+// getLoginLockouts retrieves the IPs and usernames currently locked out of the panel login.
+func (a *ServerController) getLoginLockouts(c *gin.Context) {
+	lockouts, err := a.loginLimitService.GetLockouts()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, lockouts, nil)
+}
+
+// clearLoginLockout lifts a login lockout by its ID.
+func (a *ServerController) clearLoginLockout(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.loginLimit.lockoutCleared"), err)
+		return
+	}
+	err = a.loginLimitService.ClearLockout(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.loginLimit.lockoutCleared"), err)
+}
+
+// clearAllLoginLockouts lifts all login lockouts.
+func (a *ServerController) clearAllLoginLockouts(c *gin.Context) {
+	err := a.loginLimitService.ClearAllLockouts()
+	jsonMsg(c, I18nWeb(c, "pages.settings.loginLimit.lockoutCleared"), err)
+}
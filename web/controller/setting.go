@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/netutil"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
@@ -74,6 +75,11 @@ func (a *SettingController) updateSetting(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 		return
 	}
+	// Refuse an allowlist that would lock the current admin out of the panel
+	if allowed, err := netutil.ParseCIDRList(allSetting.PanelAllowedIps); err == nil && len(allowed) > 0 && !netutil.Contains(allowed, getRemoteIp(c)) {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), errors.New(I18nWeb(c, "pages.settings.loginLimit.allowedIpsExcludeYou", "IP=="+getRemoteIp(c))))
+		return
+	}
 	err = a.settingService.UpdateAllSetting(allSetting)
 	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 }
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SignupController handles the Telegram signup plans and requests.
+type SignupController struct {
+	signupService service.SignupService
+}
+
+// NewSignupController creates a new SignupController and sets up its routes.
+func NewSignupController(g *gin.RouterGroup) *SignupController {
+	a := &SignupController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for signup plan and request operations.
+func (a *SignupController) initRouter(g *gin.RouterGroup) {
+	g.GET("/plans", a.getPlans)
+	g.GET("/requests", a.getRequests)
+
+	g.POST("/plans/add", a.addPlan)
+	g.POST("/plans/update/:id", a.updatePlan)
+	g.POST("/plans/del/:id", a.delPlan)
+	g.POST("/requests/del/:id", a.delRequest)
+}
+
+// getPlans retrieves all signup plans.
+func (a *SignupController) getPlans(c *gin.Context) {
+	plans, err := a.signupService.GetPlans(false)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, plans, nil)
+}
+
+// getRequests retrieves all signup requests.
+func (a *SignupController) getRequests(c *gin.Context) {
+	requests, err := a.signupService.GetRequests()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, requests, nil)
+}
+
+// addPlan creates a new signup plan.
+func (a *SignupController) addPlan(c *gin.Context) {
+	plan := &model.SignupPlan{}
+	if err := c.ShouldBind(plan); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.planSaved"), err)
+		return
+	}
+	err := a.signupService.AddPlan(plan)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.signup.toasts.planSaved"), plan, err)
+}
+
+// updatePlan updates a signup plan by its ID.
+func (a *SignupController) updatePlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.planSaved"), err)
+		return
+	}
+	plan := &model.SignupPlan{}
+	if err := c.ShouldBind(plan); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.planSaved"), err)
+		return
+	}
+	plan.Id = id
+	err = a.signupService.UpdatePlan(plan)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.signup.toasts.planSaved"), plan, err)
+}
+
+// delPlan deletes a signup plan by its ID.
+func (a *SignupController) delPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.planDeleted"), err)
+		return
+	}
+	err = a.signupService.DelPlan(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.planDeleted"), err)
+}
+
+// delRequest deletes a signup request by its ID.
+func (a *SignupController) delRequest(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.requestDeleted"), err)
+		return
+	}
+	err = a.signupService.DelRequest(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.signup.toasts.requestDeleted"), err)
+}
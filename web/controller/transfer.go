@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TransferController handles the portable export and import of inbounds and settings.
+type TransferController struct {
+	transferService service.TransferService
+	backupService   service.BackupService
+	xrayService     service.XrayService
+}
+
+// NewTransferController creates a new TransferController and sets up its routes.
+func NewTransferController(g *gin.RouterGroup) *TransferController {
+	a := &TransferController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for export and import operations.
+func (a *TransferController) initRouter(g *gin.RouterGroup) {
+	g.GET("/export", a.export)
+
+	g.POST("/import", a.importExport)
+	g.POST("/importXray", a.importXray)
+}
+
+// export downloads the selected inbounds, and optionally the settings and the Xray template, as
+// a JSON file. It is encrypted like the backups.
+func (a *TransferController) export(c *gin.Context) {
+	var inboundIds []int
+	for _, item := range strings.Split(c.Query("inbounds"), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.export"), err)
+			return
+		}
+		inboundIds = append(inboundIds, id)
+	}
+	export, err := a.transferService.Export(inboundIds, c.Query("settings") == "true", c.Query("xrayTemplate") == "true")
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.export"), err)
+		return
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.export"), err)
+		return
+	}
+	filename, data, err := a.backupService.Seal("x-ui-export-"+time.Now().Format("20060102-150405")+".json", data)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.export"), err)
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Writer.Write(data)
+}
+
+// importExport imports an export file, or previews what the import would change.
+func (a *TransferController) importExport(c *gin.Context) {
+	options := service.ImportOptions{}
+	if err := c.ShouldBind(&options); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.import"), err)
+		return
+	}
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.import"), err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err == nil {
+		data, err = a.backupService.Open(data, c.PostForm("key"))
+	}
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.import"), err)
+		return
+	}
+	user := session.GetLoginUser(c)
+	report, needRestart, err := a.transferService.Import(data, user.Id, options)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.import"), err)
+		return
+	}
+	msg := ""
+	if !options.DryRun {
+		msg = I18nWeb(c, "pages.inbounds.transfer.toasts.imported")
+	}
+	jsonMsgObj(c, msg, report, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// importXray creates inbounds from an Xray config or share links, or previews what it would create.
+func (a *TransferController) importXray(c *gin.Context) {
+	dryRun := c.PostForm("dryRun") == "true"
+	user := session.GetLoginUser(c)
+	report, needRestart, err := a.transferService.ImportXray(c.PostForm("data"), user.Id, dryRun)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.transfer.toasts.import"), err)
+		return
+	}
+	msg := ""
+	if !dryRun {
+		msg = I18nWeb(c, "pages.inbounds.transfer.toasts.imported")
+	}
+	jsonMsgObj(c, msg, report, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -3,7 +3,6 @@ package controller
 import (
 	"net"
 	"net/http"
-	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/logger"
@@ -12,16 +11,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// getRemoteIp extracts the real IP address from the request headers or remote address.
+// getRemoteIp returns the client IP address resolved by the client IP middleware,
+// falling back to the remote address of the connection.
 func getRemoteIp(c *gin.Context) string {
-	value := c.GetHeader("X-Real-IP")
-	if value != "" {
-		return value
-	}
-	value = c.GetHeader("X-Forwarded-For")
-	if value != "" {
-		ips := strings.Split(value, ",")
-		return ips[0]
+	if ip := c.GetString("remote_ip"); ip != "" {
+		return ip
 	}
 	addr := c.Request.RemoteAddr
 	ip, _, _ := net.SplitHostPort(addr)
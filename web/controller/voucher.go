@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VoucherController handles voucher generation, redemption and the redemption log.
+type VoucherController struct {
+	voucherService service.VoucherService
+	xrayService    service.XrayService
+}
+
+// NewVoucherController creates a new VoucherController and sets up its routes.
+func NewVoucherController(g *gin.RouterGroup) *VoucherController {
+	a := &VoucherController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for voucher operations.
+func (a *VoucherController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getVouchers)
+	g.GET("/redemptions", a.getRedemptions)
+
+	g.POST("/generate", a.generate)
+	g.POST("/setEnable/:id", a.setEnable)
+	g.POST("/del/:id", a.delVoucher)
+	g.POST("/delBatch", a.delBatch)
+	g.POST("/redeem", a.redeem)
+	g.POST("/revoke/:id", a.revoke)
+}
+
+// getVouchers retrieves all vouchers.
+func (a *VoucherController) getVouchers(c *gin.Context) {
+	vouchers, err := a.voucherService.GetVouchers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, vouchers, nil)
+}
+
+// getRedemptions retrieves the redemption log.
+func (a *VoucherController) getRedemptions(c *gin.Context) {
+	redemptions, err := a.voucherService.GetRedemptions()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, redemptions, nil)
+}
+
+// generate creates a batch of vouchers and returns them.
+func (a *VoucherController) generate(c *gin.Context) {
+	batch := &service.VoucherBatch{}
+	if err := c.ShouldBind(batch); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.generated"), err)
+		return
+	}
+	vouchers, err := a.voucherService.GenerateBatch(batch)
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.voucher.toasts.generated"), vouchers, err)
+}
+
+// setEnable enables or disables a voucher by its ID.
+func (a *VoucherController) setEnable(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.updated"), err)
+		return
+	}
+	enable, err := strconv.ParseBool(c.PostForm("enable"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.updated"), err)
+		return
+	}
+	err = a.voucherService.SetEnable(id, enable)
+	jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.updated"), err)
+}
+
+// delVoucher deletes a voucher by its ID.
+func (a *VoucherController) delVoucher(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.deleted"), err)
+		return
+	}
+	err = a.voucherService.DelVoucher(id)
+	jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.deleted"), err)
+}
+
+// delBatch deletes all vouchers of a batch.
+func (a *VoucherController) delBatch(c *gin.Context) {
+	err := a.voucherService.DelBatch(c.PostForm("batch"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.deleted"), err)
+}
+
+// redeem applies a voucher to a client on behalf of an admin.
+func (a *VoucherController) redeem(c *gin.Context) {
+	redemption, needRestart, err := a.voucherService.Redeem(c.PostForm("code"), c.PostForm("email"), service.VoucherSourcePanel)
+	if err == nil && needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.voucher.toasts.redeemed"), redemption, err)
+}
+
+// revoke takes back a redemption by its ID.
+func (a *VoucherController) revoke(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.revoked"), err)
+		return
+	}
+	needRestart, err := a.voucherService.Revoke(id)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.voucher.toasts.revoked"), err)
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
@@ -34,6 +35,7 @@ func (a *XraySettingController) initRouter(g *gin.RouterGroup) {
 	g.POST("/warp/:action", a.warp)
 	g.POST("/update", a.updateSetting)
 	g.POST("/resetOutboundsTraffic", a.resetOutboundsTraffic)
+	g.POST("/setOutboundQuota", a.setOutboundQuota)
 }
 
 // getXraySetting retrieves the Xray configuration template and inbound tags.
@@ -111,10 +113,32 @@ func (a *XraySettingController) getOutboundsTraffic(c *gin.Context) {
 // resetOutboundsTraffic resets the traffic statistics for the specified outbound tag.
 func (a *XraySettingController) resetOutboundsTraffic(c *gin.Context) {
 	tag := c.PostForm("tag")
-	err := a.OutboundService.ResetOutboundTraffic(tag)
+	needRestart, err := a.OutboundService.ResetOutboundTraffic(tag)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.resetOutboundTrafficError"), err)
 		return
 	}
+	if needRestart {
+		a.XrayService.SetToNeedRestart()
+	}
 	jsonObj(c, "", nil)
 }
+
+// setOutboundQuota updates the traffic quota, fallback outbound and reset schedule of an outbound.
+func (a *XraySettingController) setOutboundQuota(c *gin.Context) {
+	outbound := &model.OutboundTraffics{}
+	err := c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+		return
+	}
+	needRestart, err := a.OutboundService.SetOutboundQuota(outbound.Tag, outbound.Quota, outbound.FallbackTag, outbound.TrafficReset)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+		return
+	}
+	if needRestart {
+		a.XrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), nil)
+}
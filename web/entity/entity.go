@@ -5,10 +5,17 @@ import (
 	"crypto/tls"
 	"math"
 	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/netutil"
+
+	"github.com/robfig/cron/v3"
 )
 
 // Msg represents a standard API response message with success status, message text, and optional data object.
@@ -45,14 +52,59 @@ type AllSetting struct {
 	TgRunTime        string `json:"tgRunTime" form:"tgRunTime"`               // Cron schedule for Telegram notifications
 	TgBotBackup      bool   `json:"tgBotBackup" form:"tgBotBackup"`           // Enable database backup via Telegram
 	TgBotLoginNotify bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"` // Send login notifications
-	TgCpu            int    `json:"tgCpu" form:"tgCpu"`                       // CPU usage threshold for alerts
+	TgBotSignup      bool   `json:"tgBotSignup" form:"tgBotSignup"`           // Let unknown Telegram users request an account
 	TgLang           string `json:"tgLang" form:"tgLang"`                     // Telegram bot language
 
+	// Telegram bot webhook settings
+	TgBotWebhook       bool   `json:"tgBotWebhook" form:"tgBotWebhook"`             // Receive bot updates by webhook instead of long polling
+	TgBotWebhookServer string `json:"tgBotWebhookServer" form:"tgBotWebhookServer"` // Server the webhook is served on, "panel" or "sub"
+	TgBotWebhookUrl    string `json:"tgBotWebhookUrl" form:"tgBotWebhookUrl"`       // Public URL of that server, if it is behind a reverse proxy
+
+	// Client warning settings
+	ClientWarnDays    string `json:"clientWarnDays" form:"clientWarnDays"`       // Days before expiry clients are warned at, comma separated
+	ClientWarnTraffic string `json:"clientWarnTraffic" form:"clientWarnTraffic"` // Percents of the quota clients are warned at, comma separated
+	ClientWarnContact string `json:"clientWarnContact" form:"clientWarnContact"` // Link of the renew button in client warnings
+
+	// Backup settings
+	BackupEnable      bool   `json:"backupEnable" form:"backupEnable"`           // Write scheduled database backups
+	BackupCron        string `json:"backupCron" form:"backupCron"`               // Cron schedule of the backups
+	BackupDir         string `json:"backupDir" form:"backupDir"`                 // Local directory backups are written to, empty for none
+	BackupS3Endpoint  string `json:"backupS3Endpoint" form:"backupS3Endpoint"`   // URL of the S3 compatible storage, empty for none
+	BackupS3Region    string `json:"backupS3Region" form:"backupS3Region"`       // Region of the bucket
+	BackupS3Bucket    string `json:"backupS3Bucket" form:"backupS3Bucket"`       // Bucket backups are uploaded to
+	BackupS3Prefix    string `json:"backupS3Prefix" form:"backupS3Prefix"`       // Prefix of the object keys
+	BackupS3AccessKey string `json:"backupS3AccessKey" form:"backupS3AccessKey"` // Access key of the storage
+	BackupS3SecretKey string `json:"backupS3SecretKey" form:"backupS3SecretKey"` // Secret key of the storage
+	BackupS3PathStyle bool   `json:"backupS3PathStyle" form:"backupS3PathStyle"` // Address the bucket in the path, as MinIO needs
+	BackupKeepLast    int    `json:"backupKeepLast" form:"backupKeepLast"`       // Number of latest backups kept
+	BackupKeepDaily   int    `json:"backupKeepDaily" form:"backupKeepDaily"`     // Number of days whose last backup is kept
+	BackupKeepWeekly  int    `json:"backupKeepWeekly" form:"backupKeepWeekly"`   // Number of weeks whose last backup is kept
+
+	// Backup encryption settings
+	BackupEncryption string `json:"backupEncryption" form:"backupEncryption"` // How backups are encrypted: none, passphrase or recipients
+	BackupPassphrase string `json:"backupPassphrase" form:"backupPassphrase"` // Passphrase backups are encrypted with
+	BackupRecipients string `json:"backupRecipients" form:"backupRecipients"` // age or SSH public keys backups are encrypted to, one per line
+
 	// Security settings
 	TimeLocation    string `json:"timeLocation" form:"timeLocation"`       // Time zone location
 	TwoFactorEnable bool   `json:"twoFactorEnable" form:"twoFactorEnable"` // Enable two-factor authentication
 	TwoFactorToken  string `json:"twoFactorToken" form:"twoFactorToken"`   // Two-factor authentication token
 
+	// IP limit settings
+	IpLimitMode        string `json:"ipLimitMode" form:"ipLimitMode"`               // How IP limit violations are enforced: fail2ban, xrayApi or nftables
+	IpLimitBanDuration int    `json:"ipLimitBanDuration" form:"ipLimitBanDuration"` // Ban duration in minutes for the built-in modes
+	IpLimitWindow      int    `json:"ipLimitWindow" form:"ipLimitWindow"`           // Sliding window in minutes over which distinct client IPs are counted
+	IpHistoryRetention int    `json:"ipHistoryRetention" form:"ipHistoryRetention"` // Hours a client IP is kept in the history after it was last seen
+	IpLimitGrouping    string `json:"ipLimitGrouping" form:"ipLimitGrouping"`       // How IPs are counted for IP limits: ip, subnet or asn
+	IpGeoCountryDb     string `json:"ipGeoCountryDb" form:"ipGeoCountryDb"`         // geoip.dat or MMDB file for client IP countries, empty for Xray's geoip.dat
+	IpGeoAsnDb         string `json:"ipGeoAsnDb" form:"ipGeoAsnDb"`                 // MMDB file for client IP autonomous systems
+
+	// Login protection settings
+	LoginMaxAttempts  int    `json:"loginMaxAttempts" form:"loginMaxAttempts"`   // Failed logins per IP or username before a lockout, 0 to disable
+	LoginLockDuration int    `json:"loginLockDuration" form:"loginLockDuration"` // First lockout duration in minutes, doubled with each further lockout
+	PanelAllowedIps   string `json:"panelAllowedIps" form:"panelAllowedIps"`     // Networks allowed to access the panel and API, empty for all
+	TrustedProxies    string `json:"trustedProxies" form:"trustedProxies"`       // Reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted
+
 	// Subscription server settings
 	SubEnable                   bool   `json:"subEnable" form:"subEnable"`                                     // Enable subscription server
 	SubJsonEnable               bool   `json:"subJsonEnable" form:"subJsonEnable"`                             // Enable JSON subscription endpoint
@@ -68,6 +120,7 @@ type AllSetting struct {
 	ExternalTrafficInformURI    string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`       // URI for external traffic reporting
 	SubEncrypt                  bool   `json:"subEncrypt" form:"subEncrypt"`                                   // Encrypt subscription responses
 	SubShowInfo                 bool   `json:"subShowInfo" form:"subShowInfo"`                                 // Show client information in subscriptions
+	SubRedeem                   bool   `json:"subRedeem" form:"subRedeem"`                                     // Allow redeeming vouchers on the subscription page
 	SubURI                      string `json:"subURI" form:"subURI"`                                           // Subscription server URI
 	SubJsonPath                 string `json:"subJsonPath" form:"subJsonPath"`                                 // Path for JSON subscription endpoint
 	SubJsonURI                  string `json:"subJsonURI" form:"subJsonURI"`                                   // JSON subscription server URI
@@ -163,6 +216,109 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	switch s.IpLimitMode {
+	case "fail2ban", "xrayApi", "nftables":
+	default:
+		return common.NewError("IP limit mode is not valid:", s.IpLimitMode)
+	}
+	if s.IpLimitBanDuration <= 0 {
+		return common.NewError("IP limit ban duration is not valid:", s.IpLimitBanDuration)
+	}
+	if s.IpLimitWindow <= 0 {
+		return common.NewError("IP limit window is not valid:", s.IpLimitWindow)
+	}
+	switch s.IpLimitGrouping {
+	case "ip", "subnet", "asn":
+	default:
+		return common.NewError("IP limit grouping is not valid:", s.IpLimitGrouping)
+	}
+	if s.IpHistoryRetention*60 < s.IpLimitWindow {
+		return common.NewError("IP history retention must cover the IP limit window:", s.IpHistoryRetention)
+	}
+
+	switch s.TgBotWebhookServer {
+	case "panel", "sub":
+	default:
+		return common.NewError("Telegram bot webhook server is not valid:", s.TgBotWebhookServer)
+	}
+	if s.TgBotWebhookUrl != "" {
+		if u, err := url.Parse(s.TgBotWebhookUrl); err != nil || u.Scheme != "https" || u.Host == "" {
+			return common.NewError("Telegram bot webhook URL must be an https URL:", s.TgBotWebhookUrl)
+		}
+	}
+
+	for _, steps := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"client warning days", s.ClientWarnDays, 365},
+		{"client warning traffic", s.ClientWarnTraffic, 100},
+	} {
+		for _, step := range strings.Split(steps.value, ",") {
+			if step = strings.TrimSpace(step); step == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(step); err != nil || n <= 0 || n > steps.max {
+				return common.NewErrorf("%s is not valid: %s", steps.name, step)
+			}
+		}
+	}
+	if s.ClientWarnContact != "" {
+		if u, err := url.Parse(s.ClientWarnContact); err != nil || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "tg") {
+			return common.NewError("client warning contact link is not valid:", s.ClientWarnContact)
+		}
+	}
+
+	if s.BackupEnable {
+		if _, err := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor).Parse(s.BackupCron); err != nil {
+			return common.NewError("backup schedule is not valid:", err)
+		}
+		if s.BackupDir == "" && s.BackupS3Endpoint == "" {
+			return common.NewError("backups need a local directory or an S3 endpoint")
+		}
+	}
+	if s.BackupDir != "" && !filepath.IsAbs(s.BackupDir) {
+		return common.NewError("backup directory must be an absolute path:", s.BackupDir)
+	}
+	if s.BackupS3Endpoint != "" {
+		if u, err := url.Parse(s.BackupS3Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return common.NewError("backup S3 endpoint must be an http or https URL:", s.BackupS3Endpoint)
+		}
+		if s.BackupS3Bucket == "" || s.BackupS3AccessKey == "" || s.BackupS3SecretKey == "" {
+			return common.NewError("backup S3 bucket, access key and secret key are required")
+		}
+	}
+	if s.BackupKeepLast < 0 || s.BackupKeepDaily < 0 || s.BackupKeepWeekly < 0 {
+		return common.NewError("backup retention must not be negative")
+	}
+	switch s.BackupEncryption {
+	case "none":
+	case "passphrase":
+		if s.BackupPassphrase == "" {
+			return common.NewError("backup passphrase is required")
+		}
+	case "recipients":
+		if _, err := crypto.ParseAgeRecipients(s.BackupRecipients); err != nil {
+			return common.NewError("backup recipients are not valid:", err)
+		}
+	default:
+		return common.NewError("backup encryption is not valid:", s.BackupEncryption)
+	}
+
+	if s.LoginMaxAttempts < 0 {
+		return common.NewError("login max attempts is not valid:", s.LoginMaxAttempts)
+	}
+	if s.LoginLockDuration <= 0 {
+		return common.NewError("login lock duration is not valid:", s.LoginLockDuration)
+	}
+	if _, err := netutil.ParseCIDRList(s.PanelAllowedIps); err != nil {
+		return common.NewError("panel allowed IPs are not valid:", err)
+	}
+	if _, err := netutil.ParseCIDRList(s.TrustedProxies); err != nil {
+		return common.NewError("trusted proxies are not valid:", err)
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
@@ -0,0 +1,37 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// AlertJob samples the server status and traffic counters and evaluates the alert rules against them.
+type AlertJob struct {
+	serverService service.ServerService
+	alertService  service.AlertService
+	lastStatus    *service.Status
+	lastSample    *service.AlertSample
+}
+
+// NewAlertJob creates a new alert rule evaluation job instance.
+func NewAlertJob() *AlertJob {
+	return new(AlertJob)
+}
+
+// Run takes a new sample and evaluates the enabled alert rules.
+func (j *AlertJob) Run() {
+	if !j.alertService.HasEnabledRules() {
+		j.lastStatus = nil
+		j.lastSample = nil
+		return
+	}
+	status := j.serverService.GetStatus(j.lastStatus)
+	sample, err := j.alertService.TakeSample(status)
+	if err != nil {
+		logger.Warning("Unable to sample alert metrics:", err)
+		return
+	}
+	j.alertService.Evaluate(sample, j.lastSample)
+	j.lastStatus = status
+	j.lastSample = sample
+}
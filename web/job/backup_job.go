@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// BackupJob writes a scheduled backup of the database.
+type BackupJob struct {
+	backupService service.BackupService
+}
+
+// NewBackupJob creates a new backup job instance.
+func NewBackupJob() *BackupJob {
+	return new(BackupJob)
+}
+
+// Run writes a backup to the configured targets and removes the ones the retention drops.
+func (j *BackupJob) Run() {
+	j.backupService.Run(service.BackupTriggerSchedule)
+}
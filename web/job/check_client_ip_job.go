@@ -15,13 +15,20 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/geoip"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 )
 
 // CheckClientIpJob monitors client IP addresses from access logs and manages IP blocking based on configured limits.
 type CheckClientIpJob struct {
-	lastClear     int64
-	disAllowedIps []string
+	lastClear      int64
+	disAllowedIps  []string
+	ipLimitMode    string
+	settingService service.SettingService
+	ipBanService   service.IpBanService
+	inboundService service.InboundService
+	xrayService    service.XrayService
 }
 
 var job *CheckClientIpJob
@@ -37,26 +44,27 @@ func (j *CheckClientIpJob) Run() {
 		j.lastClear = time.Now().Unix()
 	}
 
+	needRestart, err := j.ipBanService.UnbanExpired()
+	j.checkError(err)
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+
+	j.ipLimitMode, err = j.settingService.GetIpLimitMode()
+	j.checkError(err)
+
+	j.ageIpHistory()
+
 	shouldClearAccessLog := false
 	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
 	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
 
-	if isAccessLogAvailable {
-		if runtime.GOOS == "windows" {
-			if iplimitActive {
-				shouldClearAccessLog = j.processLogFile()
-			}
+	if isAccessLogAvailable && iplimitActive {
+		// The built-in modes enforce the limit themselves, only the fail2ban mode needs the jail
+		if runtime.GOOS == "windows" || j.ipLimitMode != service.IpLimitModeFail2ban || j.checkFail2BanInstalled() {
+			shouldClearAccessLog = j.processLogFile()
 		} else {
-			if iplimitActive {
-				if f2bInstalled {
-					shouldClearAccessLog = j.processLogFile()
-				} else {
-					if !f2bInstalled {
-						logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
-					}
-				}
-			}
+			logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu or choose a built-in IP limit mode.")
 		}
 	}
 
@@ -90,7 +98,7 @@ func (j *CheckClientIpJob) hasLimitIp() bool {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
 
-	err := db.Model(model.Inbound{}).Find(&inbounds).Error
+	err := model.FindInbounds(db.Model(model.Inbound{}), &inbounds)
 	if err != nil {
 		return false
 	}
@@ -119,12 +127,15 @@ func (j *CheckClientIpJob) processLogFile() bool {
 
 	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
 	emailRegex := regexp.MustCompile(`email: (.+)$`)
+	timeRegex := regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
 
 	accessLogPath, _ := xray.GetAccessLogPath()
 	file, _ := os.Open(accessLogPath)
 	defer file.Close()
 
-	inboundClientIps := make(map[string]map[string]struct{}, 100)
+	// Last time each client was seen from each IP, in milliseconds
+	inboundClientIps := make(map[string]map[string]int64, 100)
+	now := time.Now().UnixMilli()
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -147,20 +158,33 @@ func (j *CheckClientIpJob) processLogFile() bool {
 		}
 		email := emailMatches[1]
 
+		seen := now
+		if timeMatches := timeRegex.FindStringSubmatch(line); len(timeMatches) == 2 {
+			if t, err := time.ParseInLocation("2006/01/02 15:04:05", timeMatches[1], time.Local); err == nil {
+				seen = t.UnixMilli()
+			}
+		}
+
 		if _, exists := inboundClientIps[email]; !exists {
-			inboundClientIps[email] = make(map[string]struct{})
+			inboundClientIps[email] = make(map[string]int64)
 		}
-		inboundClientIps[email][ip] = struct{}{}
+		inboundClientIps[email][ip] = max(inboundClientIps[email][ip], seen)
+	}
+
+	window, err := j.settingService.GetIpLimitWindow()
+	if err != nil || window <= 0 {
+		window = 5
 	}
+	windowStart := now - int64(window)*time.Minute.Milliseconds()
 
 	shouldCleanLog := false
-	for email, uniqueIps := range inboundClientIps {
+	for email, seenIps := range inboundClientIps {
 
-		ips := make([]string, 0, len(uniqueIps))
-		for ip := range uniqueIps {
-			ips = append(ips, ip)
+		ips, err := j.recordClientIps(email, seenIps, windowStart)
+		if err != nil {
+			logger.Warning("failed to record client IPs:", err)
+			continue
 		}
-		sort.Strings(ips)
 
 		clientIpsRecord, err := j.getInboundClientIps(email)
 		if err != nil {
@@ -174,6 +198,76 @@ func (j *CheckClientIpJob) processLogFile() bool {
 	return shouldCleanLog
 }
 
+// recordClientIps updates the IP history of a client and returns the IPs seen within the
+// sliding window, ordered by first sight so that the newest IPs exceed the limit.
+func (j *CheckClientIpJob) recordClientIps(email string, seenIps map[string]int64, windowStart int64) ([]string, error) {
+	db := database.GetDB()
+	for ip, seen := range seenIps {
+		history := &model.ClientIpHistory{}
+		err := db.Model(model.ClientIpHistory{}).
+			Where("client_email = ? AND ip = ?", email, ip).
+			Attrs(model.ClientIpHistory{FirstSeen: seen, LastSeen: seen}).
+			FirstOrCreate(history, model.ClientIpHistory{ClientEmail: email, Ip: ip}).Error
+		if err != nil {
+			return nil, err
+		}
+		if seen > history.LastSeen {
+			err = db.Model(history).Update("last_seen", seen).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var ips []string
+	err := db.Model(model.ClientIpHistory{}).
+		Where("client_email = ? AND last_seen >= ?", email, windowStart).
+		Order("first_seen asc, ip asc").
+		Pluck("ip", &ips).Error
+	return ips, err
+}
+
+// getSurplusIps returns the IPs exceeding the limit. IPs are counted individually or, depending on
+// the grouping setting, per /24 subnet or autonomous system; the groups seen first are allowed.
+func (j *CheckClientIpJob) getSurplusIps(ips []string, limitIp int) []string {
+	grouping, err := j.settingService.GetIpLimitGrouping()
+	if err != nil || grouping == "" || grouping == "ip" {
+		if limitIp < len(ips) {
+			return ips[limitIp:]
+		}
+		return nil
+	}
+
+	allowed := map[string]bool{}
+	var surplusIps []string
+	for _, ip := range ips {
+		info := geoip.Info{IP: ip}
+		if grouping == "asn" {
+			info = j.inboundService.ResolveIp(ip)
+		}
+		key := geoip.GroupKey(info, grouping)
+		if !allowed[key] && len(allowed) < limitIp {
+			allowed[key] = true
+		}
+		if !allowed[key] {
+			surplusIps = append(surplusIps, ip)
+		}
+	}
+	return surplusIps
+}
+
+// ageIpHistory removes client IPs that were not seen within the retention period.
+func (j *CheckClientIpJob) ageIpHistory() {
+	retention, err := j.settingService.GetIpHistoryRetention()
+	if err != nil || retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(retention) * time.Hour).UnixMilli()
+	db := database.GetDB()
+	err = db.Where("last_seen < ?", cutoff).Delete(model.ClientIpHistory{}).Error
+	j.checkError(err)
+}
+
 func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
 	cmd := "fail2ban-client"
 	args := []string{"-h"}
@@ -282,10 +376,14 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 			if limitIp > 0 && inbound.Enable {
 				shouldCleanLog = true
 
-				if limitIp < len(ips) {
-					j.disAllowedIps = append(j.disAllowedIps, ips[limitIp:]...)
-					for i := limitIp; i < len(ips); i++ {
-						log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ips[i])
+				if surplusIps := j.getSurplusIps(ips, limitIp); len(surplusIps) > 0 {
+					j.disAllowedIps = append(j.disAllowedIps, surplusIps...)
+					if j.ipLimitMode == service.IpLimitModeFail2ban {
+						for _, ip := range surplusIps {
+							log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ip)
+						}
+					} else if err := j.ipBanService.BanClient(inbound, clientEmail, surplusIps); err != nil {
+						logger.Warning("[LimitIP] Unable to ban client:", err)
 					}
 				}
 			}
@@ -312,7 +410,9 @@ func (j *CheckClientIpJob) getInboundByEmail(clientEmail string) (*model.Inbound
 	db := database.GetDB()
 	inbound := &model.Inbound{}
 
-	err := db.Model(&model.Inbound{}).Where("settings LIKE ?", "%"+clientEmail+"%").First(inbound).Error
+	err := db.Model(&model.Inbound{}).
+		Where("id IN (?)", db.Model(model.InboundClient{}).Select("inbound_id").Where("email = ?", clientEmail)).
+		First(inbound).Error
 	if err != nil {
 		return nil, err
 	}
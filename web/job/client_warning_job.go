@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ClientWarningJob warns clients that are about to expire or use up their traffic.
+type ClientWarningJob struct {
+	clientWarningService service.ClientWarningService
+}
+
+// NewClientWarningJob creates a new client warning job instance.
+func NewClientWarningJob() *ClientWarningJob {
+	return new(ClientWarningJob)
+}
+
+// Run sends the warnings for the thresholds clients passed since the last run.
+func (j *ClientWarningJob) Run() {
+	j.clientWarningService.Check()
+}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// NodeSyncJob collects the traffic and status of the nodes and pushes them the inbounds they serve.
+type NodeSyncJob struct {
+	nodeService service.NodeService
+}
+
+// NewNodeSyncJob creates a new node sync job instance.
+func NewNodeSyncJob() *NodeSyncJob {
+	return new(NodeSyncJob)
+}
+
+// Run syncs all enabled nodes.
+func (j *NodeSyncJob) Run() {
+	j.nodeService.SyncAll()
+}
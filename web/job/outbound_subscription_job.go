@@ -0,0 +1,23 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// OutboundSubscriptionJob refreshes the outbounds of remote subscriptions on their schedule.
+type OutboundSubscriptionJob struct {
+	outboundSubscriptionService service.OutboundSubscriptionService
+	xrayService                 service.XrayService
+}
+
+// NewOutboundSubscriptionJob creates a new outbound subscription job instance.
+func NewOutboundSubscriptionJob() *OutboundSubscriptionJob {
+	return new(OutboundSubscriptionJob)
+}
+
+// Run refreshes the subscriptions that are due and restarts Xray if their outbounds changed.
+func (j *OutboundSubscriptionJob) Run() {
+	if j.outboundSubscriptionService.RefreshDue() {
+		j.xrayService.SetToNeedRestart()
+	}
+}
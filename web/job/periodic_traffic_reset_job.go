@@ -8,10 +8,12 @@ import (
 // Period represents the time period for traffic resets.
 type Period string
 
-// PeriodicTrafficResetJob resets traffic statistics for inbounds based on their configured reset period.
+// PeriodicTrafficResetJob resets traffic statistics for inbounds and outbounds based on their configured reset period.
 type PeriodicTrafficResetJob struct {
-	inboundService service.InboundService
-	period         Period
+	inboundService  service.InboundService
+	outboundService service.OutboundService
+	xrayService     service.XrayService
+	period          Period
 }
 
 // NewPeriodicTrafficResetJob creates a new periodic traffic reset job for the specified period.
@@ -21,8 +23,10 @@ func NewPeriodicTrafficResetJob(period Period) *PeriodicTrafficResetJob {
 	}
 }
 
-// Run resets traffic statistics for all inbounds that match the configured reset period.
+// Run resets traffic statistics for all inbounds and outbounds that match the configured reset period.
 func (j *PeriodicTrafficResetJob) Run() {
+	j.resetOutbounds()
+
 	inbounds, err := j.inboundService.GetInboundsByTrafficReset(string(j.period))
 	if err != nil {
 		logger.Warning("Failed to get inbounds for traffic reset:", err)
@@ -56,3 +60,15 @@ func (j *PeriodicTrafficResetJob) Run() {
 		logger.Infof("Periodic traffic reset completed: %d inbounds reset", resetCount)
 	}
 }
+
+// resetOutbounds resets outbound traffic for the period and restores the routing of outbounds that exceeded their quota.
+func (j *PeriodicTrafficResetJob) resetOutbounds() {
+	needRestart, err := j.outboundService.ResetOutboundTrafficsByPeriod(string(j.period))
+	if err != nil {
+		logger.Warning("Failed to reset outbound traffic:", err)
+		return
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -12,7 +12,7 @@ const (
 	LoginFail    LoginStatus = 0 // Failed login attempt
 )
 
-// StatsNotifyJob sends periodic statistics reports via Telegram bot.
+// StatsNotifyJob sends periodic statistics reports to the notification channels.
 type StatsNotifyJob struct {
 	xrayService  service.XrayService
 	tgbotService service.Tgbot
@@ -23,7 +23,7 @@ func NewStatsNotifyJob() *StatsNotifyJob {
 	return new(StatsNotifyJob)
 }
 
-// Run sends a statistics report via Telegram bot if Xray is running.
+// Run sends a statistics report if Xray is running.
 func (j *StatsNotifyJob) Run() {
 	if !j.xrayService.IsXrayRunning() {
 		return
@@ -4,9 +4,11 @@ package locale
 
 import (
 	"embed"
+	"errors"
 	"io/fs"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 
@@ -20,8 +22,69 @@ var (
 	i18nBundle   *i18n.Bundle
 	LocalizerWeb *i18n.Localizer
 	LocalizerBot *i18n.Localizer
+
+	// botLocalizers caches the localizers of the languages Telegram users chose
+	botLocalizers sync.Map
 )
 
+// Language is a language the translation files are available in.
+type Language struct {
+	Name  string
+	Value string
+	Icon  string
+}
+
+// BotLanguages lists the languages Telegram users can talk to the bot in.
+var BotLanguages = []Language{
+	{Name: "العربية", Value: "ar-EG", Icon: "🇪🇬"},
+	{Name: "English", Value: "en-US", Icon: "🇺🇸"},
+	{Name: "فارسی", Value: "fa-IR", Icon: "🇮🇷"},
+	{Name: "简体中文", Value: "zh-CN", Icon: "🇨🇳"},
+	{Name: "繁體中文", Value: "zh-TW", Icon: "🇹🇼"},
+	{Name: "日本語", Value: "ja-JP", Icon: "🇯🇵"},
+	{Name: "Русский", Value: "ru-RU", Icon: "🇷🇺"},
+	{Name: "Tiếng Việt", Value: "vi-VN", Icon: "🇻🇳"},
+	{Name: "Español", Value: "es-ES", Icon: "🇪🇸"},
+	{Name: "Indonesian", Value: "id-ID", Icon: "🇮🇩"},
+	{Name: "Український", Value: "uk-UA", Icon: "🇺🇦"},
+	{Name: "Türkçe", Value: "tr-TR", Icon: "🇹🇷"},
+	{Name: "Português", Value: "pt-BR", Icon: "🇧🇷"},
+}
+
+var botLanguageMatcher = language.NewMatcher(botLanguageTags())
+
+func botLanguageTags() []language.Tag {
+	tags := make([]language.Tag, len(BotLanguages))
+	for i, lang := range BotLanguages {
+		tags[i] = language.MustParse(lang.Value)
+	}
+	return tags
+}
+
+// IsBotLanguage reports whether the bot can talk in the language.
+func IsBotLanguage(lang string) bool {
+	for _, botLang := range BotLanguages {
+		if botLang.Value == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchBotLanguage returns the bot language that fits a language code sent by Telegram,
+// like "ru" or "pt-br", or an empty string if the bot doesn't speak the language.
+func MatchBotLanguage(code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return ""
+	}
+	_, i, confidence := botLanguageMatcher.Match(tag)
+	if confidence == language.No {
+		return ""
+	}
+	return BotLanguages[i].Value
+}
+
 // I18nType represents the type of interface for internationalization.
 type I18nType string
 
@@ -40,6 +103,7 @@ func InitLocalizer(i18nFS embed.FS, settingService SettingService) error {
 	// set default bundle to english
 	i18nBundle = i18n.NewBundle(language.MustParse("en-US"))
 	i18nBundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	botLocalizers.Clear()
 
 	// parse files
 	if err := parseTranslationFiles(i18nFS, i18nBundle); err != nil {
@@ -86,6 +150,23 @@ func I18n(i18nType I18nType, key string, params ...string) string {
 		return ""
 	}
 
+	return localize(localizer, key, params)
+}
+
+// I18nBot retrieves a localized bot message in the given language.
+// An empty language means the bot language configured in the panel.
+func I18nBot(lang string, key string, params ...string) string {
+	if lang == "" || i18nBundle == nil {
+		return I18n(Bot, key, params...)
+	}
+	localizer, ok := botLocalizers.Load(lang)
+	if !ok {
+		localizer, _ = botLocalizers.LoadOrStore(lang, i18n.NewLocalizer(i18nBundle, lang))
+	}
+	return localize(localizer.(*i18n.Localizer), key, params)
+}
+
+func localize(localizer *i18n.Localizer, key string, params []string) string {
 	templateData := createTemplateData(params)
 
 	if localizer == nil {
@@ -98,8 +179,12 @@ func I18n(i18nType I18nType, key string, params ...string) string {
 		TemplateData: templateData,
 	})
 	if err != nil {
-		logger.Errorf("Failed to localize message: %v", err)
-		return ""
+		// Messages missing in a language come back in English along with the error
+		var notFound *i18n.MessageNotFoundErr
+		if !errors.As(err, &notFound) || msg == "" {
+			logger.Errorf("Failed to localize message: %v", err)
+			return ""
+		}
 	}
 
 	return msg
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/util/netutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIPMiddleware returns a Gin middleware that resolves the IP address of the client
+// and stores it in the context as "remote_ip". The X-Forwarded-For and X-Real-IP headers
+// are only honored when the request comes from one of the trusted proxies, so clients
+// can't spoof their address when the panel is reached directly.
+func ClientIPMiddleware(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("remote_ip", resolveClientIP(c.Request, trustedProxies))
+		c.Next()
+	}
+}
+
+// resolveClientIP walks X-Forwarded-For from the nearest hop backwards and returns
+// the first address that is not a trusted proxy.
+func resolveClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !netutil.Contains(trustedProxies, ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !netutil.Contains(trustedProxies, hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// IPAllowlistMiddleware returns a Gin middleware that rejects requests from clients outside
+// the allowed networks with HTTP 403 Forbidden. It relies on ClientIPMiddleware running first.
+func IPAllowlistMiddleware(allowed []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !netutil.Contains(allowed, c.GetString("remote_ip")) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// Units of alert metric values.
+const (
+	AlertUnitPercent = "%"
+	AlertUnitNumber  = ""
+	AlertUnitRate    = "B/s"
+	AlertUnitBytes   = "B"
+)
+
+// AlertMetric describes a value alert rules can watch.
+type AlertMetric struct {
+	Name    string `json:"name"`
+	Unit    string `json:"unit"`
+	Traffic bool   `json:"traffic"` // Traffic metrics can target an inbound or outbound tag
+}
+
+// AlertMetrics lists the metrics alert rules can watch.
+var AlertMetrics = []AlertMetric{
+	{Name: "cpu", Unit: AlertUnitPercent},
+	{Name: "mem", Unit: AlertUnitPercent},
+	{Name: "swap", Unit: AlertUnitPercent},
+	{Name: "disk", Unit: AlertUnitPercent},
+	{Name: "load1", Unit: AlertUnitNumber},
+	{Name: "load5", Unit: AlertUnitNumber},
+	{Name: "load15", Unit: AlertUnitNumber},
+	{Name: "tcpCount", Unit: AlertUnitNumber},
+	{Name: "udpCount", Unit: AlertUnitNumber},
+	{Name: "netUp", Unit: AlertUnitRate},
+	{Name: "netDown", Unit: AlertUnitRate},
+	{Name: "xrayRunning", Unit: AlertUnitNumber},
+	{Name: "inboundRate", Unit: AlertUnitRate, Traffic: true},
+	{Name: "outboundRate", Unit: AlertUnitRate, Traffic: true},
+	{Name: "inboundTotal", Unit: AlertUnitBytes, Traffic: true},
+	{Name: "outboundTotal", Unit: AlertUnitBytes, Traffic: true},
+}
+
+// AlertSample is a snapshot of the server status and the traffic counters the rules are evaluated against.
+type AlertSample struct {
+	T         time.Time
+	Status    *Status
+	Inbounds  map[string]int64 // Uploaded and downloaded bytes by inbound tag
+	Outbounds map[string]int64 // Uploaded and downloaded bytes by outbound tag
+}
+
+// AlertService manages alert rules and evaluates them against server and traffic metrics.
+type AlertService struct {
+	tgbotService        Tgbot
+	notificationService NotificationService
+}
+
+// GetMetrics returns the metrics alert rules can watch.
+func (s *AlertService) GetMetrics() []AlertMetric {
+	return AlertMetrics
+}
+
+// GetRules returns all alert rules.
+func (s *AlertService) GetRules() ([]*model.AlertRule, error) {
+	db := database.GetDB()
+	var rules []*model.AlertRule
+	err := db.Model(model.AlertRule{}).Order("id asc").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// HasEnabledRules reports whether any alert rule is enabled.
+func (s *AlertService) HasEnabledRules() bool {
+	db := database.GetDB()
+	var count int64
+	err := db.Model(model.AlertRule{}).Where("enable = ?", true).Count(&count).Error
+	return err == nil && count > 0
+}
+
+// AddRule validates and stores a new alert rule.
+func (s *AlertService) AddRule(rule *model.AlertRule) error {
+	if err := s.checkRule(rule); err != nil {
+		return err
+	}
+	rule.Id = 0
+	resetAlertState(rule)
+	db := database.GetDB()
+	return db.Create(rule).Error
+}
+
+// UpdateRule validates and stores the changes of an alert rule. The rule starts over from the normal state.
+func (s *AlertService) UpdateRule(rule *model.AlertRule) error {
+	if err := s.checkRule(rule); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	oldRule := &model.AlertRule{}
+	if err := db.First(oldRule, rule.Id).Error; err != nil {
+		return err
+	}
+	resetAlertState(rule)
+	return db.Save(rule).Error
+}
+
+// DelRule deletes an alert rule.
+func (s *AlertService) DelRule(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.AlertRule{}, id).Error
+}
+
+// TakeSample reads the traffic counters of inbounds and outbounds to go with the server status.
+func (s *AlertService) TakeSample(status *Status) (*AlertSample, error) {
+	db := database.GetDB()
+	sample := &AlertSample{
+		T:         status.T,
+		Status:    status,
+		Inbounds:  map[string]int64{},
+		Outbounds: map[string]int64{},
+	}
+	var counters []struct {
+		Tag  string
+		Up   int64
+		Down int64
+	}
+	if err := db.Model(model.Inbound{}).Select("tag, up, down").Scan(&counters).Error; err != nil {
+		return nil, err
+	}
+	for _, counter := range counters {
+		sample.Inbounds[counter.Tag] = counter.Up + counter.Down
+	}
+	counters = nil
+	if err := db.Model(model.OutboundTraffics{}).Select("tag, up, down").Scan(&counters).Error; err != nil {
+		return nil, err
+	}
+	for _, counter := range counters {
+		sample.Outbounds[counter.Tag] = counter.Up + counter.Down
+	}
+	return sample, nil
+}
+
+// Evaluate checks the enabled rules against the sample. A rule fires once its condition has held
+// for the rule's duration, notifies again after each cooldown while firing, and recovers as soon
+// as the condition no longer holds. Rate metrics are computed against the previous sample.
+func (s *AlertService) Evaluate(sample *AlertSample, prev *AlertSample) {
+	db := database.GetDB()
+	var rules []*model.AlertRule
+	if err := db.Model(model.AlertRule{}).Where("enable = ?", true).Find(&rules).Error; err != nil {
+		logger.Warning("Unable to get alert rules:", err)
+		return
+	}
+	now := sample.T.UnixMilli()
+	for _, rule := range rules {
+		value, ok := alertMetricValue(rule, sample, prev)
+		if !ok {
+			continue
+		}
+		rule.LastValue = value
+		breached := (rule.Operator == ">" && value > rule.Threshold) || (rule.Operator == "<" && value < rule.Threshold)
+		switch {
+		case breached && !rule.Firing:
+			if rule.PendingSince == 0 {
+				rule.PendingSince = now
+			}
+			if now-rule.PendingSince >= int64(rule.Duration)*1000 {
+				rule.Firing = true
+				rule.LastNotified = now
+				s.notify(rule, "tgbot.messages.alertFiring")
+			}
+		case breached && rule.Firing:
+			if rule.Cooldown > 0 && now-rule.LastNotified >= int64(rule.Cooldown)*1000 {
+				rule.LastNotified = now
+				s.notify(rule, "tgbot.messages.alertFiring")
+			}
+		default:
+			if rule.Firing && rule.NotifyRecovery {
+				s.notify(rule, "tgbot.messages.alertRecovered")
+			}
+			rule.Firing = false
+			rule.PendingSince = 0
+		}
+		err := db.Model(rule).Updates(map[string]any{
+			"firing":        rule.Firing,
+			"pending_since": rule.PendingSince,
+			"last_notified": rule.LastNotified,
+			"last_value":    rule.LastValue,
+		}).Error
+		if err != nil {
+			logger.Warning("Unable to save alert rule state:", err)
+		}
+	}
+}
+
+func (s *AlertService) notify(rule *model.AlertRule, key string) {
+	metric := rule.Metric
+	if rule.Target != "" {
+		metric += " [" + rule.Target + "]"
+	}
+	value := formatAlertValue(rule.Metric, rule.LastValue)
+	threshold := formatAlertValue(rule.Metric, rule.Threshold)
+	s.tgbotService.NotifyLocalized(NotifyEventAlert, func(t *Tgbot) string {
+		return t.I18nBot(key,
+			"Name=="+rule.Name,
+			"Metric=="+metric,
+			"Value=="+value,
+			"Threshold=="+threshold)
+	})
+}
+
+func (s *AlertService) checkRule(rule *model.AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return common.NewError("alert rule name is required")
+	}
+	i := slices.IndexFunc(AlertMetrics, func(metric AlertMetric) bool { return metric.Name == rule.Metric })
+	if i < 0 {
+		return common.NewError("unknown alert metric:", rule.Metric)
+	}
+	rule.Target = strings.TrimSpace(rule.Target)
+	if !AlertMetrics[i].Traffic {
+		rule.Target = ""
+	}
+	if rule.Operator != ">" && rule.Operator != "<" {
+		return common.NewError("alert rule operator must be > or <")
+	}
+	if rule.Duration < 0 || rule.Cooldown < 0 {
+		return common.NewError("alert rule duration and cooldown must not be negative")
+	}
+	return nil
+}
+
+func resetAlertState(rule *model.AlertRule) {
+	rule.Firing = false
+	rule.PendingSince = 0
+	rule.LastNotified = 0
+	rule.LastValue = 0
+}
+
+// alertMetricValue returns the value of the rule's metric, or false if it isn't available yet.
+func alertMetricValue(rule *model.AlertRule, sample *AlertSample, prev *AlertSample) (float64, bool) {
+	status := sample.Status
+	percent := func(current, total uint64) (float64, bool) {
+		if total == 0 {
+			return 0, false
+		}
+		return float64(current) / float64(total) * 100, true
+	}
+	load := func(i int) (float64, bool) {
+		if len(status.Loads) <= i {
+			return 0, false
+		}
+		return status.Loads[i], true
+	}
+	rate := func(counters func(*AlertSample) map[string]int64) (float64, bool) {
+		if prev == nil {
+			return 0, false
+		}
+		seconds := sample.T.Sub(prev.T).Seconds()
+		if seconds <= 0 {
+			return 0, false
+		}
+		// Counters that were reset in between don't count
+		delta := max(sumAlertCounters(counters(sample), rule.Target)-sumAlertCounters(counters(prev), rule.Target), 0)
+		return float64(delta) / seconds, true
+	}
+	inbounds := func(sample *AlertSample) map[string]int64 { return sample.Inbounds }
+	outbounds := func(sample *AlertSample) map[string]int64 { return sample.Outbounds }
+
+	switch rule.Metric {
+	case "cpu":
+		return status.Cpu, true
+	case "mem":
+		return percent(status.Mem.Current, status.Mem.Total)
+	case "swap":
+		return percent(status.Swap.Current, status.Swap.Total)
+	case "disk":
+		return percent(status.Disk.Current, status.Disk.Total)
+	case "load1":
+		return load(0)
+	case "load5":
+		return load(1)
+	case "load15":
+		return load(2)
+	case "tcpCount":
+		return float64(status.TcpCount), true
+	case "udpCount":
+		return float64(status.UdpCount), true
+	case "netUp":
+		return float64(status.NetIO.Up), prev != nil
+	case "netDown":
+		return float64(status.NetIO.Down), prev != nil
+	case "xrayRunning":
+		if status.Xray.State == Running {
+			return 1, true
+		}
+		return 0, true
+	case "inboundRate":
+		return rate(inbounds)
+	case "outboundRate":
+		return rate(outbounds)
+	case "inboundTotal":
+		return float64(sumAlertCounters(sample.Inbounds, rule.Target)), true
+	case "outboundTotal":
+		return float64(sumAlertCounters(sample.Outbounds, rule.Target)), true
+	}
+	return 0, false
+}
+
+// sumAlertCounters returns the counter of the tag, or the sum of all counters if no tag is given.
+func sumAlertCounters(counters map[string]int64, tag string) int64 {
+	if tag != "" {
+		return counters[tag]
+	}
+	var sum int64
+	for _, counter := range counters {
+		sum += counter
+	}
+	return sum
+}
+
+func formatAlertValue(metric string, value float64) string {
+	unit := AlertUnitNumber
+	if i := slices.IndexFunc(AlertMetrics, func(m AlertMetric) bool { return m.Name == metric }); i >= 0 {
+		unit = AlertMetrics[i].Unit
+	}
+	switch unit {
+	case AlertUnitPercent:
+		return fmt.Sprintf("%.2f%%", value)
+	case AlertUnitRate:
+		return common.FormatTraffic(int64(value)) + "/s"
+	case AlertUnitBytes:
+		return common.FormatTraffic(int64(value))
+	}
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/s3"
+)
+
+// Places backups are written to.
+const (
+	BackupTargetLocal = "local"
+	BackupTargetS3    = "s3"
+)
+
+// What started a backup.
+const (
+	BackupTriggerSchedule = "schedule"
+	BackupTriggerManual   = "manual"
+)
+
+// States of a backup.
+const (
+	BackupSuccess = "success"
+	BackupFailed  = "failed"
+	BackupPruned  = "pruned"
+)
+
+const (
+	// backupTimeFormat is the time in backup names, which sorts them by age.
+	backupTimeFormat = "20060102-150405"
+	// checksumExt is the extension of the file holding the checksum of a backup, in the format
+	// of sha256sum.
+	checksumExt = ".sha256"
+)
+
+var (
+	backupNameRegex = regexp.MustCompile(`^x-ui-(\d{8}-\d{6})\.db(\.age)?$`)
+	// backupLock keeps backups from running at the same time.
+	backupLock sync.Mutex
+)
+
+// backupFile is a backup found at a target.
+type backupFile struct {
+	name string
+	time time.Time
+}
+
+// BackupService writes snapshots of the database to a local directory and an S3 compatible
+// bucket, and removes the old ones by the retention settings.
+type BackupService struct {
+	settingService SettingService
+}
+
+// GetBackups returns the latest backup records, newest first.
+func (s *BackupService) GetBackups() ([]*model.Backup, error) {
+	db := database.GetDB()
+	var backups []*model.Backup
+	if err := db.Model(model.Backup{}).Order("id desc").Limit(200).Find(&backups).Error; err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// Run writes a backup to every configured target and applies the retention to each of them.
+// It returns the records of the backup, one per target.
+func (s *BackupService) Run(trigger string) ([]*model.Backup, error) {
+	if !backupLock.TryLock() {
+		return nil, common.NewError("a backup is already running")
+	}
+	defer backupLock.Unlock()
+
+	dir, err := s.settingService.GetBackupDir()
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" && bucket == nil {
+		return nil, common.NewError("no backup target is configured")
+	}
+
+	now := time.Now()
+	data, err := snapshotDB()
+	if err != nil {
+		return nil, err
+	}
+	name, data, err := s.Seal("x-ui-"+now.Format(backupTimeFormat)+".db", data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	checksumLine := []byte(checksum + "  " + name + "\n")
+
+	db := database.GetDB()
+	var records []*model.Backup
+	record := func(target string, err error) {
+		backup := &model.Backup{
+			Name:      name,
+			Target:    target,
+			Trigger:   trigger,
+			Status:    BackupSuccess,
+			Size:      int64(len(data)),
+			Checksum:  checksum,
+			CreatedAt: now.UnixMilli(),
+		}
+		if err != nil {
+			logger.Warningf("Backup to %s failed: %v", target, err)
+			backup.Status = BackupFailed
+			backup.Error = err.Error()
+		}
+		if err := db.Create(backup).Error; err != nil {
+			logger.Warning("Unable to save backup record:", err)
+		}
+		records = append(records, backup)
+	}
+
+	if dir != "" {
+		err := writeLocalBackup(dir, name, data, checksumLine)
+		record(BackupTargetLocal, err)
+		if err == nil {
+			s.prune(BackupTargetLocal, func() ([]string, error) {
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(entries))
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				return names, nil
+			}, func(name string) error {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+				if err := os.Remove(filepath.Join(dir, name+checksumExt)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				return nil
+			})
+		}
+	}
+	if bucket != nil {
+		ctx := context.Background()
+		err := bucket.Put(ctx, prefix+name, data)
+		if err == nil {
+			err = bucket.Put(ctx, prefix+name+checksumExt, checksumLine)
+		}
+		record(BackupTargetS3, err)
+		if err == nil {
+			s.prune(BackupTargetS3, func() ([]string, error) {
+				objects, err := bucket.List(ctx, prefix)
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, 0, len(objects))
+				for _, object := range objects {
+					// Objects in deeper "directories" aren't ours
+					if name, ok := strings.CutPrefix(object.Key, prefix); ok && !strings.Contains(name, "/") {
+						names = append(names, name)
+					}
+				}
+				return names, nil
+			}, func(name string) error {
+				if err := bucket.Delete(ctx, prefix+name); err != nil {
+					return err
+				}
+				return bucket.Delete(ctx, prefix+name+checksumExt)
+			})
+		}
+	}
+
+	for _, backup := range records {
+		if backup.Status == BackupFailed {
+			return records, common.NewErrorf("backup to %s failed: %s", backup.Target, backup.Error)
+		}
+	}
+	logger.Info("Backup", name, "written")
+	return records, nil
+}
+
+// Seal encrypts a backup artifact if backup encryption is enabled, and returns it with its name,
+// which then ends in ".age". Every backup leaving the server goes through here.
+func (s *BackupService) Seal(name string, data []byte) (string, []byte, error) {
+	encryption, err := s.settingService.GetBackupEncryption()
+	if err != nil {
+		return "", nil, err
+	}
+	var passphrase, recipients string
+	switch encryption {
+	case "passphrase":
+		if passphrase, err = s.settingService.GetBackupPassphrase(); err == nil && passphrase == "" {
+			err = common.NewError("backup passphrase is not set")
+		}
+	case "recipients":
+		recipients, err = s.settingService.GetBackupRecipients()
+	default:
+		return name, data, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := crypto.AgeEncrypt(data, passphrase, recipients)
+	if err != nil {
+		return "", nil, common.NewError("unable to encrypt backup:", err)
+	}
+	return name + crypto.AgeExt, sealed, nil
+}
+
+// Open decrypts a backup if it is encrypted. Without a key the configured backup passphrase is
+// tried.
+func (s *BackupService) Open(data []byte, key string) ([]byte, error) {
+	if !crypto.IsAgeEncrypted(data) {
+		return data, nil
+	}
+	if key == "" {
+		key, _ = s.settingService.GetBackupPassphrase()
+	}
+	opened, err := crypto.AgeDecrypt(data, key)
+	if err != nil {
+		return nil, common.NewError("unable to decrypt backup:", err)
+	}
+	return opened, nil
+}
+
+// s3Client returns the client of the backup bucket with the prefix of the backup keys, or nil if
+// no S3 endpoint is configured.
+func (s *BackupService) s3Client() (*s3.Client, string, error) {
+	endpoint, err := s.settingService.GetBackupS3Endpoint()
+	if err != nil || endpoint == "" {
+		return nil, "", err
+	}
+	region, _ := s.settingService.GetBackupS3Region()
+	bucket, _ := s.settingService.GetBackupS3Bucket()
+	prefix, _ := s.settingService.GetBackupS3Prefix()
+	accessKey, _ := s.settingService.GetBackupS3AccessKey()
+	secretKey, _ := s.settingService.GetBackupS3SecretKey()
+	pathStyle, _ := s.settingService.GetBackupS3PathStyle()
+	client, err := s3.New(endpoint, region, bucket, accessKey, secretKey, pathStyle)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return client, prefix, nil
+}
+
+// prune removes the backups of a target the retention doesn't keep, and marks their records.
+func (s *BackupService) prune(target string, list func() ([]string, error), remove func(name string) error) {
+	keepLast, _ := s.settingService.GetBackupKeepLast()
+	keepDaily, _ := s.settingService.GetBackupKeepDaily()
+	keepWeekly, _ := s.settingService.GetBackupKeepWeekly()
+	if keepLast == 0 && keepDaily == 0 && keepWeekly == 0 {
+		return
+	}
+	names, err := list()
+	if err != nil {
+		logger.Warningf("Unable to list %s backups: %v", target, err)
+		return
+	}
+	var backups []backupFile
+	for _, name := range names {
+		match := backupNameRegex.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, match[1], time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{name: name, time: t})
+	}
+
+	keep := retainBackups(backups, keepLast, keepDaily, keepWeekly)
+	db := database.GetDB()
+	for _, backup := range backups {
+		if keep[backup.name] {
+			continue
+		}
+		if err := remove(backup.name); err != nil {
+			logger.Warningf("Unable to remove %s backup %s: %v", target, backup.name, err)
+			continue
+		}
+		db.Model(model.Backup{}).
+			Where("name = ? and target = ? and status = ?", backup.name, target, BackupSuccess).
+			Update("status", BackupPruned)
+	}
+}
+
+// retainBackups returns the names of the backups to keep: the latest keepLast ones, and the
+// latest one of each of the latest keepDaily days and keepWeekly weeks that have backups.
+func retainBackups(backups []backupFile, keepLast, keepDaily, keepWeekly int) map[string]bool {
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	keep := map[string]bool{}
+	for i := 0; i < keepLast && i < len(backups); i++ {
+		keep[backups[i].name] = true
+	}
+	for _, period := range []struct {
+		count int
+		key   func(t time.Time) string
+	}{
+		{keepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{keepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprint(year, "-", week)
+		}},
+	} {
+		seen := map[string]bool{}
+		for _, backup := range backups {
+			if len(seen) >= period.count {
+				break
+			}
+			key := period.key(backup.time)
+			if !seen[key] {
+				seen[key] = true
+				keep[backup.name] = true
+			}
+		}
+	}
+	return keep
+}
+
+// snapshotDB returns a consistent copy of the database.
+func snapshotDB() ([]byte, error) {
+	dir, err := os.MkdirTemp("", "x-ui-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "x-ui.db")
+	if err := database.Snapshot(file); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+// readDB returns the database as a SQLite file. The live SQLite file is read after a checkpoint,
+// other engines are copied into a snapshot.
+func readDB() ([]byte, error) {
+	if !database.IsSQLite() {
+		return snapshotDB()
+	}
+	if err := database.Checkpoint(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(config.GetDBDSN())
+}
+
+// writeLocalBackup writes a backup with its checksum file to a directory. The backup only
+// gets its name once it is completely written.
+func writeLocalBackup(dir, name string, data, checksumLine []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	temp := filepath.Join(dir, "."+name+".tmp")
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, filepath.Join(dir, name)); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+checksumExt), checksumLine, 0o600)
+}
@@ -0,0 +1,438 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/telegoapi"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Places broadcasts are sent from.
+const (
+	BroadcastSourcePanel = "panel"
+	BroadcastSourceTgbot = "tgbot"
+)
+
+// States of a broadcast.
+const (
+	BroadcastQueued      = "queued"
+	BroadcastSending     = "sending"
+	BroadcastDone        = "done"
+	BroadcastInterrupted = "interrupted"
+)
+
+const (
+	// maxBroadcastText is the longest message Telegram accepts.
+	maxBroadcastText = 4096
+	// broadcastInterval keeps the sender below the limit of about 30 messages per second
+	// Telegram puts on bots.
+	broadcastInterval = 40 * time.Millisecond
+	// maxBroadcastRetries caps the retries of a message Telegram asks to send later.
+	maxBroadcastRetries = 3
+)
+
+// broadcastQueue passes queued broadcasts to the sender, which sends them one after another.
+// The sender keeps running across panel restarts.
+var (
+	broadcastQueue      = make(chan int, 100)
+	broadcastSenderOnce sync.Once
+)
+
+// BroadcastFilter selects the clients whose Telegram users receive a broadcast. A client has
+// to match all the filters that are set.
+type BroadcastFilter struct {
+	InboundIds   []int `json:"inboundIds" form:"inboundIds"`     // Inbounds of the clients, empty for all
+	PlanId       int   `json:"planId" form:"planId"`             // Signup plan the clients were created with, 0 for any
+	ExpiringDays int   `json:"expiringDays" form:"expiringDays"` // Clients expiring within the days, 0 for any
+	Depleted     bool  `json:"depleted" form:"depleted"`         // Clients that expired or used up their traffic
+}
+
+// BroadcastRequest is a message to broadcast with the filter of its recipients.
+type BroadcastRequest struct {
+	BroadcastFilter
+	Text string `json:"text" form:"text"`
+}
+
+// BroadcastService sends messages by the Telegram bot to the users bound to clients.
+type BroadcastService struct {
+	inboundService InboundService
+}
+
+// GetBroadcasts returns the latest broadcasts, newest first.
+func (s *BroadcastService) GetBroadcasts() ([]*model.Broadcast, error) {
+	db := database.GetDB()
+	var broadcasts []*model.Broadcast
+	if err := db.Model(model.Broadcast{}).Order("id desc").Limit(50).Find(&broadcasts).Error; err != nil {
+		return nil, err
+	}
+	return broadcasts, nil
+}
+
+// GetRecipients returns the Telegram users bound to the clients matching a filter, each once.
+func (s *BroadcastService) GetRecipients(filter *BroadcastFilter) ([]int64, error) {
+	if filter.PlanId < 0 || filter.ExpiringDays < 0 {
+		return nil, common.NewError("broadcast plan and expiring days must not be negative")
+	}
+	db := database.GetDB()
+	var planEmails map[string]bool
+	if filter.PlanId > 0 {
+		var emails []string
+		err := db.Model(model.SignupRequest{}).
+			Where("plan_id = ? and status = ? and email != ''", filter.PlanId, "approved").
+			Pluck("email", &emails).Error
+		if err != nil {
+			return nil, err
+		}
+		planEmails = make(map[string]bool, len(emails))
+		for _, email := range emails {
+			planEmails[email] = true
+		}
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	seen := map[int64]bool{}
+	recipients := []int64{}
+	for _, inbound := range inbounds {
+		if len(filter.InboundIds) > 0 && !slices.Contains(filter.InboundIds, inbound.Id) {
+			continue
+		}
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.TgID == 0 || seen[client.TgID] {
+				continue
+			}
+			if planEmails != nil && !planEmails[client.Email] {
+				continue
+			}
+			if filter.ExpiringDays > 0 || filter.Depleted {
+				expiry, total, used := client.ExpiryTime, client.TotalGB, int64(0)
+				for _, traffic := range inbound.ClientStats {
+					if traffic.Email == client.Email {
+						expiry, total, used = traffic.ExpiryTime, traffic.Total, traffic.Up+traffic.Down
+						break
+					}
+				}
+				expired := expiry > 0 && expiry <= now
+				if filter.ExpiringDays > 0 && (expired || expiry <= 0 || expiry-now > int64(filter.ExpiringDays)*86400000) {
+					continue
+				}
+				if filter.Depleted && !expired && (total <= 0 || used < total) {
+					continue
+				}
+			}
+			seen[client.TgID] = true
+			recipients = append(recipients, client.TgID)
+		}
+	}
+	return recipients, nil
+}
+
+// Queue records a broadcast and passes it to the sender. The outcome is reported to reportChat
+// once the broadcast is sent, unless it is 0.
+func (s *BroadcastService) Queue(req *BroadcastRequest, source string, reportChat int64) (*model.Broadcast, error) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return nil, common.NewError("broadcast text is empty")
+	}
+	if utf8.RuneCountInString(text) > maxBroadcastText {
+		return nil, common.NewErrorf("broadcast text must be at most %d characters", maxBroadcastText)
+	}
+	if !isRunning {
+		return nil, common.NewError("Telegram bot is not running")
+	}
+	recipients, err := s.GetRecipients(&req.BroadcastFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, common.NewError("no Telegram users match the broadcast filter")
+	}
+	filter, err := json.Marshal(req.BroadcastFilter)
+	if err != nil {
+		return nil, err
+	}
+	broadcast := &model.Broadcast{
+		Text:       text,
+		Filter:     string(filter),
+		Source:     source,
+		Status:     BroadcastQueued,
+		Total:      len(recipients),
+		CreatedAt:  time.Now().UnixMilli(),
+		ReportChat: reportChat,
+	}
+	db := database.GetDB()
+	if err := db.Create(broadcast).Error; err != nil {
+		return nil, err
+	}
+
+	s.StartSender()
+	select {
+	case broadcastQueue <- broadcast.Id:
+	default:
+		broadcast.Status = BroadcastInterrupted
+		broadcast.FinishedAt = time.Now().UnixMilli()
+		db.Save(broadcast)
+		return nil, common.NewError("too many broadcasts are queued, try again later")
+	}
+	return broadcast, nil
+}
+
+// StartSender starts sending queued broadcasts, once per process. Broadcasts left queued or
+// sending by an earlier run are marked as interrupted.
+func (s *BroadcastService) StartSender() {
+	broadcastSenderOnce.Do(func() {
+		s.interruptPending()
+		go s.sender()
+	})
+}
+
+func (s *BroadcastService) interruptPending() {
+	db := database.GetDB()
+	err := db.Model(model.Broadcast{}).
+		Where("status in ?", []string{BroadcastQueued, BroadcastSending}).
+		Updates(map[string]any{"status": BroadcastInterrupted, "finished_at": time.Now().UnixMilli()}).Error
+	if err != nil {
+		logger.Warning("Unable to interrupt pending broadcasts:", err)
+	}
+}
+
+// sender sends the queued broadcasts one after another.
+func (s *BroadcastService) sender() {
+	for id := range broadcastQueue {
+		s.send(id)
+	}
+}
+
+// send delivers a broadcast to its recipients at the rate Telegram allows, saving its progress
+// as it goes.
+func (s *BroadcastService) send(id int) {
+	db := database.GetDB()
+	broadcast := &model.Broadcast{}
+	if err := db.Model(model.Broadcast{}).Where("id = ?", id).First(broadcast).Error; err != nil {
+		logger.Warning("Unable to load broadcast:", err)
+		return
+	}
+	// The clients may have changed while the broadcast was queued
+	var filter BroadcastFilter
+	var recipients []int64
+	err := json.Unmarshal([]byte(broadcast.Filter), &filter)
+	if err == nil {
+		recipients, err = s.GetRecipients(&filter)
+	}
+	if err != nil {
+		logger.Warning("Unable to get broadcast recipients:", err)
+		broadcast.Status = BroadcastInterrupted
+		broadcast.FinishedAt = time.Now().UnixMilli()
+		db.Save(broadcast)
+		return
+	}
+	broadcast.Status = BroadcastSending
+	broadcast.Total = len(recipients)
+	db.Save(broadcast)
+
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+	lastSaved := time.Now()
+	for _, chatId := range recipients {
+		<-ticker.C
+		if !isRunning {
+			break
+		}
+		switch err := sendBroadcastMessage(chatId, broadcast.Text); {
+		case err == nil:
+			broadcast.Delivered++
+		case isBotBlocked(err):
+			broadcast.Blocked++
+		default:
+			logger.Debug("Unable to send broadcast to", chatId, ":", err)
+			broadcast.Failed++
+		}
+		if time.Since(lastSaved) >= 5*time.Second {
+			db.Save(broadcast)
+			lastSaved = time.Now()
+		}
+	}
+
+	broadcast.Status = BroadcastDone
+	if broadcast.Delivered+broadcast.Failed+broadcast.Blocked < broadcast.Total {
+		broadcast.Status = BroadcastInterrupted
+	}
+	broadcast.FinishedAt = time.Now().UnixMilli()
+	if err := db.Save(broadcast).Error; err != nil {
+		logger.Warning("Unable to save broadcast:", err)
+	}
+	logger.Infof("Broadcast %d %s: %d delivered, %d failed, %d blocked of %d",
+		broadcast.Id, broadcast.Status, broadcast.Delivered, broadcast.Failed, broadcast.Blocked, broadcast.Total)
+
+	if broadcast.ReportChat != 0 && isRunning {
+		t := (&Tgbot{}).forChat(broadcast.ReportChat)
+		t.SendMsgToTgbot(broadcast.ReportChat, t.broadcastReportMsg(broadcast))
+	}
+}
+
+// sendBroadcastMessage sends the plain text of a broadcast to a chat, waiting as long as
+// Telegram asks when the bot sends too fast.
+func sendBroadcastMessage(chatId int64, text string) error {
+	params := &telego.SendMessageParams{
+		ChatID: tu.ID(chatId),
+		Text:   text,
+	}
+	var err error
+	for range maxBroadcastRetries {
+		if _, err = bot.SendMessage(context.Background(), params); err == nil {
+			return nil
+		}
+		var apiErr *telegoapi.Error
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode != http.StatusTooManyRequests ||
+			apiErr.Parameters == nil || apiErr.Parameters.RetryAfter <= 0 {
+			return err
+		}
+		time.Sleep(time.Duration(apiErr.Parameters.RetryAfter) * time.Second)
+	}
+	return err
+}
+
+// isBotBlocked reports whether an error means the user can't be messaged anymore, because they
+// blocked the bot or deleted their account.
+func isBotBlocked(err error) bool {
+	var apiErr *telegoapi.Error
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == http.StatusForbidden
+}
+
+// broadcastReportMsg renders the outcome of a broadcast.
+func (t *Tgbot) broadcastReportMsg(broadcast *model.Broadcast) string {
+	key := "tgbot.messages.broadcastDone"
+	if broadcast.Status == BroadcastInterrupted {
+		key = "tgbot.messages.broadcastInterrupted"
+	}
+	return t.I18nBot(key,
+		"Total=="+fmt.Sprint(broadcast.Total),
+		"Delivered=="+fmt.Sprint(broadcast.Delivered),
+		"Failed=="+fmt.Sprint(broadcast.Failed),
+		"Blocked=="+fmt.Sprint(broadcast.Blocked))
+}
+
+// offerBroadcast parses the /broadcast command of an admin and asks to confirm the broadcast,
+// showing how many Telegram users it reaches. Filters lead the message text, like
+// "/broadcast inbound=1,2 plan=3 expiring=7 depleted Text".
+func (t *Tgbot) offerBroadcast(chatId int64, command string) {
+	req, ok := parseBroadcastCommand(command)
+	if !ok {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.commands.broadcastUsage"))
+		return
+	}
+	recipients, err := t.broadcastService.GetRecipients(&req.BroadcastFilter)
+	if err != nil {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.broadcastFailed", "Error=="+html.EscapeString(err.Error())))
+		return
+	}
+	if len(recipients) == 0 {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.broadcastNoRecipients"))
+		return
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.wentWrong"))
+		return
+	}
+
+	preview := []rune(req.Text)
+	if len(preview) > 500 {
+		preview = append(preview[:500], '…')
+	}
+	keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.broadcastSend")).WithCallbackData(t.encodeQuery("broadcast_send "+string(data))),
+		tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData("broadcast_cancel"),
+	))
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.broadcastConfirm",
+		"Count=="+strconv.Itoa(len(recipients)),
+		"Text=="+html.EscapeString(string(preview))), keyboard)
+}
+
+// parseBroadcastCommand splits a /broadcast command into its filters and the message text,
+// keeping the line breaks of the text.
+func parseBroadcastCommand(command string) (*BroadcastRequest, bool) {
+	req := &BroadcastRequest{}
+	rest := command
+	for first := true; ; first = false {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		token := rest[:end]
+		if first {
+			// Skip the command itself
+			rest = rest[end:]
+			continue
+		}
+		key, value, hasValue := strings.Cut(token, "=")
+		var err error
+		switch {
+		case key == "inbound" && hasValue:
+			for id := range strings.SplitSeq(value, ",") {
+				var inboundId int
+				if inboundId, err = strconv.Atoi(id); err != nil {
+					break
+				}
+				req.InboundIds = append(req.InboundIds, inboundId)
+			}
+		case key == "plan" && hasValue:
+			req.PlanId, err = strconv.Atoi(value)
+		case key == "expiring" && hasValue:
+			req.ExpiringDays, err = strconv.Atoi(value)
+		case key == "depleted" && !hasValue:
+			req.Depleted = true
+		default:
+			req.Text = strings.TrimSpace(rest)
+			return req, req.Text != ""
+		}
+		if err != nil {
+			return nil, false
+		}
+		rest = rest[end:]
+	}
+}
+
+// sendBroadcast queues a broadcast an admin confirmed. Its outcome is reported to the admin
+// once it is sent.
+func (t *Tgbot) sendBroadcast(chatId int64, callbackQuery *telego.CallbackQuery, data string) {
+	messageId := callbackQuery.Message.GetMessageID()
+	req := &BroadcastRequest{}
+	if err := json.Unmarshal([]byte(data), req); err != nil {
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+		return
+	}
+	broadcast, err := t.broadcastService.Queue(req, BroadcastSourceTgbot, chatId)
+	if err != nil {
+		t.editMessageTgBot(chatId, messageId, t.I18nBot("tgbot.messages.broadcastFailed", "Error=="+html.EscapeString(err.Error())))
+		return
+	}
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+	t.editMessageTgBot(chatId, messageId, t.I18nBot("tgbot.messages.broadcastQueued", "Count=="+strconv.Itoa(broadcast.Total)))
+}
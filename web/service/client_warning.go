@@ -0,0 +1,249 @@
+package service
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"slices"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/notify"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Kinds of client warnings.
+const (
+	ClientWarnExpiry  = "expiry"
+	ClientWarnTraffic = "traffic"
+)
+
+// ClientWarningService warns clients before they expire or use up their traffic. Clients bound to
+// a Telegram user are warned by the bot, clients whose email is an address by the email channels
+// routed to client warnings.
+type ClientWarningService struct {
+	inboundService      InboundService
+	settingService      SettingService
+	notificationService NotificationService
+	tgbotService        Tgbot
+}
+
+// Check warns the clients that passed a warning threshold since the last check. Each threshold is
+// warned about once, and when several are passed at once only the closest one is sent. Thresholds
+// a client is no longer past, like after a renewal or a traffic reset, are armed again.
+func (s *ClientWarningService) Check() {
+	days, err := s.settingService.GetClientWarnDays()
+	if err != nil {
+		logger.Warning("Invalid client warning days:", err)
+	}
+	percents, err := s.settingService.GetClientWarnTraffic()
+	if err != nil {
+		logger.Warning("Invalid client warning traffic:", err)
+	}
+	if len(days) == 0 && len(percents) == 0 {
+		return
+	}
+	tgbot := s.tgbotService.IsRunning()
+	email := s.notificationService.HasChannelFor(NotifyEventClientWarning)
+	if !tgbot && !email {
+		return
+	}
+	contact, _ := s.settingService.GetClientWarnContact()
+
+	db := database.GetDB()
+	var warnings []*model.ClientWarning
+	if err := db.Model(model.ClientWarning{}).Find(&warnings).Error; err != nil {
+		logger.Warning("Unable to get client warnings:", err)
+		return
+	}
+	sent := map[string][]*model.ClientWarning{}
+	for _, warning := range warnings {
+		sent[warning.Email] = append(sent[warning.Email], warning)
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		logger.Warning("Unable to load inbounds:", err)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	seen := map[string]bool{}
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			i := slices.IndexFunc(inbound.ClientStats, func(traffic xray.ClientTraffic) bool { return traffic.Email == client.Email })
+			if seen[client.Email] || i < 0 {
+				continue
+			}
+			seen[client.Email] = true
+			traffic := &inbound.ClientStats[i]
+			toChat := tgbot && client.TgID != 0 && !checkAdmin(client.TgID)
+			address := ""
+			if email {
+				address = clientMailAddress(client.Email)
+			}
+			// The traffic job disables depleted and expired clients within seconds, so their
+			// traffic is checked regardless to send the last warnings
+			if !client.Enable || (!toChat && address == "") {
+				continue
+			}
+
+			var passedDays, passedPercents []int
+			for _, day := range days {
+				if traffic.ExpiryTime > 0 && traffic.ExpiryTime-now <= int64(day)*86400000 {
+					passedDays = append(passedDays, day)
+				}
+			}
+			for _, percent := range percents {
+				if traffic.Total > 0 && (traffic.Up+traffic.Down)*100 >= traffic.Total*int64(percent) {
+					passedPercents = append(passedPercents, percent)
+				}
+			}
+			if s.update(client.Email, ClientWarnExpiry, passedDays, sent[client.Email], now) {
+				s.send(traffic, ClientWarnExpiry, toChat, client.TgID, address, contact)
+			}
+			if s.update(client.Email, ClientWarnTraffic, passedPercents, sent[client.Email], now) {
+				s.send(traffic, ClientWarnTraffic, toChat, client.TgID, address, contact)
+			}
+		}
+	}
+
+	// Forget the warnings of clients that no longer exist
+	for email, warnings := range sent {
+		if seen[email] {
+			continue
+		}
+		for _, warning := range warnings {
+			db.Delete(warning)
+		}
+	}
+}
+
+// update records the passed thresholds of a kind and re-arms the others. It reports whether a
+// threshold was passed that hasn't been warned about yet.
+func (s *ClientWarningService) update(email string, kind string, passed []int, sent []*model.ClientWarning, now int64) bool {
+	db := database.GetDB()
+	warn := false
+	for _, threshold := range passed {
+		if !slices.ContainsFunc(sent, func(warning *model.ClientWarning) bool {
+			return warning.Kind == kind && warning.Threshold == threshold
+		}) {
+			err := db.Create(&model.ClientWarning{Email: email, Kind: kind, Threshold: threshold, SentAt: now}).Error
+			if err != nil {
+				logger.Warning("Unable to save client warning:", err)
+				continue
+			}
+			warn = true
+		}
+	}
+	for _, warning := range sent {
+		if warning.Kind == kind && !slices.Contains(passed, warning.Threshold) {
+			db.Delete(warning)
+		}
+	}
+	return warn
+}
+
+// send delivers a warning to the Telegram user of the client and to its email address.
+func (s *ClientWarningService) send(traffic *xray.ClientTraffic, kind string, toChat bool, tgId int64, address string, contact string) {
+	if toChat {
+		t := s.tgbotService.forChat(tgId)
+		text := t.clientWarningMsg(traffic, kind)
+		renew := tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.renew"))
+		if contact != "" {
+			renew = renew.WithURL(contact)
+		} else {
+			renew = renew.WithCallbackData(t.encodeQuery("client_renew " + traffic.Email))
+		}
+		keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(renew))
+		t.SendMsgToTgbot(tgId, text, keyboard)
+	}
+	if address != "" {
+		t := &s.tgbotService
+		text := t.clientWarningMsg(traffic, kind)
+		if contact != "" {
+			text += "\r\n\r\n" + t.I18nBot("tgbot.buttons.renew") + ": " + contact
+		}
+		s.notificationService.SendTo(notify.Message{
+			Event: NotifyEventClientWarning,
+			Title: notify.PlainText(t.I18nBot("tgbot.messages.clientWarnSubject", "Email=="+traffic.Email)),
+			Text:  text,
+		}, address)
+	}
+}
+
+// clientWarningMsg renders the warning of a kind about a client.
+func (t *Tgbot) clientWarningMsg(traffic *xray.ClientTraffic, kind string) string {
+	email := html.EscapeString(traffic.Email)
+	if kind == ClientWarnExpiry {
+		left := max(traffic.ExpiryTime-time.Now().UnixMilli(), 0)
+		remaining := fmt.Sprintf("%d %s", left/3600000, t.I18nBot("tgbot.hours"))
+		if left >= 86400000 {
+			remaining = fmt.Sprintf("%d %s", left/86400000, t.I18nBot("tgbot.days"))
+		}
+		return t.I18nBot("tgbot.messages.clientWarnExpiry",
+			"Email=="+email,
+			"Remaining=="+remaining,
+			"Time=="+time.UnixMilli(traffic.ExpiryTime).Format("2006-01-02 15:04:05"))
+	}
+	used := traffic.Up + traffic.Down
+	if used >= traffic.Total {
+		return t.I18nBot("tgbot.messages.clientWarnDepleted", "Email=="+email, "Total=="+common.FormatTraffic(traffic.Total))
+	}
+	return t.I18nBot("tgbot.messages.clientWarnTraffic",
+		"Email=="+email,
+		"Percent=="+fmt.Sprint(used*100/traffic.Total),
+		"Used=="+common.FormatTraffic(used),
+		"Total=="+common.FormatTraffic(traffic.Total))
+}
+
+// requestRenew lets the admins know a Telegram user asks to renew one of their clients.
+func (t *Tgbot) requestRenew(chatId int64, callbackQuery *telego.CallbackQuery, email string) {
+	from := callbackQuery.From
+	traffics, err := t.inboundService.GetClientTrafficTgBot(from.ID)
+	if err != nil || !slices.ContainsFunc(traffics, func(traffic *xray.ClientTraffic) bool { return traffic.Email == email }) {
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.noResult"))
+		return
+	}
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+	// Drop the button, so the admins are only asked once
+	t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), nil)
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.renewRequested", "Email=="+html.EscapeString(email)))
+
+	username := "-"
+	if from.Username != "" {
+		username = "@" + from.Username
+	}
+	for _, adminId := range adminIds {
+		admin := t.forChat(adminId)
+		keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(admin.I18nBot("tgbot.buttons.clientUsage")).WithCallbackData(t.encodeQuery("client_get_usage " + email)),
+		))
+		admin.SendMsgToTgbot(adminId, admin.I18nBot("tgbot.messages.renewNewRequest",
+			"Name=="+html.EscapeString(from.FirstName),
+			"Username=="+username,
+			"TgUserID=="+fmt.Sprint(from.ID),
+			"Email=="+html.EscapeString(email)), keyboard)
+	}
+}
+
+// clientMailAddress returns the email of a client if it is a mail address, or an empty string.
+func clientMailAddress(email string) string {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ""
+	}
+	return address.Address
+}
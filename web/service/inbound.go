@@ -4,16 +4,20 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/core"
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/geoip"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"gorm.io/gorm"
@@ -31,7 +35,7 @@ type InboundService struct {
 func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where("user_id = ?", userId).Find(&inbounds).Error
+	err := model.FindInbounds(db.Model(model.Inbound{}).Preload("ClientStats").Where("user_id = ?", userId), &inbounds)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -62,7 +66,7 @@ func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Find(&inbounds).Error
+	err := model.FindInbounds(db.Model(model.Inbound{}).Preload("ClientStats"), &inbounds)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -90,7 +94,7 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 func (s *InboundService) GetInboundsByTrafficReset(period string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Where("traffic_reset = ?", period).Find(&inbounds).Error
+	err := model.FindInbounds(db.Model(model.Inbound{}).Where("traffic_reset = ?", period), &inbounds)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
@@ -108,13 +112,13 @@ func (s *InboundService) checkPortExist(listen string, port int, ignoreId int) (
 				db.Model(model.Inbound{}).Where(
 					"listen = ?", listen,
 				).Or(
-					"listen = \"\"",
+					"listen = ''",
 				).Or(
-					"listen = \"0.0.0.0\"",
+					"listen = '0.0.0.0'",
 				).Or(
-					"listen = \"::\"",
+					"listen = '::'",
 				).Or(
-					"listen = \"::0\""))
+					"listen = '::0'"))
 	}
 	if ignoreId > 0 {
 		db = db.Where("id != ?", ignoreId)
@@ -144,11 +148,7 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
-	err := db.Raw(`
-		SELECT JSON_EXTRACT(client.value, '$.email')
-		FROM inbounds,
-			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		`).Scan(&emails).Error
+	err := db.Model(model.InboundClient{}).Order("id").Pluck("email", &emails).Error
 	if err != nil {
 		return nil, err
 	}
@@ -165,76 +165,57 @@ func (s *InboundService) contains(slice []string, str string) bool {
 	return false
 }
 
-func (s *InboundService) checkEmailsExistForClients(clients []model.Client) (string, error) {
-	allEmails, err := s.getAllEmails()
+// findUsedEmails returns the emails of the list that clients already use, ignoring case.
+func (s *InboundService) findUsedEmails(emails []string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, len(emails))
+	for i, email := range emails {
+		lowered[i] = strings.ToLower(email)
+	}
+	db := database.GetDB()
+	var used []string
+	err := db.Model(model.InboundClient{}).Where("LOWER(email) IN ?", lowered).Pluck("email", &used).Error
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return used, nil
+}
+
+func (s *InboundService) checkEmailsExistForClients(clients []model.Client) (string, error) {
 	var emails []string
 	for _, client := range clients {
 		if client.Email != "" {
 			if s.contains(emails, client.Email) {
 				return client.Email, nil
 			}
-			if s.contains(allEmails, client.Email) {
-				return client.Email, nil
-			}
 			emails = append(emails, client.Email)
 		}
 	}
-	return "", nil
-}
-
-func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (string, error) {
-	clients, err := s.GetClients(inbound)
-	if err != nil {
-		return "", err
-	}
-	allEmails, err := s.getAllEmails()
+	usedEmails, err := s.findUsedEmails(emails)
 	if err != nil {
 		return "", err
 	}
-	var emails []string
-	for _, client := range clients {
-		if client.Email != "" {
-			if s.contains(emails, client.Email) {
-				return client.Email, nil
-			}
-			if s.contains(allEmails, client.Email) {
-				return client.Email, nil
-			}
-			emails = append(emails, client.Email)
+	for _, email := range emails {
+		if s.contains(usedEmails, email) {
+			return email, nil
 		}
 	}
 	return "", nil
 }
 
-// AddInbound creates a new inbound configuration.
-// It validates port uniqueness, client email uniqueness, and required fields,
-// then saves the inbound to the database and optionally adds it to the running Xray instance.
-// Returns the created inbound, whether Xray needs restart, and any error.
-func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
-	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
-	if err != nil {
-		return inbound, false, err
-	}
-	if exist {
-		return inbound, false, common.NewError("Port already exists:", inbound.Port)
-	}
-
-	existEmail, err := s.checkEmailExistForInbound(inbound)
-	if err != nil {
-		return inbound, false, err
-	}
-	if existEmail != "" {
-		return inbound, false, common.NewError("Duplicate email:", existEmail)
-	}
-
+func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (string, error) {
 	clients, err := s.GetClients(inbound)
 	if err != nil {
-		return inbound, false, err
+		return "", err
 	}
+	return s.checkEmailsExistForClients(clients)
+}
 
+// prepareInboundClients stamps the clients of a new inbound with their creation and update
+// times and checks that each of them has an ID.
+func (s *InboundService) prepareInboundClients(inbound *model.Inbound, clients []model.Client) error {
 	// Ensure created_at and updated_at on clients in settings
 	if len(clients) > 0 {
 		var settings map[string]any
@@ -262,26 +243,63 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 	// Secure client ID
 	for _, client := range clients {
 		switch inbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			if client.Password == "" {
-				return inbound, false, common.NewError("empty client ID")
+				return common.NewError("empty client ID")
 			}
 		case "shadowsocks":
 			if client.Email == "" {
-				return inbound, false, common.NewError("empty client ID")
+				return common.NewError("empty client ID")
 			}
 		default:
 			if client.ID == "" {
-				return inbound, false, common.NewError("empty client ID")
+				return common.NewError("empty client ID")
 			}
 		}
 	}
+	return nil
+}
+
+// AddInbound creates a new inbound configuration.
+// It validates port uniqueness, client email uniqueness, and required fields,
+// then saves the inbound to the database and optionally adds it to the running Xray instance.
+// Returns the created inbound, whether Xray needs restart, and any error.
+func (s *InboundService) AddInbound(inbound *model.Inbound) (_ *model.Inbound, needRestart bool, err error) {
+	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
+	if err != nil {
+		return inbound, false, err
+	}
+	if exist {
+		return inbound, false, common.NewError("Port already exists:", inbound.Port)
+	}
+
+	existEmail, err := s.checkEmailExistForInbound(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+	if existEmail != "" {
+		return inbound, false, common.NewError("Duplicate email:", existEmail)
+	}
+
+	clients, err := s.GetClients(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+
+	if err = s.prepareInboundClients(inbound, clients); err != nil {
+		return inbound, false, err
+	}
 
 	db := database.GetDB()
 	tx := db.Begin()
+	var exitRuleTags []string
 	defer func() {
 		if err == nil {
 			tx.Commit()
+			// Xray only gets the exit rules of clients that were saved
+			if s.applyClientExitRules(exitRuleTags) {
+				needRestart = true
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -298,8 +316,9 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		return inbound, false, err
 	}
 
-	needRestart := false
-	if inbound.Enable {
+	if inbound.Enable && core.ForProtocol(inbound.Protocol) != core.Xray {
+		needRestart = true
+	} else if inbound.Enable {
 		s.xrayApi.Init(p.GetAPIPort())
 		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
 		if err1 != nil {
@@ -316,19 +335,137 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		s.xrayApi.Close()
 	}
 
+	exitRuleTags = clientExitRuleTags(clients)
 	return inbound, needRestart, err
 }
 
+// ReplaceInbound replaces the inbound of the given ID with a new inbound. The old inbound, its
+// clients and their traffic are removed in the transaction that adds the new one, so they stay
+// in place if it can't be added.
+// Returns the new inbound, whether Xray needs restart, and any error.
+func (s *InboundService) ReplaceInbound(id int, inbound *model.Inbound) (*model.Inbound, bool, error) {
+	oldInbound, err := s.GetInbound(id)
+	if err != nil {
+		return inbound, false, err
+	}
+	oldClients, err := s.GetClients(oldInbound)
+	if err != nil {
+		return inbound, false, err
+	}
+	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, id)
+	if err != nil {
+		return inbound, false, err
+	}
+	if exist {
+		return inbound, false, common.NewError("Port already exists:", inbound.Port)
+	}
+
+	clients, err := s.GetClients(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+	// Emails of the replaced inbound are free for the new one
+	var emails []string
+	for _, client := range clients {
+		if client.Email == "" {
+			continue
+		}
+		if slices.ContainsFunc(emails, func(email string) bool { return strings.EqualFold(email, client.Email) }) {
+			return inbound, false, common.NewError("Duplicate email:", client.Email)
+		}
+		emails = append(emails, client.Email)
+	}
+	usedEmails, err := s.findUsedEmails(emails)
+	if err != nil {
+		return inbound, false, err
+	}
+	for _, email := range usedEmails {
+		if !slices.ContainsFunc(oldClients, func(client model.Client) bool { return strings.EqualFold(client.Email, email) }) {
+			return inbound, false, common.NewError("Duplicate email:", email)
+		}
+	}
+	if err = s.prepareInboundClients(inbound, clients); err != nil {
+		return inbound, false, err
+	}
+
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("inbound_id = ?", id).Delete(xray.ClientTraffic{}).Error; err != nil {
+			return err
+		}
+		for _, client := range oldClients {
+			if err := s.DelClientIPs(tx, client.Email); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("inbound_id = ?", id).Delete(model.InboundClient{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(model.Inbound{}, id).Error; err != nil {
+			return err
+		}
+		inbound.Id = 0
+		if err := tx.Save(inbound).Error; err != nil {
+			return err
+		}
+		if len(inbound.ClientStats) == 0 {
+			for _, client := range clients {
+				s.AddClientStat(tx, inbound.Id, &client)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return inbound, false, err
+	}
+
+	// Other cores take the change with their next config sync
+	needRestart := false
+	s.xrayApi.Init(p.GetAPIPort())
+	if oldInbound.Enable && core.ForProtocol(oldInbound.Protocol) == core.Xray {
+		if err1 := s.xrayApi.DelInbound(oldInbound.Tag); err1 == nil {
+			logger.Debug("Replaced inbound deleted by api:", oldInbound.Tag)
+		} else {
+			logger.Debug("Unable to delete replaced inbound by api:", err1)
+			needRestart = true
+		}
+	}
+	if inbound.Enable && core.ForProtocol(inbound.Protocol) == core.Xray {
+		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
+		if err1 == nil {
+			err1 = s.xrayApi.AddInbound(inboundJson)
+		}
+		if err1 == nil {
+			logger.Debug("New inbound added by api:", inbound.Tag)
+		} else {
+			logger.Debug("Unable to add inbound by api:", err1)
+			needRestart = true
+		}
+	}
+	s.xrayApi.Close()
+
+	if s.applyClientExitRules(clientExitRuleTags(oldClients, clients)) {
+		needRestart = true
+	}
+	return inbound, needRestart, nil
+}
+
 // DelInbound deletes an inbound configuration by ID.
 // It removes the inbound from the database and the running Xray instance if active.
 // Returns whether Xray needs restart and any error.
 func (s *InboundService) DelInbound(id int) (bool, error) {
 	db := database.GetDB()
 
-	var tag string
+	var enabled struct {
+		Tag      string
+		Protocol model.Protocol
+	}
 	needRestart := false
-	result := db.Model(model.Inbound{}).Select("tag").Where("id = ? and enable = ?", id, true).First(&tag)
-	if result.Error == nil {
+	result := db.Model(model.Inbound{}).Select("tag, protocol").Where("id = ? and enable = ?", id, true).Take(&enabled)
+	tag := enabled.Tag
+	if result.Error == nil && core.ForProtocol(enabled.Protocol) != core.Xray {
+		logger.Debug("Inbound is dropped with the next config sync of", core.ForProtocol(enabled.Protocol))
+	} else if result.Error == nil {
 		s.xrayApi.Init(p.GetAPIPort())
 		err1 := s.xrayApi.DelInbound(tag)
 		if err1 == nil {
@@ -362,7 +499,15 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 		}
 	}
 
-	return needRestart, db.Delete(model.Inbound{}, id).Error
+	err = db.Where("inbound_id = ?", id).Delete(model.InboundClient{}).Error
+	if err != nil {
+		return false, err
+	}
+	err = db.Delete(model.Inbound{}, id).Error
+	if err == nil && s.applyClientExitRules(clientExitRuleTags(clients)) {
+		needRestart = true
+	}
+	return needRestart, err
 }
 
 func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
@@ -378,7 +523,7 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 // UpdateInbound modifies an existing inbound configuration.
 // It validates changes, updates the database, and syncs with the running Xray instance.
 // Returns the updated inbound, whether Xray needs restart, and any error.
-func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+func (s *InboundService) UpdateInbound(inbound *model.Inbound) (_ *model.Inbound, needRestart bool, err error) {
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, inbound.Id)
 	if err != nil {
 		return inbound, false, err
@@ -397,11 +542,15 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	db := database.GetDB()
 	tx := db.Begin()
 
+	var exitRuleTags []string
 	defer func() {
 		if err != nil {
 			tx.Rollback()
 		} else {
 			tx.Commit()
+			if s.applyClientExitRules(exitRuleTags) {
+				needRestart = true
+			}
 		}
 	}()
 
@@ -469,6 +618,9 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		}
 	}
 
+	oldClients, _ := s.GetClients(oldInbound)
+	newClients, _ := s.GetClients(inbound)
+
 	oldInbound.Up = inbound.Up
 	oldInbound.Down = inbound.Down
 	oldInbound.Total = inbound.Total
@@ -488,12 +640,13 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		oldInbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
-	needRestart := false
 	s.xrayApi.Init(p.GetAPIPort())
 	if s.xrayApi.DelInbound(tag) == nil {
 		logger.Debug("Old inbound deleted by api:", tag)
 	}
-	if inbound.Enable {
+	if core.ForProtocol(oldInbound.Protocol) != core.Xray {
+		needRestart = true
+	} else if inbound.Enable {
 		inboundJson, err2 := json.MarshalIndent(oldInbound.GenXrayInboundConfig(), "", "  ")
 		if err2 != nil {
 			logger.Debug("Unable to marshal updated inbound config:", err2)
@@ -510,7 +663,9 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	}
 	s.xrayApi.Close()
 
-	return inbound, needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	exitRuleTags = clientExitRuleTags(oldClients, newClients)
+	return inbound, needRestart, err
 }
 
 func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inbound, newInbound *model.Inbound) error {
@@ -558,7 +713,7 @@ func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inb
 	return nil
 }
 
-func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
+func (s *InboundService) AddInboundClient(data *model.Inbound) (needRestart bool, err error) {
 	clients, err := s.GetClients(data)
 	if err != nil {
 		return false, err
@@ -598,7 +753,7 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	// Secure client ID
 	for _, client := range clients {
 		switch oldInbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			if client.Password == "" {
 				return false, common.NewError("empty client ID")
 			}
@@ -634,16 +789,19 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	db := database.GetDB()
 	tx := db.Begin()
 
+	var exitRuleTags []string
 	defer func() {
 		if err != nil {
 			tx.Rollback()
 		} else {
 			tx.Commit()
+			if s.applyClientExitRules(exitRuleTags) {
+				needRestart = true
+			}
 		}
 	}()
 
-	needRestart := false
-	s.xrayApi.Init(p.GetAPIPort())
+	s.initXrayApi()
 	for _, client := range clients {
 		if len(client.Email) > 0 {
 			s.AddClientStat(tx, data.Id, &client)
@@ -652,7 +810,7 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 				if oldInbound.Protocol == "shadowsocks" {
 					cipher = oldSettings["method"].(string)
 				}
-				err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, map[string]any{
+				err1 := s.addCoreUser(oldInbound.Protocol, oldInbound.Tag, map[string]any{
 					"email":    client.Email,
 					"id":       client.ID,
 					"security": client.Security,
@@ -673,7 +831,9 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	}
 	s.xrayApi.Close()
 
-	return needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	exitRuleTags = clientExitRuleTags(clients)
+	return needRestart, err
 }
 
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
@@ -690,7 +850,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	email := ""
 	client_key := "id"
-	if oldInbound.Protocol == "trojan" {
+	if oldInbound.Protocol == "trojan" || oldInbound.Protocol == "hysteria2" {
 		client_key = "password"
 	}
 	if oldInbound.Protocol == "shadowsocks" {
@@ -721,7 +881,9 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 		return false, err
 	}
 
+	oldClients, _ := s.GetClients(oldInbound)
 	oldInbound.Settings = string(newSettings)
+	remainingClients, _ := s.GetClients(oldInbound)
 
 	db := database.GetDB()
 
@@ -745,8 +907,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			return false, err
 		}
 		if needApiDel && notDepleted {
-			s.xrayApi.Init(p.GetAPIPort())
-			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
+			err1 := s.removeCoreUser(oldInbound.Protocol, oldInbound.Tag, email)
 			if err1 == nil {
 				logger.Debug("Client deleted by api:", email)
 				needRestart = false
@@ -758,13 +919,16 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 					needRestart = true
 				}
 			}
-			s.xrayApi.Close()
 		}
 	}
-	return needRestart, db.Save(oldInbound).Error
+	err = db.Save(oldInbound).Error
+	if err == nil && s.applyClientExitRules(changedClientExitRuleTags(oldClients, remainingClients)) {
+		needRestart = true
+	}
+	return needRestart, err
 }
 
-func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
+func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (needRestart bool, err error) {
 	// TODO: check if TrafficReset field is updating
 	clients, err := s.GetClients(data)
 	if err != nil {
@@ -795,7 +959,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	for index, oldClient := range oldClients {
 		oldClientId := ""
 		switch oldInbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			oldClientId = oldClient.Password
 			newClientId = clients[0].Password
 		case "shadowsocks":
@@ -864,11 +1028,15 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	db := database.GetDB()
 	tx := db.Begin()
 
+	var exitRuleTags []string
 	defer func() {
 		if err != nil {
 			tx.Rollback()
 		} else {
 			tx.Commit()
+			if s.applyClientExitRules(exitRuleTags) {
+				needRestart = true
+			}
 		}
 	}()
 
@@ -895,11 +1063,10 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			return false, err
 		}
 	}
-	needRestart := false
 	if len(oldEmail) > 0 {
-		s.xrayApi.Init(p.GetAPIPort())
+		s.initXrayApi()
 		if oldClients[clientIndex].Enable {
-			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail)
+			err1 := s.removeCoreUser(oldInbound.Protocol, oldInbound.Tag, oldEmail)
 			if err1 == nil {
 				logger.Debug("Old client deleted by api:", oldEmail)
 			} else {
@@ -916,7 +1083,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			if oldInbound.Protocol == "shadowsocks" {
 				cipher = oldSettings["method"].(string)
 			}
-			err1 := s.xrayApi.AddUser(string(oldInbound.Protocol), oldInbound.Tag, map[string]any{
+			err1 := s.addCoreUser(oldInbound.Protocol, oldInbound.Tag, map[string]any{
 				"email":    clients[0].Email,
 				"id":       clients[0].ID,
 				"security": clients[0].Security,
@@ -936,10 +1103,24 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		logger.Debug("Client old email not found")
 		needRestart = true
 	}
-	return needRestart, tx.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	exitRuleTags = changedClientExitRuleTags(oldClients[clientIndex:clientIndex+1], clients[:1])
+	return needRestart, err
 }
 
 func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
+	return s.addTraffic(inboundTraffics, clientTraffics, true, nil)
+}
+
+// AddNodeTraffic adds the traffic a node counted since its last sync and applies the limits
+// of the clients and inbounds. Unlike traffic of the local Xray, it leaves the online clients
+// of this server as they are. saveCounters runs in the same transaction, so the counters the
+// traffic was worked out from are only stored along with it.
+func (s *InboundService) AddNodeTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic, saveCounters func(tx *gorm.DB) error) (error, bool) {
+	return s.addTraffic(inboundTraffics, clientTraffics, false, saveCounters)
+}
+
+func (s *InboundService) addTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic, local bool, saveCounters func(tx *gorm.DB) error) (error, bool) {
 	var err error
 	db := database.GetDB()
 	tx := db.Begin()
@@ -951,11 +1132,17 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 			tx.Commit()
 		}
 	}()
+	if saveCounters != nil {
+		err = saveCounters(tx)
+		if err != nil {
+			return err, false
+		}
+	}
 	err = s.addInboundTraffic(tx, inboundTraffics)
 	if err != nil {
 		return err, false
 	}
-	err = s.addClientTraffic(tx, clientTraffics)
+	err = s.addClientTraffic(tx, clientTraffics, local)
 	if err != nil {
 		return err, false
 	}
@@ -1006,10 +1193,10 @@ func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic
 	return nil
 }
 
-func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTraffic) (err error) {
+func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTraffic, local bool) (err error) {
 	if len(traffics) == 0 {
 		// Empty onlineUsers
-		if p != nil {
+		if p != nil && local {
 			p.SetOnlineClients(nil)
 		}
 		return nil
@@ -1055,7 +1242,9 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 	}
 
 	// Set onlineUsers
-	p.SetOnlineClients(onlineClients)
+	if local {
+		p.SetOnlineClients(onlineClients)
+	}
 
 	err = tx.Save(dbClientTraffics).Error
 	if err != nil {
@@ -1075,7 +1264,7 @@ func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.Cl
 
 	if len(inboundIds) > 0 {
 		var inbounds []*model.Inbound
-		err := tx.Model(model.Inbound{}).Where("id IN (?)", inboundIds).Find(&inbounds).Error
+		err := model.FindInbounds(tx.Model(model.Inbound{}).Where("id IN (?)", inboundIds), &inbounds)
 		if err != nil {
 			return nil, err
 		}
@@ -1150,7 +1339,7 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 	for _, traffic := range traffics {
 		inbound_ids = append(inbound_ids, traffic.InboundId)
 	}
-	err = tx.Model(model.Inbound{}).Where("id IN ?", inbound_ids).Find(&inbounds).Error
+	err = model.FindInbounds(tx.Model(model.Inbound{}).Where("id IN ?", inbound_ids), &inbounds)
 	if err != nil {
 		return false, 0, err
 	}
@@ -1204,12 +1393,9 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 		return false, 0, err
 	}
 	if p != nil {
-		err1 = s.xrayApi.Init(p.GetAPIPort())
-		if err1 != nil {
-			return true, int64(len(traffics)), nil
-		}
+		s.initXrayApi()
 		for _, clientToAdd := range clientsToAdd {
-			err1 = s.xrayApi.AddUser(clientToAdd.protocol, clientToAdd.tag, clientToAdd.client)
+			err1 = s.addCoreUser(model.Protocol(clientToAdd.protocol), clientToAdd.tag, clientToAdd.client)
 			if err1 != nil {
 				needRestart = true
 			}
@@ -1224,19 +1410,26 @@ func (s *InboundService) disableInvalidInbounds(tx *gorm.DB) (bool, int64, error
 	needRestart := false
 
 	if p != nil {
-		var tags []string
+		var results []struct {
+			Tag      string
+			Protocol model.Protocol
+		}
 		err := tx.Table("inbounds").
-			Select("inbounds.tag").
+			Select("inbounds.tag, inbounds.protocol").
 			Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
-			Scan(&tags).Error
+			Scan(&results).Error
 		if err != nil {
 			return false, 0, err
 		}
 		s.xrayApi.Init(p.GetAPIPort())
-		for _, tag := range tags {
-			err1 := s.xrayApi.DelInbound(tag)
+		for _, result := range results {
+			// Other cores drop the inbound with their next config sync
+			if core.ForProtocol(result.Protocol) != core.Xray {
+				continue
+			}
+			err1 := s.xrayApi.DelInbound(result.Tag)
 			if err1 == nil {
-				logger.Debug("Inbound disabled by api:", tag)
+				logger.Debug("Inbound disabled by api:", result.Tag)
 			} else {
 				logger.Debug("Error in disabling inbound by api:", err1)
 				needRestart = true
@@ -1245,7 +1438,7 @@ func (s *InboundService) disableInvalidInbounds(tx *gorm.DB) (bool, int64, error
 		s.xrayApi.Close()
 	}
 
-	result := tx.Model(model.Inbound{}).
+	result := tx.Model(&model.Inbound{}).
 		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
 		Update("enable", false)
 	err := result.Error
@@ -1259,21 +1452,22 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 
 	if p != nil {
 		var results []struct {
-			Tag   string
-			Email string
+			Tag      string
+			Protocol model.Protocol
+			Email    string
 		}
 
 		err := tx.Table("inbounds").
-			Select("inbounds.tag, client_traffics.email").
+			Select("inbounds.tag, inbounds.protocol, client_traffics.email").
 			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
 			Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
 			Scan(&results).Error
 		if err != nil {
 			return false, 0, err
 		}
-		s.xrayApi.Init(p.GetAPIPort())
+		s.initXrayApi()
 		for _, result := range results {
-			err1 := s.xrayApi.RemoveUser(result.Tag, result.Email)
+			err1 := s.removeCoreUser(result.Protocol, result.Tag, result.Email)
 			if err1 == nil {
 				logger.Debug("Client disabled by api:", result.Email)
 			} else {
@@ -1310,18 +1504,6 @@ func (s *InboundService) GetInboundTags() (string, error) {
 	return string(tags), nil
 }
 
-func (s *InboundService) MigrationRemoveOrphanedTraffics() {
-	db := database.GetDB()
-	db.Exec(`
-		DELETE FROM client_traffics
-		WHERE email NOT IN (
-			SELECT JSON_EXTRACT(client.value, '$.email')
-			FROM inbounds,
-				JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		)
-	`)
-}
-
 func (s *InboundService) AddClientStat(tx *gorm.DB, inboundId int, client *model.Client) error {
 	clientTraffic := xray.ClientTraffic{}
 	clientTraffic.InboundId = inboundId
@@ -1352,6 +1534,10 @@ func (s *InboundService) UpdateClientStat(tx *gorm.DB, email string, client *mod
 }
 
 func (s *InboundService) UpdateClientIPs(tx *gorm.DB, oldEmail string, newEmail string) error {
+	err := tx.Model(model.ClientIpHistory{}).Where("client_email = ?", oldEmail).Update("client_email", newEmail).Error
+	if err != nil {
+		return err
+	}
 	return tx.Model(model.InboundClientIps{}).Where("client_email = ?", oldEmail).Update("client_email", newEmail).Error
 }
 
@@ -1360,6 +1546,10 @@ func (s *InboundService) DelClientStat(tx *gorm.DB, email string) error {
 }
 
 func (s *InboundService) DelClientIPs(tx *gorm.DB, email string) error {
+	err := tx.Where("client_email = ?", email).Delete(model.ClientIpHistory{}).Error
+	if err != nil {
+		return err
+	}
 	return tx.Where("client_email = ?", email).Delete(model.InboundClientIps{}).Error
 }
 
@@ -1437,7 +1627,7 @@ func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (boo
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1523,7 +1713,7 @@ func (s *InboundService) ToggleClientEnableByEmail(clientEmail string) (bool, bo
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1605,7 +1795,7 @@ func (s *InboundService) ResetClientIpLimitByEmail(clientEmail string, count int
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1664,7 +1854,7 @@ func (s *InboundService) ResetClientExpiryTimeByEmail(clientEmail string, expiry
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1726,7 +1916,7 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1766,6 +1956,88 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 	return needRestart, err
 }
 
+// UpdateClientLimitsByEmail sets the expiry time and traffic limit of a client.
+func (s *InboundService) UpdateClientLimitsByEmail(clientEmail string, expiryTime int64, totalGB int64) (bool, error) {
+	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	if err != nil {
+		return false, err
+	}
+	if inbound == nil {
+		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
+	}
+
+	oldClients, err := s.GetClients(inbound)
+	if err != nil {
+		return false, err
+	}
+
+	clientId := ""
+
+	for _, oldClient := range oldClients {
+		if oldClient.Email == clientEmail {
+			switch inbound.Protocol {
+			case "trojan", "hysteria2":
+				clientId = oldClient.Password
+			case "shadowsocks":
+				clientId = oldClient.Email
+			default:
+				clientId = oldClient.ID
+			}
+			break
+		}
+	}
+
+	if len(clientId) == 0 {
+		return false, common.NewError("Client Not Found For Email:", clientEmail)
+	}
+
+	var settings map[string]any
+	err = json.Unmarshal([]byte(inbound.Settings), &settings)
+	if err != nil {
+		return false, err
+	}
+	clients := settings["clients"].([]any)
+	var newClients []any
+	for client_index := range clients {
+		c := clients[client_index].(map[string]any)
+		if c["email"] == clientEmail {
+			c["expiryTime"] = expiryTime
+			c["totalGB"] = totalGB
+			c["updated_at"] = time.Now().Unix() * 1000
+			newClients = append(newClients, any(c))
+		}
+	}
+	settings["clients"] = newClients
+	modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	inbound.Settings = string(modifiedSettings)
+	needRestart, err := s.UpdateInboundClient(inbound, clientId)
+	return needRestart, err
+}
+
+// clientLimitsLock serializes the limit changes that build on the current limits of a client.
+var clientLimitsLock sync.Mutex
+
+// ChangeClientLimitsByEmail changes the expiry time and traffic limit of a client based on the
+// current ones. change gets the client as it is stored and returns the new limits, or an error to
+// leave the client as it is. Changes made this way don't overwrite each other.
+func (s *InboundService) ChangeClientLimitsByEmail(clientEmail string, change func(client *model.Client) (int64, int64, error)) (bool, error) {
+	clientLimitsLock.Lock()
+	defer clientLimitsLock.Unlock()
+
+	_, client, err := s.GetClientByEmail(clientEmail)
+	if err != nil {
+		return false, err
+	}
+	expiryTime, totalGB, err := change(client)
+	if err != nil {
+		return false, err
+	}
+	return s.UpdateClientLimitsByEmail(clientEmail, expiryTime, totalGB)
+}
+
 func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
 	db := database.GetDB()
 
@@ -1801,7 +2073,6 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		}
 		for _, client := range clients {
 			if client.Email == clientEmail && client.Enable {
-				s.xrayApi.Init(p.GetAPIPort())
 				cipher := ""
 				if string(inbound.Protocol) == "shadowsocks" {
 					var oldSettings map[string]any
@@ -1811,7 +2082,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 					}
 					cipher = oldSettings["method"].(string)
 				}
-				err1 := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
+				err1 := s.addCoreUser(inbound.Protocol, inbound.Tag, map[string]any{
 					"email":    client.Email,
 					"id":       client.ID,
 					"security": client.Security,
@@ -1825,7 +2096,6 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 					logger.Debug("Error in enabling client by api:", err1)
 					needRestart = true
 				}
-				s.xrayApi.Close()
 				break
 			}
 		}
@@ -1873,7 +2143,7 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 			inboundWhereText += " = ?"
 		}
 
-		result = tx.Model(model.Inbound{}).
+		result = tx.Model(&model.Inbound{}).
 			Where(inboundWhereText, id).
 			Update("last_traffic_reset_time", now)
 
@@ -1884,7 +2154,7 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 func (s *InboundService) ResetAllTraffics() error {
 	db := database.GetDB()
 
-	result := db.Model(model.Inbound{}).
+	result := db.Model(&model.Inbound{}).
 		Where("user_id > ?", 0).
 		Updates(map[string]any{"up": 0, "down": 0})
 
@@ -1913,9 +2183,13 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 	// Only consider truly depleted clients: expired OR traffic exhausted
 	now := time.Now().Unix() * 1000
 	depletedClients := []xray.ClientTraffic{}
+	groupEmails := "GROUP_CONCAT(email)"
+	if database.Dialect() == database.DialectPostgres {
+		groupEmails = "STRING_AGG(email, ',')"
+	}
 	err = db.Model(xray.ClientTraffic{}).
 		Where(whereText+" and ((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?))", id, now).
-		Select("inbound_id, GROUP_CONCAT(email) as email").
+		Select("inbound_id, " + groupEmails + " as email").
 		Group("inbound_id").
 		Find(&depletedClients).Error
 	if err != nil {
@@ -1979,27 +2253,13 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 
 func (s *InboundService) GetClientTrafficTgBot(tgId int64) ([]*xray.ClientTraffic, error) {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
-
-	// Retrieve inbounds where settings contain the given tgId
-	err := db.Model(model.Inbound{}).Where("settings LIKE ?", fmt.Sprintf(`%%"tgId": %d%%`, tgId)).Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		logger.Errorf("Error retrieving inbounds with tgId %d: %v", tgId, err)
-		return nil, err
-	}
 
+	// Retrieve the emails of the clients with the given tgId
 	var emails []string
-	for _, inbound := range inbounds {
-		clients, err := s.GetClients(inbound)
-		if err != nil {
-			logger.Errorf("Error retrieving clients for inbound %d: %v", inbound.Id, err)
-			continue
-		}
-		for _, client := range clients {
-			if client.TgID == tgId {
-				emails = append(emails, client.Email)
-			}
-		}
+	err := db.Model(model.InboundClient{}).Where("tg_id = ?", tgId).Pluck("email", &emails).Error
+	if err != nil {
+		logger.Errorf("Error retrieving clients with tgId %d: %v", tgId, err)
+		return nil, err
 	}
 
 	var traffics []*xray.ClientTraffic
@@ -2060,14 +2320,22 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 	db := database.GetDB()
 	var traffics []xray.ClientTraffic
 
-	err := db.Model(xray.ClientTraffic{}).Where(`email IN(
-		SELECT JSON_EXTRACT(client.value, '$.email') as email
-		FROM inbounds,
-	  	JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
-		WHERE
-	  	JSON_EXTRACT(client.value, '$.id') in (?)
-		)`, id).Find(&traffics).Error
+	var rows []*model.InboundClient
+	err := db.Where("settings LIKE ?", "%"+id+"%").Find(&rows).Error
+	if err != nil {
+		logger.Debug(err)
+		return nil, err
+	}
+	var emails []string
+	for _, row := range rows {
+		var client model.Client
+		json.Unmarshal([]byte(row.Settings), &client)
+		if client.ID == id {
+			emails = append(emails, client.Email)
+		}
+	}
 
+	err = db.Model(xray.ClientTraffic{}).Where("email IN ?", emails).Find(&traffics).Error
 	if err != nil {
 		logger.Debug(err)
 		return nil, err
@@ -2085,39 +2353,31 @@ func (s *InboundService) GetClientTrafficByID(id string) ([]xray.ClientTraffic,
 
 func (s *InboundService) SearchClientTraffic(query string) (traffic *xray.ClientTraffic, err error) {
 	db := database.GetDB()
-	inbound := &model.Inbound{}
 	traffic = &xray.ClientTraffic{}
 
-	// Search for inbound settings that contain the query
-	err = db.Model(model.Inbound{}).Where("settings LIKE ?", "%\""+query+"\"%").First(inbound).Error
+	// Search for clients that contain the query
+	var rows []*model.InboundClient
+	err = db.Where("settings LIKE ?", "%\""+query+"\"%").Find(&rows).Error
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			logger.Warningf("Inbound settings containing query %s not found: %v", query, err)
-			return nil, err
-		}
-		logger.Errorf("Error searching for inbound settings with query %s: %v", query, err)
+		logger.Errorf("Error searching for clients with query %s: %v", query, err)
 		return nil, err
 	}
 
-	traffic.InboundId = inbound.Id
-
-	// Unmarshal settings to get clients
-	settings := map[string][]model.Client{}
-	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
-		logger.Errorf("Error unmarshalling inbound settings for inbound ID %d: %v", inbound.Id, err)
-		return nil, err
-	}
-
-	clients := settings["clients"]
-	for _, client := range clients {
+	for _, row := range rows {
+		var client model.Client
+		if err := json.Unmarshal([]byte(row.Settings), &client); err != nil {
+			logger.Errorf("Error unmarshalling client %d of inbound ID %d: %v", row.Id, row.InboundId, err)
+			continue
+		}
 		if (client.ID == query || client.Password == query) && client.Email != "" {
+			traffic.InboundId = row.InboundId
 			traffic.Email = client.Email
 			break
 		}
 	}
 
 	if traffic.Email == "" {
-		logger.Warningf("No client found with query %s in inbound ID %d", query, inbound.Id)
+		logger.Warningf("No client found with query %s", query)
 		return nil, gorm.ErrRecordNotFound
 	}
 
@@ -2145,188 +2405,75 @@ func (s *InboundService) GetInboundClientIps(clientEmail string) (string, error)
 	return InboundClientIps.Ips, nil
 }
 
-func (s *InboundService) ClearClientIps(clientEmail string) error {
-	db := database.GetDB()
-
-	result := db.Model(model.InboundClientIps{}).
-		Where("client_email = ?", clientEmail).
-		Update("ips", "")
-	err := result.Error
-	if err != nil {
-		return err
+// GetClientIpInfos returns the recorded IPs of a client with their country and autonomous system.
+func (s *InboundService) GetClientIpInfos(clientEmail string) ([]geoip.Info, error) {
+	ipsJson, err := s.GetInboundClientIps(clientEmail)
+	if err != nil || ipsJson == "" {
+		return nil, err
 	}
-	return nil
+	var ips []string
+	if err = json.Unmarshal([]byte(ipsJson), &ips); err != nil {
+		return nil, err
+	}
+	infos := make([]geoip.Info, 0, len(ips))
+	for _, ip := range ips {
+		infos = append(infos, s.ResolveIp(ip))
+	}
+	return infos, nil
 }
 
-func (s *InboundService) SearchInbounds(query string) ([]*model.Inbound, error) {
+// ResolveIp looks up the country and autonomous system of an IP in the configured GeoIP databases.
+func (s *InboundService) ResolveIp(ip string) geoip.Info {
+	settingService := SettingService{}
+	countryDb, _ := settingService.GetIpGeoCountryDb()
+	asnDb, _ := settingService.GetIpGeoAsnDb()
+	return geoip.Lookup(ip, countryDb, asnDb)
+}
+
+// GetClientIpHistory returns the IPs a client was seen connecting from, most recently seen first.
+func (s *InboundService) GetClientIpHistory(clientEmail string) ([]*model.ClientIpHistory, error) {
 	db := database.GetDB()
-	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).Preload("ClientStats").Where("remark like ?", "%"+query+"%").Find(&inbounds).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
+	var history []*model.ClientIpHistory
+	err := db.Model(model.ClientIpHistory{}).
+		Where("client_email = ?", clientEmail).
+		Order("last_seen desc").
+		Find(&history).Error
+	if err != nil {
 		return nil, err
 	}
-	return inbounds, nil
+	for _, entry := range history {
+		info := s.ResolveIp(entry.Ip)
+		entry.Country, entry.ASN, entry.ASOrg = info.Country, info.ASN, info.ASOrg
+	}
+	return history, nil
 }
 
-func (s *InboundService) MigrationRequirements() {
+func (s *InboundService) ClearClientIps(clientEmail string) error {
 	db := database.GetDB()
-	tx := db.Begin()
-	var err error
-	defer func() {
-		if err == nil {
-			tx.Commit()
-			if dbErr := db.Exec(`VACUUM "main"`).Error; dbErr != nil {
-				logger.Warningf("VACUUM failed: %v", dbErr)
-			}
-		} else {
-			tx.Rollback()
-		}
-	}()
 
-	// Calculate and backfill all_time from up+down for inbounds and clients
-	err = tx.Exec(`
-		UPDATE inbounds
-		SET all_time = IFNULL(up, 0) + IFNULL(down, 0)
-		WHERE IFNULL(all_time, 0) = 0 AND (IFNULL(up, 0) + IFNULL(down, 0)) > 0
-	`).Error
+	err := db.Where("client_email = ?", clientEmail).Delete(model.ClientIpHistory{}).Error
 	if err != nil {
-		return
+		return err
 	}
-	err = tx.Exec(`
-		UPDATE client_traffics
-		SET all_time = IFNULL(up, 0) + IFNULL(down, 0)
-		WHERE IFNULL(all_time, 0) = 0 AND (IFNULL(up, 0) + IFNULL(down, 0)) > 0
-	`).Error
 
+	result := db.Model(model.InboundClientIps{}).
+		Where("client_email = ?", clientEmail).
+		Update("ips", "")
+	err = result.Error
 	if err != nil {
-		return
+		return err
 	}
+	return nil
+}
 
-	// Fix inbounds based problems
+func (s *InboundService) SearchInbounds(query string) ([]*model.Inbound, error) {
+	db := database.GetDB()
 	var inbounds []*model.Inbound
-	err = tx.Model(model.Inbound{}).Where("protocol IN (?)", []string{"vmess", "vless", "trojan"}).Find(&inbounds).Error
+	err := model.FindInbounds(db.Model(model.Inbound{}).Preload("ClientStats").Where("remark like ?", "%"+query+"%"), &inbounds)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		return
-	}
-	for inbound_index := range inbounds {
-		settings := map[string]any{}
-		json.Unmarshal([]byte(inbounds[inbound_index].Settings), &settings)
-		clients, ok := settings["clients"].([]any)
-		if ok {
-			// Fix Client configuration problems
-			var newClients []any
-			for client_index := range clients {
-				c := clients[client_index].(map[string]any)
-
-				// Add email='' if it is not exists
-				if _, ok := c["email"]; !ok {
-					c["email"] = ""
-				}
-
-				// Convert string tgId to int64
-				if _, ok := c["tgId"]; ok {
-					var tgId any = c["tgId"]
-					if tgIdStr, ok2 := tgId.(string); ok2 {
-						tgIdInt64, err := strconv.ParseInt(strings.ReplaceAll(tgIdStr, " ", ""), 10, 64)
-						if err == nil {
-							c["tgId"] = tgIdInt64
-						}
-					}
-				}
-
-				// Remove "flow": "xtls-rprx-direct"
-				if _, ok := c["flow"]; ok {
-					if c["flow"] == "xtls-rprx-direct" {
-						c["flow"] = ""
-					}
-				}
-				// Backfill created_at and updated_at
-				if _, ok := c["created_at"]; !ok {
-					c["created_at"] = time.Now().Unix() * 1000
-				}
-				c["updated_at"] = time.Now().Unix() * 1000
-				newClients = append(newClients, any(c))
-			}
-			settings["clients"] = newClients
-			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-			if err != nil {
-				return
-			}
-
-			inbounds[inbound_index].Settings = string(modifiedSettings)
-		}
-
-		// Add client traffic row for all clients which has email
-		modelClients, err := s.GetClients(inbounds[inbound_index])
-		if err != nil {
-			return
-		}
-		for _, modelClient := range modelClients {
-			if len(modelClient.Email) > 0 {
-				var count int64
-				tx.Model(xray.ClientTraffic{}).Where("email = ?", modelClient.Email).Count(&count)
-				if count == 0 {
-					s.AddClientStat(tx, inbounds[inbound_index].Id, &modelClient)
-				}
-			}
-		}
-	}
-	tx.Save(inbounds)
-
-	// Remove orphaned traffics
-	tx.Where("inbound_id = 0").Delete(xray.ClientTraffic{})
-
-	// Migrate old MultiDomain to External Proxy
-	var externalProxy []struct {
-		Id             int
-		Port           int
-		StreamSettings []byte
-	}
-	err = tx.Raw(`select id, port, stream_settings
-	from inbounds
-	WHERE protocol in ('vmess','vless','trojan')
-	  AND json_extract(stream_settings, '$.security') = 'tls'
-	  AND json_extract(stream_settings, '$.tlsSettings.settings.domains') IS NOT NULL`).Scan(&externalProxy).Error
-	if err != nil || len(externalProxy) == 0 {
-		return
-	}
-
-	for _, ep := range externalProxy {
-		var reverses any
-		var stream map[string]any
-		json.Unmarshal(ep.StreamSettings, &stream)
-		if tlsSettings, ok := stream["tlsSettings"].(map[string]any); ok {
-			if settings, ok := tlsSettings["settings"].(map[string]any); ok {
-				if domains, ok := settings["domains"].([]any); ok {
-					for _, domain := range domains {
-						if domainMap, ok := domain.(map[string]any); ok {
-							domainMap["forceTls"] = "same"
-							domainMap["port"] = ep.Port
-							domainMap["dest"] = domainMap["domain"].(string)
-							delete(domainMap, "domain")
-						}
-					}
-				}
-				reverses = settings["domains"]
-				delete(settings, "domains")
-			}
-		}
-		stream["externalProxy"] = reverses
-		newStream, _ := json.MarshalIndent(stream, " ", "  ")
-		tx.Model(model.Inbound{}).Where("id = ?", ep.Id).Update("stream_settings", newStream)
-	}
-
-	err = tx.Raw(`UPDATE inbounds
-	SET tag = REPLACE(tag, '0.0.0.0:', '')
-	WHERE INSTR(tag, '0.0.0.0:') > 0;`).Error
-	if err != nil {
-		return
+		return nil, err
 	}
-}
-
-func (s *InboundService) MigrateDB() {
-	s.MigrationRequirements()
-	s.MigrationRemoveOrphanedTraffics()
+	return inbounds, nil
 }
 
 func (s *InboundService) GetOnlineClients() []string {
@@ -2428,7 +2575,9 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		return false, err
 	}
 
+	oldClients, _ := s.GetClients(oldInbound)
 	oldInbound.Settings = string(newSettings)
+	remainingClients, _ := s.GetClients(oldInbound)
 
 	db := database.GetDB()
 
@@ -2454,8 +2603,7 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		}
 
 		if needApiDel {
-			s.xrayApi.Init(p.GetAPIPort())
-			if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email); err1 == nil {
+			if err1 := s.removeCoreUser(oldInbound.Protocol, oldInbound.Tag, email); err1 == nil {
 				logger.Debug("Client deleted by api:", email)
 				needRestart = false
 			} else {
@@ -2466,9 +2614,274 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 					needRestart = true
 				}
 			}
-			s.xrayApi.Close()
 		}
 	}
 
-	return needRestart, db.Save(oldInbound).Error
+	err = db.Save(oldInbound).Error
+	if err == nil && s.applyClientExitRules(changedClientExitRuleTags(oldClients, remainingClients)) {
+		needRestart = true
+	}
+	return needRestart, err
+}
+
+const (
+	clientOutboundRulePrefix = "client-outbound-"
+	clientBalancerRulePrefix = "client-balancer-"
+)
+
+// clientExitRuleTag returns the routing rule tag that carries the client's outbound or balancer assignment.
+// It returns an empty string for clients that use the default routing.
+func clientExitRuleTag(client model.Client) string {
+	if client.OutboundTag != "" {
+		return clientOutboundRulePrefix + client.OutboundTag
+	}
+	if client.BalancerTag != "" {
+		return clientBalancerRulePrefix + client.BalancerTag
+	}
+	return ""
+}
+
+// clientExitRuleTags returns the distinct exit rule tags used by the given clients.
+func clientExitRuleTags(clientLists ...[]model.Client) []string {
+	seen := map[string]bool{}
+	tags := make([]string, 0)
+	for _, clients := range clientLists {
+		for _, client := range clients {
+			tag := clientExitRuleTag(client)
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// changedClientExitRuleTags returns the exit rule tags affected by replacing oldClients with newClients.
+func changedClientExitRuleTags(oldClients []model.Client, newClients []model.Client) []string {
+	previous := make(map[string]model.Client, len(oldClients))
+	for _, client := range oldClients {
+		previous[client.Email] = client
+	}
+	changed := map[string]bool{}
+	for _, client := range newClients {
+		newTag := clientExitRuleTag(client)
+		oldClient, ok := previous[client.Email]
+		delete(previous, client.Email)
+		oldTag := clientExitRuleTag(oldClient)
+		if ok && oldTag == newTag && oldClient.Enable == client.Enable {
+			continue
+		}
+		if oldTag != "" {
+			changed[oldTag] = true
+		}
+		if newTag != "" {
+			changed[newTag] = true
+		}
+	}
+	for _, oldClient := range previous {
+		if oldTag := clientExitRuleTag(oldClient); oldTag != "" {
+			changed[oldTag] = true
+		}
+	}
+	tags := make([]string, 0, len(changed))
+	for tag := range changed {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// buildClientExitRules builds one email-based routing rule per outbound or balancer
+// that enabled clients of the given inbounds are assigned to.
+func (s *InboundService) buildClientExitRules(inbounds []*model.Inbound) []map[string]any {
+	users := map[string][]string{}
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			ruleTag := clientExitRuleTag(client)
+			if ruleTag == "" || client.Email == "" || !client.Enable {
+				continue
+			}
+			users[ruleTag] = append(users[ruleTag], client.Email)
+		}
+	}
+
+	ruleTags := make([]string, 0, len(users))
+	for ruleTag := range users {
+		ruleTags = append(ruleTags, ruleTag)
+	}
+	sort.Strings(ruleTags)
+
+	rules := make([]map[string]any, 0, len(ruleTags))
+	for _, ruleTag := range ruleTags {
+		emails := users[ruleTag]
+		sort.Strings(emails)
+		rule := map[string]any{
+			"type":    "field",
+			"ruleTag": ruleTag,
+			"user":    emails,
+		}
+		if tag, ok := strings.CutPrefix(ruleTag, clientOutboundRulePrefix); ok {
+			rule["outboundTag"] = tag
+		} else {
+			rule["balancerTag"] = strings.TrimPrefix(ruleTag, clientBalancerRulePrefix)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// GetClientExitRules returns the routing rules generated from the clients' outbound and balancer assignments.
+func (s *InboundService) GetClientExitRules(tx *gorm.DB) ([]map[string]any, error) {
+	var inbounds []*model.Inbound
+	err := model.FindInbounds(tx.Model(model.Inbound{}).Where("enable = ?", true), &inbounds)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return s.buildClientExitRules(inbounds), nil
+}
+
+// applyClientExitRules refreshes the given client exit rules in the running Xray core once the
+// clients are saved. Rules added through the API come after the rules of the running config, so
+// a rule is only applied live if none of those would catch the traffic of its clients first.
+// It returns true if the change could not be applied live.
+func (s *InboundService) applyClientExitRules(ruleTags []string) bool {
+	if len(ruleTags) == 0 || p == nil || !p.IsRunning() || p.GetConfig() == nil {
+		return false
+	}
+	runningConfig := p.GetConfig()
+
+	var inbounds []*model.Inbound
+	err := model.FindInbounds(database.GetDB().Model(model.Inbound{}).Where("enable = ?", true), &inbounds)
+	if err != nil {
+		logger.Debug("Unable to build client exit rules:", err)
+		return true
+	}
+	rules := filterClientExitRules(runningConfig, s.buildClientExitRules(inbounds))
+	rulesByTag := make(map[string]map[string]any)
+	for _, rule := range rules {
+		rulesByTag[rule["ruleTag"].(string)] = rule
+	}
+
+	emails := map[string]bool{}
+	for _, ruleTag := range ruleTags {
+		if rule, ok := rulesByTag[ruleTag]; ok {
+			for _, email := range rule["user"].([]string) {
+				emails[email] = true
+			}
+		}
+	}
+	if len(emails) > 0 {
+		inboundTags := map[string]bool{}
+		for _, inbound := range inbounds {
+			clients, _ := s.GetClients(inbound)
+			if slices.ContainsFunc(clients, func(client model.Client) bool { return emails[client.Email] }) {
+				inboundTags[inbound.Tag] = true
+			}
+		}
+		if catchesClientTraffic(runningConfig.RouterConfig, emails, inboundTags) {
+			return true
+		}
+	}
+	// Rules to exceeded outbounds are rerouted when the config is generated
+	outboundService := OutboundService{}
+	exceeded, err := outboundService.GetExceededOutbounds()
+	if err != nil {
+		return true
+	}
+	for _, outbound := range exceeded {
+		if _, ok := rulesByTag[clientOutboundRulePrefix+outbound.Tag]; ok {
+			return true
+		}
+	}
+
+	if err = s.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return true
+	}
+	defer s.xrayApi.Close()
+
+	runningRuleTags := routingRuleTags(runningConfig.RouterConfig)
+	needRestart := false
+	for _, ruleTag := range ruleTags {
+		// Xray may refuse to remove a rule it doesn't have yet, like the first exit rule of an outbound
+		if err1 := s.xrayApi.RemoveRoutingRule(ruleTag); err1 != nil && runningRuleTags[ruleTag] {
+			logger.Debug("Unable to remove client exit rule by api:", err1)
+			needRestart = true
+			continue
+		}
+		rule, ok := rulesByTag[ruleTag]
+		if !ok {
+			logger.Debug("Client exit rule removed by api:", ruleTag)
+			continue
+		}
+		ruleJson, err1 := json.Marshal(rule)
+		if err1 == nil {
+			err1 = s.xrayApi.AddRoutingRule(ruleJson)
+		}
+		if err1 == nil {
+			logger.Debug("Client exit rule updated by api:", ruleTag)
+		} else {
+			logger.Debug("Unable to update client exit rule by api:", err1)
+			needRestart = true
+		}
+	}
+	return needRestart
+}
+
+// initXrayApi connects to the Xray API for a batch of client changes, if Xray is running.
+// addCoreUser and removeCoreUser use the connection until s.xrayApi.Close.
+func (s *InboundService) initXrayApi() {
+	if p != nil && p.IsRunning() {
+		s.xrayApi.Init(p.GetAPIPort())
+	}
+}
+
+// addCoreUser adds a client to a running inbound of the core that serves its protocol.
+// Cores that can't change users at runtime, like sing-box, take the client with their
+// next config sync, so only a failure of Xray calls for a restart. Xray changes go through
+// the connection of initXrayApi if there is one.
+func (s *InboundService) addCoreUser(protocol model.Protocol, inboundTag string, user map[string]any) error {
+	c := coreForProtocol(protocol)
+	if c == nil {
+		if core.ForProtocol(protocol) == core.Xray {
+			return common.NewError("xray is not running")
+		}
+		return nil
+	}
+	if _, ok := c.(*xray.Process); ok && s.xrayApi.HandlerServiceClient != nil {
+		return s.xrayApi.AddUser(string(protocol), inboundTag, user)
+	}
+	if err := c.AddUser(string(protocol), inboundTag, user); !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	logger.Debugf("%s applies client %v on its next config sync", c.Name(), user["email"])
+	return nil
+}
+
+// removeCoreUser removes a client from a running inbound of the core that serves its protocol.
+// Like addCoreUser, only a failure of Xray calls for a restart.
+func (s *InboundService) removeCoreUser(protocol model.Protocol, inboundTag string, email string) error {
+	c := coreForProtocol(protocol)
+	if c == nil {
+		if core.ForProtocol(protocol) == core.Xray {
+			return common.NewError("xray is not running")
+		}
+		return nil
+	}
+	if _, ok := c.(*xray.Process); ok && s.xrayApi.HandlerServiceClient != nil {
+		return s.xrayApi.RemoveUser(inboundTag, email)
+	}
+	if err := c.RemoveUser(inboundTag, email); !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	logger.Debugf("%s removes client %s on its next config sync", c.Name(), email)
+	return nil
 }
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/nftables"
+)
+
+// IP limit enforcement modes.
+const (
+	// IpLimitModeFail2ban only logs violations for an external fail2ban jail.
+	IpLimitModeFail2ban = "fail2ban"
+	// IpLimitModeXrayAPI temporarily removes the offending client from Xray.
+	IpLimitModeXrayAPI = "xrayApi"
+	// IpLimitModeNftables temporarily blocks the surplus IPs in an nftables set managed by the panel.
+	IpLimitModeNftables = "nftables"
+)
+
+// IpBanService provides the built-in enforcement of client IP limits.
+// Bans are stored in the database and lifted once their window elapses or when cleared manually.
+type IpBanService struct {
+	settingService SettingService
+}
+
+// BanClient enforces the IP limit of a client according to the configured mode.
+// It does nothing in fail2ban mode or for clients and IPs that are already banned.
+func (s *IpBanService) BanClient(inbound *model.Inbound, email string, ips []string) error {
+	mode, err := s.settingService.GetIpLimitMode()
+	if err != nil || mode == IpLimitModeFail2ban {
+		return err
+	}
+	minutes, err := s.settingService.GetIpLimitBanDuration()
+	if err != nil {
+		return err
+	}
+	duration := time.Duration(minutes) * time.Minute
+	now := time.Now()
+	db := database.GetDB()
+
+	switch mode {
+	case IpLimitModeXrayAPI:
+		var count int64
+		err = db.Model(model.IpBan{}).Where("client_email = ? AND mode = ?", email, mode).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		if p != nil && p.IsRunning() {
+			if err1 := p.RemoveUser(inbound.Tag, email); err1 != nil {
+				logger.Debug("Unable to remove banned client by api:", err1)
+			}
+		}
+		logger.Infof("[LimitIP] Client %s removed for %d minutes, surplus IPs: %s", email, minutes, strings.Join(ips, ", "))
+		return db.Create(&model.IpBan{
+			ClientEmail: email,
+			InboundId:   inbound.Id,
+			Ip:          strings.Join(ips, ","),
+			Mode:        mode,
+			CreatedAt:   now.UnixMilli(),
+			ExpiresAt:   now.Add(duration).UnixMilli(),
+		}).Error
+	case IpLimitModeNftables:
+		if !nftables.IsAvailable() {
+			return common.NewError("nftables is not installed")
+		}
+		for _, ip := range ips {
+			var count int64
+			err = db.Model(model.IpBan{}).Where("ip = ? AND mode = ?", ip, mode).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err = nftables.BanIP(ip, duration); err != nil {
+				return err
+			}
+			logger.Infof("[LimitIP] IP %s of client %s banned for %d minutes", ip, email, minutes)
+			err = db.Create(&model.IpBan{
+				ClientEmail: email,
+				InboundId:   inbound.Id,
+				Ip:          ip,
+				Mode:        mode,
+				CreatedAt:   now.UnixMilli(),
+				ExpiresAt:   now.Add(duration).UnixMilli(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return common.NewError("unknown IP limit mode:", mode)
+	}
+}
+
+// GetBans returns all current bans, newest first.
+func (s *IpBanService) GetBans() ([]*model.IpBan, error) {
+	db := database.GetDB()
+	var bans []*model.IpBan
+	err := db.Model(model.IpBan{}).Order("created_at desc").Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// GetRemovedClients returns the emails of clients currently removed from Xray by a ban.
+func (s *IpBanService) GetRemovedClients() (map[string]bool, error) {
+	db := database.GetDB()
+	var emails []string
+	err := db.Model(model.IpBan{}).Where("mode = ?", IpLimitModeXrayAPI).Pluck("client_email", &emails).Error
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		removed[email] = true
+	}
+	return removed, nil
+}
+
+// ClearBan lifts the ban with the given id. It returns true if Xray needs a restart.
+func (s *IpBanService) ClearBan(id int) (bool, error) {
+	db := database.GetDB()
+	ban := &model.IpBan{}
+	if err := db.Model(model.IpBan{}).First(ban, id).Error; err != nil {
+		return false, err
+	}
+	return s.unban(ban)
+}
+
+// ClearAllBans lifts all bans. It returns true if Xray needs a restart.
+func (s *IpBanService) ClearAllBans() (bool, error) {
+	return s.unbanWhere("1 = 1")
+}
+
+// UnbanExpired lifts the bans whose window has elapsed. It returns true if Xray needs a restart.
+func (s *IpBanService) UnbanExpired() (bool, error) {
+	return s.unbanWhere("expires_at <= ?", time.Now().UnixMilli())
+}
+
+func (s *IpBanService) unbanWhere(query string, args ...any) (bool, error) {
+	db := database.GetDB()
+	var bans []*model.IpBan
+	if err := db.Model(model.IpBan{}).Where(query, args...).Find(&bans).Error; err != nil {
+		return false, err
+	}
+	needRestart := false
+	for _, ban := range bans {
+		restart, err := s.unban(ban)
+		if err != nil {
+			logger.Warning("[LimitIP] Unable to lift ban:", err)
+			continue
+		}
+		needRestart = needRestart || restart
+	}
+	return needRestart, nil
+}
+
+func (s *IpBanService) unban(ban *model.IpBan) (bool, error) {
+	if ban.Mode == IpLimitModeNftables {
+		if err := nftables.UnbanIP(ban.Ip); err != nil {
+			return false, err
+		}
+	}
+	db := database.GetDB()
+	if err := db.Delete(ban).Error; err != nil {
+		return false, err
+	}
+
+	needRestart := false
+	if ban.Mode == IpLimitModeXrayAPI {
+		needRestart = s.restoreClient(ban.ClientEmail)
+		logger.Infof("[LimitIP] Client %s restored", ban.ClientEmail)
+	} else {
+		logger.Infof("[LimitIP] IP %s of client %s unbanned", ban.Ip, ban.ClientEmail)
+	}
+	return needRestart, nil
+}
+
+// restoreClient adds a client removed by a ban back to the running Xray.
+// It returns true if that failed and a restart is needed instead.
+func (s *IpBanService) restoreClient(email string) bool {
+	if p == nil || !p.IsRunning() {
+		return false
+	}
+	inboundService := InboundService{}
+	traffic, inbound, err := inboundService.GetClientInboundByEmail(email)
+	if err != nil || inbound == nil || !inbound.Enable || (traffic != nil && !traffic.Enable) {
+		return false
+	}
+	clients, err := inboundService.GetClients(inbound)
+	if err != nil {
+		return false
+	}
+	for _, client := range clients {
+		if client.Email != email {
+			continue
+		}
+		if !client.Enable {
+			return false
+		}
+		cipher := ""
+		if inbound.Protocol == model.Shadowsocks {
+			settings := map[string]any{}
+			json.Unmarshal([]byte(inbound.Settings), &settings)
+			cipher, _ = settings["method"].(string)
+		}
+		err1 := p.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
+			"email":    client.Email,
+			"id":       client.ID,
+			"security": client.Security,
+			"flow":     client.Flow,
+			"password": client.Password,
+			"cipher":   cipher,
+		})
+		if err1 != nil {
+			logger.Debug("Error in restoring banned client by api:", err1)
+			return true
+		}
+		return false
+	}
+	return false
+}
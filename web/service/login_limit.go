@@ -0,0 +1,142 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of login attempt records.
+const (
+	LoginAttemptIP       = "ip"
+	LoginAttemptUsername = "username"
+)
+
+const (
+	// maxLoginLockDuration caps the progressive lockout duration.
+	maxLoginLockDuration = 24 * time.Hour
+	// loginAttemptExpiry is how long failures are remembered after the last one.
+	loginAttemptExpiry = 24 * time.Hour
+)
+
+// LoginLimitService throttles failed panel logins per IP address and per username.
+// Each time the attempt limit is reached, the IP or username is locked out for twice as long as before.
+type LoginLimitService struct {
+	settingService SettingService
+}
+
+// GetLockedFor returns how much longer logins from the IP or for the username are locked out.
+func (s *LoginLimitService) GetLockedFor(ip string, username string) time.Duration {
+	db := database.GetDB()
+	var attempts []*model.LoginAttempt
+	err := db.Model(model.LoginAttempt{}).
+		Where("(kind = ? AND value = ?) OR (kind = ? AND value = ?)", LoginAttemptIP, ip, LoginAttemptUsername, username).
+		Find(&attempts).Error
+	if err != nil {
+		logger.Warning("Unable to get login attempts:", err)
+		return 0
+	}
+	now := time.Now().UnixMilli()
+	var lockedFor time.Duration
+	for _, attempt := range attempts {
+		if attempt.LockedUntil > now {
+			lockedFor = max(lockedFor, time.Duration(attempt.LockedUntil-now)*time.Millisecond)
+		}
+	}
+	return lockedFor
+}
+
+// RecordFailure counts a failed login of the IP and the username and locks them out once the limit is reached.
+func (s *LoginLimitService) RecordFailure(ip string, username string) {
+	maxAttempts, err := s.settingService.GetLoginMaxAttempts()
+	if err != nil || maxAttempts <= 0 {
+		return
+	}
+	minutes, err := s.settingService.GetLoginLockDuration()
+	if err != nil {
+		return
+	}
+
+	db := database.GetDB()
+	now := time.Now()
+	// Forget stale records so that random usernames don't pile up
+	db.Where("last_failure < ? AND locked_until < ?", now.Add(-loginAttemptExpiry).UnixMilli(), now.UnixMilli()).
+		Delete(model.LoginAttempt{})
+
+	for kind, value := range map[string]string{LoginAttemptIP: ip, LoginAttemptUsername: username} {
+		if value == "" {
+			continue
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			attempt := &model.LoginAttempt{}
+			err := tx.Where(model.LoginAttempt{Kind: kind, Value: value}).FirstOrCreate(attempt).Error
+			if err != nil {
+				return err
+			}
+			if attempt.LockedUntil > now.UnixMilli() {
+				return nil
+			}
+			attempt.Failures++
+			attempt.LastFailure = now.UnixMilli()
+			if attempt.Failures >= maxAttempts {
+				lockFor := time.Duration(minutes) * time.Minute << min(attempt.Lockouts, 16)
+				lockFor = min(lockFor, maxLoginLockDuration)
+				attempt.Lockouts++
+				attempt.Failures = 0
+				attempt.LockedUntil = now.Add(lockFor).UnixMilli()
+				logger.Warningf("Panel login locked out for %s %s for %s after %d failed attempts", kind, value, lockFor, maxAttempts)
+			}
+			return tx.Save(attempt).Error
+		})
+		if err != nil {
+			logger.Warning("Unable to record failed login:", err)
+		}
+	}
+}
+
+// Reset forgets the failed logins of the IP and the username after a successful login.
+func (s *LoginLimitService) Reset(ip string, username string) {
+	db := database.GetDB()
+	err := db.Where("(kind = ? AND value = ?) OR (kind = ? AND value = ?)", LoginAttemptIP, ip, LoginAttemptUsername, username).
+		Delete(model.LoginAttempt{}).Error
+	if err != nil {
+		logger.Warning("Unable to reset login attempts:", err)
+	}
+}
+
+// GetLockouts returns the IPs and usernames that are currently locked out, latest first.
+func (s *LoginLimitService) GetLockouts() ([]*model.LoginAttempt, error) {
+	db := database.GetDB()
+	var attempts []*model.LoginAttempt
+	err := db.Model(model.LoginAttempt{}).
+		Where("locked_until > ?", time.Now().UnixMilli()).
+		Order("locked_until desc").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// ClearLockout lifts the lockout with the given id and forgets its failed logins.
+func (s *LoginLimitService) ClearLockout(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.LoginAttempt{}, id).Error
+}
+
+// ClearLockoutOf lifts the lockouts of an IP address or username. It returns the number of lifted records.
+func (s *LoginLimitService) ClearLockoutOf(value string) (int64, error) {
+	db := database.GetDB()
+	result := db.Where("value = ?", value).Delete(model.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// ClearAllLockouts lifts all lockouts and forgets all failed logins.
+func (s *LoginLimitService) ClearAllLockouts() error {
+	db := database.GetDB()
+	return db.Where("1 = 1").Delete(model.LoginAttempt{}).Error
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Paths of the node API.
+const (
+	NodeReportPath = "/node/report"
+	NodeConfigPath = "/node/config"
+)
+
+// nodeRequestTimeout bounds every request to a node, so an unreachable node doesn't hold up
+// the sync of the others.
+const nodeRequestTimeout = 15 * time.Second
+
+// nodeSyncLock keeps syncs from overlapping, as the traffic of a node must be counted once.
+var nodeSyncLock sync.Mutex
+
+// NodeInbound is an inbound as the panel pushes it to a node.
+type NodeInbound struct {
+	Remark          string         `json:"remark"`
+	Enable          bool           `json:"enable"`
+	Listen          string         `json:"listen"`
+	Port            int            `json:"port"`
+	Protocol        model.Protocol `json:"protocol"`
+	Settings        string         `json:"settings"`
+	StreamSettings  string         `json:"streamSettings"`
+	Tag             string         `json:"tag"`
+	Sniffing        string         `json:"sniffing"`
+	DisabledClients []string       `json:"disabledClients"` // Emails of the clients the panel took out for their limits or an IP ban
+}
+
+// NodeConfig is what the panel pushes to a node. The inbounds replace all inbounds of the
+// node; the Xray template, routing and outbounds stay those of the node.
+type NodeConfig struct {
+	Inbounds []*NodeInbound `json:"inbounds"`
+}
+
+// Hash returns the hex encoded SHA-256 of the config. Nodes report the hash of the config they
+// applied, so the panel only pushes changes.
+func (c *NodeConfig) Hash() string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NodeCounter is a traffic counter of a node, which only grows until the inbound or client is
+// recreated on the node.
+type NodeCounter struct {
+	Name string `json:"name"`
+	Up   int64  `json:"up"`
+	Down int64  `json:"down"`
+}
+
+// NodeReport is what a node reports to the panel on every sync.
+type NodeReport struct {
+	ConfigHash string         `json:"configHash"` // Hash of the config the node applied, empty until it got one
+	Status     *Status        `json:"status"`
+	Inbounds   []*NodeCounter `json:"inbounds"` // Traffic of the inbounds by tag
+	Clients    []*NodeCounter `json:"clients"`  // Traffic of the clients by email
+}
+
+// NodeService manages the nodes of the panel: servers running x-ui as a node, which the panel
+// pushes its inbounds to and collects traffic and status from.
+type NodeService struct {
+	inboundService InboundService
+	xrayService    XrayService
+}
+
+// GetNodes returns all nodes.
+func (s *NodeService) GetNodes() ([]*model.Node, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	if err := db.Model(model.Node{}).Order("id").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// AddNode validates and stores a new node.
+func (s *NodeService) AddNode(node *model.Node) error {
+	if err := s.checkNode(node); err != nil {
+		return err
+	}
+	node.Id = 0
+	node.LastSeen = 0
+	node.Status = ""
+	node.Error = ""
+	db := database.GetDB()
+	return db.Create(node).Error
+}
+
+// UpdateNode validates and stores the changes of a node, keeping the state of its last sync.
+func (s *NodeService) UpdateNode(node *model.Node) error {
+	if err := s.checkNode(node); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	oldNode := &model.Node{}
+	if err := db.First(oldNode, node.Id).Error; err != nil {
+		return err
+	}
+	node.LastSeen = oldNode.LastSeen
+	node.Status = oldNode.Status
+	node.Error = oldNode.Error
+	return db.Save(node).Error
+}
+
+// DelNode deletes a node and the traffic counters it reported. The inbounds stay on the node
+// until it is set up again.
+func (s *NodeService) DelNode(id int) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("node_id = ?", id).Delete(model.NodeTraffic{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(model.Node{}, id).Error
+	})
+}
+
+func (s *NodeService) checkNode(node *model.Node) error {
+	node.Name = strings.TrimSpace(node.Name)
+	if node.Name == "" {
+		return common.NewError("the node needs a name")
+	}
+	node.Address = strings.TrimRight(strings.TrimSpace(node.Address), "/")
+	u, err := url.Parse(node.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return common.NewError("the node address must be an http or https URL")
+	}
+	node.Token = strings.TrimSpace(node.Token)
+	if node.Token == "" {
+		return common.NewError("the node needs the token of its API")
+	}
+	node.CertSha256 = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(node.CertSha256), ":", ""))
+	if node.CertSha256 != "" {
+		if u.Scheme != "https" {
+			return common.NewError("a pinned certificate needs an https address")
+		}
+		if sum, err := hex.DecodeString(node.CertSha256); err != nil || len(sum) != sha256.Size {
+			return common.NewError("the certificate fingerprint must be a SHA-256 in hex")
+		}
+	}
+	node.Host = strings.TrimSpace(node.Host)
+	ids, err := parseNodeInboundIds(node.InboundIds)
+	if err != nil {
+		return err
+	}
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	node.InboundIds = strings.Join(parts, ",")
+	return nil
+}
+
+// parseNodeInboundIds returns the sorted IDs of the inbounds a node serves.
+func parseNodeInboundIds(inboundIds string) ([]int, error) {
+	var ids []int
+	for part := range strings.SplitSeq(inboundIds, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, common.NewErrorf("invalid inbound ID %q", part)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+// GetInboundNodes returns the enabled nodes that clients can connect to by the IDs of the
+// inbounds they serve.
+func (s *NodeService) GetInboundNodes() (map[int][]*model.Node, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	err := db.Model(model.Node{}).Where("enable = ? AND host <> ''", true).Order("id").Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	inboundNodes := map[int][]*model.Node{}
+	for _, node := range nodes {
+		ids, _ := parseNodeInboundIds(node.InboundIds)
+		for _, id := range ids {
+			inboundNodes[id] = append(inboundNodes[id], node)
+		}
+	}
+	return inboundNodes, nil
+}
+
+// SyncAll syncs all enabled nodes at once. It returns right away if the last sync is still
+// running.
+func (s *NodeService) SyncAll() {
+	if !nodeSyncLock.TryLock() {
+		return
+	}
+	defer nodeSyncLock.Unlock()
+
+	db := database.GetDB()
+	var nodes []*model.Node
+	if err := db.Model(model.Node{}).Where("enable = ?", true).Find(&nodes).Error; err != nil {
+		logger.Warning("Unable to get nodes:", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Go(func() {
+			if err := s.syncNode(node); err != nil {
+				logger.Warningf("Unable to sync node %s: %v", node.Name, err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// SyncNode syncs a node right away, after the running sync if there is one.
+func (s *NodeService) SyncNode(id int) error {
+	nodeSyncLock.Lock()
+	defer nodeSyncLock.Unlock()
+
+	db := database.GetDB()
+	node := &model.Node{}
+	if err := db.First(node, id).Error; err != nil {
+		return err
+	}
+	return s.syncNode(node)
+}
+
+// syncNode adds the traffic the node counted since the last sync, pushes the inbounds if the
+// node has a different config and stores the status of the node or the error of the sync.
+func (s *NodeService) syncNode(node *model.Node) error {
+	client := s.newClient(node)
+	report := &NodeReport{}
+	err := s.call(client, node, http.MethodGet, NodeReportPath, nil, report)
+	if err == nil {
+		err = s.addTraffic(node, report)
+	}
+	if err == nil {
+		var config *NodeConfig
+		config, err = s.buildConfig(node)
+		if err == nil && config.Hash() != report.ConfigHash {
+			logger.Infof("Pushing %d inbounds to node %s", len(config.Inbounds), node.Name)
+			err = s.call(client, node, http.MethodPost, NodeConfigPath, config, nil)
+		}
+	}
+
+	updates := map[string]any{"error": ""}
+	if err != nil {
+		updates["error"] = err.Error()
+	} else {
+		status, _ := json.Marshal(report.Status)
+		updates["status"] = string(status)
+		updates["last_seen"] = time.Now().UnixMilli()
+	}
+	db := database.GetDB()
+	if err1 := db.Model(node).Updates(updates).Error; err1 != nil {
+		logger.Warning("Unable to store the node status:", err1)
+	}
+	return err
+}
+
+// buildConfig returns the inbounds of the panel the node serves, with the clients the panel
+// took out for their limits or an IP ban.
+func (s *NodeService) buildConfig(node *model.Node) (*NodeConfig, error) {
+	config := &NodeConfig{Inbounds: []*NodeInbound{}}
+	ids, err := parseNodeInboundIds(node.InboundIds)
+	if err != nil || len(ids) == 0 {
+		return config, err
+	}
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	if err := model.FindInbounds(db.Model(model.Inbound{}).Preload("ClientStats").Where("id IN ?", ids).Order("id"), &inbounds); err != nil {
+		return nil, err
+	}
+	ipBanService := IpBanService{}
+	removedClients, err := ipBanService.GetRemovedClients()
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		disabled := []string{}
+		for _, stats := range inbound.ClientStats {
+			if !stats.Enable || removedClients[stats.Email] {
+				disabled = append(disabled, stats.Email)
+			}
+		}
+		slices.Sort(disabled)
+		config.Inbounds = append(config.Inbounds, &NodeInbound{
+			Remark:          inbound.Remark,
+			Enable:          inbound.Enable,
+			Listen:          inbound.Listen,
+			Port:            inbound.Port,
+			Protocol:        inbound.Protocol,
+			Settings:        inbound.Settings,
+			StreamSettings:  inbound.StreamSettings,
+			Tag:             inbound.Tag,
+			Sniffing:        inbound.Sniffing,
+			DisabledClients: disabled,
+		})
+	}
+	return config, nil
+}
+
+// addTraffic adds what the counters of the node grew by since the last sync to the inbounds
+// and clients of the panel. A counter below its last value was reset on the node, then all
+// of it is new.
+func (s *NodeService) addTraffic(node *model.Node, report *NodeReport) error {
+	db := database.GetDB()
+	var last []*model.NodeTraffic
+	if err := db.Where("node_id = ?", node.Id).Find(&last).Error; err != nil {
+		return err
+	}
+	counters := map[bool]map[string]*model.NodeTraffic{true: {}, false: {}}
+	for _, counter := range last {
+		counters[counter.IsInbound][counter.Name] = counter
+	}
+
+	var changed []*model.NodeTraffic
+	delta := func(isInbound bool, reported *NodeCounter) (int64, int64) {
+		counter := counters[isInbound][reported.Name]
+		if counter == nil {
+			counter = &model.NodeTraffic{NodeId: node.Id, IsInbound: isInbound, Name: reported.Name}
+		}
+		delete(counters[isInbound], reported.Name)
+		up, down := reported.Up, reported.Down
+		if up >= counter.Up && down >= counter.Down {
+			up -= counter.Up
+			down -= counter.Down
+		}
+		if counter.Id == 0 || up != 0 || down != 0 {
+			counter.Up, counter.Down = reported.Up, reported.Down
+			changed = append(changed, counter)
+		}
+		return up, down
+	}
+
+	var inboundTraffics []*xray.Traffic
+	for _, reported := range report.Inbounds {
+		if up, down := delta(true, reported); up+down > 0 {
+			inboundTraffics = append(inboundTraffics, &xray.Traffic{IsInbound: true, Tag: reported.Name, Up: up, Down: down})
+		}
+	}
+	var clientTraffics []*xray.ClientTraffic
+	for _, reported := range report.Clients {
+		if up, down := delta(false, reported); up+down > 0 {
+			clientTraffics = append(clientTraffics, &xray.ClientTraffic{Email: reported.Name, Up: up, Down: down})
+		}
+	}
+	// Counters the node doesn't report anymore belong to removed inbounds and clients
+	var stale []int
+	for _, byName := range counters {
+		for _, counter := range byName {
+			stale = append(stale, counter.Id)
+		}
+	}
+
+	err, needRestart := s.inboundService.AddNodeTraffic(inboundTraffics, clientTraffics, func(tx *gorm.DB) error {
+		if len(stale) > 0 {
+			if err := tx.Where("id IN ?", stale).Delete(model.NodeTraffic{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(changed) > 0 {
+			return tx.Save(changed).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if needRestart {
+		s.xrayService.SetToNeedRestart()
+	}
+	return nil
+}
+
+// newClient returns an HTTP client for the node API. With a pinned certificate, the node
+// certificate is checked against the fingerprint instead of the system CAs, so nodes can use
+// self-signed certificates.
+func (s *NodeService) newClient(node *model.Node) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if node.CertSha256 != "" {
+		pinned := node.CertSha256
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) > 0 {
+					sum := sha256.Sum256(rawCerts[0])
+					if hex.EncodeToString(sum[:]) == pinned {
+						return nil
+					}
+				}
+				return errors.New("the node certificate doesn't match the pinned fingerprint")
+			},
+		}
+	}
+	return &http.Client{Timeout: nodeRequestTimeout, Transport: transport}
+}
+
+// call sends a request to the node API and decodes the object of the response into result.
+func (s *NodeService) call(client *http.Client, node *model.Node, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, node.Address+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+node.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg := &entity.Msg{Obj: result}
+	if err := json.NewDecoder(resp.Body).Decode(msg); err != nil {
+		return fmt.Errorf("unexpected response from the node: %s", resp.Status)
+	}
+	if !msg.Success {
+		return errors.New(msg.Msg)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+var (
+	nodeAgentLock   sync.Mutex
+	nodeConfigHash  string  // Hash of the config the panel pushed last
+	nodeAgentStatus *Status // Status reported last, to work out the network speed
+)
+
+// NodeAgentService is the side of a node: it applies the inbounds the panel pushes and reports
+// traffic and status back. Limits are up to the panel, which takes out clients by pushing them
+// as disabled, so the inbounds and clients of a node never expire or run out on their own.
+type NodeAgentService struct {
+	inboundService InboundService
+	xrayService    XrayService
+	serverService  ServerService
+}
+
+// Report returns the hash of the applied config, the status of the server and the traffic
+// counters of the inbounds and clients.
+func (s *NodeAgentService) Report() (*NodeReport, error) {
+	nodeAgentLock.Lock()
+	defer nodeAgentLock.Unlock()
+
+	report := &NodeReport{
+		ConfigHash: nodeConfigHash,
+		Inbounds:   []*NodeCounter{},
+		Clients:    []*NodeCounter{},
+	}
+	db := database.GetDB()
+	if err := db.Model(&model.Inbound{}).Select("tag AS name, up, down").Scan(&report.Inbounds).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&xray.ClientTraffic{}).Select("email AS name, up, down").Scan(&report.Clients).Error; err != nil {
+		return nil, err
+	}
+	report.Status = s.serverService.GetStatus(nodeAgentStatus)
+	nodeAgentStatus = report.Status
+	return report, nil
+}
+
+// ApplyConfig replaces the inbounds of the node with the pushed ones and restarts Xray if its
+// config changed. Inbounds and clients are matched by tag and email, so their traffic counters
+// carry over.
+func (s *NodeAgentService) ApplyConfig(config *NodeConfig) error {
+	nodeAgentLock.Lock()
+	defer nodeAgentLock.Unlock()
+
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var inbounds []*model.Inbound
+		if err := model.FindInbounds(tx, &inbounds); err != nil {
+			return err
+		}
+		existing := map[string]*model.Inbound{}
+		for _, inbound := range inbounds {
+			existing[inbound.Tag] = inbound
+		}
+
+		keptEmails := []string{""} // Never empty, so NOT IN stays valid SQL
+		for _, pushed := range config.Inbounds {
+			inbound := existing[pushed.Tag]
+			if inbound == nil {
+				inbound = &model.Inbound{TrafficReset: "never"}
+			}
+			delete(existing, pushed.Tag)
+			inbound.Remark = pushed.Remark
+			inbound.Enable = pushed.Enable
+			inbound.Listen = pushed.Listen
+			inbound.Port = pushed.Port
+			inbound.Protocol = pushed.Protocol
+			inbound.Settings = pushed.Settings
+			inbound.StreamSettings = pushed.StreamSettings
+			inbound.Tag = pushed.Tag
+			inbound.Sniffing = pushed.Sniffing
+			inbound.Total = 0
+			inbound.ExpiryTime = 0
+			if err := tx.Omit("ClientStats").Save(inbound).Error; err != nil {
+				return err
+			}
+
+			clients, _ := s.inboundService.GetClients(inbound)
+			for _, client := range clients {
+				if client.Email == "" {
+					continue
+				}
+				enable := !slices.Contains(pushed.DisabledClients, client.Email)
+				if err := s.ensureClientTraffic(tx, inbound.Id, client.Email, enable); err != nil {
+					return err
+				}
+				keptEmails = append(keptEmails, client.Email)
+			}
+		}
+
+		// Inbounds the panel doesn't push anymore were deleted or moved off the node
+		for _, inbound := range existing {
+			if err := tx.Where("inbound_id = ?", inbound.Id).Delete(model.InboundClient{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(inbound).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("email NOT IN ?", keptEmails).Delete(xray.ClientTraffic{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	nodeConfigHash = config.Hash()
+	return s.xrayService.RestartXray(false)
+}
+
+// ensureClientTraffic creates or updates the traffic row of a client without limits of its own.
+func (s *NodeAgentService) ensureClientTraffic(tx *gorm.DB, inboundId int, email string, enable bool) error {
+	var count int64
+	if err := tx.Model(&xray.ClientTraffic{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return tx.Model(&xray.ClientTraffic{}).Where("email = ?", email).Updates(map[string]any{
+			"inbound_id":  inboundId,
+			"enable":      enable,
+			"total":       0,
+			"expiry_time": 0,
+			"reset":       0,
+		}).Error
+	}
+	return tx.Create(&xray.ClientTraffic{
+		InboundId: inboundId,
+		Email:     email,
+		Enable:    enable,
+	}).Error
+}
@@ -0,0 +1,443 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/notify"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Notification event types. Channels subscribe to a subset of them.
+const (
+	NotifyEventLogin         = "login"
+	NotifyEventAlert         = "alert"
+	NotifyEventReport        = "report"
+	NotifyEventExhausted     = "exhausted"
+	NotifyEventBackup        = "backup"
+	NotifyEventOutboundQuota = "outboundQuota"
+	NotifyEventClientWarning = "clientWarning"
+	NotifyEventTest          = "test"
+)
+
+// NotifyEvents lists the event types channels can subscribe to.
+var NotifyEvents = []string{
+	NotifyEventLogin,
+	NotifyEventAlert,
+	NotifyEventReport,
+	NotifyEventExhausted,
+	NotifyEventBackup,
+	NotifyEventOutboundQuota,
+	NotifyEventClientWarning,
+}
+
+var notifyEventTitles = map[string]string{
+	NotifyEventLogin:         "Panel login",
+	NotifyEventAlert:         "Alert",
+	NotifyEventReport:        "Server report",
+	NotifyEventExhausted:     "Depleting clients",
+	NotifyEventBackup:        "Backup",
+	NotifyEventOutboundQuota: "Outbound quota",
+	NotifyEventClientWarning: "Client warning",
+	NotifyEventTest:          "Test notification",
+}
+
+// notifyOptInEvents are only routed to channels that list them. Client warnings go to the
+// clients, login attempts carry the password tried and backups the database, so they don't
+// reach third parties unasked. Telegram channels go through the panel's own bot, which has
+// always sent logins and backups to the admins, so they keep getting those by default.
+var notifyOptInEvents = []string{NotifyEventClientWarning, NotifyEventLogin, NotifyEventBackup}
+
+// maxNotifyFailures is the number of delivery failures kept in the log.
+const maxNotifyFailures = 200
+
+// notifySecretMask replaces the secrets of channel configs sent to the browser. A channel
+// saved with the mask keeps its stored secret.
+const notifySecretMask = "******"
+
+// notifySecretKeys are the config keys holding secrets, per channel type. Webhook URLs
+// often embed tokens, and the headers of webhooks are masked as well, as they usually
+// carry credentials such as Authorization.
+var notifySecretKeys = map[string][]string{
+	notify.SMTP:    {"password"},
+	notify.Matrix:  {"accessToken"},
+	notify.Webhook: {"url"},
+	notify.Discord: {"url"},
+	notify.Slack:   {"url"},
+}
+
+// NotificationService routes panel notifications to the configured channels
+// and records the deliveries that failed.
+type NotificationService struct{}
+
+// Notify sends the text to every enabled channel routed to the event.
+func (s *NotificationService) Notify(event string, text string, attachments ...notify.Attachment) {
+	s.Send(notify.Message{
+		Event:       event,
+		Text:        text,
+		Attachments: attachments,
+	})
+}
+
+// Send sends the message to every enabled channel routed to its event.
+// Deliveries run in the background, so slow channels don't hold up the caller.
+func (s *NotificationService) Send(msg notify.Message) {
+	channels, err := s.getChannelsFor(msg.Event)
+	if err != nil {
+		logger.Warning("Unable to get notification channels:", err)
+		return
+	}
+	if msg.Title == "" {
+		msg.Title = notifyTitle(msg.Event)
+	}
+	for _, channel := range channels {
+		go s.deliver(channel, msg)
+	}
+}
+
+// SendTo sends a message addressed to a client to the email channels routed to its event,
+// in place of their own recipients.
+func (s *NotificationService) SendTo(msg notify.Message, address string) {
+	channels, err := s.getChannelsFor(msg.Event)
+	if err != nil {
+		logger.Warning("Unable to get notification channels:", err)
+		return
+	}
+	if msg.Title == "" {
+		msg.Title = notifyTitle(msg.Event)
+	}
+	msg.To = []string{address}
+	for _, channel := range channels {
+		if channel.Type == notify.SMTP {
+			go s.deliver(channel, msg)
+		}
+	}
+}
+
+// HasChannelFor reports whether any enabled channel receives the event.
+func (s *NotificationService) HasChannelFor(event string) bool {
+	channels, err := s.getChannelsFor(event)
+	return err == nil && len(channels) > 0
+}
+
+func (s *NotificationService) getChannelsFor(event string) ([]*model.NotifyChannel, error) {
+	db := database.GetDB()
+	var channels []*model.NotifyChannel
+	if err := db.Model(model.NotifyChannel{}).Where("enable = ?", true).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	routed := channels[:0]
+	tgbot := Tgbot{}
+	for _, channel := range channels {
+		// The Telegram channel is only available while the bot runs
+		if channel.Type == notify.Telegram && !tgbot.IsRunning() {
+			continue
+		}
+		if channel.Events == "" {
+			if !slices.Contains(notifyOptInEvents, event) || (channel.Type == notify.Telegram && event != NotifyEventClientWarning) {
+				routed = append(routed, channel)
+			}
+		} else if slices.Contains(strings.Split(channel.Events, ","), event) {
+			routed = append(routed, channel)
+		}
+	}
+	return routed, nil
+}
+
+// deliver sends the message to the channel and logs a failure if that didn't work.
+func (s *NotificationService) deliver(channel *model.NotifyChannel, msg notify.Message) error {
+	sender, err := newNotifySender(channel)
+	if err == nil {
+		err = sender.Send(msg)
+	}
+	if err != nil {
+		logger.Warningf("Unable to send %s notification to %s: %v", msg.Event, channel.Name, err)
+		s.addFailure(channel, msg.Event, err)
+	}
+	return err
+}
+
+func (s *NotificationService) addFailure(channel *model.NotifyChannel, event string, sendErr error) {
+	db := database.GetDB()
+	err := db.Create(&model.NotifyFailure{
+		ChannelId: channel.Id,
+		Channel:   channel.Name,
+		Event:     event,
+		Error:     sendErr.Error(),
+		CreatedAt: time.Now().UnixMilli(),
+	}).Error
+	if err != nil {
+		logger.Warning("Unable to log notification failure:", err)
+		return
+	}
+	// Keep only the latest failures
+	var oldest []int
+	db.Model(model.NotifyFailure{}).Order("id desc").Offset(maxNotifyFailures).Limit(1).Pluck("id", &oldest)
+	if len(oldest) > 0 {
+		db.Where("id <= ?", oldest[0]).Delete(model.NotifyFailure{})
+	}
+}
+
+// GetChannels returns all notification channels, with the secrets of their configs masked.
+func (s *NotificationService) GetChannels() ([]*model.NotifyChannel, error) {
+	db := database.GetDB()
+	var channels []*model.NotifyChannel
+	err := db.Model(model.NotifyChannel{}).Order("id asc").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		maskChannelSecrets(channel)
+	}
+	return channels, nil
+}
+
+// AddChannel validates and stores a new notification channel.
+func (s *NotificationService) AddChannel(channel *model.NotifyChannel) error {
+	if err := s.checkChannel(channel); err != nil {
+		return err
+	}
+	channel.Id = 0
+	db := database.GetDB()
+	if err := db.Create(channel).Error; err != nil {
+		return err
+	}
+	maskChannelSecrets(channel)
+	return nil
+}
+
+// UpdateChannel validates and stores the changes of a notification channel.
+// Masked secrets keep their stored values.
+func (s *NotificationService) UpdateChannel(channel *model.NotifyChannel) error {
+	db := database.GetDB()
+	oldChannel := &model.NotifyChannel{}
+	if err := db.First(oldChannel, channel.Id).Error; err != nil {
+		return err
+	}
+	if channel.Type == oldChannel.Type {
+		restoreChannelSecrets(channel, oldChannel)
+	}
+	if err := s.checkChannel(channel); err != nil {
+		return err
+	}
+	if err := db.Save(channel).Error; err != nil {
+		return err
+	}
+	maskChannelSecrets(channel)
+	return nil
+}
+
+// maskChannelSecrets replaces the secrets in the config of the channel with notifySecretMask.
+func maskChannelSecrets(channel *model.NotifyChannel) {
+	config := map[string]any{}
+	if channel.Config == "" || json.Unmarshal([]byte(channel.Config), &config) != nil {
+		return
+	}
+	for _, key := range notifySecretKeys[channel.Type] {
+		if value, _ := config[key].(string); value != "" {
+			config[key] = notifySecretMask
+		}
+	}
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for name := range headers {
+			headers[name] = notifySecretMask
+		}
+	}
+	if data, err := json.Marshal(config); err == nil {
+		channel.Config = string(data)
+	}
+}
+
+// restoreChannelSecrets puts the stored secrets of the old channel back in place of the
+// masks left in the config of the channel.
+func restoreChannelSecrets(channel *model.NotifyChannel, oldChannel *model.NotifyChannel) {
+	config := map[string]any{}
+	oldConfig := map[string]any{}
+	if channel.Config == "" || json.Unmarshal([]byte(channel.Config), &config) != nil {
+		return
+	}
+	json.Unmarshal([]byte(oldChannel.Config), &oldConfig)
+	for _, key := range notifySecretKeys[channel.Type] {
+		if config[key] == notifySecretMask {
+			config[key] = oldConfig[key]
+		}
+	}
+	if headers, ok := config["headers"].(map[string]any); ok {
+		oldHeaders, _ := oldConfig["headers"].(map[string]any)
+		for name, value := range headers {
+			if value == notifySecretMask {
+				headers[name] = oldHeaders[name]
+			}
+		}
+	}
+	if data, err := json.Marshal(config); err == nil {
+		channel.Config = string(data)
+	}
+}
+
+// DelChannel deletes a notification channel and its failure log.
+func (s *NotificationService) DelChannel(id int) error {
+	db := database.GetDB()
+	if err := db.Where("channel_id = ?", id).Delete(model.NotifyFailure{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(model.NotifyChannel{}, id).Error
+}
+
+// TestChannel sends a test message to the channel and returns the delivery error, if any.
+func (s *NotificationService) TestChannel(id int) error {
+	db := database.GetDB()
+	channel := &model.NotifyChannel{}
+	if err := db.First(channel, id).Error; err != nil {
+		return err
+	}
+	return s.deliver(channel, notify.Message{
+		Event: NotifyEventTest,
+		Title: notifyTitle(NotifyEventTest),
+		Text:  "This is a test notification from the 3x-ui panel on " + notifyHostname() + ".",
+	})
+}
+
+// GetFailures returns the logged delivery failures, newest first.
+func (s *NotificationService) GetFailures() ([]*model.NotifyFailure, error) {
+	db := database.GetDB()
+	var failures []*model.NotifyFailure
+	err := db.Model(model.NotifyFailure{}).Order("id desc").Find(&failures).Error
+	if err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// ClearFailures empties the delivery failure log.
+func (s *NotificationService) ClearFailures() error {
+	db := database.GetDB()
+	return db.Where("1 = 1").Delete(model.NotifyFailure{}).Error
+}
+
+func (s *NotificationService) checkChannel(channel *model.NotifyChannel) error {
+	channel.Name = strings.TrimSpace(channel.Name)
+	if channel.Name == "" {
+		return common.NewError("notification channel name is required")
+	}
+	var events []string
+	for _, event := range strings.Split(channel.Events, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !slices.Contains(NotifyEvents, event) {
+			return common.NewError("unknown notification event:", event)
+		}
+		if event == NotifyEventClientWarning && channel.Type != notify.SMTP {
+			return common.NewError("client warnings can only be sent to email channels")
+		}
+		events = append(events, event)
+	}
+	channel.Events = strings.Join(events, ",")
+	_, err := newNotifySender(channel)
+	return err
+}
+
+// newNotifySender creates the sender of a channel. Telegram channels use the panel's bot.
+func newNotifySender(channel *model.NotifyChannel) (notify.Sender, error) {
+	if channel.Type != notify.Telegram {
+		return notify.New(channel.Type, channel.Config)
+	}
+	sender := &telegramSender{}
+	if channel.Config != "" {
+		if err := json.Unmarshal([]byte(channel.Config), sender); err != nil {
+			return nil, common.NewError("invalid notification channel config:", err)
+		}
+	}
+	for _, id := range strings.Split(sender.ChatIds, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, common.NewError("invalid Telegram chat ID:", id)
+		}
+	}
+	return sender, nil
+}
+
+// telegramSender sends messages through the panel's Telegram bot, to the
+// admin chats by default or to the configured chat IDs.
+type telegramSender struct {
+	ChatIds string `json:"chatIds"`
+}
+
+func (s *telegramSender) chats() []int64 {
+	var chats []int64
+	for _, id := range strings.Split(s.ChatIds, ",") {
+		if chatId, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil {
+			chats = append(chats, chatId)
+		}
+	}
+	if len(chats) == 0 {
+		chats = adminIds
+	}
+	return chats
+}
+
+// Send sends the text and then each attachment as a document.
+func (s *telegramSender) Send(msg notify.Message) error {
+	t := Tgbot{}
+	if !t.IsRunning() {
+		return common.NewError("Telegram bot is not running")
+	}
+	chats := s.chats()
+	if len(chats) == 0 {
+		return common.NewError("no Telegram chat to notify")
+	}
+	var lastErr error
+	for _, chatId := range chats {
+		// Chats get the message in the language they talk to the bot in
+		text, markup := msg.Text, msg.ReplyMarkup
+		if lang, _ := getTgUserLang(chatId); lang != "" && msg.Localize != nil {
+			text, markup = msg.Localize(lang)
+		}
+		var replyMarkup []telego.ReplyMarkup
+		if markup, ok := markup.(telego.ReplyMarkup); ok && markup != nil {
+			replyMarkup = append(replyMarkup, markup)
+		}
+		if err := t.sendMessage(chatId, text, replyMarkup...); err != nil {
+			lastErr = err
+			continue
+		}
+		for _, attachment := range msg.Attachments {
+			document := tu.Document(tu.ID(chatId), tu.FileFromBytes(attachment.Data, attachment.Name))
+			if _, err := bot.SendDocument(context.Background(), document); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+func notifyTitle(event string) string {
+	title, ok := notifyEventTitles[event]
+	if !ok {
+		title = event
+	}
+	return "3x-ui " + notifyHostname() + ": " + title
+}
+
+func notifyHostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "panel"
+	}
+	return host
+}
@@ -1,17 +1,28 @@
 package service
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"gorm.io/gorm"
 )
 
+// quotaBlackholeTag is the outbound added to the config when an exceeded outbound
+// has no usable fallback and the template defines no blackhole outbound.
+const quotaBlackholeTag = "quota-blackhole"
+
 // OutboundService provides business logic for managing Xray outbound configurations.
-// It handles outbound traffic monitoring and statistics.
-type OutboundService struct{}
+// It handles outbound traffic monitoring, statistics and quota enforcement.
+type OutboundService struct {
+	tgbotService        Tgbot
+	notificationService NotificationService
+}
 
 func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
 	var err error
@@ -31,7 +42,17 @@ func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []
 		return err, false
 	}
 
-	return nil, false
+	exceeded, err := s.disableExceededOutbounds(tx)
+	if err != nil {
+		logger.Warning("Error in disabling exceeded outbounds:", err)
+		return err, false
+	}
+	for _, outbound := range exceeded {
+		logger.Infof("Outbound %s exceeded its quota, rerouting its rules", outbound.Tag)
+		s.notifyQuota("tgbot.messages.outboundQuotaExceeded", outbound)
+	}
+
+	return nil, len(exceeded) > 0
 }
 
 func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) error {
@@ -66,6 +87,29 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
 	return nil
 }
 
+// disableExceededOutbounds marks outbounds that have just used up their quota as exceeded
+// and returns them.
+func (s *OutboundService) disableExceededOutbounds(tx *gorm.DB) ([]*model.OutboundTraffics, error) {
+	var outbounds []*model.OutboundTraffics
+	err := tx.Model(model.OutboundTraffics{}).
+		Where("quota > 0 AND up + down >= quota AND exceeded = ?", false).
+		Find(&outbounds).Error
+	if err != nil || len(outbounds) == 0 {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		ids = append(ids, outbound.Id)
+		outbound.Exceeded = true
+	}
+	err = tx.Model(model.OutboundTraffics{}).Where("id IN ?", ids).Update("exceeded", true).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbounds, nil
+}
+
 func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, error) {
 	db := database.GetDB()
 	var traffics []*model.OutboundTraffics
@@ -79,9 +123,20 @@ func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, erro
 	return traffics, nil
 }
 
-func (s *OutboundService) ResetOutboundTraffic(tag string) error {
+// GetExceededOutbounds returns the outbounds whose rules are currently rerouted.
+func (s *OutboundService) GetExceededOutbounds() ([]*model.OutboundTraffics, error) {
 	db := database.GetDB()
+	var outbounds []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).Where("exceeded = ?", true).Find(&outbounds).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return outbounds, nil
+}
 
+// ResetOutboundTraffic resets the traffic of the given outbound, or of all outbounds for "-alltags-".
+// Exceeded outbounds get their original routing back, in which case a restart is needed.
+func (s *OutboundService) ResetOutboundTraffic(tag string) (bool, error) {
 	whereText := "tag "
 	if tag == "-alltags-" {
 		whereText += " <> ?"
@@ -89,14 +144,192 @@ func (s *OutboundService) ResetOutboundTraffic(tag string) error {
 		whereText += " = ?"
 	}
 
-	result := db.Model(model.OutboundTraffics{}).
-		Where(whereText, tag).
-		Updates(map[string]any{"up": 0, "down": 0, "total": 0})
+	return s.resetOutbounds(whereText, tag, nil)
+}
 
-	err := result.Error
+// ResetOutboundTrafficsByPeriod resets the traffic of all outbounds with the given reset schedule.
+func (s *OutboundService) ResetOutboundTrafficsByPeriod(period string) (bool, error) {
+	return s.resetOutbounds("traffic_reset = ?", period, map[string]any{
+		"last_traffic_reset_time": time.Now().UnixMilli(),
+	})
+}
+
+func (s *OutboundService) resetOutbounds(whereText string, arg any, extra map[string]any) (bool, error) {
+	db := database.GetDB()
+
+	var outbounds []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).Where(whereText, arg).Find(&outbounds).Error
+	if err != nil {
+		return false, err
+	}
+	if len(outbounds) == 0 {
+		return false, nil
+	}
+
+	updates := map[string]any{"up": 0, "down": 0, "total": 0, "exceeded": false}
+	for key, value := range extra {
+		updates[key] = value
+	}
+	err = db.Model(model.OutboundTraffics{}).Where(whereText, arg).Updates(updates).Error
 	if err != nil {
+		return false, err
+	}
+
+	needRestart := false
+	for _, outbound := range outbounds {
+		if outbound.Exceeded {
+			logger.Infof("Outbound %s traffic reset, restoring its rules", outbound.Tag)
+			s.notifyQuota("tgbot.messages.outboundQuotaRestored", outbound)
+			needRestart = true
+		}
+	}
+	return needRestart, nil
+}
+
+// SetOutboundQuota updates the quota, fallback outbound and reset schedule of an outbound.
+// It returns true if the outbound's routing changed and Xray needs a restart.
+func (s *OutboundService) SetOutboundQuota(tag string, quota int64, fallbackTag string, trafficReset string) (bool, error) {
+	if tag == "" {
+		return false, common.NewError("outbound tag is empty")
+	}
+	if quota < 0 {
+		return false, common.NewError("invalid outbound quota:", quota)
+	}
+	if fallbackTag == tag {
+		return false, common.NewError("outbound can't fall back to itself:", tag)
+	}
+	switch trafficReset {
+	case "":
+		trafficReset = "never"
+	case "never", "daily", "weekly", "monthly":
+	default:
+		return false, common.NewError("invalid traffic reset period:", trafficReset)
+	}
+
+	db := database.GetDB()
+	var outbound model.OutboundTraffics
+	err := db.Model(&model.OutboundTraffics{}).Where("tag = ?", tag).FirstOrCreate(&outbound).Error
+	if err != nil {
+		return false, err
+	}
+
+	wasExceeded := outbound.Exceeded
+	outbound.Tag = tag
+	outbound.Quota = quota
+	outbound.FallbackTag = fallbackTag
+	outbound.TrafficReset = trafficReset
+	outbound.Exceeded = quota > 0 && outbound.Up+outbound.Down >= quota
+	err = db.Save(&outbound).Error
+	if err != nil {
+		return false, err
+	}
+
+	// A different fallback only matters while the rules are rerouted
+	return wasExceeded || outbound.Exceeded, nil
+}
+
+// ApplyQuotas reroutes the rules of exceeded outbounds in the given config to their
+// fallback outbound, or to a blackhole if the fallback is missing or exceeded as well.
+func (s *OutboundService) ApplyQuotas(xrayConfig *xray.Config) error {
+	exceeded, err := s.GetExceededOutbounds()
+	if err != nil || len(exceeded) == 0 {
 		return err
 	}
 
-	return nil
+	var outbounds []map[string]any
+	if err = json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+		return err
+	}
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err = json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+
+	exceededTags := make(map[string]bool, len(exceeded))
+	for _, outbound := range exceeded {
+		exceededTags[outbound.Tag] = true
+	}
+	outboundTags := map[string]bool{}
+	blackholeTag := ""
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		outboundTags[tag] = true
+		if blackholeTag == "" && tag != "" && outbound["protocol"] == "blackhole" && !exceededTags[tag] {
+			blackholeTag = tag
+		}
+	}
+
+	redirects := map[string]string{}
+	needBlackhole := false
+	for _, outbound := range exceeded {
+		if !outboundTags[outbound.Tag] {
+			continue
+		}
+		fallbackTag := outbound.FallbackTag
+		if fallbackTag == "" || !outboundTags[fallbackTag] || exceededTags[fallbackTag] {
+			if fallbackTag != "" {
+				logger.Warningf("Fallback outbound %s of %s is unavailable, using blackhole", fallbackTag, outbound.Tag)
+			}
+			if blackholeTag == "" {
+				blackholeTag = quotaBlackholeTag
+				needBlackhole = true
+			}
+			fallbackTag = blackholeTag
+		}
+		redirects[outbound.Tag] = fallbackTag
+	}
+	if len(redirects) == 0 {
+		return nil
+	}
+
+	rules, _ := routing["rules"].([]any)
+	for _, r := range rules {
+		rule, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		if tag, ok := rule["outboundTag"].(string); ok && redirects[tag] != "" {
+			rule["outboundTag"] = redirects[tag]
+		}
+	}
+
+	// Traffic that matches no rule goes to the first outbound
+	if len(outbounds) > 0 {
+		if tag, _ := outbounds[0]["tag"].(string); redirects[tag] != "" {
+			rules = append(rules, map[string]any{
+				"type":        "field",
+				"network":     "tcp,udp",
+				"outboundTag": redirects[tag],
+			})
+		}
+	}
+	routing["rules"] = rules
+
+	if needBlackhole {
+		outbounds = append(outbounds, map[string]any{
+			"tag":      quotaBlackholeTag,
+			"protocol": "blackhole",
+		})
+		xrayConfig.OutboundConfigs, err = json.MarshalIndent(outbounds, "", "  ")
+		if err != nil {
+			return err
+		}
+	}
+	xrayConfig.RouterConfig, err = json.MarshalIndent(routing, "", "  ")
+	return err
+}
+
+func (s *OutboundService) notifyQuota(messageKey string, outbound *model.OutboundTraffics) {
+	fallbackTag := outbound.FallbackTag
+	if fallbackTag == "" {
+		fallbackTag = "blackhole"
+	}
+	s.tgbotService.NotifyLocalized(NotifyEventOutboundQuota, func(t *Tgbot) string {
+		return t.I18nBot(messageKey,
+			"Tag=="+outbound.Tag,
+			"Quota=="+common.FormatTraffic(outbound.Quota),
+			"Fallback=="+fallbackTag)
+	})
 }
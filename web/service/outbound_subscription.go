@@ -0,0 +1,918 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+const (
+	outboundSubTimeout = 30 * time.Second
+	outboundSubMaxSize = 10 << 20
+	// Providers pick the format of a subscription by the client asking for it, and send base64
+	// share links to v2rayN
+	outboundSubUserAgent = "v2rayN/7.0"
+)
+
+// Strategies of the balancer of an outbound subscription.
+var outboundSubStrategies = []string{"random", "roundRobin", "leastPing", "leastLoad"}
+
+var outboundSubPrefixRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// outboundSubLock keeps refreshes from overlapping.
+var outboundSubLock sync.Mutex
+
+// SubscriptionOutbound is an outbound built from a share link.
+type SubscriptionOutbound struct {
+	Tag      string         `json:"tag"`
+	Remark   string         `json:"remark"` // Name of the server in the link
+	Outbound map[string]any `json:"outbound"`
+}
+
+// OutboundLinksResult is the outbounds built from share links, and the links that were skipped.
+type OutboundLinksResult struct {
+	Outbounds []*SubscriptionOutbound `json:"outbounds"`
+	Skipped   []string                `json:"skipped"`
+}
+
+// OutboundSubscriptionService manages groups of outbounds built from share links or remote
+// subscriptions, and adds them to the Xray config.
+type OutboundSubscriptionService struct {
+	xraySettingService XraySettingService
+}
+
+// GetSubscriptions returns all outbound subscriptions.
+func (s *OutboundSubscriptionService) GetSubscriptions() ([]*model.OutboundSubscription, error) {
+	db := database.GetDB()
+	var subs []*model.OutboundSubscription
+	if err := db.Model(model.OutboundSubscription{}).Order("id").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// AddSubscription validates and stores a new subscription and builds its outbounds. A
+// subscription that can't be fetched is kept with the error. It returns whether Xray needs a
+// restart.
+func (s *OutboundSubscriptionService) AddSubscription(sub *model.OutboundSubscription) (bool, error) {
+	if err := s.checkSubscription(sub); err != nil {
+		return false, err
+	}
+	sub.Id = 0
+	sub.Outbounds = "[]"
+	sub.LastUpdate = 0
+	sub.Error = ""
+	db := database.GetDB()
+	if err := db.Create(sub).Error; err != nil {
+		return false, err
+	}
+	changed, err := s.refresh(sub)
+	if err != nil {
+		logger.Warningf("Unable to refresh outbound subscription %s: %v", sub.Name, err)
+	}
+	return changed, nil
+}
+
+// UpdateSubscription validates and stores the changes of a subscription. Outbounds are built
+// again when their links change, and rules of the Xray template follow a new prefix.
+func (s *OutboundSubscriptionService) UpdateSubscription(sub *model.OutboundSubscription) (bool, error) {
+	if err := s.checkSubscription(sub); err != nil {
+		return false, err
+	}
+	db := database.GetDB()
+	oldSub := &model.OutboundSubscription{}
+	if err := db.First(oldSub, sub.Id).Error; err != nil {
+		return false, err
+	}
+	sub.Outbounds = oldSub.Outbounds
+	sub.LastUpdate = oldSub.LastUpdate
+	sub.Error = oldSub.Error
+
+	if sub.Prefix != oldSub.Prefix {
+		items := parseSubscriptionOutbounds(sub.Outbounds)
+		for _, item := range items {
+			item.Tag = sub.Prefix + strings.TrimPrefix(item.Tag, oldSub.Prefix)
+			item.Outbound["tag"] = item.Tag
+		}
+		data, err := json.Marshal(items)
+		if err != nil {
+			return false, err
+		}
+		sub.Outbounds = string(data)
+		if err := s.retagTemplate(oldSub.Prefix, sub.Prefix); err != nil {
+			return false, err
+		}
+		// The outbounds keep their traffic under the new tags
+		traffics, err := subscriptionTraffics(db, oldSub.Prefix)
+		if err != nil {
+			return false, err
+		}
+		for _, traffic := range traffics {
+			newTag := sub.Prefix + strings.TrimPrefix(traffic.Tag, oldSub.Prefix)
+			if err := db.Model(traffic).Update("tag", newTag).Error; err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := db.Save(sub).Error; err != nil {
+		return false, err
+	}
+	if sub.Url != oldSub.Url || sub.Links != oldSub.Links {
+		if _, err := s.refresh(sub); err != nil {
+			logger.Warningf("Unable to refresh outbound subscription %s: %v", sub.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// DelSubscription deletes a subscription together with the traffic of its outbounds and the
+// rules of the Xray template that route to them.
+func (s *OutboundSubscriptionService) DelSubscription(id int) (bool, error) {
+	db := database.GetDB()
+	sub := &model.OutboundSubscription{}
+	if err := db.First(sub, id).Error; err != nil {
+		return false, err
+	}
+	if err := s.retagTemplate(sub.Prefix, ""); err != nil {
+		return false, err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		traffics, err := subscriptionTraffics(tx, sub.Prefix)
+		if err != nil {
+			return err
+		}
+		for _, traffic := range traffics {
+			if err := tx.Delete(traffic).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(model.OutboundSubscription{}, id).Error
+	})
+	return sub.Enable, err
+}
+
+// RefreshSubscription builds the outbounds of a subscription from its links again. It returns
+// whether they changed.
+func (s *OutboundSubscriptionService) RefreshSubscription(id int) (bool, error) {
+	db := database.GetDB()
+	sub := &model.OutboundSubscription{}
+	if err := db.First(sub, id).Error; err != nil {
+		return false, err
+	}
+	return s.refresh(sub)
+}
+
+// RefreshDue refreshes the enabled remote subscriptions whose interval has passed. It returns
+// whether the outbounds of any of them changed.
+func (s *OutboundSubscriptionService) RefreshDue() bool {
+	db := database.GetDB()
+	var subs []*model.OutboundSubscription
+	err := db.Model(model.OutboundSubscription{}).
+		Where("enable = ? AND url <> '' AND refresh_interval > 0", true).
+		Find(&subs).Error
+	if err != nil {
+		logger.Warning("Unable to get outbound subscriptions:", err)
+		return false
+	}
+	now := time.Now()
+	changed := false
+	for _, sub := range subs {
+		if now.Sub(time.UnixMilli(sub.LastUpdate)) < time.Duration(sub.RefreshInterval)*time.Minute {
+			continue
+		}
+		subChanged, err := s.refresh(sub)
+		if err != nil {
+			logger.Warningf("Unable to refresh outbound subscription %s: %v", sub.Name, err)
+			continue
+		}
+		if subChanged {
+			logger.Infof("Outbounds of subscription %s changed", sub.Name)
+		}
+		changed = changed || subChanged
+	}
+	return changed
+}
+
+// ParseLinks builds the outbounds of share links, or of the links of a remote subscription,
+// without storing them.
+func (s *OutboundSubscriptionService) ParseLinks(subUrl, links, prefix string) (*OutboundLinksResult, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		prefix = "out"
+	}
+	if !outboundSubPrefixRegex.MatchString(prefix) {
+		return nil, common.NewError("the prefix may only hold letters, digits, dots, dashes and underscores")
+	}
+	if subUrl = strings.TrimSpace(subUrl); subUrl != "" {
+		var err error
+		if links, err = fetchOutboundSubscription(subUrl); err != nil {
+			return nil, err
+		}
+	}
+	return buildSubscriptionOutbounds(links, prefix), nil
+}
+
+func (s *OutboundSubscriptionService) checkSubscription(sub *model.OutboundSubscription) error {
+	sub.Name = strings.TrimSpace(sub.Name)
+	if sub.Name == "" {
+		return common.NewError("the subscription needs a name")
+	}
+	sub.Prefix = strings.TrimSpace(sub.Prefix)
+	if !outboundSubPrefixRegex.MatchString(sub.Prefix) {
+		return common.NewError("the prefix may only hold letters, digits, dots, dashes and underscores")
+	}
+	sub.Url = strings.TrimSpace(sub.Url)
+	sub.Links = strings.TrimSpace(sub.Links)
+	if sub.Url != "" {
+		u, err := url.Parse(sub.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewError("the subscription URL must be an http or https URL")
+		}
+		sub.Links = ""
+	} else if sub.Links == "" {
+		return common.NewError("the subscription needs a URL or share links")
+	}
+	if sub.RefreshInterval < 0 {
+		return common.NewError("the refresh interval can't be negative")
+	}
+	if sub.Strategy == "" {
+		sub.Strategy = "random"
+	}
+	if !slices.Contains(outboundSubStrategies, sub.Strategy) {
+		return common.NewError("unknown balancer strategy:", sub.Strategy)
+	}
+
+	// Tags of the group must not be those of other outbounds or match the selector of another group
+	db := database.GetDB()
+	var others []*model.OutboundSubscription
+	if err := db.Model(model.OutboundSubscription{}).Where("id <> ?", sub.Id).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if strings.HasPrefix(sub.Prefix+"-", other.Prefix+"-") || strings.HasPrefix(other.Prefix+"-", sub.Prefix+"-") {
+			return common.NewErrorf("the prefix overlaps the prefix %s of subscription %s", other.Prefix, other.Name)
+		}
+	}
+	template, err := s.xraySettingService.GetXrayConfigTemplate()
+	if err != nil {
+		return err
+	}
+	config := &xray.Config{}
+	if err := json.Unmarshal([]byte(template), config); err != nil {
+		return err
+	}
+	var outbounds []map[string]any
+	json.Unmarshal(config.OutboundConfigs, &outbounds)
+	for _, outbound := range outbounds {
+		if tag, _ := outbound["tag"].(string); tag == sub.Prefix || strings.HasPrefix(tag, sub.Prefix+"-") {
+			return common.NewErrorf("outbound %s of the Xray configuration has the prefix", tag)
+		}
+	}
+	var routing struct {
+		Balancers []map[string]any `json:"balancers"`
+	}
+	json.Unmarshal(config.RouterConfig, &routing)
+	for _, balancer := range routing.Balancers {
+		if balancer["tag"] == sub.Prefix {
+			return common.NewErrorf("balancer %s of the Xray configuration has the prefix as tag", sub.Prefix)
+		}
+	}
+	return nil
+}
+
+// refresh builds the outbounds of a subscription again and stores them with the result. A remote
+// subscription that fails or holds no usable links keeps its outbounds, so a provider outage
+// doesn't take the group down. It returns whether the outbounds changed.
+func (s *OutboundSubscriptionService) refresh(sub *model.OutboundSubscription) (bool, error) {
+	outboundSubLock.Lock()
+	defer outboundSubLock.Unlock()
+
+	db := database.GetDB()
+	links := sub.Links
+	var err error
+	if sub.Url != "" {
+		links, err = fetchOutboundSubscription(sub.Url)
+	}
+	var result *OutboundLinksResult
+	if err == nil {
+		result = buildSubscriptionOutbounds(links, sub.Prefix)
+		if len(result.Outbounds) == 0 && sub.Url != "" {
+			err = common.NewError("the subscription holds no supported share links")
+		}
+	}
+	sub.LastUpdate = time.Now().UnixMilli()
+	if err != nil {
+		sub.Error = err.Error()
+		if err1 := db.Model(&model.OutboundSubscription{}).Where("id = ?", sub.Id).
+			Updates(map[string]any{"last_update": sub.LastUpdate, "error": sub.Error}).Error; err1 != nil {
+			return false, err1
+		}
+		return false, err
+	}
+	for _, skipped := range result.Skipped {
+		logger.Debugf("Outbound subscription %s skipped %s", sub.Name, skipped)
+	}
+
+	data, err := json.Marshal(result.Outbounds)
+	if err != nil {
+		return false, err
+	}
+	changed := string(data) != sub.Outbounds
+	var removed []string
+	for _, item := range parseSubscriptionOutbounds(sub.Outbounds) {
+		if !slices.ContainsFunc(result.Outbounds, func(o *SubscriptionOutbound) bool { return o.Tag == item.Tag }) {
+			removed = append(removed, item.Tag)
+		}
+	}
+	sub.Outbounds = string(data)
+	sub.Error = ""
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(removed) > 0 {
+			if err := tx.Where("tag IN ?", removed).Delete(model.OutboundTraffics{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&model.OutboundSubscription{}).Where("id = ?", sub.Id).Updates(map[string]any{
+			"outbounds":   sub.Outbounds,
+			"last_update": sub.LastUpdate,
+			"error":       sub.Error,
+		}).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed && sub.Enable, nil
+}
+
+// retagTemplate moves the rules and balancers of the Xray template that route to the outbounds
+// of a prefix to a new prefix. Without a new prefix, the rules are removed and the balancers stop
+// selecting the outbounds.
+func (s *OutboundSubscriptionService) retagTemplate(oldPrefix, newPrefix string) error {
+	template, err := s.xraySettingService.GetXrayConfigTemplate()
+	if err != nil {
+		return err
+	}
+	config := map[string]any{}
+	if err := json.Unmarshal([]byte(template), &config); err != nil {
+		return err
+	}
+	routing, _ := config["routing"].(map[string]any)
+	if routing == nil {
+		return nil
+	}
+	changed := false
+	rules, _ := routing["rules"].([]any)
+	kept := make([]any, 0, len(rules))
+	for _, item := range rules {
+		rule, ok := item.(map[string]any)
+		if !ok {
+			kept = append(kept, item)
+			continue
+		}
+		outboundTag, _ := rule["outboundTag"].(string)
+		balancerTag, _ := rule["balancerTag"].(string)
+		toOutbound := strings.HasPrefix(outboundTag, oldPrefix+"-")
+		toBalancer := balancerTag == oldPrefix
+		if !toOutbound && !toBalancer {
+			kept = append(kept, rule)
+			continue
+		}
+		changed = true
+		if newPrefix == "" {
+			continue
+		}
+		if toOutbound {
+			rule["outboundTag"] = newPrefix + strings.TrimPrefix(outboundTag, oldPrefix)
+		}
+		if toBalancer {
+			rule["balancerTag"] = newPrefix
+		}
+		kept = append(kept, rule)
+	}
+	routing["rules"] = kept
+
+	balancers, _ := routing["balancers"].([]any)
+	for _, item := range balancers {
+		balancer, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		selectors, _ := balancer["selector"].([]any)
+		newSelectors := make([]any, 0, len(selectors))
+		for _, selector := range selectors {
+			if selector, ok := selector.(string); ok && strings.HasPrefix(selector, oldPrefix+"-") {
+				changed = true
+				if newPrefix != "" {
+					newSelectors = append(newSelectors, newPrefix+strings.TrimPrefix(selector, oldPrefix))
+				}
+				continue
+			}
+			newSelectors = append(newSelectors, selector)
+		}
+		balancer["selector"] = newSelectors
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.xraySettingService.SaveXraySetting(string(data))
+}
+
+// ApplySubscriptions adds the outbounds of the enabled subscriptions to the given config, with
+// a balancer tagged with the prefix and an observatory for the groups that want one. Rules that
+// route to outbounds or balancers of a subscription that no longer exist are removed, so
+// outbounds that disappear upstream leave the routing cleanly.
+func (s *OutboundSubscriptionService) ApplySubscriptions(xrayConfig *xray.Config) error {
+	subs, err := s.GetSubscriptions()
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+
+	var outbounds []any
+	if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+		return err
+	}
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	outboundTags := map[string]bool{}
+	for _, item := range outbounds {
+		if outbound, ok := item.(map[string]any); ok {
+			if tag, _ := outbound["tag"].(string); tag != "" {
+				outboundTags[tag] = true
+			}
+		}
+	}
+	balancers, _ := routing["balancers"].([]any)
+	balancerTags := map[string]bool{}
+	for _, item := range balancers {
+		if balancer, ok := item.(map[string]any); ok {
+			if tag, _ := balancer["tag"].(string); tag != "" {
+				balancerTags[tag] = true
+			}
+		}
+	}
+	observatory := rawObject(xrayConfig.Observatory)
+	burstObservatory := rawObject(xrayConfig.BurstObservatory)
+
+	for _, sub := range subs {
+		if !sub.Enable {
+			continue
+		}
+		added := 0
+		for _, item := range parseSubscriptionOutbounds(sub.Outbounds) {
+			if outboundTags[item.Tag] {
+				logger.Warningf("Outbound %s of subscription %s is already defined, skipping it", item.Tag, sub.Name)
+				continue
+			}
+			outbounds = append(outbounds, item.Outbound)
+			outboundTags[item.Tag] = true
+			added++
+		}
+		if !sub.Balancer || added == 0 {
+			continue
+		}
+		if balancerTags[sub.Prefix] {
+			logger.Warningf("Balancer %s of subscription %s is already defined, skipping it", sub.Prefix, sub.Name)
+			continue
+		}
+		selector := sub.Prefix + "-"
+		balancer := map[string]any{"tag": sub.Prefix, "selector": []any{selector}}
+		if sub.Strategy != "" && sub.Strategy != "random" {
+			balancer["strategy"] = map[string]any{"type": sub.Strategy}
+		}
+		balancers = append(balancers, balancer)
+		balancerTags[sub.Prefix] = true
+
+		// Like the balancers of the template, leastPing ones are measured by the observatory
+		// and all others by the burst observatory
+		if sub.Strategy == "leastPing" {
+			if observatory == nil {
+				observatory = map[string]any{
+					"probeURL":          "http://www.google.com/gen_204",
+					"probeInterval":     "10m",
+					"enableConcurrency": true,
+				}
+			}
+			addSubjectSelector(observatory, selector)
+		} else {
+			if burstObservatory == nil {
+				burstObservatory = map[string]any{
+					"pingConfig": map[string]any{
+						"destination":  "http://www.google.com/gen_204",
+						"interval":     "30m",
+						"connectivity": "http://connectivitycheck.platform.hicloud.com/generate_204",
+						"timeout":      "10s",
+						"sampling":     2,
+					},
+				}
+			}
+			addSubjectSelector(burstObservatory, selector)
+		}
+	}
+
+	rules, _ := routing["rules"].([]any)
+	kept := make([]any, 0, len(rules))
+	for _, item := range rules {
+		rule, ok := item.(map[string]any)
+		if ok && staleSubscriptionRule(rule, subs, outboundTags, balancerTags) {
+			logger.Infof("Removing the rule to %v%v, its subscription outbound is gone", rule["outboundTag"], rule["balancerTag"])
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(rules) > 0 {
+		routing["rules"] = kept
+	}
+	if len(balancers) > 0 {
+		routing["balancers"] = balancers
+	}
+
+	if xrayConfig.OutboundConfigs, err = json.MarshalIndent(outbounds, "", "  "); err != nil {
+		return err
+	}
+	if xrayConfig.RouterConfig, err = json.MarshalIndent(routing, "", "  "); err != nil {
+		return err
+	}
+	if observatory != nil {
+		if xrayConfig.Observatory, err = json.MarshalIndent(observatory, "", "  "); err != nil {
+			return err
+		}
+	}
+	if burstObservatory != nil {
+		if xrayConfig.BurstObservatory, err = json.MarshalIndent(burstObservatory, "", "  "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleSubscriptionRule reports whether a rule routes to an outbound or balancer of a subscription
+// that isn't in the config.
+func staleSubscriptionRule(rule map[string]any, subs []*model.OutboundSubscription, outboundTags, balancerTags map[string]bool) bool {
+	outboundTag, _ := rule["outboundTag"].(string)
+	balancerTag, _ := rule["balancerTag"].(string)
+	for _, sub := range subs {
+		if strings.HasPrefix(outboundTag, sub.Prefix+"-") && !outboundTags[outboundTag] {
+			return true
+		}
+		if balancerTag == sub.Prefix && !balancerTags[balancerTag] {
+			return true
+		}
+	}
+	return false
+}
+
+// rawObject decodes a JSON object of the config, or returns nil if there is none.
+func rawObject(data []byte) map[string]any {
+	var object map[string]any
+	if len(data) > 0 {
+		json.Unmarshal(data, &object)
+	}
+	return object
+}
+
+// addSubjectSelector adds a selector to the outbounds an observatory measures.
+func addSubjectSelector(observatory map[string]any, selector string) {
+	selectors, _ := observatory["subjectSelector"].([]any)
+	if !slices.Contains(selectors, any(selector)) {
+		selectors = append(selectors, selector)
+	}
+	observatory["subjectSelector"] = selectors
+}
+
+// subscriptionTraffics returns the traffic of the outbounds of a prefix.
+func subscriptionTraffics(tx *gorm.DB, prefix string) ([]*model.OutboundTraffics, error) {
+	var traffics []*model.OutboundTraffics
+	if err := tx.Where("tag LIKE ?", prefix+"-%").Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	// Underscores in the prefix match any character in LIKE
+	return slices.DeleteFunc(traffics, func(traffic *model.OutboundTraffics) bool {
+		return !strings.HasPrefix(traffic.Tag, prefix+"-")
+	}), nil
+}
+
+// parseSubscriptionOutbounds decodes the stored outbounds of a subscription.
+func parseSubscriptionOutbounds(data string) []*SubscriptionOutbound {
+	var items []*SubscriptionOutbound
+	if data != "" {
+		json.Unmarshal([]byte(data), &items)
+	}
+	return items
+}
+
+// fetchOutboundSubscription downloads the share links of a remote subscription.
+func fetchOutboundSubscription(subUrl string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, subUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", outboundSubUserAgent)
+	client := &http.Client{Timeout: outboundSubTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("the subscription returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, outboundSubMaxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > outboundSubMaxSize {
+		return "", common.NewError("the subscription is too large")
+	}
+	return string(data), nil
+}
+
+// buildSubscriptionOutbounds builds the outbounds of share links, given one per line or as a
+// base64 subscription. The tag of an outbound is the prefix and a hash of its server and
+// credentials, so it stays the same while the provider renames or reorders the servers.
+func buildSubscriptionOutbounds(links, prefix string) *OutboundLinksResult {
+	links = strings.TrimSpace(links)
+	if !strings.Contains(links, "://") {
+		if decoded, err := decodeBase64(strings.Join(strings.Fields(links), "")); err == nil {
+			links = string(decoded)
+		}
+	}
+
+	result := &OutboundLinksResult{Outbounds: []*SubscriptionOutbound{}, Skipped: []string{}}
+	for line := range strings.SplitSeq(links, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		outbound, remark, identity, err := parseOutboundLink(line)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", shortLink(line), err))
+			continue
+		}
+		sum := sha256.Sum256([]byte(identity))
+		tag := prefix + "-" + hex.EncodeToString(sum[:4])
+		if slices.ContainsFunc(result.Outbounds, func(o *SubscriptionOutbound) bool { return o.Tag == tag }) {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: same server as another link", shortLink(line)))
+			continue
+		}
+		outbound["tag"] = tag
+		result.Outbounds = append(result.Outbounds, &SubscriptionOutbound{Tag: tag, Remark: remark, Outbound: outbound})
+	}
+	return result
+}
+
+// parseOutboundLink builds the outbound of a vless, vmess, trojan, ss or wireguard share link.
+// It also returns the name of the server and what identifies the server and its user.
+func parseOutboundLink(line string) (map[string]any, string, string, error) {
+	link, err := splitShareLink(line)
+	if err != nil {
+		return nil, "", "", err
+	}
+	outbound := map[string]any{}
+	server := map[string]any{"address": link.address, "port": link.port}
+	switch link.scheme {
+	case "vless":
+		outbound["protocol"] = "vless"
+		encryption := link.query.Get("encryption")
+		if encryption == "" {
+			encryption = "none"
+		}
+		server["users"] = []any{map[string]any{"id": link.user, "encryption": encryption, "flow": link.query.Get("flow")}}
+		outbound["settings"] = map[string]any{"vnext": []any{server}}
+	case "vmess":
+		outbound["protocol"] = "vmess"
+		security := link.query.Get("scy")
+		if security == "" {
+			security = "auto"
+		}
+		server["users"] = []any{map[string]any{"id": link.user, "security": security}}
+		outbound["settings"] = map[string]any{"vnext": []any{server}}
+	case "trojan":
+		outbound["protocol"] = "trojan"
+		server["password"] = link.user
+		outbound["settings"] = map[string]any{"servers": []any{server}}
+	case "ss":
+		if link.query.Get("plugin") != "" {
+			return nil, "", "", fmt.Errorf("shadowsocks plugins are not supported")
+		}
+		method, password, ok := strings.Cut(link.user, ":")
+		if !ok || method == "" || password == "" {
+			return nil, "", "", fmt.Errorf("the link has no method and password")
+		}
+		outbound["protocol"] = "shadowsocks"
+		server["method"] = method
+		server["password"] = password
+		// UDP over TCP only works with servers that support it, so it's up to the link
+		switch firstQuery(link.query, "uot", "udp-over-tcp") {
+		case "1", "true":
+			server["uot"] = true
+		}
+		outbound["settings"] = map[string]any{"servers": []any{server}}
+	case "wireguard", "wg":
+		settings, err := wireguardOutboundSettings(link)
+		if err != nil {
+			return nil, "", "", err
+		}
+		outbound["protocol"] = "wireguard"
+		outbound["settings"] = settings
+		identity := fmt.Sprintf("wireguard|%s|%d|%s", link.address, link.port, link.query.Get("publickey"))
+		return outbound, link.remark, identity, nil
+	default:
+		return nil, "", "", fmt.Errorf("%s links are not supported", link.scheme)
+	}
+	stream, err := outboundStream(link.query)
+	if err != nil {
+		return nil, "", "", err
+	}
+	outbound["streamSettings"] = stream
+	identity := fmt.Sprintf("%s|%s|%d|%s", outbound["protocol"], link.address, link.port, link.user)
+	return outbound, link.remark, identity, nil
+}
+
+// wireguardOutboundSettings builds the settings of a wireguard link, whose user is the private
+// key of the client.
+func wireguardOutboundSettings(link *linkParts) (map[string]any, error) {
+	query := link.query
+	publicKey := firstQuery(query, "publickey", "publicKey", "peer_public_key")
+	if link.user == "" || publicKey == "" {
+		return nil, fmt.Errorf("the link needs the private key and the public key of the peer")
+	}
+	addresses := []any{}
+	for address := range strings.SplitSeq(firstQuery(query, "address", "ip"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("the link has no interface address")
+	}
+	peer := map[string]any{
+		"publicKey":  publicKey,
+		"endpoint":   net.JoinHostPort(link.address, strconv.Itoa(link.port)),
+		"allowedIPs": []any{"0.0.0.0/0", "::/0"},
+	}
+	if psk := firstQuery(query, "presharedkey", "preSharedKey", "psk"); psk != "" {
+		peer["preSharedKey"] = psk
+	}
+	settings := map[string]any{
+		"secretKey":   link.user,
+		"address":     addresses,
+		"peers":       []any{peer},
+		"noKernelTun": false,
+	}
+	if mtu, err := strconv.Atoi(query.Get("mtu")); err == nil && mtu > 0 {
+		settings["mtu"] = mtu
+	}
+	if reserved := query.Get("reserved"); reserved != "" {
+		var values []any
+		for part := range strings.SplitSeq(reserved, ",") {
+			value, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || value < 0 || value > 255 {
+				return nil, fmt.Errorf("reserved %q is not valid", reserved)
+			}
+			values = append(values, value)
+		}
+		settings["reserved"] = values
+	}
+	return settings, nil
+}
+
+// outboundStream builds the stream settings of an outbound from the query of a share link.
+func outboundStream(query url.Values) (map[string]any, error) {
+	network := query.Get("type")
+	switch network {
+	case "", "none", "raw":
+		network = "tcp"
+	case "splithttp":
+		network = "xhttp"
+	}
+	stream := map[string]any{"network": network}
+	host, path := query.Get("host"), query.Get("path")
+	switch network {
+	case "tcp":
+		header := map[string]any{"type": "none"}
+		if query.Get("headerType") == "http" {
+			if path == "" {
+				path = "/"
+			}
+			hosts := []any{}
+			if host != "" {
+				for item := range strings.SplitSeq(host, ",") {
+					hosts = append(hosts, item)
+				}
+			}
+			var paths []any
+			for item := range strings.SplitSeq(path, ",") {
+				paths = append(paths, item)
+			}
+			header = map[string]any{
+				"type":    "http",
+				"request": map[string]any{"headers": map[string]any{"Host": hosts}, "path": paths},
+			}
+		}
+		stream["tcpSettings"] = map[string]any{"header": header}
+	case "kcp":
+		headerType := query.Get("headerType")
+		if headerType == "" {
+			headerType = "none"
+		}
+		seed := query.Get("seed")
+		if seed == "" {
+			seed = path
+		}
+		stream["kcpSettings"] = map[string]any{"header": map[string]any{"type": headerType}, "seed": seed}
+	case "ws", "httpupgrade":
+		stream[network+"Settings"] = map[string]any{"path": path, "host": host}
+	case "grpc":
+		stream["grpcSettings"] = map[string]any{
+			"serviceName": query.Get("serviceName"),
+			"authority":   query.Get("authority"),
+			"multiMode":   query.Get("mode") == "multi",
+		}
+	case "xhttp":
+		xhttp := map[string]any{"path": path, "host": host, "mode": query.Get("mode")}
+		if xhttp["mode"] == "" {
+			xhttp["mode"] = "auto"
+		}
+		stream["xhttpSettings"] = xhttp
+	default:
+		return nil, fmt.Errorf("transport %s is not supported", network)
+	}
+
+	switch security := query.Get("security"); security {
+	case "", "none":
+		stream["security"] = "none"
+	case "tls":
+		alpn := []any{}
+		for item := range strings.SplitSeq(query.Get("alpn"), ",") {
+			if item != "" {
+				alpn = append(alpn, item)
+			}
+		}
+		tlsSettings := map[string]any{
+			"serverName":    query.Get("sni"),
+			"alpn":          alpn,
+			"allowInsecure": query.Get("allowInsecure") == "1" || query.Get("allowInsecure") == "true",
+		}
+		if fp := query.Get("fp"); fp != "" && fp != "none" {
+			tlsSettings["fingerprint"] = fp
+		}
+		stream["security"] = "tls"
+		stream["tlsSettings"] = tlsSettings
+	case "reality":
+		if query.Get("pbk") == "" {
+			return nil, fmt.Errorf("the REALITY link has no public key")
+		}
+		fingerprint := query.Get("fp")
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		stream["security"] = "reality"
+		stream["realitySettings"] = map[string]any{
+			"publicKey":   query.Get("pbk"),
+			"fingerprint": fingerprint,
+			"serverName":  query.Get("sni"),
+			"shortId":     query.Get("sid"),
+			"spiderX":     query.Get("spx"),
+		}
+	default:
+		return nil, fmt.Errorf("security %s is not supported", security)
+	}
+	return stream, nil
+}
+
+// firstQuery returns the first of the query parameters that is set.
+func firstQuery(query url.Values, keys ...string) string {
+	for _, key := range keys {
+		if value := query.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
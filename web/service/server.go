@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,6 +47,13 @@ const (
 	Error   ProcessState = "error"   // Process is in error state
 )
 
+// CoreStatus describes a running proxy core.
+type CoreStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Uptime  uint64 `json:"uptime"`
+}
+
 // Status represents comprehensive system and application status information.
 // It includes CPU, memory, disk, network statistics, and Xray process status.
 type Status struct {
@@ -71,10 +79,11 @@ type Status struct {
 		ErrorMsg string       `json:"errorMsg"`
 		Version  string       `json:"version"`
 	} `json:"xray"`
-	Uptime   uint64    `json:"uptime"`
-	Loads    []float64 `json:"loads"`
-	TcpCount int       `json:"tcpCount"`
-	UdpCount int       `json:"udpCount"`
+	Cores    []CoreStatus `json:"cores"`
+	Uptime   uint64       `json:"uptime"`
+	Loads    []float64    `json:"loads"`
+	TcpCount int          `json:"tcpCount"`
+	UdpCount int          `json:"udpCount"`
 	NetIO    struct {
 		Up   uint64 `json:"up"`
 		Down uint64 `json:"down"`
@@ -104,6 +113,7 @@ type Release struct {
 type ServerService struct {
 	xrayService        XrayService
 	inboundService     InboundService
+	backupService      BackupService
 	cachedIPv4         string
 	cachedIPv6         string
 	noIPv6             bool
@@ -402,6 +412,14 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 		status.Xray.ErrorMsg = s.xrayService.GetXrayResult()
 	}
 	status.Xray.Version = s.xrayService.GetXrayVersion()
+	status.Cores = []CoreStatus{}
+	for _, c := range s.xrayService.GetCores() {
+		status.Cores = append(status.Cores, CoreStatus{
+			Name:    c.Name(),
+			Version: c.GetVersion(),
+			Uptime:  c.GetUptime(),
+		})
+	}
 
 	// Application stats
 	var rtm runtime.MemStats
@@ -869,58 +887,74 @@ func (s *ServerService) GetConfigJson() (any, error) {
 	return jsonData, nil
 }
 
+// GetDb returns the database as a SQLite file, whatever engine it is stored in.
 func (s *ServerService) GetDb() ([]byte, error) {
-	// Update by manually trigger a checkpoint operation
-	err := database.Checkpoint()
-	if err != nil {
-		return nil, err
-	}
-	// Open the file for reading
-	file, err := os.Open(config.GetDBPath())
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	return readDB()
+}
 
-	// Read the file contents
-	fileContents, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
-	}
+// xrayStartCheckDelay is how long Xray has to keep running after an import to count as started,
+// as it exits right after starting when the imported config is bad.
+const xrayStartCheckDelay = 2 * time.Second
+
+// DBImportDiff compares a kind of data in an uploaded database with the current database.
+type DBImportDiff struct {
+	Count   int      `json:"count"`   // In the uploaded database
+	Current int      `json:"current"` // In the current database
+	Added   []string `json:"added"`   // Only in the uploaded database
+	Removed []string `json:"removed"` // Only in the current database
+	Changed []string `json:"changed"` // In both with different values, for settings
+}
 
-	return fileContents, nil
+// DBImportReport describes an uploaded database and how it differs from the current one.
+type DBImportReport struct {
+	DryRun    bool         `json:"dryRun"`
+	Version   int          `json:"version"` // Schema version of the uploaded database
+	Inbounds  DBImportDiff `json:"inbounds"`
+	Clients   DBImportDiff `json:"clients"`
+	Settings  DBImportDiff `json:"settings"`
+	Snapshot  bool         `json:"snapshot"`  // Whether the replaced database can be reverted to
+	XrayError string       `json:"xrayError"` // Why Xray failed to start after the import
 }
 
-func (s *ServerService) ImportDB(file multipart.File) error {
-	// Check if the file is a SQLite database
-	isValidDb, err := database.IsSQLiteDB(file)
+// ImportDB replaces the database with an uploaded one. Encrypted backups are decrypted with the
+// key, or with the configured backup passphrase if the key is empty. The upload is checked
+// read-only first and compared with the current data; with dryRun that report is all it
+// returns. Otherwise the current database is snapshotted, so RevertDBImport can put it back.
+func (s *ServerService) ImportDB(file multipart.File, key string, dryRun bool) (*DBImportReport, error) {
+	data, err := io.ReadAll(file)
 	if err != nil {
-		return common.NewErrorf("Error checking db file format: %v", err)
+		return nil, common.NewErrorf("Error reading db file: %v", err)
 	}
-	if !isValidDb {
-		return common.NewError("Invalid db file format")
+	if data, err = s.backupService.Open(data, key); err != nil {
+		return nil, err
 	}
 
-	// Reset the file reader to the beginning
-	_, err = file.Seek(0, 0)
+	// Check if the file is a SQLite database
+	isValidDb, err := database.IsSQLiteDB(bytes.NewReader(data))
 	if err != nil {
-		return common.NewErrorf("Error resetting file reader: %v", err)
+		return nil, common.NewErrorf("Error checking db file format: %v", err)
+	}
+	if !isValidDb {
+		return nil, common.NewError("Invalid db file format")
 	}
 
 	// Save the file as a temporary file
-	tempPath := fmt.Sprintf("%s.temp", config.GetDBPath())
+	tempPath := fmt.Sprintf("%s.temp", config.GetDBDSN())
+	if !database.IsSQLite() {
+		tempPath = filepath.Join(os.TempDir(), "x-ui-import.db")
+	}
 
 	// Remove the existing temporary file (if any)
 	if _, err := os.Stat(tempPath); err == nil {
 		if errRemove := os.Remove(tempPath); errRemove != nil {
-			return common.NewErrorf("Error removing existing temporary db file: %v", errRemove)
+			return nil, common.NewErrorf("Error removing existing temporary db file: %v", errRemove)
 		}
 	}
 
 	// Create the temporary file
 	tempFile, err := os.Create(tempPath)
 	if err != nil {
-		return common.NewErrorf("Error creating temporary db file: %v", err)
+		return nil, common.NewErrorf("Error creating temporary db file: %v", err)
 	}
 
 	// Robust deferred cleanup for the temporary file
@@ -938,20 +972,88 @@ func (s *ServerService) ImportDB(file multipart.File) error {
 	}()
 
 	// Save uploaded file to temporary file
-	if _, err = io.Copy(tempFile, file); err != nil {
-		return common.NewErrorf("Error saving db: %v", err)
+	if _, err = tempFile.Write(data); err != nil {
+		return nil, common.NewErrorf("Error saving db: %v", err)
 	}
 
-	// Check if we can init the db or not
-	if err = database.InitDB(tempPath); err != nil {
-		return common.NewErrorf("Error checking db: %v", err)
+	// Check the upload and compare it with the current data
+	uploaded, err := database.Check(tempPath)
+	if err != nil {
+		return nil, common.NewErrorf("Error checking db: %v", err)
+	}
+	current, err := database.CurrentContents()
+	if err != nil {
+		return nil, err
 	}
+	report := &DBImportReport{
+		DryRun:   dryRun,
+		Version:  uploaded.Version,
+		Inbounds: diffNames(uploaded.Inbounds, current.Inbounds),
+		Clients:  diffNames(uploaded.Clients, current.Clients),
+		Settings: diffSettings(uploaded.Settings, current.Settings),
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	// Snapshot the current database to revert to
+	snapshotPath := importSnapshotPath()
+	if err := os.Remove(snapshotPath); err != nil && !os.IsNotExist(err) {
+		return nil, common.NewErrorf("Error removing previous db snapshot: %v", err)
+	}
+	if err := database.Snapshot(snapshotPath); err != nil {
+		return nil, common.NewErrorf("Error snapshotting current db: %v", err)
+	}
+	report.Snapshot = true
 
-	// Stop Xray
+	if err := s.replaceDB(tempPath); err != nil {
+		return nil, err
+	}
+	if err := s.startImportedXray(); err != nil {
+		logger.Warning("Xray failed to start after importing a db:", err)
+		report.XrayError = strings.TrimSpace(err.Error())
+	}
+	return report, nil
+}
+
+// RevertDBImport replaces the database with the snapshot taken before the last import.
+func (s *ServerService) RevertDBImport() error {
+	snapshotPath := importSnapshotPath()
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return common.NewError("No db snapshot to revert to")
+	}
+	if err := s.replaceDB(snapshotPath); err != nil {
+		return err
+	}
+	// A SQLite snapshot has become the database, others were copied from it
+	if err := os.Remove(snapshotPath); err != nil && !os.IsNotExist(err) {
+		logger.Warning("Unable to remove the db snapshot:", err)
+	}
+	return s.RestartXrayService()
+}
+
+// importSnapshotPath is where the database is snapshotted before an import.
+func importSnapshotPath() string {
+	return filepath.Join(config.GetDBFolderPath(), config.GetName()+"-pre-import.db")
+}
+
+// replaceDB replaces the database with the SQLite file at path, with Xray stopped. SQLite
+// databases are swapped for the file, other engines get its data copied in one transaction.
+func (s *ServerService) replaceDB(path string) error {
 	s.StopXrayService()
+	if !database.IsSQLite() {
+		if err := database.Import(path); err != nil {
+			if errRestart := s.RestartXrayService(); errRestart != nil {
+				logger.Warning("Unable to restart Xray after a failed import:", errRestart)
+			}
+			return common.NewErrorf("Error importing db: %v", err)
+		}
+		return nil
+	}
 
 	// Backup the current database for fallback
-	fallbackPath := fmt.Sprintf("%s.backup", config.GetDBPath())
+	dbPath := config.GetDBDSN()
+	fallbackPath := fmt.Sprintf("%s.backup", dbPath)
 
 	// Remove the existing fallback file (if any)
 	if _, err := os.Stat(fallbackPath); err == nil {
@@ -961,7 +1063,7 @@ func (s *ServerService) ImportDB(file multipart.File) error {
 	}
 
 	// Move the current database to the fallback location
-	if err = os.Rename(config.GetDBPath(), fallbackPath); err != nil {
+	if err := os.Rename(dbPath, fallbackPath); err != nil {
 		return common.NewErrorf("Error backing up current db file: %v", err)
 	}
 
@@ -974,31 +1076,88 @@ func (s *ServerService) ImportDB(file multipart.File) error {
 		}
 	}()
 
-	// Move temp to DB path
-	if err = os.Rename(tempPath, config.GetDBPath()); err != nil {
+	// Move the file to DB path
+	if err := os.Rename(path, dbPath); err != nil {
 		// Restore from fallback
-		if errRename := os.Rename(fallbackPath, config.GetDBPath()); errRename != nil {
+		if errRename := os.Rename(fallbackPath, dbPath); errRename != nil {
 			return common.NewErrorf("Error moving db file and restoring fallback: %v", errRename)
 		}
 		return common.NewErrorf("Error moving db file: %v", err)
 	}
 
 	// Migrate DB
-	if err = database.InitDB(config.GetDBPath()); err != nil {
-		if errRename := os.Rename(fallbackPath, config.GetDBPath()); errRename != nil {
+	if err := database.InitDB(dbPath); err != nil {
+		if errRename := os.Rename(fallbackPath, dbPath); errRename != nil {
 			return common.NewErrorf("Error migrating db and restoring fallback: %v", errRename)
 		}
+		if errInit := database.InitDB(dbPath); errInit != nil {
+			logger.Warning("Unable to reopen the restored db:", errInit)
+		}
 		return common.NewErrorf("Error migrating db: %v", err)
 	}
+	return nil
+}
 
-	s.inboundService.MigrateDB()
+// startImportedXray restarts Xray and makes sure it keeps running.
+func (s *ServerService) startImportedXray() error {
+	if err := s.RestartXrayService(); err != nil {
+		return err
+	}
+	time.Sleep(xrayStartCheckDelay)
+	if s.xrayService.IsXrayRunning() {
+		return nil
+	}
+	if result := s.xrayService.GetXrayResult(); result != "" {
+		return common.NewError(result)
+	}
+	return common.NewError("Xray stopped right after starting")
+}
+
+// diffNames compares the names of things in an uploaded database with the current ones.
+func diffNames(uploaded, current []string) DBImportDiff {
+	diff := DBImportDiff{Count: len(uploaded), Current: len(current)}
+	diff.Added = missingNames(uploaded, current)
+	diff.Removed = missingNames(current, uploaded)
+	return diff
+}
 
-	// Start Xray
-	if err = s.RestartXrayService(); err != nil {
-		return common.NewErrorf("Imported DB but failed to start Xray: %v", err)
+// missingNames returns the names that are in names but not in others, once each.
+func missingNames(names, others []string) []string {
+	seen := map[string]bool{}
+	for _, name := range others {
+		seen[name] = true
+	}
+	var missing []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
 	}
+	return missing
+}
 
-	return nil
+// diffSettings compares the settings of an uploaded database with the current ones.
+func diffSettings(uploaded, current map[string]string) DBImportDiff {
+	diff := DBImportDiff{Count: len(uploaded), Current: len(current)}
+	for key, value := range uploaded {
+		currentValue, ok := current[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case value != currentValue:
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range current {
+		if _, ok := uploaded[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	return diff
 }
 
 // IsValidGeofileName validates that the filename is safe for geofile operations.
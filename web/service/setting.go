@@ -46,7 +46,29 @@ var defaultValueMap = map[string]string{
 	"tgRunTime":                   "@daily",
 	"tgBotBackup":                 "false",
 	"tgBotLoginNotify":            "true",
-	"tgCpu":                       "80",
+	"tgBotSignup":                 "false",
+	"tgBotWebhook":                "false",
+	"tgBotWebhookServer":          "panel",
+	"tgBotWebhookUrl":             "",
+	"clientWarnDays":              "7,3,1",
+	"clientWarnTraffic":           "80,90,100",
+	"clientWarnContact":           "",
+	"backupEnable":                "false",
+	"backupCron":                  "@daily",
+	"backupDir":                   "",
+	"backupS3Endpoint":            "",
+	"backupS3Region":              "us-east-1",
+	"backupS3Bucket":              "",
+	"backupS3Prefix":              "x-ui/",
+	"backupS3AccessKey":           "",
+	"backupS3SecretKey":           "",
+	"backupS3PathStyle":           "true",
+	"backupKeepLast":              "7",
+	"backupKeepDaily":             "7",
+	"backupKeepWeekly":            "4",
+	"backupEncryption":           "none",
+	"backupPassphrase":           "",
+	"backupRecipients":           "",
 	"tgLang":                      "en-US",
 	"twoFactorEnable":             "false",
 	"twoFactorToken":              "",
@@ -62,6 +84,7 @@ var defaultValueMap = map[string]string{
 	"subUpdates":                  "12",
 	"subEncrypt":                  "true",
 	"subShowInfo":                 "true",
+	"subRedeem":                   "false",
 	"subURI":                      "",
 	"subJsonPath":                 "/json/",
 	"subJsonURI":                  "",
@@ -73,6 +96,17 @@ var defaultValueMap = map[string]string{
 	"warp":                        "",
 	"externalTrafficInformEnable": "false",
 	"externalTrafficInformURI":    "",
+	"ipLimitMode":                 "fail2ban",
+	"ipLimitBanDuration":          "30",
+	"ipLimitWindow":               "5",
+	"ipHistoryRetention":          "24",
+	"ipLimitGrouping":             "ip",
+	"ipGeoCountryDb":              "",
+	"ipGeoAsnDb":                  "",
+	"loginMaxAttempts":            "5",
+	"loginLockDuration":           "5",
+	"panelAllowedIps":             "",
+	"trustedProxies":              "127.0.0.1,::1",
 	// LDAP defaults
 	"ldapEnable":                  "false",
 	"ldapHost":                    "",
@@ -112,7 +146,7 @@ func (s *SettingService) GetDefaultJsonConfig() (any, error) {
 func (s *SettingService) GetAllSetting() (*entity.AllSetting, error) {
 	db := database.GetDB()
 	settings := make([]*model.Setting, 0)
-	err := db.Model(model.Setting{}).Not("key = ?", "xrayTemplateConfig").Find(&settings).Error
+	err := db.Model(model.Setting{}).Not(map[string]any{"key": "xrayTemplateConfig"}).Find(&settings).Error
 	if err != nil {
 		return nil, err
 	}
@@ -197,7 +231,7 @@ func (s *SettingService) ResetSettings() error {
 func (s *SettingService) getSetting(key string) (*model.Setting, error) {
 	db := database.GetDB()
 	setting := &model.Setting{}
-	err := db.Model(model.Setting{}).Where("key = ?", key).First(setting).Error
+	err := db.Model(model.Setting{}).Where(map[string]any{"key": key}).First(setting).Error
 	if err != nil {
 		return nil, err
 	}
@@ -262,6 +296,26 @@ func (s *SettingService) setInt(key string, value int) error {
 	return s.setString(key, strconv.Itoa(value))
 }
 
+// getIntList returns a setting holding comma separated integers.
+func (s *SettingService) getIntList(key string) ([]int, error) {
+	str, err := s.getString(key)
+	if err != nil {
+		return nil, err
+	}
+	var list []int
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
 func (s *SettingService) GetXrayConfigTemplate() (string, error) {
 	return s.getString("xrayTemplateConfig")
 }
@@ -334,8 +388,149 @@ func (s *SettingService) GetTgBotLoginNotify() (bool, error) {
 	return s.getBool("tgBotLoginNotify")
 }
 
-func (s *SettingService) GetTgCpu() (int, error) {
-	return s.getInt("tgCpu")
+func (s *SettingService) GetTgBotSignup() (bool, error) {
+	return s.getBool("tgBotSignup")
+}
+
+func (s *SettingService) GetTgBotWebhook() (bool, error) {
+	return s.getBool("tgBotWebhook")
+}
+
+func (s *SettingService) GetTgBotWebhookServer() (string, error) {
+	return s.getString("tgBotWebhookServer")
+}
+
+func (s *SettingService) GetTgBotWebhookUrl() (string, error) {
+	return s.getString("tgBotWebhookUrl")
+}
+
+func (s *SettingService) GetClientWarnDays() ([]int, error) {
+	return s.getIntList("clientWarnDays")
+}
+
+func (s *SettingService) GetClientWarnTraffic() ([]int, error) {
+	return s.getIntList("clientWarnTraffic")
+}
+
+func (s *SettingService) GetClientWarnContact() (string, error) {
+	return s.getString("clientWarnContact")
+}
+
+func (s *SettingService) GetBackupEnable() (bool, error) {
+	return s.getBool("backupEnable")
+}
+
+func (s *SettingService) GetBackupCron() (string, error) {
+	return s.getString("backupCron")
+}
+
+func (s *SettingService) GetBackupDir() (string, error) {
+	return s.getString("backupDir")
+}
+
+func (s *SettingService) GetBackupS3Endpoint() (string, error) {
+	return s.getString("backupS3Endpoint")
+}
+
+func (s *SettingService) GetBackupS3Region() (string, error) {
+	return s.getString("backupS3Region")
+}
+
+func (s *SettingService) GetBackupS3Bucket() (string, error) {
+	return s.getString("backupS3Bucket")
+}
+
+func (s *SettingService) GetBackupS3Prefix() (string, error) {
+	return s.getString("backupS3Prefix")
+}
+
+func (s *SettingService) GetBackupS3AccessKey() (string, error) {
+	return s.getString("backupS3AccessKey")
+}
+
+func (s *SettingService) GetBackupS3SecretKey() (string, error) {
+	return s.getString("backupS3SecretKey")
+}
+
+func (s *SettingService) GetBackupS3PathStyle() (bool, error) {
+	return s.getBool("backupS3PathStyle")
+}
+
+func (s *SettingService) GetBackupKeepLast() (int, error) {
+	return s.getInt("backupKeepLast")
+}
+
+func (s *SettingService) GetBackupKeepDaily() (int, error) {
+	return s.getInt("backupKeepDaily")
+}
+
+func (s *SettingService) GetBackupKeepWeekly() (int, error) {
+	return s.getInt("backupKeepWeekly")
+}
+
+func (s *SettingService) GetBackupEncryption() (string, error) {
+	return s.getString("backupEncryption")
+}
+
+func (s *SettingService) GetBackupPassphrase() (string, error) {
+	return s.getString("backupPassphrase")
+}
+
+func (s *SettingService) GetBackupRecipients() (string, error) {
+	return s.getString("backupRecipients")
+}
+
+func (s *SettingService) GetIpLimitMode() (string, error) {
+	return s.getString("ipLimitMode")
+}
+
+func (s *SettingService) GetIpLimitBanDuration() (int, error) {
+	return s.getInt("ipLimitBanDuration")
+}
+
+func (s *SettingService) GetIpLimitWindow() (int, error) {
+	return s.getInt("ipLimitWindow")
+}
+
+func (s *SettingService) GetIpHistoryRetention() (int, error) {
+	return s.getInt("ipHistoryRetention")
+}
+
+func (s *SettingService) GetIpLimitGrouping() (string, error) {
+	return s.getString("ipLimitGrouping")
+}
+
+// GetIpGeoCountryDb returns the country database used to resolve client IPs, Xray's geoip.dat by default.
+func (s *SettingService) GetIpGeoCountryDb() (string, error) {
+	path, err := s.getString("ipGeoCountryDb")
+	if err != nil || path != "" {
+		return path, err
+	}
+	return xray.GetGeoipPath(), nil
+}
+
+func (s *SettingService) GetIpGeoAsnDb() (string, error) {
+	return s.getString("ipGeoAsnDb")
+}
+
+func (s *SettingService) GetLoginMaxAttempts() (int, error) {
+	return s.getInt("loginMaxAttempts")
+}
+
+func (s *SettingService) GetLoginLockDuration() (int, error) {
+	return s.getInt("loginLockDuration")
+}
+
+func (s *SettingService) GetPanelAllowedIps() (string, error) {
+	return s.getString("panelAllowedIps")
+}
+
+func (s *SettingService) SetPanelAllowedIps(ips string) error {
+	return s.setString("panelAllowedIps", ips)
+}
+
+func (s *SettingService) GetTrustedProxies() (string, error) {
+	return s.getString("trustedProxies")
 }
 
 func (s *SettingService) GetTgLang() (string, error) {
@@ -499,6 +694,10 @@ func (s *SettingService) GetSubShowInfo() (bool, error) {
 	return s.getBool("subShowInfo")
 }
 
+func (s *SettingService) GetSubRedeem() (bool, error) {
+	return s.getBool("subRedeem")
+}
+
 func (s *SettingService) GetPageSize() (int, error) {
 	return s.getInt("pageSize")
 }
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// linkParts are the parts of a share link, with the parameters of its transport in link query form.
+type linkParts struct {
+	scheme  string
+	user    string
+	address string
+	port    int
+	remark  string
+	query   url.Values
+}
+
+// splitShareLink splits a share link into its parts. The JSON of vmess links is turned into the
+// query parameters of the other links. Inbounds and outbounds are both built from the parts.
+func splitShareLink(line string) (*linkParts, error) {
+	scheme, rest, ok := strings.Cut(line, "://")
+	if !ok {
+		return nil, fmt.Errorf("not a share link")
+	}
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "vmess":
+		return splitVmessLink(rest)
+	case "ss":
+		return splitShadowsocksLink(rest)
+	}
+
+	u, err := url.Parse(scheme + "://" + rest)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := xrayPort(u.Port())
+	if !ok || u.Hostname() == "" {
+		return nil, fmt.Errorf("the link has no valid address")
+	}
+	if u.User.Username() == "" {
+		return nil, fmt.Errorf("the link has no user")
+	}
+	return &linkParts{
+		scheme:  scheme,
+		user:    u.User.Username(),
+		address: u.Hostname(),
+		port:    port,
+		remark:  u.Fragment,
+		query:   u.Query(),
+	}, nil
+}
+
+// splitVmessLink splits a vmess link, whose parts are base64 JSON.
+func splitVmessLink(rest string) (*linkParts, error) {
+	data, err := decodeBase64(rest)
+	if err != nil {
+		return nil, fmt.Errorf("the link is not base64: %v", err)
+	}
+	var vmess map[string]any
+	if err := json.Unmarshal(data, &vmess); err != nil {
+		return nil, fmt.Errorf("the link is not JSON: %v", err)
+	}
+	field := func(key string) string {
+		switch value := vmess[key].(type) {
+		case string:
+			return value
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return ""
+	}
+	port, ok := xrayPort(vmess["port"])
+	if !ok || field("add") == "" {
+		return nil, fmt.Errorf("the link has no valid address")
+	}
+	if field("id") == "" {
+		return nil, fmt.Errorf("the link has no user")
+	}
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	network := field("net")
+	set("type", network)
+	set("security", field("tls"))
+	set("scy", field("scy"))
+	set("aid", field("aid"))
+	set("host", field("host"))
+	set("sni", field("sni"))
+	set("alpn", field("alpn"))
+	set("fp", field("fp"))
+	set("authority", field("authority"))
+	switch network {
+	case "grpc":
+		set("serviceName", field("path"))
+		set("mode", field("type"))
+	case "kcp":
+		set("headerType", field("type"))
+		set("seed", field("path"))
+	default:
+		set("path", field("path"))
+		set("headerType", field("type"))
+		set("mode", field("mode"))
+	}
+	if insecure, _ := vmess["allowInsecure"].(bool); insecure {
+		query.Set("allowInsecure", "1")
+	}
+	return &linkParts{
+		scheme:  "vmess",
+		user:    field("id"),
+		address: field("add"),
+		port:    port,
+		remark:  field("ps"),
+		query:   query,
+	}, nil
+}
+
+// splitShadowsocksLink splits an ss link in the SIP002 format or the legacy base64 one. The user
+// is the method and password.
+func splitShadowsocksLink(rest string) (*linkParts, error) {
+	rest, remark, _ := strings.Cut(rest, "#")
+	remark, _ = url.PathUnescape(remark)
+	rest, rawQuery, _ := strings.Cut(rest, "?")
+	rest = strings.TrimSuffix(rest, "/")
+	query, _ := url.ParseQuery(rawQuery)
+
+	userInfo, address, ok := strings.Cut(rest, "@")
+	if ok {
+		if decoded, err := decodeBase64(userInfo); err == nil && strings.Contains(string(decoded), ":") {
+			userInfo = string(decoded)
+		} else if unescaped, err := url.PathUnescape(userInfo); err == nil {
+			userInfo = unescaped
+		}
+	} else {
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return nil, fmt.Errorf("the link is not base64: %v", err)
+		}
+		i := strings.LastIndex(string(decoded), "@")
+		if i < 0 {
+			return nil, fmt.Errorf("the link has no address")
+		}
+		userInfo, address = string(decoded[:i]), string(decoded[i+1:])
+	}
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := xrayPort(portText)
+	if !ok || host == "" {
+		return nil, fmt.Errorf("the link has no valid address")
+	}
+	return &linkParts{
+		scheme:  "ss",
+		user:    userInfo,
+		address: host,
+		port:    port,
+		remark:  remark,
+		query:   query,
+	}, nil
+}
+
+// decodeBase64 decodes standard or URL base64, with or without padding.
+func decodeBase64(data string) ([]byte, error) {
+	data = strings.TrimRight(strings.TrimSpace(data), "=")
+	if strings.ContainsAny(data, "-_") {
+		return base64.RawURLEncoding.DecodeString(data)
+	}
+	return base64.RawStdEncoding.DecodeString(data)
+}
+
+// shortLink shortens a link for the report, leaving out its credentials.
+func shortLink(link string) string {
+	scheme, rest, _ := strings.Cut(link, "://")
+	if _, remark, ok := strings.Cut(rest, "#"); ok && remark != "" {
+		if unescaped, err := url.PathUnescape(remark); err == nil {
+			remark = unescaped
+		}
+		return scheme + " " + remark
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		return scheme + "://" + rest[i+1:]
+	}
+	return scheme + " link"
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of signup requests.
+const (
+	SignupPending  = "pending"
+	SignupApproved = "approved"
+	SignupDenied   = "denied"
+
+	// signupApproving marks a request while its client is being created, so it isn't approved twice.
+	signupApproving = "approving"
+)
+
+var signupEmailRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// SignupService manages the plans Telegram users can request an account for and their requests.
+type SignupService struct {
+	inboundService InboundService
+}
+
+// GetPlans returns the signup plans, or only the enabled ones.
+func (s *SignupService) GetPlans(onlyEnabled bool) ([]*model.SignupPlan, error) {
+	db := database.GetDB()
+	query := db.Model(model.SignupPlan{})
+	if onlyEnabled {
+		query = query.Where("enable = ?", true)
+	}
+	var plans []*model.SignupPlan
+	if err := query.Order("id asc").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlan returns the signup plan with the given id.
+func (s *SignupService) GetPlan(id int) (*model.SignupPlan, error) {
+	db := database.GetDB()
+	plan := &model.SignupPlan{}
+	if err := db.First(plan, id).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// AddPlan validates and stores a new signup plan.
+func (s *SignupService) AddPlan(plan *model.SignupPlan) error {
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	plan.Id = 0
+	db := database.GetDB()
+	return db.Create(plan).Error
+}
+
+// UpdatePlan validates and stores the changes of a signup plan.
+func (s *SignupService) UpdatePlan(plan *model.SignupPlan) error {
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	if _, err := s.GetPlan(plan.Id); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Save(plan).Error
+}
+
+// DelPlan deletes a signup plan. Pending requests for it can no longer be approved.
+func (s *SignupService) DelPlan(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.SignupPlan{}, id).Error
+}
+
+func (s *SignupService) checkPlan(plan *model.SignupPlan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return common.NewError("signup plan name is required")
+	}
+	if plan.TotalGB < 0 || plan.ExpiryDays < 0 || plan.LimitIP < 0 {
+		return common.NewError("signup plan limits must not be negative")
+	}
+	inbound, err := s.inboundService.GetInbound(plan.InboundId)
+	if err != nil {
+		return common.NewError("signup plan inbound not found:", plan.InboundId)
+	}
+	switch inbound.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+	default:
+		return common.NewError("signup plans don't support the protocol:", inbound.Protocol)
+	}
+	return nil
+}
+
+// GetRequests returns the signup requests, latest first.
+func (s *SignupService) GetRequests() ([]*model.SignupRequest, error) {
+	db := database.GetDB()
+	var requests []*model.SignupRequest
+	if err := db.Model(model.SignupRequest{}).Order("id desc").Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// GetPendingRequest returns the pending request of a Telegram user, or nil if there is none.
+func (s *SignupService) GetPendingRequest(tgId int64) (*model.SignupRequest, error) {
+	db := database.GetDB()
+	var requests []*model.SignupRequest
+	err := db.Model(model.SignupRequest{}).
+		Where("tg_id = ? AND status IN ?", tgId, []string{SignupPending, signupApproving}).
+		Limit(1).Find(&requests).Error
+	if err != nil || len(requests) == 0 {
+		return nil, err
+	}
+	return requests[0], nil
+}
+
+// DelRequest deletes a signup request, so its user can request an account again.
+func (s *SignupService) DelRequest(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.SignupRequest{}, id).Error
+}
+
+// CreateRequest stores a pending account request of a Telegram user for an enabled plan.
+func (s *SignupService) CreateRequest(tgId int64, username string, firstName string, planId int) (*model.SignupRequest, error) {
+	pending, err := s.GetPendingRequest(tgId)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, common.NewError("signup request already pending")
+	}
+	plan, err := s.GetPlan(planId)
+	if err != nil || !plan.Enable {
+		return nil, common.NewError("signup plan not available:", planId)
+	}
+	request := &model.SignupRequest{
+		TgId:      tgId,
+		Username:  username,
+		FirstName: firstName,
+		PlanId:    plan.Id,
+		Plan:      plan.Name,
+		Status:    SignupPending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	db := database.GetDB()
+	if err := db.Create(request).Error; err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Approve creates the client of a pending request in the inbound of its plan, bound to the
+// Telegram user. It returns the approved request and whether Xray needs a restart.
+func (s *SignupService) Approve(id int) (*model.SignupRequest, bool, error) {
+	request, err := s.claimRequest(id, signupApproving)
+	if err != nil {
+		return nil, false, err
+	}
+	needRestart, err := s.createClient(request)
+	db := database.GetDB()
+	if err != nil {
+		// Give the request back so it can be approved again or denied
+		db.Model(request).Update("status", SignupPending)
+		return nil, false, err
+	}
+	request.Status = SignupApproved
+	request.DecidedAt = time.Now().UnixMilli()
+	err = db.Model(request).Updates(map[string]any{
+		"status":     request.Status,
+		"email":      request.Email,
+		"decided_at": request.DecidedAt,
+	}).Error
+	return request, needRestart, err
+}
+
+// Deny rejects a pending request.
+func (s *SignupService) Deny(id int) (*model.SignupRequest, error) {
+	request, err := s.claimRequest(id, SignupDenied)
+	if err != nil {
+		return nil, err
+	}
+	request.DecidedAt = time.Now().UnixMilli()
+	db := database.GetDB()
+	return request, db.Model(request).Update("decided_at", request.DecidedAt).Error
+}
+
+// claimRequest moves a pending request to the given status. Only one admin can decide a request.
+func (s *SignupService) claimRequest(id int, status string) (*model.SignupRequest, error) {
+	db := database.GetDB()
+	result := db.Model(model.SignupRequest{}).
+		Where("id = ? AND status = ?", id, SignupPending).
+		Update("status", status)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.NewError("signup request is not pending:", id)
+	}
+	request := &model.SignupRequest{}
+	if err := db.First(request, id).Error; err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *SignupService) createClient(request *model.SignupRequest) (bool, error) {
+	plan, err := s.GetPlan(request.PlanId)
+	if err != nil {
+		return false, common.NewError("signup plan not found:", request.PlanId)
+	}
+	inbound, err := s.inboundService.GetInbound(plan.InboundId)
+	if err != nil {
+		return false, err
+	}
+
+	request.Email = signupEmail(request)
+	client := map[string]any{
+		"email":   request.Email,
+		"limitIp": plan.LimitIP,
+		"totalGB": plan.TotalGB,
+		"enable":  true,
+		"tgId":    request.TgId,
+		"subId":   random.Seq(16),
+		"comment": "Telegram signup: " + request.Plan,
+		"reset":   0,
+	}
+	if plan.ExpiryDays > 0 {
+		client["expiryTime"] = time.Now().AddDate(0, 0, plan.ExpiryDays).UnixMilli()
+	} else {
+		client["expiryTime"] = 0
+	}
+	switch inbound.Protocol {
+	case model.VMESS:
+		client["id"] = uuid.NewString()
+		client["security"] = "auto"
+	case model.VLESS:
+		client["id"] = uuid.NewString()
+		client["flow"] = ""
+	case model.Trojan:
+		client["password"] = random.Seq(10)
+	case model.Shadowsocks:
+		var settings struct {
+			Method string `json:"method"`
+		}
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		client["method"] = ""
+		client["password"] = shadowsocksPassword(settings.Method)
+	default:
+		return false, common.NewError("signup plans don't support the protocol:", inbound.Protocol)
+	}
+
+	clientSettings, err := json.Marshal(map[string]any{"clients": []any{client}})
+	if err != nil {
+		return false, err
+	}
+	return s.inboundService.AddInboundClient(&model.Inbound{
+		Id:       inbound.Id,
+		Settings: string(clientSettings),
+	})
+}
+
+// signupEmail derives a unique client email from the Telegram username or ID.
+func signupEmail(request *model.SignupRequest) string {
+	name := signupEmailRegex.ReplaceAllString(strings.ToLower(request.Username), "")
+	if name == "" {
+		name = "tg" + strconv.FormatInt(request.TgId, 10)
+	}
+	return name + "-" + random.Seq(4)
+}
+
+// shadowsocksPassword generates a client password with the key length the Shadowsocks 2022 method requires.
+func shadowsocksPassword(method string) string {
+	size := 32
+	if method == "2022-blake3-aes-128-gcm" {
+		size = 16
+	}
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return random.Seq(32)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
@@ -7,13 +7,16 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"math/big"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +27,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/notify"
 	"github.com/mhsanaei/3x-ui/v2/web/global"
 	"github.com/mhsanaei/3x-ui/v2/web/locale"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -49,6 +53,10 @@ var (
 	messageWorkerPool   chan struct{} // Semaphore for limiting concurrent message processing
 	optimizedHTTPClient *http.Client  // HTTP client with connection pooling and timeouts
 
+	// botServerService samples the server status for all chats, so CPU usage is measured
+	// against the previous sample no matter which chat asked for it
+	botServerService ServerService
+
 	// Simple cache for frequently accessed data
 	statusCache struct {
 		data      *Status
@@ -58,10 +66,14 @@ var (
 
 	serverStatsCache struct {
 		data      string
+		lang      string
 		timestamp time.Time
 		mutex     sync.RWMutex
 	}
 
+	// tgUserLangs caches the languages of Telegram users by ID
+	tgUserLangs sync.Map
+
 	// clients data to adding new client
 	receiver_inbound_ID int
 	client_Id           string
@@ -98,9 +110,15 @@ const (
 type Tgbot struct {
 	inboundService InboundService
 	settingService SettingService
-	serverService  ServerService
 	xrayService    XrayService
 	lastStatus     *Status
+	lang           string // Language messages are rendered in, empty for the bot language setting
+
+	notificationService NotificationService
+	signupService       SignupService
+	voucherService      VoucherService
+	broadcastService    BroadcastService
+	backupService       BackupService
 }
 
 // NewTgbot creates a new Tgbot instance.
@@ -110,7 +128,65 @@ func (t *Tgbot) NewTgbot() *Tgbot {
 
 // I18nBot retrieves a localized message for the bot interface.
 func (t *Tgbot) I18nBot(name string, params ...string) string {
-	return locale.I18n(locale.Bot, name, params...)
+	return locale.I18nBot(t.lang, name, params...)
+}
+
+// withLang returns a copy of the bot that renders messages in the given language.
+func (t *Tgbot) withLang(lang string) *Tgbot {
+	localized := *t
+	localized.lang = lang
+	return &localized
+}
+
+// forUser returns a copy of the bot that talks to the Telegram user in their language. Users new
+// to the bot start with the language of their Telegram app, admins with the bot language setting.
+func (t *Tgbot) forUser(user *telego.User) *Tgbot {
+	if user == nil {
+		return t
+	}
+	lang, ok := getTgUserLang(user.ID)
+	if !ok {
+		if !checkAdmin(user.ID) {
+			lang = locale.MatchBotLanguage(user.LanguageCode)
+		}
+		if err := setTgUserLang(user.ID, lang); err != nil {
+			logger.Warning("Unable to save Telegram user language:", err)
+		}
+	}
+	return t.withLang(lang)
+}
+
+// forChat returns a copy of the bot that talks to the chat in the language stored for it.
+func (t *Tgbot) forChat(chatId int64) *Tgbot {
+	lang, _ := getTgUserLang(chatId)
+	return t.withLang(lang)
+}
+
+// getTgUserLang returns the language of a Telegram user and whether the user is known to the bot.
+func getTgUserLang(tgId int64) (string, bool) {
+	if lang, ok := tgUserLangs.Load(tgId); ok {
+		return lang.(string), true
+	}
+	db := database.GetDB()
+	var users []*model.TgUser
+	if err := db.Model(model.TgUser{}).Where("tg_id = ?", tgId).Limit(1).Find(&users).Error; err != nil || len(users) == 0 {
+		return "", false
+	}
+	tgUserLangs.Store(tgId, users[0].Lang)
+	return users[0].Lang, true
+}
+
+// setTgUserLang stores the language of a Telegram user.
+func setTgUserLang(tgId int64, lang string) error {
+	db := database.GetDB()
+	err := db.Where(model.TgUser{TgId: tgId}).
+		Assign(map[string]any{"lang": lang}).
+		FirstOrCreate(&model.TgUser{}).Error
+	if err != nil {
+		return err
+	}
+	tgUserLangs.Store(tgId, lang)
+	return nil
 }
 
 // GetHashStorage returns the hash storage instance for callback queries.
@@ -129,6 +205,14 @@ func (t *Tgbot) getCachedStatus() (*Status, bool) {
 	return nil, false
 }
 
+// getLastStatus returns the last cached server status, however old it is.
+func (t *Tgbot) getLastStatus() *Status {
+	statusCache.mutex.RLock()
+	defer statusCache.mutex.RUnlock()
+
+	return statusCache.data
+}
+
 // setCachedStatus updates the status cache
 func (t *Tgbot) setCachedStatus(status *Status) {
 	statusCache.mutex.Lock()
@@ -143,7 +227,7 @@ func (t *Tgbot) getCachedServerStats() (string, bool) {
 	serverStatsCache.mutex.RLock()
 	defer serverStatsCache.mutex.RUnlock()
 
-	if serverStatsCache.data != "" && time.Since(serverStatsCache.timestamp) < 10*time.Second {
+	if serverStatsCache.data != "" && serverStatsCache.lang == t.lang && time.Since(serverStatsCache.timestamp) < 10*time.Second {
 		return serverStatsCache.data, true
 	}
 	return "", false
@@ -155,6 +239,7 @@ func (t *Tgbot) setCachedServerStats(stats string) {
 	defer serverStatsCache.mutex.Unlock()
 
 	serverStatsCache.data = stats
+	serverStatsCache.lang = t.lang
 	serverStatsCache.timestamp = time.Now()
 }
 
@@ -230,18 +315,23 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 		return err
 	}
 
-	// After bot initialization, set up bot commands with localized descriptions
-	err = bot.SetMyCommands(context.Background(), &telego.SetMyCommandsParams{
-		Commands: []telego.BotCommand{
-			{Command: "start", Description: t.I18nBot("tgbot.commands.startDesc")},
-			{Command: "help", Description: t.I18nBot("tgbot.commands.helpDesc")},
-			{Command: "status", Description: t.I18nBot("tgbot.commands.statusDesc")},
-			{Command: "id", Description: t.I18nBot("tgbot.commands.idDesc")},
-		},
-	})
-	if err != nil {
+	// After bot initialization, set up bot commands with localized descriptions,
+	// in the bot language by default and in each language for Telegram apps using it
+	if err = t.setMyCommands(nil, ""); err != nil {
 		logger.Warning("Failed to set bot commands:", err)
 	}
+	commandLangs := map[string]bool{}
+	for _, lang := range locale.BotLanguages {
+		// Telegram only passes the language without region, the first translation of a language wins
+		code, _, _ := strings.Cut(lang.Value, "-")
+		if commandLangs[code] {
+			continue
+		}
+		commandLangs[code] = true
+		if err = t.withLang(lang.Value).setMyCommands(nil, code); err != nil {
+			logger.Warning("Failed to set bot commands for", lang.Value+":", err)
+		}
+	}
 
 	// Start receiving Telegram bot messages
 	if !isRunning {
@@ -253,6 +343,23 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 	return nil
 }
 
+// setMyCommands sets the command menu of the bot with descriptions in the bot's language,
+// for a scope and a language code or for everyone.
+func (t *Tgbot) setMyCommands(scope telego.BotCommandScope, languageCode string) error {
+	return bot.SetMyCommands(context.Background(), &telego.SetMyCommandsParams{
+		Commands: []telego.BotCommand{
+			{Command: "start", Description: t.I18nBot("tgbot.commands.startDesc")},
+			{Command: "help", Description: t.I18nBot("tgbot.commands.helpDesc")},
+			{Command: "status", Description: t.I18nBot("tgbot.commands.statusDesc")},
+			{Command: "id", Description: t.I18nBot("tgbot.commands.idDesc")},
+			{Command: "redeem", Description: t.I18nBot("tgbot.commands.redeemDesc")},
+			{Command: "language", Description: t.I18nBot("tgbot.commands.languageDesc")},
+		},
+		Scope:        scope,
+		LanguageCode: languageCode,
+	})
+}
+
 // NewBot creates a new Telegram bot instance with optional proxy and API server settings.
 func (t *Tgbot) NewBot(token string, proxyUrl string, apiServerUrl string) (*telego.Bot, error) {
 	if proxyUrl == "" && apiServerUrl == "" {
@@ -308,6 +415,7 @@ func (t *Tgbot) SetHostname() {
 
 // Stop stops the Telegram bot and cleans up resources.
 func (t *Tgbot) Stop() {
+	t.stopWebhook()
 	if botHandler != nil {
 		botHandler.Stop()
 	}
@@ -342,19 +450,24 @@ func (t *Tgbot) decodeQuery(query string) (string, error) {
 
 // OnReceive starts the message receiving loop for the Telegram bot.
 func (t *Tgbot) OnReceive() {
-	params := telego.GetUpdatesParams{
-		Timeout: 30, // Increased timeout to reduce API calls
+	updates, err := t.getUpdates()
+	if err != nil {
+		logger.Error("Failed to receive Telegram bot updates:", err)
+		return
 	}
 
-	updates, _ := bot.UpdatesViaLongPolling(context.Background(), &params)
-
 	botHandler, _ = th.NewBotHandler(bot, updates)
 
 	botHandler.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		delete(userStates, message.Chat.ID)
+		t := t.forUser(message.From)
 		t.SendMsgToTgbot(message.Chat.ID, t.I18nBot("tgbot.keyboardClosed"), tu.ReplyKeyboardRemove())
 		return nil
-	}, th.TextEqual(t.I18nBot("tgbot.buttons.closeKeyboard")))
+	}, func(ctx context.Context, update telego.Update) bool {
+		// The button is labeled in the language of the user
+		message := update.Message
+		return message != nil && message.Text == t.forUser(message.From).I18nBot("tgbot.buttons.closeKeyboard")
+	})
 
 	botHandler.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		// Use goroutine with worker pool for concurrent command processing
@@ -363,7 +476,7 @@ func (t *Tgbot) OnReceive() {
 			defer func() { <-messageWorkerPool }() // Release worker
 
 			delete(userStates, message.Chat.ID)
-			t.answerCommand(&message, message.Chat.ID, checkAdmin(message.From.ID))
+			t.forUser(message.From).answerCommand(&message, message.Chat.ID, checkAdmin(message.From.ID))
 		}()
 		return nil
 	}, th.AnyCommand())
@@ -375,12 +488,13 @@ func (t *Tgbot) OnReceive() {
 			defer func() { <-messageWorkerPool }() // Release worker
 
 			delete(userStates, query.Message.GetChat().ID)
-			t.answerCallback(&query, checkAdmin(query.From.ID))
+			t.forUser(&query.From).answerCallback(&query, checkAdmin(query.From.ID))
 		}()
 		return nil
 	}, th.AnyCallbackQueryWithMessage())
 
 	botHandler.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		t := t.forUser(message.From)
 		if userState, exists := userStates[message.Chat.ID]; exists {
 			switch userState {
 			case "awaiting_id":
@@ -549,6 +663,8 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		msg += t.I18nBot("tgbot.commands.start", "Firstname=="+message.From.FirstName)
 		if isAdmin {
 			msg += t.I18nBot("tgbot.commands.welcome", "Hostname=="+hostname)
+		} else if t.offerSignup(chatId, message.From.ID) {
+			return
 		}
 		msg += "\n\n" + t.I18nBot("tgbot.commands.pleaseChoose")
 	case "status":
@@ -568,6 +684,27 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		} else {
 			msg += t.I18nBot("tgbot.commands.usage")
 		}
+	case "language":
+		onlyMessage = true
+		t.sendLanguageMenu(chatId)
+	case "redeem":
+		onlyMessage = true
+		if isAdmin && len(commandArgs) == 2 {
+			t.redeemVoucher(chatId, message.From.ID, commandArgs[0], commandArgs[1], true)
+		} else if !isAdmin && len(commandArgs) == 1 {
+			t.offerRedeem(chatId, message.From.ID, commandArgs[0])
+		} else if isAdmin {
+			msg += t.I18nBot("tgbot.commands.redeemAdminUsage")
+		} else {
+			msg += t.I18nBot("tgbot.commands.redeemUsage")
+		}
+	case "broadcast":
+		onlyMessage = true
+		if isAdmin {
+			t.offerBroadcast(chatId, message.Text)
+		} else {
+			handleUnknownCommand()
+		}
 	case "inbound":
 		onlyMessage = true
 		if isAdmin && len(commandArgs) > 0 {
@@ -639,6 +776,11 @@ func (t *Tgbot) randomShadowSocksPassword() string {
 func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool) {
 	chatId := callbackQuery.Message.GetChat().ID
 
+	if lang, ok := strings.CutPrefix(callbackQuery.Data, "set_lang "); ok {
+		t.setLanguage(chatId, callbackQuery, lang)
+		return
+	}
+
 	if isAdmin {
 		// get query from hash storage
 		decodedQuery, err := t.decodeQuery(callbackQuery.Data)
@@ -1468,10 +1610,29 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				}
 
 				t.addClient(callbackQuery.Message.GetChat().ID, message_text)
+			case "signup_approve":
+				requestId, err := strconv.Atoi(dataArray[1])
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+					return
+				}
+				t.approveSignup(chatId, callbackQuery, requestId)
+			case "signup_deny":
+				requestId, err := strconv.Atoi(dataArray[1])
+				if err != nil {
+					t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+					return
+				}
+				t.denySignup(chatId, callbackQuery, requestId)
+			case "broadcast_send":
+				t.sendBroadcast(chatId, callbackQuery, strings.TrimPrefix(decodedQuery, "broadcast_send "))
 			}
 			return
 		} else {
 			switch callbackQuery.Data {
+			case "broadcast_cancel":
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.messages.broadcastCanceled"))
+				t.editMessageTgBot(chatId, callbackQuery.Message.GetMessageID(), t.I18nBot("tgbot.messages.broadcastCanceled"))
 			case "get_inbounds":
 				inbounds, err := t.getInbounds()
 				if err != nil {
@@ -1900,6 +2061,28 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 
 		}
 	default:
+		if query, err := t.decodeQuery(callbackQuery.Data); err == nil {
+			if after, ok := strings.CutPrefix(query, "redeem_voucher "); ok {
+				code, email, _ := strings.Cut(after, " ")
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+				t.deleteMessageTgBot(chatId, callbackQuery.Message.GetMessageID())
+				t.redeemVoucher(chatId, callbackQuery.From.ID, code, email, false)
+				return
+			}
+			if email, ok := strings.CutPrefix(query, "client_renew "); ok {
+				t.requestRenew(chatId, callbackQuery, email)
+				return
+			}
+		}
+		if after, ok := strings.CutPrefix(callbackQuery.Data, "signup_plan "); ok {
+			planId, err := strconv.Atoi(after)
+			if err != nil {
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+				return
+			}
+			t.requestSignup(chatId, callbackQuery, planId)
+			return
+		}
 		if after, ok := strings.CutPrefix(callbackQuery.Data, "client_sub_links "); ok {
 			email := after
 			t.sendClientSubLinks(chatId, email)
@@ -2147,6 +2330,14 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 		return
 	}
 
+	if err := t.sendMessage(chatId, msg, replyMarkup...); err != nil {
+		logger.Warning("Error sending telegram message :", err)
+	}
+}
+
+// sendMessage sends a message in pages of at most 2000 characters and returns the last error.
+func (t *Tgbot) sendMessage(chatId int64, msg string, replyMarkup ...telego.ReplyMarkup) error {
+
 	var allMessages []string
 	limit := 2000
 
@@ -2169,6 +2360,7 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 	} else {
 		allMessages = append(allMessages, msg)
 	}
+	var lastErr error
 	for n, message := range allMessages {
 		params := telego.SendMessageParams{
 			ChatID:    tu.ID(chatId),
@@ -2181,13 +2373,14 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 		}
 		_, err := bot.SendMessage(context.Background(), &params)
 		if err != nil {
-			logger.Warning("Error sending telegram message :", err)
+			lastErr = err
 		}
 		// Reduced delay to improve performance (only needed for rate limiting)
 		if n < len(allMessages)-1 { // Only delay between messages, not after the last one
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
+	return lastErr
 }
 
 // buildSubscriptionURLs builds the HTML sub page URL and JSON subscription URL for a client email
@@ -2454,6 +2647,208 @@ func (t *Tgbot) sendClientQRLinks(chatId int64, email string) {
 	}
 }
 
+// offerSignup shows the signup plans to a Telegram user without clients if self-service signup
+// is enabled. It returns false if there is nothing to offer.
+func (t *Tgbot) offerSignup(chatId int64, tgUserID int64) bool {
+	if enabled, err := t.settingService.GetTgBotSignup(); err != nil || !enabled {
+		return false
+	}
+	traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserID)
+	if err != nil || len(traffics) > 0 {
+		return false
+	}
+	pending, err := t.signupService.GetPendingRequest(tgUserID)
+	if err != nil {
+		return false
+	}
+	if pending != nil {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.signupPending", "Plan=="+html.EscapeString(pending.Plan)))
+		return true
+	}
+	plans, err := t.signupService.GetPlans(true)
+	if err != nil || len(plans) == 0 {
+		return false
+	}
+	var buttons []telego.InlineKeyboardButton
+	for _, plan := range plans {
+		buttons = append(buttons, tu.InlineKeyboardButton(t.signupPlanInfo(plan)).WithCallbackData(t.encodeQuery("signup_plan "+strconv.Itoa(plan.Id))))
+	}
+	keyboard := tu.InlineKeyboardGrid(tu.InlineKeyboardCols(1, buttons...))
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.signupChoosePlan"), keyboard)
+	return true
+}
+
+// signupPlanInfo describes the limits of a signup plan in one line.
+func (t *Tgbot) signupPlanInfo(plan *model.SignupPlan) string {
+	traffic := t.I18nBot("unlimited")
+	if plan.TotalGB > 0 {
+		traffic = common.FormatTraffic(plan.TotalGB)
+	}
+	days := t.I18nBot("unlimited")
+	if plan.ExpiryDays > 0 {
+		days = strconv.Itoa(plan.ExpiryDays) + " " + t.I18nBot("tgbot.days")
+	}
+	return plan.Name + " (" + traffic + ", " + days + ")"
+}
+
+// requestSignup records the account request of a Telegram user and asks the admins to decide on it.
+func (t *Tgbot) requestSignup(chatId int64, callbackQuery *telego.CallbackQuery, planId int) {
+	if enabled, err := t.settingService.GetTgBotSignup(); err != nil || !enabled {
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	from := callbackQuery.From
+	request, err := t.signupService.CreateRequest(from.ID, from.Username, from.FirstName, planId)
+	if err != nil {
+		logger.Warning("Unable to create signup request:", err)
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+	t.deleteMessageTgBot(chatId, callbackQuery.Message.GetMessageID())
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.signupRequested", "Plan=="+html.EscapeString(request.Plan)))
+
+	username := "-"
+	if request.Username != "" {
+		username = "@" + request.Username
+	}
+	for _, adminId := range adminIds {
+		admin := t.forChat(adminId)
+		keyboard := tu.InlineKeyboard(
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(admin.I18nBot("tgbot.buttons.approve")).WithCallbackData(t.encodeQuery("signup_approve "+strconv.Itoa(request.Id))),
+				tu.InlineKeyboardButton(admin.I18nBot("tgbot.buttons.deny")).WithCallbackData(t.encodeQuery("signup_deny "+strconv.Itoa(request.Id))),
+			),
+		)
+		admin.SendMsgToTgbot(adminId, admin.I18nBot("tgbot.messages.signupNewRequest",
+			"Name=="+html.EscapeString(request.FirstName),
+			"Username=="+username,
+			"TgUserID=="+strconv.FormatInt(request.TgId, 10),
+			"Plan=="+html.EscapeString(request.Plan)), keyboard)
+	}
+}
+
+// approveSignup creates the client of a signup request and sends its subscription links to the user.
+func (t *Tgbot) approveSignup(chatId int64, callbackQuery *telego.CallbackQuery, requestId int) {
+	request, needRestart, err := t.signupService.Approve(requestId)
+	if err != nil {
+		logger.Warning("Unable to approve signup request:", err)
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.errorOperation")+"\r\n"+err.Error())
+		return
+	}
+	if needRestart {
+		t.xrayService.SetToNeedRestart()
+	}
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+	t.editMessageTgBot(chatId, callbackQuery.Message.GetMessageID(), t.I18nBot("tgbot.messages.signupApprovedAdmin",
+		"Name=="+html.EscapeString(request.FirstName),
+		"Plan=="+html.EscapeString(request.Plan),
+		"Email=="+request.Email))
+	user := t.forChat(request.TgId)
+	user.SendMsgToTgbot(request.TgId, user.I18nBot("tgbot.messages.signupApproved", "Plan=="+html.EscapeString(request.Plan), "Email=="+request.Email))
+	user.sendClientSubLinks(request.TgId, request.Email)
+}
+
+// denySignup rejects a signup request and lets the user know.
+func (t *Tgbot) denySignup(chatId int64, callbackQuery *telego.CallbackQuery, requestId int) {
+	request, err := t.signupService.Deny(requestId)
+	if err != nil {
+		logger.Warning("Unable to deny signup request:", err)
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.successfulOperation"))
+	t.editMessageTgBot(chatId, callbackQuery.Message.GetMessageID(), t.I18nBot("tgbot.messages.signupDeniedAdmin",
+		"Name=="+html.EscapeString(request.FirstName),
+		"Plan=="+html.EscapeString(request.Plan)))
+	user := t.forChat(request.TgId)
+	user.SendMsgToTgbot(request.TgId, user.I18nBot("tgbot.messages.signupDenied", "Plan=="+html.EscapeString(request.Plan)))
+}
+
+// offerRedeem redeems a voucher for the only client of a Telegram user, or lets the user choose the client.
+func (t *Tgbot) offerRedeem(chatId int64, tgUserID int64, code string) {
+	traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserID)
+	if err != nil {
+		logger.Warning(err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.wentWrong"))
+		return
+	}
+	switch len(traffics) {
+	case 0:
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.askToAddUserId", "TgUserID=="+strconv.FormatInt(tgUserID, 10)))
+	case 1:
+		t.redeemVoucher(chatId, tgUserID, code, traffics[0].Email, false)
+	default:
+		code = NormalizeVoucherCode(code)
+		var buttons []telego.InlineKeyboardButton
+		for _, traffic := range traffics {
+			buttons = append(buttons, tu.InlineKeyboardButton(traffic.Email).WithCallbackData(t.encodeQuery("redeem_voucher "+code+" "+traffic.Email)))
+		}
+		keyboard := tu.InlineKeyboardGrid(tu.InlineKeyboardCols(1, buttons...))
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.voucherChooseClient"), keyboard)
+	}
+}
+
+// redeemVoucher applies a voucher to a client and shows the updated usage. Telegram users
+// can only redeem vouchers for their own clients.
+func (t *Tgbot) redeemVoucher(chatId int64, tgUserID int64, code string, email string, isAdmin bool) {
+	if !isAdmin {
+		traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserID)
+		if err != nil || !slices.ContainsFunc(traffics, func(traffic *xray.ClientTraffic) bool { return traffic.Email == email }) {
+			t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.noResult"))
+			return
+		}
+	}
+	redemption, needRestart, err := t.voucherService.Redeem(code, email, VoucherSourceTgbot)
+	if err != nil {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.voucherFailed", "Error=="+html.EscapeString(err.Error())))
+		return
+	}
+	if needRestart {
+		t.xrayService.SetToNeedRestart()
+	}
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.voucherRedeemed", "Code=="+redemption.Code, "Email=="+html.EscapeString(email)))
+	if isAdmin {
+		t.searchClient(chatId, email)
+	} else {
+		t.getClientUsage(chatId, tgUserID, email)
+	}
+}
+
+// sendLanguageMenu lets the user choose the language the bot talks in.
+func (t *Tgbot) sendLanguageMenu(chatId int64) {
+	var buttons []telego.InlineKeyboardButton
+	for _, lang := range locale.BotLanguages {
+		text := lang.Icon + " " + lang.Name
+		if lang.Value == t.lang {
+			text = "✅ " + text
+		}
+		buttons = append(buttons, tu.InlineKeyboardButton(text).WithCallbackData("set_lang "+lang.Value))
+	}
+	keyboard := tu.InlineKeyboardGrid(tu.InlineKeyboardCols(2, buttons...))
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.chooseLanguage"), keyboard)
+}
+
+// setLanguage stores the language the user chose and switches their command menu to it.
+func (t *Tgbot) setLanguage(chatId int64, callbackQuery *telego.CallbackQuery, lang string) {
+	if !locale.IsBotLanguage(lang) {
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	if err := setTgUserLang(callbackQuery.From.ID, lang); err != nil {
+		logger.Warning("Unable to save Telegram user language:", err)
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	localized := t.withLang(lang)
+	if err := localized.setMyCommands(tu.ScopeChat(tu.ID(chatId)), ""); err != nil {
+		logger.Warning("Failed to set bot commands for chat:", err)
+	}
+	localized.sendCallbackAnswerTgBot(callbackQuery.ID, localized.I18nBot("tgbot.answers.successfulOperation"))
+	localized.editMessageTgBot(chatId, callbackQuery.Message.GetMessageID(), localized.I18nBot("tgbot.messages.languageChanged"))
+}
+
 // SendMsgToTgbotAdmins sends a message to all admin Telegram chats.
 func (t *Tgbot) SendMsgToTgbotAdmins(msg string, replyMarkup ...telego.ReplyMarkup) {
 	if len(replyMarkup) > 0 {
@@ -2467,21 +2862,23 @@ func (t *Tgbot) SendMsgToTgbotAdmins(msg string, replyMarkup ...telego.ReplyMark
 	}
 }
 
-// SendReport sends a periodic report to admin chats.
+// SendReport sends a periodic report to the notification channels.
 func (t *Tgbot) SendReport() {
-	runTime, err := t.settingService.GetTgbotRuntime()
-	if err == nil && len(runTime) > 0 {
-		msg := ""
-		msg += t.I18nBot("tgbot.messages.report", "RunTime=="+runTime)
-		msg += t.I18nBot("tgbot.messages.datetime", "DateTime=="+time.Now().Format("2006-01-02 15:04:05"))
-		t.SendMsgToTgbotAdmins(msg)
+	if t.notificationService.HasChannelFor(NotifyEventReport) {
+		runTime, err := t.settingService.GetTgbotRuntime()
+		t.NotifyLocalized(NotifyEventReport, func(t *Tgbot) string {
+			msg := ""
+			if err == nil && len(runTime) > 0 {
+				msg += t.I18nBot("tgbot.messages.report", "RunTime=="+runTime)
+				msg += t.I18nBot("tgbot.messages.datetime", "DateTime=="+time.Now().Format("2006-01-02 15:04:05"))
+				msg += "\r\n"
+			}
+			msg += t.sendServerUsage()
+			return msg
+		})
 	}
 
-	info := t.sendServerUsage()
-	t.SendMsgToTgbotAdmins(info)
-
 	t.sendExhaustedToAdmins()
-	t.notifyExhausted()
 
 	backupEnable, err := t.settingService.GetTgBotBackup()
 	if err == nil && backupEnable {
@@ -2489,24 +2886,84 @@ func (t *Tgbot) SendReport() {
 	}
 }
 
-// SendBackupToAdmins sends a database backup to admin chats.
+// SendBackupToAdmins sends a database backup to the notification channels.
 func (t *Tgbot) SendBackupToAdmins() {
-	if !t.IsRunning() {
+	if !t.notificationService.HasChannelFor(NotifyEventBackup) {
 		return
 	}
-	for _, adminId := range adminIds {
-		t.sendBackup(int64(adminId))
+	attachments := t.backupAttachments()
+	if len(attachments) == 0 {
+		return
+	}
+	backupTime := time.Now().Format("2006-01-02 15:04:05")
+	t.NotifyLocalized(NotifyEventBackup, func(t *Tgbot) string {
+		return t.I18nBot("tgbot.messages.backupTime", "Time=="+backupTime)
+	}, attachments...)
+}
+
+// backupAttachments returns the database and the Xray configuration, encrypted if backup
+// encryption is enabled. Files that can't be read or encrypted are left out.
+func (t *Tgbot) backupAttachments() []notify.Attachment {
+	var attachments []notify.Attachment
+	for _, path := range []string{config.GetDBPath(), xray.GetConfigPath()} {
+		var data []byte
+		var err error
+		if path == config.GetDBPath() {
+			data, err = readDB()
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			logger.Error("Error in reading file for backup: ", err)
+			continue
+		}
+		name, data, err := t.backupService.Seal(filepath.Base(path), data)
+		if err != nil {
+			logger.Error("Error in encrypting file for backup: ", err)
+			continue
+		}
+		attachments = append(attachments, notify.Attachment{Name: name, Data: data})
 	}
+	return attachments
 }
 
-// sendExhaustedToAdmins sends notifications about exhausted clients to admins.
+// sendExhaustedToAdmins sends notifications about exhausted clients to the notification channels.
 func (t *Tgbot) sendExhaustedToAdmins() {
-	if !t.IsRunning() {
+	if !t.notificationService.HasChannelFor(NotifyEventExhausted) {
 		return
 	}
-	for _, adminId := range adminIds {
-		t.getExhausted(int64(adminId))
+	t.notifyLocalized(NotifyEventExhausted, func(t *Tgbot) (string, telego.ReplyMarkup) {
+		return t.prepareExhaustedInfo()
+	})
+}
+
+// NotifyLocalized sends a notification rendered in the bot language to the channels of the event.
+// Telegram chats that talk to the bot in another language get it rendered in theirs.
+func (t *Tgbot) NotifyLocalized(event string, render func(t *Tgbot) string, attachments ...notify.Attachment) {
+	t.notifyLocalized(event, func(t *Tgbot) (string, telego.ReplyMarkup) {
+		return render(t), nil
+	}, attachments...)
+}
+
+// notifyLocalized is NotifyLocalized for messages with a keyboard.
+func (t *Tgbot) notifyLocalized(event string, render func(t *Tgbot) (string, telego.ReplyMarkup), attachments ...notify.Attachment) {
+	text, keyboard := render(t)
+	msg := notify.Message{
+		Event:       event,
+		Text:        text,
+		Attachments: attachments,
+		Localize: func(lang string) (string, any) {
+			text, keyboard := render(t.withLang(lang))
+			if keyboard == nil {
+				return text, nil
+			}
+			return text, keyboard
+		},
 	}
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	t.notificationService.Send(msg)
 }
 
 // getServerUsage retrieves and formats server usage information.
@@ -2544,7 +3001,7 @@ func (t *Tgbot) prepareServerUsageInfo() string {
 	if cachedStatus, found := t.getCachedStatus(); found {
 		t.lastStatus = cachedStatus
 	} else {
-		t.lastStatus = t.serverService.GetStatus(t.lastStatus)
+		t.lastStatus = botServerService.GetStatus(t.getLastStatus())
 		t.setCachedStatus(t.lastStatus)
 	}
 	onlines := p.GetOnlineClients()
@@ -2595,12 +3052,8 @@ func (t *Tgbot) prepareServerUsageInfo() string {
 	return info
 }
 
-// UserLoginNotify sends a notification about user login attempts to admins.
+// UserLoginNotify sends a notification about user login attempts to the notification channels.
 func (t *Tgbot) UserLoginNotify(username string, password string, ip string, time string, status LoginStatus) {
-	if !t.IsRunning() {
-		return
-	}
-
 	if username == "" || ip == "" || time == "" {
 		logger.Warning("UserLoginNotify failed, invalid info!")
 		return
@@ -2611,20 +3064,22 @@ func (t *Tgbot) UserLoginNotify(username string, password string, ip string, tim
 		return
 	}
 
-	msg := ""
-	switch status {
-	case LoginSuccess:
-		msg += t.I18nBot("tgbot.messages.loginSuccess")
-		msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
-	case LoginFail:
-		msg += t.I18nBot("tgbot.messages.loginFailed")
-		msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
-		msg += t.I18nBot("tgbot.messages.password", "Password=="+password)
-	}
-	msg += t.I18nBot("tgbot.messages.username", "Username=="+username)
-	msg += t.I18nBot("tgbot.messages.ip", "IP=="+ip)
-	msg += t.I18nBot("tgbot.messages.time", "Time=="+time)
-	t.SendMsgToTgbotAdmins(msg)
+	t.NotifyLocalized(NotifyEventLogin, func(t *Tgbot) string {
+		msg := ""
+		switch status {
+		case LoginSuccess:
+			msg += t.I18nBot("tgbot.messages.loginSuccess")
+			msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
+		case LoginFail:
+			msg += t.I18nBot("tgbot.messages.loginFailed")
+			msg += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
+			msg += t.I18nBot("tgbot.messages.password", "Password=="+password)
+		}
+		msg += t.I18nBot("tgbot.messages.username", "Username=="+username)
+		msg += t.I18nBot("tgbot.messages.ip", "IP=="+ip)
+		msg += t.I18nBot("tgbot.messages.time", "Time=="+time)
+		return msg
+	})
 }
 
 // getInboundUsages retrieves and formats inbound usage information.
@@ -2983,9 +3438,14 @@ func (t *Tgbot) getClientUsage(chatId int64, tgUserID int64, email ...string) {
 
 // searchClientIps searches and sends client IP addresses for the given email.
 func (t *Tgbot) searchClientIps(chatId int64, email string, messageID ...int) {
-	ips, err := t.inboundService.GetInboundClientIps(email)
-	if err != nil || len(ips) == 0 {
-		ips = t.I18nBot("tgbot.noIpRecord")
+	ips := t.I18nBot("tgbot.noIpRecord")
+	infos, err := t.inboundService.GetClientIpInfos(email)
+	if err == nil && len(infos) > 0 {
+		lines := make([]string, 0, len(infos))
+		for _, info := range infos {
+			lines = append(lines, info.String())
+		}
+		ips = strings.Join(lines, "\r\n")
 	}
 
 	output := ""
@@ -3245,6 +3705,17 @@ func (t *Tgbot) searchInbound(chatId int64, remark string) {
 
 // getExhausted retrieves and sends information about exhausted clients.
 func (t *Tgbot) getExhausted(chatId int64) {
+	output, keyboard := t.prepareExhaustedInfo()
+	if keyboard != nil {
+		t.SendMsgToTgbot(chatId, output, keyboard)
+	} else {
+		t.SendMsgToTgbot(chatId, output)
+	}
+}
+
+// prepareExhaustedInfo prepares the information about exhausted inbounds and clients,
+// with a keyboard to look up the exhausted clients if there are any.
+func (t *Tgbot) prepareExhaustedInfo() (string, telego.ReplyMarkup) {
 	trDiff := int64(0)
 	exDiff := int64(0)
 	now := time.Now().Unix() * 1000
@@ -3333,93 +3804,10 @@ func (t *Tgbot) getExhausted(chatId int64) {
 		}
 		output += t.I18nBot("tgbot.messages.refreshedOn", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
 		keyboard := tu.InlineKeyboardGrid(tu.InlineKeyboardCols(cols, buttons...))
-		t.SendMsgToTgbot(chatId, output, keyboard)
-	} else {
-		output += t.I18nBot("tgbot.messages.refreshedOn", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
-		t.SendMsgToTgbot(chatId, output)
-	}
-}
-
-// notifyExhausted sends notifications for exhausted clients.
-func (t *Tgbot) notifyExhausted() {
-	trDiff := int64(0)
-	exDiff := int64(0)
-	now := time.Now().Unix() * 1000
-
-	TrafficThreshold, err := t.settingService.GetTrafficDiff()
-	if err == nil && TrafficThreshold > 0 {
-		trDiff = int64(TrafficThreshold) * 1073741824
-	}
-	ExpireThreshold, err := t.settingService.GetExpireDiff()
-	if err == nil && ExpireThreshold > 0 {
-		exDiff = int64(ExpireThreshold) * 86400000
-	}
-	inbounds, err := t.inboundService.GetAllInbounds()
-	if err != nil {
-		logger.Warning("Unable to load Inbounds", err)
-	}
-
-	var chatIDsDone []int64
-	for _, inbound := range inbounds {
-		if inbound.Enable {
-			if len(inbound.ClientStats) > 0 {
-				clients, err := t.inboundService.GetClients(inbound)
-				if err == nil {
-					for _, client := range clients {
-						if client.TgID != 0 {
-							chatID := client.TgID
-							if !int64Contains(chatIDsDone, chatID) && !checkAdmin(chatID) {
-								var disabledClients []xray.ClientTraffic
-								var exhaustedClients []xray.ClientTraffic
-								traffics, err := t.inboundService.GetClientTrafficTgBot(client.TgID)
-								if err == nil && len(traffics) > 0 {
-									output := t.I18nBot("tgbot.messages.exhaustedCount", "Type=="+t.I18nBot("tgbot.clients"))
-									for _, traffic := range traffics {
-										if traffic.Enable {
-											if (traffic.ExpiryTime > 0 && (traffic.ExpiryTime-now < exDiff)) ||
-												(traffic.Total > 0 && (traffic.Total-(traffic.Up+traffic.Down) < trDiff)) {
-												exhaustedClients = append(exhaustedClients, *traffic)
-											}
-										} else {
-											disabledClients = append(disabledClients, *traffic)
-										}
-									}
-									if len(exhaustedClients) > 0 {
-										output += t.I18nBot("tgbot.messages.disabled", "Disabled=="+strconv.Itoa(len(disabledClients)))
-										if len(disabledClients) > 0 {
-											output += t.I18nBot("tgbot.clients") + ":\r\n"
-											for _, traffic := range disabledClients {
-												output += " " + traffic.Email
-											}
-											output += "\r\n"
-										}
-										output += "\r\n"
-										output += t.I18nBot("tgbot.messages.depleteSoon", "Deplete=="+strconv.Itoa(len(exhaustedClients)))
-										for _, traffic := range exhaustedClients {
-											output += t.clientInfoMsg(&traffic, true, false, false, true, true, false)
-											output += "\r\n"
-										}
-										t.SendMsgToTgbot(chatID, output)
-									}
-									chatIDsDone = append(chatIDsDone, chatID)
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-}
-
-// int64Contains checks if an int64 slice contains a specific item.
-func int64Contains(slice []int64, item int64) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+		return output, keyboard
 	}
-	return false
+	output += t.I18nBot("tgbot.messages.refreshedOn", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
+	return output, nil
 }
 
 // onlineClients retrieves and sends information about online clients.
@@ -3462,38 +3850,15 @@ func (t *Tgbot) sendBackup(chatId int64) {
 	output := t.I18nBot("tgbot.messages.backupTime", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
 	t.SendMsgToTgbot(chatId, output)
 
-	// Update by manually trigger a checkpoint operation
-	err := database.Checkpoint()
-	if err != nil {
-		logger.Error("Error in trigger a checkpoint operation: ", err)
-	}
-
-	file, err := os.Open(config.GetDBPath())
-	if err == nil {
+	for _, attachment := range t.backupAttachments() {
 		document := tu.Document(
 			tu.ID(chatId),
-			tu.File(file),
+			tu.FileFromBytes(attachment.Data, attachment.Name),
 		)
-		_, err = bot.SendDocument(context.Background(), document)
+		_, err := bot.SendDocument(context.Background(), document)
 		if err != nil {
 			logger.Error("Error in uploading backup: ", err)
 		}
-	} else {
-		logger.Error("Error in opening db file for backup: ", err)
-	}
-
-	file, err = os.Open(xray.GetConfigPath())
-	if err == nil {
-		document := tu.Document(
-			tu.ID(chatId),
-			tu.File(file),
-		)
-		_, err = bot.SendDocument(context.Background(), document)
-		if err != nil {
-			logger.Error("Error in uploading config.json: ", err)
-		}
-	} else {
-		logger.Error("Error in opening config.json file for backup: ", err)
 	}
 }
 
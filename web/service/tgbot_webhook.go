@@ -0,0 +1,233 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Servers the bot webhook can be served on.
+const (
+	TgWebhookPanel = "panel"
+	TgWebhookSub   = "sub"
+)
+
+// TgWebhookPath is the path the webhook is served at, below the base path of the panel or
+// the root of the subscription server. It is followed by the secret path of the webhook.
+const TgWebhookPath = "tgbot/"
+
+// webhook is the endpoint Telegram posts updates to while the bot runs in webhook mode.
+var webhook struct {
+	sync.RWMutex
+	server  string // Server the webhook is served on
+	path    string // Secret last element of the webhook path
+	token   string // Secret token Telegram sends in a header
+	handler telego.WebhookHandler
+	cancel  context.CancelFunc
+}
+
+// getUpdates receives the bot updates by webhook if it is enabled, or by long polling.
+// If the webhook can't be set, it falls back to long polling.
+func (t *Tgbot) getUpdates() (<-chan telego.Update, error) {
+	if enabled, err := t.settingService.GetTgBotWebhook(); err == nil && enabled {
+		updates, err := t.startWebhook()
+		if err == nil {
+			return updates, nil
+		}
+		logger.Warning("Failed to set Telegram bot webhook, using long polling:", err)
+	}
+	// A webhook left from an earlier run keeps Telegram from handing out updates by polling
+	if err := bot.DeleteWebhook(context.Background(), &telego.DeleteWebhookParams{}); err != nil {
+		logger.Warning("Failed to delete Telegram bot webhook:", err)
+	}
+	params := telego.GetUpdatesParams{
+		Timeout: 30, // Increased timeout to reduce API calls
+	}
+	return bot.UpdatesViaLongPolling(context.Background(), &params)
+}
+
+// startWebhook registers a webhook with a secret path and token on the panel or subscription
+// server and returns the updates Telegram posts to it.
+func (t *Tgbot) startWebhook() (<-chan telego.Update, error) {
+	server, err := t.settingService.GetTgBotWebhookServer()
+	if err != nil {
+		return nil, err
+	}
+	baseUrl, certFile, err := t.webhookBaseUrl(server)
+	if err != nil {
+		return nil, err
+	}
+	secretPath := random.Seq(32)
+	secretToken := random.Seq(32)
+	params := &telego.SetWebhookParams{
+		URL:            baseUrl + TgWebhookPath + secretPath,
+		SecretToken:    secretToken,
+		AllowedUpdates: []string{"message", "callback_query"},
+	}
+	// Telegram only needs the certificate if it can't verify it on its own
+	if certFile != "" {
+		certificate, err := selfSignedCert(certFile)
+		if err != nil {
+			return nil, err
+		}
+		if certificate != nil {
+			file := tu.FileFromBytes(certificate, "cert.pem")
+			params.Certificate = &file
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := bot.UpdatesViaWebhook(ctx, func(handler telego.WebhookHandler) error {
+		webhook.Lock()
+		defer webhook.Unlock()
+		webhook.server = server
+		webhook.path = secretPath
+		webhook.token = secretToken
+		webhook.handler = handler
+		webhook.cancel = cancel
+		return nil
+	}, telego.WithWebhookSet(context.Background(), params))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	logger.Info("Telegram bot receives updates by webhook on the", server, "server")
+	return updates, nil
+}
+
+// webhookBaseUrl returns the public URL of the server the webhook is served on, ending with a
+// slash, and the certificate file of the server if the URL is derived from its settings.
+func (t *Tgbot) webhookBaseUrl(server string) (string, string, error) {
+	if enabled, err := t.settingService.GetSubEnable(); server == TgWebhookSub && (err != nil || !enabled) {
+		return "", "", common.NewError("subscription server is disabled")
+	}
+	baseUrl, err := t.settingService.GetTgBotWebhookUrl()
+	if err != nil {
+		return "", "", err
+	}
+	if baseUrl != "" {
+		return strings.TrimSuffix(baseUrl, "/") + "/", "", nil
+	}
+
+	var domain, certFile, basePath string
+	var port int
+	switch server {
+	case TgWebhookPanel:
+		domain, _ = t.settingService.GetWebDomain()
+		certFile, _ = t.settingService.GetCertFile()
+		port, err = t.settingService.GetPort()
+		if err == nil {
+			basePath, err = t.settingService.GetBasePath()
+		}
+	case TgWebhookSub:
+		domain, _ = t.settingService.GetSubDomain()
+		certFile, _ = t.settingService.GetSubCertFile()
+		port, err = t.settingService.GetSubPort()
+		basePath = "/"
+	default:
+		return "", "", common.NewError("unknown Telegram bot webhook server:", server)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if domain == "" || certFile == "" {
+		return "", "", common.NewError("the webhook needs the domain and TLS certificate of the", server, "server, or its public URL")
+	}
+	return "https://" + net.JoinHostPort(domain, strconv.Itoa(port)) + basePath, certFile, nil
+}
+
+// selfSignedCert returns the PEM encoded certificate of a certificate file if it is self-signed,
+// or nil if it is issued by an authority Telegram can verify.
+func selfSignedCert(certFile string) ([]byte, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, common.NewError("no certificate found in", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) || cert.CheckSignatureFrom(cert) != nil {
+		return nil, nil
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// stopWebhook stops receiving updates by webhook and removes the webhook from Telegram.
+func (t *Tgbot) stopWebhook() {
+	webhook.RLock()
+	active := webhook.handler != nil
+	webhook.RUnlock()
+	if !active {
+		return
+	}
+	clearWebhook()
+	if err := bot.DeleteWebhook(context.Background(), &telego.DeleteWebhookParams{}); err != nil {
+		logger.Warning("Failed to delete Telegram bot webhook:", err)
+	}
+}
+
+func clearWebhook() {
+	webhook.Lock()
+	defer webhook.Unlock()
+	if webhook.cancel != nil {
+		webhook.cancel()
+	}
+	webhook.server = ""
+	webhook.path = ""
+	webhook.token = ""
+	webhook.handler = nil
+	webhook.cancel = nil
+}
+
+// WebhookHandler returns the handler of the webhook on a server. The last element of the
+// request path has to be the secret path of the webhook, and the request has to carry its
+// secret token.
+func (t *Tgbot) WebhookHandler(server string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the lock while passing the update on, so the webhook isn't stopped meanwhile
+		webhook.RLock()
+		defer webhook.RUnlock()
+		if webhook.handler == nil || webhook.server != server ||
+			subtle.ConstantTimeCompare([]byte(path.Base(r.URL.Path)), []byte(webhook.path)) != 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(telego.WebhookSecretTokenHeader)), []byte(webhook.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Updates are handled after the request is answered
+		if err := webhook.handler(context.WithoutCancel(r.Context()), data); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
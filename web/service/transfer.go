@@ -0,0 +1,560 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// ExportVersion is the version of the export format. Exports of newer versions are refused.
+const ExportVersion = 1
+
+// How an import treats inbounds that already exist.
+const (
+	ImportReplace = "replace" // The existing inbound is replaced by the imported one
+	ImportMerge   = "merge"   // The missing clients are added to the existing inbound
+	ImportSkip    = "skip"    // The existing inbound is left as it is
+)
+
+// What an import does with an inbound.
+const (
+	ImportActionAdd      = "add"
+	ImportActionReplace  = "replace"
+	ImportActionMerge    = "merge"
+	ImportActionSkip     = "skip"
+	ImportActionConflict = "conflict"
+)
+
+// hostSettings are the settings bound to the server itself, like its addresses and certificate
+// files. They are left out of exports and imports, so an import keeps the panel reachable.
+var hostSettings = []string{
+	"webListen", "webDomain", "webPort", "webCertFile", "webKeyFile", "webBasePath",
+	"subListen", "subPort", "subDomain", "subCertFile", "subKeyFile",
+}
+
+// securitySettings guard the access to the panel or hold secrets. They are left out of exports
+// and imports too, so an import can't lock the admin out or change the keys of future backups,
+// and exports don't carry secrets in plain text.
+var securitySettings = []string{
+	"panelAllowedIps", "trustedProxies", "twoFactorEnable", "twoFactorToken",
+	"backupEncryption", "backupPassphrase", "backupRecipients", "backupS3AccessKey", "backupS3SecretKey",
+	"tgBotToken", "ldapPassword",
+}
+
+// Export is a portable copy of inbounds with their clients and traffic, and optionally of the
+// panel settings and the Xray template, that can be imported on another server.
+type Export struct {
+	Version      int              `json:"version"`
+	AppVersion   string           `json:"appVersion"`
+	ExportedAt   int64            `json:"exportedAt"`
+	Inbounds     []*model.Inbound `json:"inbounds"`
+	Settings     json.RawMessage  `json:"settings,omitempty"`
+	XrayTemplate json.RawMessage  `json:"xrayTemplate,omitempty"`
+}
+
+// ImportOptions select what an import applies and how.
+type ImportOptions struct {
+	Mode         string `json:"mode" form:"mode"`                 // replace, merge or skip
+	DryRun       bool   `json:"dryRun" form:"dryRun"`             // Only report what would change
+	Settings     bool   `json:"settings" form:"settings"`         // Import the panel settings
+	XrayTemplate bool   `json:"xrayTemplate" form:"xrayTemplate"` // Import the Xray template
+}
+
+// ImportReport describes what an import changed, or would change in a dry run.
+type ImportReport struct {
+	DryRun       bool                 `json:"dryRun"`
+	Inbounds     []*ImportInboundPlan `json:"inbounds"`
+	Settings     []string             `json:"settings"`     // Keys of the settings that change
+	XrayTemplate bool                 `json:"xrayTemplate"` // Whether the Xray template changes
+}
+
+// ImportInboundPlan is what an import does with one inbound.
+type ImportInboundPlan struct {
+	Remark         string         `json:"remark"`
+	Tag            string         `json:"tag"`
+	Port           int            `json:"port"`
+	Protocol       model.Protocol `json:"protocol"`
+	Action         string         `json:"action"`
+	TargetId       int            `json:"targetId"`       // Existing inbound that is replaced, merged into or skipped
+	Clients        int            `json:"clients"`        // Clients that are added
+	ClientsSkipped int            `json:"clientsSkipped"` // Clients that already exist and are kept
+	Conflicts      []string       `json:"conflicts"`
+	Error          string         `json:"error"`
+
+	inbound *model.Inbound
+	added   []string // Emails of the added clients
+}
+
+// TransferService exports inbounds and settings to a portable JSON file and imports them.
+type TransferService struct {
+	inboundService     InboundService
+	settingService     SettingService
+	xraySettingService XraySettingService
+}
+
+// Export returns the export of the inbounds with the given IDs, all of them if none are given.
+func (s *TransferService) Export(inboundIds []int, withSettings, withXrayTemplate bool) (*Export, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	export := &Export{
+		Version:    ExportVersion,
+		AppVersion: config.GetVersion(),
+		ExportedAt: time.Now().UnixMilli(),
+		Inbounds:   []*model.Inbound{},
+	}
+	for _, inbound := range inbounds {
+		if len(inboundIds) == 0 || slices.Contains(inboundIds, inbound.Id) {
+			export.Inbounds = append(export.Inbounds, inbound)
+		}
+	}
+	if withSettings {
+		allSetting, err := s.settingService.GetAllSetting()
+		if err != nil {
+			return nil, err
+		}
+		settings, err := settingsMap(allSetting)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range slices.Concat(hostSettings, securitySettings) {
+			delete(settings, key)
+		}
+		if export.Settings, err = json.Marshal(settings); err != nil {
+			return nil, err
+		}
+	}
+	if withXrayTemplate {
+		template, err := s.settingService.GetXrayConfigTemplate()
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid([]byte(template)) {
+			return nil, common.NewError("xray template is not valid JSON")
+		}
+		export.XrayTemplate = json.RawMessage(template)
+	}
+	return export, nil
+}
+
+// Import applies an export, or only reports what it would change in a dry run. Imported inbounds
+// belong to the given user. It also returns whether Xray needs a restart.
+func (s *TransferService) Import(data []byte, userId int, options ImportOptions) (*ImportReport, bool, error) {
+	export := &Export{}
+	if err := json.Unmarshal(data, export); err != nil {
+		return nil, false, common.NewError("export file is not valid:", err)
+	}
+	if export.Version <= 0 || export.Version > ExportVersion {
+		return nil, false, common.NewErrorf("export version %d is not supported", export.Version)
+	}
+	switch options.Mode {
+	case ImportReplace, ImportMerge, ImportSkip:
+	default:
+		return nil, false, common.NewError("import mode is not valid:", options.Mode)
+	}
+
+	report := &ImportReport{DryRun: options.DryRun, Settings: []string{}}
+	var err error
+	if report.Inbounds, err = s.planInbounds(export.Inbounds, options.Mode); err != nil {
+		return nil, false, err
+	}
+
+	var newSetting *entity.AllSetting
+	if options.Settings && len(export.Settings) > 0 {
+		if newSetting, report.Settings, err = s.mergeSettings(export.Settings); err != nil {
+			return nil, false, err
+		}
+	}
+	var template string
+	if options.XrayTemplate && len(export.XrayTemplate) > 0 {
+		template = string(export.XrayTemplate)
+		if err := s.xraySettingService.CheckXrayConfig(template); err != nil {
+			return nil, false, err
+		}
+		current, err := s.settingService.GetXrayConfigTemplate()
+		if err != nil {
+			return nil, false, err
+		}
+		report.XrayTemplate = !sameJson(current, template)
+	}
+	if options.DryRun {
+		return report, false, nil
+	}
+
+	if newSetting != nil && len(report.Settings) > 0 {
+		if err := s.settingService.UpdateAllSetting(newSetting); err != nil {
+			return nil, false, err
+		}
+	}
+	needRestart := false
+	if report.XrayTemplate {
+		if err := s.xraySettingService.SaveXraySetting(template); err != nil {
+			return nil, false, err
+		}
+		needRestart = true
+	}
+	for _, plan := range report.Inbounds {
+		restart, err := s.applyInbound(plan, userId)
+		if err != nil {
+			logger.Warningf("Unable to import inbound %s: %v", plan.Tag, err)
+			plan.Error = err.Error()
+		}
+		needRestart = needRestart || restart
+	}
+	return report, needRestart, nil
+}
+
+// planInbounds decides what happens to each imported inbound. An imported inbound matches an
+// existing one with the same tag or, failing that, one listening on the same port. Ports, tags
+// and client emails used by other inbounds are conflicts.
+func (s *TransferService) planInbounds(imported []*model.Inbound, mode string) ([]*ImportInboundPlan, error) {
+	existing, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	// Owners of the client emails, by their lowercase email
+	emailOwners := map[string]int{}
+	for _, inbound := range existing {
+		clients, _ := s.inboundService.GetClients(inbound)
+		for _, client := range clients {
+			if client.Email != "" {
+				emailOwners[strings.ToLower(client.Email)] = inbound.Id
+			}
+		}
+	}
+	// Inbounds as they will be after the import, to find conflicts between imported inbounds
+	var planned []*model.Inbound
+	usedTargets := map[int]bool{}
+
+	plans := make([]*ImportInboundPlan, 0, len(imported))
+	for _, inbound := range imported {
+		if inbound.Tag == "" {
+			inbound.Tag = inboundTag(inbound.Listen, inbound.Port)
+		}
+		plan := &ImportInboundPlan{
+			Remark:    inbound.Remark,
+			Tag:       inbound.Tag,
+			Port:      inbound.Port,
+			Protocol:  inbound.Protocol,
+			Conflicts: []string{},
+			inbound:   inbound,
+		}
+		plans = append(plans, plan)
+		conflict := func(format string, args ...any) {
+			plan.Action = ImportActionConflict
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf(format, args...))
+		}
+
+		for _, other := range planned {
+			if other.Tag == inbound.Tag {
+				conflict("tag %s is used by another imported inbound", inbound.Tag)
+			} else if other.Port == inbound.Port && listensOverlap(other.Listen, inbound.Listen) {
+				conflict("port %d is used by another imported inbound", inbound.Port)
+			}
+		}
+		var target *model.Inbound
+		for _, other := range existing {
+			if other.Tag == inbound.Tag {
+				target = other
+				break
+			}
+		}
+		for _, other := range existing {
+			if other == target || other.Port != inbound.Port || !listensOverlap(other.Listen, inbound.Listen) {
+				continue
+			}
+			if target == nil {
+				target = other
+			} else {
+				conflict("port %d is used by inbound %s", inbound.Port, other.Tag)
+			}
+		}
+		if target != nil {
+			plan.TargetId = target.Id
+			if usedTargets[target.Id] {
+				conflict("inbound %s matches another imported inbound", target.Tag)
+			}
+		}
+
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			conflict("clients are not valid: %v", err)
+			continue
+		}
+		var emails []string
+		for _, client := range clients {
+			if missingClientId(inbound.Protocol, client) {
+				conflict("client %s has no ID", client.Email)
+			}
+			email := strings.ToLower(client.Email)
+			if email == "" {
+				continue
+			}
+			if slices.Contains(emails, email) {
+				conflict("email %s is used twice", client.Email)
+			}
+			emails = append(emails, email)
+		}
+
+		switch {
+		case plan.Action == ImportActionConflict:
+		case target == nil:
+			plan.Action = ImportActionAdd
+		case mode == ImportSkip:
+			plan.Action = ImportActionSkip
+		case mode == ImportReplace:
+			plan.Action = ImportActionReplace
+		case target.Protocol != inbound.Protocol:
+			conflict("inbound %s is a %s inbound", target.Tag, target.Protocol)
+		default:
+			plan.Action = ImportActionMerge
+		}
+
+		switch plan.Action {
+		case ImportActionAdd, ImportActionReplace:
+			for _, email := range emails {
+				if owner, ok := emailOwners[email]; ok && (target == nil || owner != target.Id) {
+					conflict("email %s is used by another inbound", email)
+				}
+			}
+		case ImportActionMerge:
+			// Clients of the target are kept, clients of other inbounds are left out
+			for _, email := range emails {
+				owner, ok := emailOwners[email]
+				switch {
+				case !ok:
+					plan.added = append(plan.added, email)
+				case owner == target.Id:
+					plan.ClientsSkipped++
+				default:
+					plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("email %s is used by another inbound", email))
+				}
+			}
+			if len(plan.added) == 0 {
+				plan.Action = ImportActionSkip
+			}
+		}
+
+		switch plan.Action {
+		case ImportActionAdd, ImportActionReplace:
+			plan.added = emails
+			planned = append(planned, inbound)
+		case ImportActionConflict:
+			plan.added = nil
+			continue
+		}
+		if target != nil {
+			usedTargets[target.Id] = true
+			if plan.Action == ImportActionReplace {
+				for email, owner := range emailOwners {
+					if owner == target.Id {
+						delete(emailOwners, email)
+					}
+				}
+			}
+		}
+		plan.Clients = len(plan.added)
+		for _, email := range plan.added {
+			emailOwners[email] = -1
+		}
+	}
+	return plans, nil
+}
+
+// applyInbound carries out the plan of an imported inbound.
+func (s *TransferService) applyInbound(plan *ImportInboundPlan, userId int) (bool, error) {
+	switch plan.Action {
+	case ImportActionAdd, ImportActionReplace:
+		inbound := plan.inbound
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			return false, err
+		}
+		inbound.Id = 0
+		inbound.UserId = userId
+		inbound.ClientStats = importedClientStats(clients, inbound.ClientStats)
+
+		var needRestart bool
+		if plan.Action == ImportActionReplace {
+			inbound, needRestart, err = s.inboundService.ReplaceInbound(plan.TargetId, inbound)
+		} else {
+			inbound, needRestart, err = s.inboundService.AddInbound(inbound)
+		}
+		if err == nil {
+			plan.TargetId = inbound.Id
+		}
+		return needRestart, err
+	case ImportActionMerge:
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(plan.inbound.Settings), &settings); err != nil {
+			return false, err
+		}
+		items, _ := settings["clients"].([]any)
+		clients := make([]any, 0, len(plan.added))
+		for _, item := range items {
+			if client, ok := item.(map[string]any); ok {
+				if email, _ := client["email"].(string); slices.Contains(plan.added, strings.ToLower(email)) {
+					clients = append(clients, client)
+				}
+			}
+		}
+		data, err := json.Marshal(map[string]any{"clients": clients})
+		if err != nil {
+			return false, err
+		}
+		needRestart, err := s.inboundService.AddInboundClient(&model.Inbound{Id: plan.TargetId, Settings: string(data)})
+		if err != nil {
+			return needRestart, err
+		}
+		// The new clients keep their traffic
+		db := database.GetDB()
+		for _, stat := range plan.inbound.ClientStats {
+			if !slices.Contains(plan.added, strings.ToLower(stat.Email)) {
+				continue
+			}
+			err := db.Model(xray.ClientTraffic{}).Where("email = ?", stat.Email).Updates(map[string]any{
+				"up":          stat.Up,
+				"down":        stat.Down,
+				"all_time":    stat.AllTime,
+				"last_online": stat.LastOnline,
+			}).Error
+			if err != nil {
+				return needRestart, err
+			}
+		}
+		return needRestart, nil
+	}
+	return false, nil
+}
+
+// mergeSettings returns the current settings with the imported ones applied, and the keys of
+// the settings that change.
+func (s *TransferService) mergeSettings(imported json.RawMessage) (*entity.AllSetting, []string, error) {
+	var values map[string]any
+	if err := json.Unmarshal(imported, &values); err != nil {
+		return nil, nil, common.NewError("settings are not valid:", err)
+	}
+	for _, key := range slices.Concat(hostSettings, securitySettings) {
+		delete(values, key)
+	}
+	allSetting, err := s.settingService.GetAllSetting()
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err := settingsMap(allSetting)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, allSetting); err != nil {
+		return nil, nil, common.NewError("settings are not valid:", err)
+	}
+	if err := allSetting.CheckValid(); err != nil {
+		return nil, nil, err
+	}
+	after, err := settingsMap(allSetting)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := []string{}
+	for key, value := range after {
+		if fmt.Sprint(before[key]) != fmt.Sprint(value) {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return allSetting, changed, nil
+}
+
+// importedClientStats returns the traffic of the clients of an imported inbound. Clients without
+// traffic start from zero, and traffic of clients that aren't in the inbound is dropped.
+func importedClientStats(clients []model.Client, stats []xray.ClientTraffic) []xray.ClientTraffic {
+	byEmail := map[string]xray.ClientTraffic{}
+	for _, stat := range stats {
+		byEmail[strings.ToLower(stat.Email)] = stat
+	}
+	result := make([]xray.ClientTraffic, 0, len(clients))
+	for _, client := range clients {
+		if client.Email == "" {
+			continue
+		}
+		stat, ok := byEmail[strings.ToLower(client.Email)]
+		if !ok {
+			stat = xray.ClientTraffic{
+				Email:      client.Email,
+				Enable:     client.Enable,
+				Total:      client.TotalGB,
+				ExpiryTime: client.ExpiryTime,
+				Reset:      client.Reset,
+			}
+		}
+		stat.Id = 0
+		stat.InboundId = 0
+		result = append(result, stat)
+	}
+	return result
+}
+
+// settingsMap returns the settings by their keys.
+func settingsMap(allSetting *entity.AllSetting) (map[string]any, error) {
+	data, err := json.Marshal(allSetting)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]any{}
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// missingClientId reports whether a client lacks the ID its protocol identifies it by.
+func missingClientId(protocol model.Protocol, client model.Client) bool {
+	switch protocol {
+	case model.Trojan, model.Hysteria2:
+		return client.Password == ""
+	case model.Shadowsocks:
+		return client.Email == ""
+	default:
+		return client.ID == ""
+	}
+}
+
+// inboundTag returns the tag the panel gives an inbound listening on an address and port.
+func inboundTag(listen string, port int) string {
+	if isAnyAddress(listen) {
+		return fmt.Sprintf("inbound-%v", port)
+	}
+	return fmt.Sprintf("inbound-%v:%v", listen, port)
+}
+
+// listensOverlap reports whether two inbounds listening on the same port would clash.
+func listensOverlap(a, b string) bool {
+	return isAnyAddress(a) || isAnyAddress(b) || a == b
+}
+
+func isAnyAddress(listen string) bool {
+	return listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "::0"
+}
+
+// sameJson reports whether two JSON documents hold the same data.
+func sameJson(a, b string) bool {
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, []byte(a)) != nil || json.Compact(&compactB, []byte(b)) != nil {
+		return a == b
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"crypto/rand"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Places vouchers are redeemed from.
+const (
+	VoucherSourcePanel = "panel"
+	VoucherSourceTgbot = "tgbot"
+	VoucherSourceSub   = "sub"
+)
+
+const (
+	// maxVoucherBatch caps the number of vouchers generated at once.
+	maxVoucherBatch = 1000
+	// voucherCharset leaves out characters that are easily confused, like 0/O and 1/I.
+	voucherCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var voucherPrefixRegex = regexp.MustCompile(`^[A-Z0-9]{0,8}$`)
+
+// VoucherBatch describes a batch of vouchers to generate.
+type VoucherBatch struct {
+	Batch     string `json:"batch" form:"batch"`
+	Prefix    string `json:"prefix" form:"prefix"`
+	Count     int    `json:"count" form:"count"`
+	Days      int    `json:"days" form:"days"`
+	Traffic   int64  `json:"traffic" form:"traffic"`
+	MaxUses   int    `json:"maxUses" form:"maxUses"`
+	ExpiresAt int64  `json:"expiresAt" form:"expiresAt"`
+}
+
+// VoucherService generates redeem codes and applies them to clients as renewals and top-ups.
+type VoucherService struct {
+	inboundService InboundService
+}
+
+// GetVouchers returns all vouchers, latest first.
+func (s *VoucherService) GetVouchers() ([]*model.Voucher, error) {
+	db := database.GetDB()
+	var vouchers []*model.Voucher
+	if err := db.Model(model.Voucher{}).Order("id desc").Find(&vouchers).Error; err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// GenerateBatch creates a batch of vouchers with random codes.
+func (s *VoucherService) GenerateBatch(batch *VoucherBatch) ([]*model.Voucher, error) {
+	batch.Batch = strings.TrimSpace(batch.Batch)
+	batch.Prefix = strings.ToUpper(strings.TrimSpace(batch.Prefix))
+	if !voucherPrefixRegex.MatchString(batch.Prefix) {
+		return nil, common.NewError("voucher prefix must be up to 8 letters or digits")
+	}
+	if batch.Count <= 0 || batch.Count > maxVoucherBatch {
+		return nil, common.NewErrorf("voucher count must be between 1 and %d", maxVoucherBatch)
+	}
+	if batch.Days < 0 || batch.Traffic < 0 || batch.MaxUses < 0 {
+		return nil, common.NewError("voucher days, traffic and uses must not be negative")
+	}
+	if batch.Days == 0 && batch.Traffic == 0 {
+		return nil, common.NewError("voucher must grant days or traffic")
+	}
+	if batch.Batch == "" {
+		batch.Batch = time.Now().Format("2006-01-02 15:04")
+	}
+
+	now := time.Now().UnixMilli()
+	vouchers := make([]*model.Voucher, 0, batch.Count)
+	for range batch.Count {
+		code, err := newVoucherCode(batch.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, &model.Voucher{
+			Code:      code,
+			Batch:     batch.Batch,
+			Enable:    true,
+			Days:      batch.Days,
+			Traffic:   batch.Traffic,
+			MaxUses:   batch.MaxUses,
+			ExpiresAt: batch.ExpiresAt,
+			CreatedAt: now,
+		})
+	}
+	db := database.GetDB()
+	if err := db.CreateInBatches(vouchers, 100).Error; err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// SetEnable enables or disables a voucher.
+func (s *VoucherService) SetEnable(id int, enable bool) error {
+	db := database.GetDB()
+	return db.Model(model.Voucher{}).Where("id = ?", id).Update("enable", enable).Error
+}
+
+// DelVoucher deletes a voucher. Its redemptions stay in the log.
+func (s *VoucherService) DelVoucher(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.Voucher{}, id).Error
+}
+
+// DelBatch deletes all vouchers of a batch.
+func (s *VoucherService) DelBatch(batch string) error {
+	db := database.GetDB()
+	return db.Where("batch = ?", batch).Delete(model.Voucher{}).Error
+}
+
+// GetRedemptions returns the redemption log, latest first.
+func (s *VoucherService) GetRedemptions() ([]*model.VoucherRedemption, error) {
+	db := database.GetDB()
+	var redemptions []*model.VoucherRedemption
+	if err := db.Model(model.VoucherRedemption{}).Order("id desc").Find(&redemptions).Error; err != nil {
+		return nil, err
+	}
+	return redemptions, nil
+}
+
+// Redeem applies a voucher to a client. Days extend the expiry from now or from the current
+// expiry, whichever is later, and traffic is added to the limit. Clients without an expiry or
+// traffic limit keep it. It returns the logged redemption and whether Xray needs a restart.
+func (s *VoucherService) Redeem(code string, email string, source string) (*model.VoucherRedemption, bool, error) {
+	db := database.GetDB()
+	voucher := &model.Voucher{}
+	if err := db.Where("code = ?", NormalizeVoucherCode(code)).First(voucher).Error; err != nil {
+		return nil, false, common.NewError("voucher not found")
+	}
+	now := time.Now().UnixMilli()
+	switch {
+	case !voucher.Enable:
+		return nil, false, common.NewError("voucher is disabled")
+	case voucher.ExpiresAt > 0 && voucher.ExpiresAt <= now:
+		return nil, false, common.NewError("voucher has expired")
+	case voucher.MaxUses > 0 && voucher.Uses >= voucher.MaxUses:
+		return nil, false, common.NewError("voucher has been used up")
+	}
+
+	redemption := &model.VoucherRedemption{
+		VoucherId:  voucher.Id,
+		Code:       voucher.Code,
+		Email:      email,
+		Source:     source,
+		RedeemedAt: now,
+	}
+	logged := false
+	needRestart, err := s.inboundService.ChangeClientLimitsByEmail(email, func(client *model.Client) (int64, int64, error) {
+		if voucher.Days > 0 {
+			duration := int64(voucher.Days) * 24 * int64(time.Hour/time.Millisecond)
+			switch {
+			case client.ExpiryTime > 0:
+				redemption.ExpiryAdded = max(client.ExpiryTime, now) + duration - client.ExpiryTime
+			case client.ExpiryTime < 0:
+				// Negative expiry times count from the first connection
+				redemption.ExpiryAdded = -duration
+			}
+		}
+		if client.TotalGB > 0 {
+			redemption.TrafficAdded = voucher.Traffic
+		}
+		if redemption.ExpiryAdded == 0 && redemption.TrafficAdded == 0 {
+			return 0, 0, common.NewError("client has no expiry or traffic limit the voucher could extend")
+		}
+		// Log the redemption before the client changes, so every extension can be revoked
+		if err := db.Transaction(func(tx *gorm.DB) error { return logRedemption(tx, redemption) }); err != nil {
+			return 0, 0, err
+		}
+		logged = true
+		return client.ExpiryTime + redemption.ExpiryAdded, client.TotalGB + redemption.TrafficAdded, nil
+	})
+	if err != nil {
+		if logged {
+			err1 := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Delete(redemption).Error; err != nil {
+					return err
+				}
+				return tx.Model(model.Voucher{}).Where("id = ? AND uses > 0", redemption.VoucherId).
+					Update("uses", gorm.Expr("uses - 1")).Error
+			})
+			if err1 != nil {
+				logger.Warning("Unable to drop the redemption of a client that wasn't extended:", err1)
+			}
+		}
+		return nil, false, err
+	}
+	return redemption, needRestart, nil
+}
+
+// logRedemption takes a use of the voucher and logs the redemption, unless the client already
+// has an active redemption of the voucher. The unique index of redemptions stops concurrent ones.
+func logRedemption(tx *gorm.DB, redemption *model.VoucherRedemption) error {
+	var redeemed int64
+	err := tx.Model(model.VoucherRedemption{}).
+		Where("voucher_id = ? AND email = ? AND revoked = ?", redemption.VoucherId, redemption.Email, false).
+		Count(&redeemed).Error
+	if err != nil {
+		return err
+	}
+	if redeemed > 0 {
+		return common.NewError("voucher already redeemed for", redemption.Email)
+	}
+	result := tx.Model(model.Voucher{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", redemption.VoucherId).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("voucher has been used up")
+	}
+	return tx.Create(redemption).Error
+}
+
+// Revoke takes back the expiry and traffic a redemption added to its client and frees the voucher use.
+func (s *VoucherService) Revoke(id int) (bool, error) {
+	db := database.GetDB()
+	redemption := &model.VoucherRedemption{}
+	if err := db.First(redemption, id).Error; err != nil {
+		return false, err
+	}
+	if redemption.Revoked {
+		return false, common.NewError("redemption already revoked")
+	}
+
+	// A client that no longer exists has nothing to take back
+	if _, _, err := s.inboundService.GetClientByEmail(redemption.Email); err != nil ||
+		(redemption.ExpiryAdded == 0 && redemption.TrafficAdded == 0) {
+		return false, setRedemptionRevoked(db, redemption, true)
+	}
+
+	revoked := false
+	needRestart, err := s.inboundService.ChangeClientLimitsByEmail(redemption.Email, func(client *model.Client) (int64, int64, error) {
+		expiryTime := client.ExpiryTime
+		if redemption.ExpiryAdded != 0 && expiryTime != 0 {
+			expiryTime -= redemption.ExpiryAdded
+			// Never turn a limited client into an unlimited one
+			if client.ExpiryTime > 0 && expiryTime <= 0 {
+				expiryTime = time.Now().UnixMilli()
+			} else if client.ExpiryTime < 0 && expiryTime >= 0 {
+				expiryTime = -1
+			}
+		}
+		totalGB := client.TotalGB
+		if redemption.TrafficAdded != 0 && totalGB > 0 {
+			totalGB = max(totalGB-redemption.TrafficAdded, 1)
+		}
+		if err := setRedemptionRevoked(db, redemption, true); err != nil {
+			return 0, 0, err
+		}
+		revoked = true
+		return expiryTime, totalGB, nil
+	})
+	if err != nil && revoked {
+		if err1 := setRedemptionRevoked(db, redemption, false); err1 != nil {
+			logger.Warning("Unable to restore the redemption of a client that wasn't changed:", err1)
+		}
+	}
+	return needRestart, err
+}
+
+// setRedemptionRevoked revokes a redemption and frees its voucher use, or undoes that.
+func setRedemptionRevoked(db *gorm.DB, redemption *model.VoucherRedemption, revoked bool) error {
+	revokedAt, usesChange := time.Now().UnixMilli(), "uses - 1"
+	if !revoked {
+		revokedAt, usesChange = 0, "uses + 1"
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(redemption).Where("revoked = ?", !revoked).Updates(map[string]any{
+			"revoked":    revoked,
+			"revoked_at": revokedAt,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if revoked {
+				return common.NewError("redemption already revoked")
+			}
+			return nil
+		}
+		query := tx.Model(model.Voucher{}).Where("id = ?", redemption.VoucherId)
+		if revoked {
+			query = query.Where("uses > 0")
+		}
+		return query.Update("uses", gorm.Expr(usesChange)).Error
+	})
+}
+
+// NormalizeVoucherCode makes codes typed by users comparable: upper case without spaces.
+func NormalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// newVoucherCode returns a random code like PREFIX-ABCD-EFGH-JKLM-NPQR.
+func newVoucherCode(prefix string) (string, error) {
+	groups := make([]string, 0, 5)
+	if prefix != "" {
+		groups = append(groups, prefix)
+	}
+	for range 4 {
+		group := make([]byte, 4)
+		for i := range group {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(voucherCharset))))
+			if err != nil {
+				return "", err
+			}
+			group[i] = voucherCharset[n.Int64()]
+		}
+		groups = append(groups, string(group))
+	}
+	return strings.Join(groups, "-"), nil
+}
@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 
+	"github.com/mhsanaei/3x-ui/v2/core"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/singbox"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"go.uber.org/atomic"
@@ -14,6 +19,7 @@ import (
 
 var (
 	p                 *xray.Process
+	sb                *singbox.Process // Serves the inbounds of protocols Xray lacks
 	lock              sync.Mutex
 	isNeedXrayRestart atomic.Bool // Indicates that restart was requested for Xray
 	isManuallyStopped atomic.Bool // Indicates that Xray was stopped manually from the panel
@@ -106,8 +112,20 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if _, err = generateRouting(xrayConfig, s.inboundService.buildClientExitRules(inbounds)); err != nil {
+		return nil, err
+	}
+
+	// Clients removed for exceeding their IP limit stay out until the ban is lifted
+	ipBanService := IpBanService{}
+	removedClients, err1 := ipBanService.GetRemovedClients()
+	if err1 != nil {
+		logger.Warning("Unable to get banned clients:", err1)
+	}
+
 	for _, inbound := range inbounds {
-		if !inbound.Enable {
+		if !inbound.Enable || core.ForProtocol(inbound.Protocol) != core.Xray {
 			continue
 		}
 		// get settings clients
@@ -140,6 +158,10 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 						continue
 					}
 				}
+				if email, _ := c["email"].(string); removedClients[email] {
+					logger.Infof("Remove Inbound User %s due to IP limit", email)
+					continue
+				}
 				for key := range c {
 					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
 						delete(c, key)
@@ -191,21 +213,218 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 	return xrayConfig, nil
 }
 
-// GetXrayTraffic fetches the current traffic statistics from the running Xray process.
+// generateRouting completes the outbounds and routing of a config built from the template with
+// the outbounds of the subscriptions, the client exit rules and the reroutes of the outbounds
+// that used up their quota. It returns the client exit rules that made it into the config.
+func generateRouting(xrayConfig *xray.Config, exitRules []map[string]any) ([]map[string]any, error) {
+	// Outbounds of the subscriptions join the template before anything routes to them
+	outboundSubscriptionService := OutboundSubscriptionService{}
+	if err := outboundSubscriptionService.ApplySubscriptions(xrayConfig); err != nil {
+		logger.Warning("Unable to apply outbound subscriptions:", err)
+	}
+
+	// Route clients with a dedicated exit before the template rules apply
+	exitRules = filterClientExitRules(xrayConfig, exitRules)
+	if len(exitRules) > 0 {
+		routerConfig, err := insertClientExitRules(xrayConfig.RouterConfig, exitRules)
+		if err != nil {
+			return nil, err
+		}
+		xrayConfig.RouterConfig = routerConfig
+	}
+
+	// Reroute the rules of outbounds that used up their quota
+	outboundService := OutboundService{}
+	if err := outboundService.ApplyQuotas(xrayConfig); err != nil {
+		logger.Warning("Unable to apply outbound quotas:", err)
+	}
+	return exitRules, nil
+}
+
+// filterClientExitRules drops client exit rules pointing to outbounds or balancers
+// that are not defined in the Xray config, so a stale assignment can't break startup.
+func filterClientExitRules(xrayConfig *xray.Config, rules []map[string]any) []map[string]any {
+	if len(rules) == 0 {
+		return rules
+	}
+
+	outboundTags := map[string]bool{}
+	var outbounds []map[string]any
+	json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds)
+	for _, outbound := range outbounds {
+		if tag, ok := outbound["tag"].(string); ok {
+			outboundTags[tag] = true
+		}
+	}
+
+	balancerTags := map[string]bool{}
+	var routing struct {
+		Balancers []map[string]any `json:"balancers"`
+	}
+	json.Unmarshal(xrayConfig.RouterConfig, &routing)
+	for _, balancer := range routing.Balancers {
+		if tag, ok := balancer["tag"].(string); ok {
+			balancerTags[tag] = true
+		}
+	}
+
+	filtered := make([]map[string]any, 0, len(rules))
+	for _, rule := range rules {
+		if tag, ok := rule["outboundTag"].(string); ok && !outboundTags[tag] {
+			logger.Warningf("Client exit outbound %s not found, skipping rule for %v", tag, rule["user"])
+			continue
+		}
+		if tag, ok := rule["balancerTag"].(string); ok && !balancerTags[tag] {
+			logger.Warningf("Client exit balancer %s not found, skipping rule for %v", tag, rule["user"])
+			continue
+		}
+		filtered = append(filtered, rule)
+	}
+	return filtered
+}
+
+// insertClientExitRules places the client exit rules right after the leading API rules of the routing config.
+func insertClientExitRules(routerConfig []byte, exitRules []map[string]any) ([]byte, error) {
+	routing := map[string]any{}
+	if len(routerConfig) > 0 {
+		if err := json.Unmarshal(routerConfig, &routing); err != nil {
+			return nil, err
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+
+	pos := 0
+	for pos < len(rules) {
+		rule, ok := rules[pos].(map[string]any)
+		if !ok || rule["outboundTag"] != "api" {
+			break
+		}
+		pos++
+	}
+
+	newRules := make([]any, 0, len(rules)+len(exitRules))
+	newRules = append(newRules, rules[:pos]...)
+	for _, rule := range exitRules {
+		newRules = append(newRules, rule)
+	}
+	newRules = append(newRules, rules[pos:]...)
+	routing["rules"] = newRules
+
+	return json.MarshalIndent(routing, "", "  ")
+}
+
+// partialRuleFields are the routing rule fields that limit a rule to part of the traffic.
+var partialRuleFields = []string{"domain", "domains", "ip", "port", "network", "protocol", "attrs", "source", "sourcePort", "localPort"}
+
+// catchesClientTraffic reports whether the routing config has a rule besides the API and client
+// exit rules that would catch all traffic of the given clients, so a client exit rule appended
+// through the API would never match. Rules that only take part of the traffic, like blocking
+// private IPs, leave the rest to the exit rule.
+func catchesClientTraffic(routerConfig []byte, emails map[string]bool, inboundTags map[string]bool) bool {
+	var routing struct {
+		Rules []map[string]any `json:"rules"`
+	}
+	if len(routerConfig) > 0 {
+		json.Unmarshal(routerConfig, &routing)
+	}
+	for _, rule := range routing.Rules {
+		if rule["outboundTag"] == "api" {
+			continue
+		}
+		ruleTag, _ := rule["ruleTag"].(string)
+		if strings.HasPrefix(ruleTag, clientOutboundRulePrefix) || strings.HasPrefix(ruleTag, clientBalancerRulePrefix) {
+			continue
+		}
+		if slices.ContainsFunc(partialRuleFields, func(field string) bool { return rule[field] != nil }) {
+			continue
+		}
+		if ruleFieldMatches(rule["user"], emails) && ruleFieldMatches(rule["inboundTag"], inboundTags) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleFieldMatches reports whether a list field of a routing rule is unset or has one of the values.
+func ruleFieldMatches(field any, values map[string]bool) bool {
+	list, ok := field.([]any)
+	if !ok {
+		return true
+	}
+	return slices.ContainsFunc(list, func(item any) bool {
+		value, _ := item.(string)
+		return values[value]
+	})
+}
+
+// routingRuleTags returns the rule tags of the routing config.
+func routingRuleTags(routerConfig []byte) map[string]bool {
+	var routing struct {
+		Rules []struct {
+			RuleTag string `json:"ruleTag"`
+		} `json:"rules"`
+	}
+	if len(routerConfig) > 0 {
+		json.Unmarshal(routerConfig, &routing)
+	}
+	tags := map[string]bool{}
+	for _, rule := range routing.Rules {
+		if rule.RuleTag != "" {
+			tags[rule.RuleTag] = true
+		}
+	}
+	return tags
+}
+
+// GetCores returns the running proxy cores.
+func (s *XrayService) GetCores() []core.Core {
+	cores := []core.Core{}
+	if s.IsXrayRunning() {
+		cores = append(cores, p)
+	}
+	if sb != nil && sb.IsRunning() {
+		cores = append(cores, sb)
+	}
+	return cores
+}
+
+// coreForProtocol returns the running core that serves inbounds of the given protocol, or nil
+// if that core is not running.
+func coreForProtocol(protocol model.Protocol) core.Core {
+	if core.ForProtocol(protocol) == core.SingBox {
+		if sb != nil && sb.IsRunning() {
+			return sb
+		}
+		return nil
+	}
+	if p != nil && p.IsRunning() {
+		return p
+	}
+	return nil
+}
+
+// GetXrayTraffic fetches the current traffic statistics from the running Xray process
+// and the other running cores.
 func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
 	if !s.IsXrayRunning() {
 		err := errors.New("xray is not running")
 		logger.Debug("Attempted to fetch Xray traffic, but Xray is not running:", err)
 		return nil, nil, err
 	}
-	apiPort := p.GetAPIPort()
-	s.xrayAPI.Init(apiPort)
-	defer s.xrayAPI.Close()
 
-	traffic, clientTraffic, err := s.xrayAPI.GetTraffic(true)
-	if err != nil {
-		logger.Debug("Failed to fetch Xray traffic:", err)
-		return nil, nil, err
+	var traffic []*xray.Traffic
+	var clientTraffic []*xray.ClientTraffic
+	for _, c := range s.GetCores() {
+		coreTraffic, coreClientTraffic, err := c.GetTraffic(true)
+		if err != nil {
+			logger.Debugf("Failed to fetch %s traffic: %v", c.Name(), err)
+			if c.Name() == core.Xray {
+				return nil, nil, err
+			}
+			continue
+		}
+		traffic = append(traffic, coreTraffic...)
+		clientTraffic = append(clientTraffic, coreClientTraffic...)
 	}
 	return traffic, clientTraffic, nil
 }
@@ -222,6 +441,10 @@ func (s *XrayService) RestartXray(isForce bool) error {
 		return err
 	}
 
+	if err := s.syncSingBox(isForce); err != nil {
+		logger.Warning("restart sing-box failed:", err)
+	}
+
 	if s.IsXrayRunning() {
 		if !isForce && p.GetConfig().Equals(xrayConfig) && !isNeedXrayRestart.Load() {
 			logger.Debug("It does not need to restart Xray")
@@ -246,12 +469,53 @@ func (s *XrayService) StopXray() error {
 	defer lock.Unlock()
 	isManuallyStopped.Store(true)
 	logger.Debug("Attempting to stop Xray...")
+	if sb != nil && sb.IsRunning() {
+		sb.Stop()
+	}
 	if s.IsXrayRunning() {
 		return p.Stop()
 	}
 	return errors.New("xray is not running")
 }
 
+// SyncSingBox starts, restarts or stops sing-box so that it serves the current inbounds.
+// sing-box can't update users at runtime, so client changes are applied this way.
+func (s *XrayService) SyncSingBox() error {
+	lock.Lock()
+	defer lock.Unlock()
+	if isManuallyStopped.Load() {
+		return nil
+	}
+	return s.syncSingBox(false)
+}
+
+func (s *XrayService) syncSingBox(isForce bool) error {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+	singboxConfig := singbox.GenConfig(inbounds)
+	running := sb != nil && sb.IsRunning()
+	if len(singboxConfig.Inbounds) == 0 {
+		if running {
+			logger.Debug("No inbounds left for sing-box, stopping it")
+			return sb.Stop()
+		}
+		return nil
+	}
+	if running {
+		if !isForce && sb.GetConfig().Equals(singboxConfig) {
+			return nil
+		}
+		if err := sb.StopAndWait(); err != nil {
+			logger.Debug("Unable to stop sing-box:", err)
+		}
+	}
+
+	sb = singbox.NewProcess(singboxConfig)
+	return sb.Start()
+}
+
 // SetToNeedRestart marks that Xray needs to be restarted.
 func (s *XrayService) SetToNeedRestart() {
 	isNeedXrayRestart.Store(true)
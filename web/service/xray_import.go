@@ -0,0 +1,912 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"github.com/google/uuid"
+)
+
+// Where the inbounds of an Xray import come from.
+const (
+	XrayImportConfig = "config" // An Xray config file or a list of its inbounds
+	XrayImportLinks  = "links"  // Share links, one per line or as a base64 subscription
+)
+
+// XrayImportReport describes the inbounds an import of an Xray config or share links creates,
+// or would create in a dry run.
+type XrayImportReport struct {
+	DryRun   bool                 `json:"dryRun"`
+	Source   string               `json:"source"`
+	Inbounds []*XrayImportInbound `json:"inbounds"`
+	Skipped  []string             `json:"skipped"` // Entries that don't map to an inbound at all
+}
+
+// XrayImportInbound is an inbound of an Xray import.
+type XrayImportInbound struct {
+	Remark    string         `json:"remark"`
+	Tag       string         `json:"tag"`
+	Listen    string         `json:"listen"`
+	Port      int            `json:"port"`
+	Protocol  model.Protocol `json:"protocol"`
+	Clients   int            `json:"clients"`
+	Id        int            `json:"id"`        // The created inbound
+	Conflicts []string       `json:"conflicts"` // Why the inbound can't be created
+	Unmapped  []string       `json:"unmapped"`  // What was left out or changed on the way
+	Error     string         `json:"error"`
+
+	inbound  *model.Inbound
+	settings map[string]any
+	stream   map[string]any
+}
+
+func (i *XrayImportInbound) note(format string, args ...any) {
+	i.Unmapped = append(i.Unmapped, fmt.Sprintf(format, args...))
+}
+
+// xrayProtocols maps the Xray inbound protocols to those of the panel.
+var xrayProtocols = map[string]model.Protocol{
+	"vmess":         model.VMESS,
+	"vless":         model.VLESS,
+	"trojan":        model.Trojan,
+	"shadowsocks":   model.Shadowsocks,
+	"tunnel":        model.Tunnel,
+	"dokodemo-door": model.Tunnel,
+	"mixed":         model.Mixed,
+	"socks":         model.Mixed,
+	"http":          model.HTTP,
+	"wireguard":     model.WireGuard,
+}
+
+// xrayInboundKeys are the keys of an Xray inbound the panel keeps.
+var xrayInboundKeys = []string{"tag", "listen", "port", "protocol", "settings", "streamSettings", "sniffing"}
+
+// clientKeys are the keys of a client the panel keeps, besides its own fields.
+var clientKeys = []string{"id", "password", "flow", "email", "security", "method", "comment"}
+
+// ImportXray creates inbounds from an Xray config or from share links, or only reports what it
+// would create in a dry run. Inbounds whose port or tag is taken are not created. It also returns
+// whether Xray needs a restart.
+func (s *TransferService) ImportXray(data string, userId int, dryRun bool) (*XrayImportReport, bool, error) {
+	data = strings.TrimSpace(data)
+	if data == "" {
+		return nil, false, common.NewError("nothing to import")
+	}
+	report := &XrayImportReport{DryRun: dryRun, Inbounds: []*XrayImportInbound{}, Skipped: []string{}}
+	var err error
+	if strings.HasPrefix(data, "{") || strings.HasPrefix(data, "[") {
+		report.Source = XrayImportConfig
+		err = s.parseXrayConfig(data, report)
+	} else {
+		report.Source = XrayImportLinks
+		err = s.parseShareLinks(data, report)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.planXrayImport(report); err != nil {
+		return nil, false, err
+	}
+	if dryRun {
+		return report, false, nil
+	}
+
+	needRestart := false
+	for _, item := range report.Inbounds {
+		if len(item.Conflicts) > 0 || item.Error != "" {
+			continue
+		}
+		item.inbound.UserId = userId
+		inbound, restart, err := s.inboundService.AddInbound(item.inbound)
+		if err != nil {
+			logger.Warningf("Unable to import inbound %s: %v", item.Tag, err)
+			item.Error = err.Error()
+			continue
+		}
+		item.Id = inbound.Id
+		needRestart = needRestart || restart
+	}
+	return report, needRestart, nil
+}
+
+// parseXrayConfig reads the inbounds of an Xray config, of a list of inbounds or of one inbound.
+func (s *TransferService) parseXrayConfig(data string, report *XrayImportReport) error {
+	var parsed any
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return common.NewError("config is not valid JSON:", err)
+	}
+	var items []any
+	switch parsed := parsed.(type) {
+	case []any:
+		items = parsed
+	case map[string]any:
+		if inbounds, ok := parsed["inbounds"].([]any); ok {
+			items = inbounds
+		} else if _, ok := parsed["protocol"]; ok {
+			items = []any{parsed}
+		}
+	}
+	if len(items) == 0 {
+		return common.NewError("config has no inbounds")
+	}
+
+	for i, item := range items {
+		raw, ok := item.(map[string]any)
+		if !ok {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("inbound %d is not an object", i+1))
+			continue
+		}
+		tag, _ := raw["tag"].(string)
+		name := tag
+		if name == "" {
+			name = fmt.Sprintf("inbound %d", i+1)
+		}
+		if tag == "api" {
+			report.Skipped = append(report.Skipped, "api: the panel adds its own API inbound")
+			continue
+		}
+		protocolName, _ := raw["protocol"].(string)
+		protocol, ok := xrayProtocols[protocolName]
+		if !ok {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: protocol %q is not supported", name, protocolName))
+			continue
+		}
+		port, ok := xrayPort(raw["port"])
+		if !ok {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: port %v is not a single port", name, raw["port"]))
+			continue
+		}
+		listen, _ := raw["listen"].(string)
+
+		imported := &XrayImportInbound{
+			Remark:    tag,
+			Tag:       tag,
+			Listen:    listen,
+			Port:      port,
+			Protocol:  protocol,
+			Conflicts: []string{},
+			Unmapped:  []string{},
+		}
+		if imported.Tag == "" {
+			imported.Tag = inboundTag(listen, port)
+		}
+		if protocolName != string(protocol) {
+			imported.note("protocol %s is now called %s", protocolName, protocol)
+		}
+		for _, key := range slices.Sorted(maps.Keys(raw)) {
+			if !slices.Contains(xrayInboundKeys, key) {
+				imported.note("%s is not supported", key)
+			}
+		}
+		imported.settings, _ = raw["settings"].(map[string]any)
+		if imported.settings == nil {
+			imported.settings = map[string]any{}
+		}
+		imported.stream, _ = raw["streamSettings"].(map[string]any)
+		s.normalizeImportSettings(imported)
+		if streamProtocol(protocol) {
+			normalizeImportStream(imported)
+		} else if imported.stream != nil {
+			imported.note("stream settings are not used by %s", protocol)
+			imported.stream = nil
+		}
+		sniffing, _ := raw["sniffing"].(map[string]any)
+		imported.inbound = &model.Inbound{
+			Remark:       imported.Remark,
+			Enable:       true,
+			Listen:       listen,
+			Port:         port,
+			Protocol:     protocol,
+			Tag:          imported.Tag,
+			TrafficReset: "never",
+			Sniffing:     importSniffing(sniffing),
+		}
+		report.Inbounds = append(report.Inbounds, imported)
+	}
+	return nil
+}
+
+// parseShareLinks reads share links, one per line or as a base64 subscription. Links to the same
+// port become the clients of one inbound.
+func (s *TransferService) parseShareLinks(data string, report *XrayImportReport) error {
+	if !strings.Contains(data, "://") {
+		decoded, err := decodeBase64(strings.Join(strings.Fields(data), ""))
+		if err != nil || !strings.Contains(string(decoded), "://") {
+			return common.NewError("data is neither an Xray config nor share links")
+		}
+		data = string(decoded)
+	}
+
+	byPort := map[int]*XrayImportInbound{}
+	firstStreams := map[int]string{} // Streams of the first links, before defaults are filled in
+	for line := range strings.SplitSeq(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		link, err := parseShareLink(line)
+		if err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", shortLink(line), err))
+			continue
+		}
+
+		imported := byPort[link.port]
+		if imported == nil {
+			imported = &XrayImportInbound{
+				Remark:    link.remark,
+				Tag:       inboundTag("", link.port),
+				Port:      link.port,
+				Protocol:  link.protocol,
+				Conflicts: []string{},
+				Unmapped:  []string{},
+				settings:  link.settings,
+				stream:    link.stream,
+			}
+			firstStreams[link.port] = streamJson(link.stream)
+			imported.Unmapped = append(imported.Unmapped, link.notes...)
+			s.normalizeImportSettings(imported)
+			normalizeImportStream(imported)
+			imported.inbound = &model.Inbound{
+				Remark:       imported.Remark,
+				Enable:       !link.needsCert,
+				Port:         link.port,
+				Protocol:     link.protocol,
+				Tag:          imported.Tag,
+				TrafficReset: "never",
+				Sniffing:     importSniffing(nil),
+			}
+			if link.needsCert {
+				imported.note("TLS needs a certificate, so the inbound is disabled until one is set")
+			}
+			byPort[link.port] = imported
+			report.Inbounds = append(report.Inbounds, imported)
+			continue
+		}
+
+		// More links to the same port add their clients, if they describe the same inbound
+		if imported.Protocol != link.protocol {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: port %d is a %s inbound", shortLink(line), link.port, imported.Protocol))
+			continue
+		}
+		if link.protocol == model.Shadowsocks && link.settings["method"] != imported.settings["method"] {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: port %d uses method %v", shortLink(line), link.port, imported.settings["method"]))
+			continue
+		}
+		if streamJson(link.stream) != firstStreams[link.port] {
+			imported.note("%s uses other transport settings than the first link to port %d", link.remark, link.port)
+		}
+		clients, _ := imported.settings["clients"].([]any)
+		for _, client := range link.settings["clients"].([]any) {
+			normalizeImportClient(imported, client.(map[string]any))
+			clients = append(clients, client)
+		}
+		imported.settings["clients"] = clients
+	}
+	if len(report.Inbounds) == 0 && len(report.Skipped) == 0 {
+		return common.NewError("no share links found")
+	}
+	return nil
+}
+
+// planXrayImport finds the imported inbounds that can't be created, renames client emails that
+// are taken and turns the settings into JSON.
+func (s *TransferService) planXrayImport(report *XrayImportReport) error {
+	var emails []string
+	for _, item := range report.Inbounds {
+		for _, client := range importClients(item.settings) {
+			if email, _ := client["email"].(string); email != "" {
+				emails = append(emails, email)
+			}
+		}
+	}
+	used, err := s.inboundService.findUsedEmails(emails)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, email := range used {
+		taken[strings.ToLower(email)] = true
+	}
+
+	db := database.GetDB()
+	var planned []*XrayImportInbound
+	for _, item := range report.Inbounds {
+		exist, err := s.inboundService.checkPortExist(item.Listen, item.Port, 0)
+		if err != nil {
+			return err
+		}
+		if exist {
+			item.Conflicts = append(item.Conflicts, fmt.Sprintf("port %d is used by an existing inbound", item.Port))
+		}
+		var count int64
+		if err := db.Model(&model.Inbound{}).Where("tag = ?", item.Tag).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			item.Conflicts = append(item.Conflicts, fmt.Sprintf("tag %s is used by an existing inbound", item.Tag))
+		}
+		for _, other := range planned {
+			if other.Tag == item.Tag {
+				item.Conflicts = append(item.Conflicts, fmt.Sprintf("tag %s is used by another imported inbound", item.Tag))
+			} else if other.Port == item.Port && listensOverlap(other.Listen, item.Listen) {
+				item.Conflicts = append(item.Conflicts, fmt.Sprintf("port %d is used by another imported inbound", item.Port))
+			}
+		}
+		if len(item.Conflicts) == 0 && item.Error == "" {
+			planned = append(planned, item)
+		}
+
+		clients := importClients(item.settings)
+		for _, client := range clients {
+			email, _ := client["email"].(string)
+			if !taken[strings.ToLower(email)] {
+				taken[strings.ToLower(email)] = true
+				continue
+			}
+			renamed := email + "-" + strings.ToLower(random.Seq(4))
+			for taken[strings.ToLower(renamed)] {
+				renamed = email + "-" + strings.ToLower(random.Seq(4))
+			}
+			taken[strings.ToLower(renamed)] = true
+			client["email"] = renamed
+			item.note("email %s is taken, the client is imported as %s", email, renamed)
+		}
+		item.Clients = len(clients)
+
+		settings, err := json.MarshalIndent(item.settings, "", "  ")
+		if err != nil {
+			return err
+		}
+		item.inbound.Settings = string(settings)
+		if item.stream != nil {
+			stream, err := json.MarshalIndent(item.stream, "", "  ")
+			if err != nil {
+				return err
+			}
+			item.inbound.StreamSettings = string(stream)
+		}
+	}
+	return nil
+}
+
+// normalizeImportSettings fills in what the panel expects in the settings of an inbound and
+// gives the clients the fields of panel clients.
+func (s *TransferService) normalizeImportSettings(item *XrayImportInbound) {
+	settings := item.settings
+	switch item.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan:
+		clients, _ := settings["clients"].([]any)
+		if clients == nil {
+			clients = []any{}
+		}
+		kept := clients[:0]
+		for _, client := range clients {
+			if client, ok := client.(map[string]any); ok {
+				normalizeImportClient(item, client)
+				kept = append(kept, client)
+			}
+		}
+		settings["clients"] = kept
+		if item.Protocol == model.VLESS {
+			if _, ok := settings["decryption"].(string); !ok {
+				settings["decryption"] = "none"
+			}
+		}
+		if item.Protocol != model.VMESS {
+			if _, ok := settings["fallbacks"].([]any); !ok {
+				settings["fallbacks"] = []any{}
+			}
+		}
+	case model.Shadowsocks:
+		method, _ := settings["method"].(string)
+		password, _ := settings["password"].(string)
+		clients, _ := settings["clients"].([]any)
+		if len(clients) == 0 && password != "" {
+			// The panel only runs Shadowsocks with clients of their own
+			client := map[string]any{"method": "", "password": password}
+			if strings.HasPrefix(method, "2022") {
+				client["password"] = shadowsocksPassword(method)
+				item.note("the single user of the Shadowsocks 2022 inbound became a client with a new key")
+			} else {
+				password = ""
+			}
+			if email, ok := settings["email"].(string); ok {
+				client["email"] = email
+			}
+			clients = []any{client}
+		}
+		if password == "" && strings.HasPrefix(method, "2022") {
+			password = shadowsocksPassword(method)
+		}
+		kept := make([]any, 0, len(clients))
+		for _, client := range clients {
+			if client, ok := client.(map[string]any); ok {
+				normalizeImportClient(item, client)
+				kept = append(kept, client)
+			}
+		}
+		settings["clients"] = kept
+		settings["password"] = password
+		if _, ok := settings["network"].(string); !ok {
+			settings["network"] = "tcp,udp"
+		}
+		delete(settings, "email")
+		delete(settings, "level")
+	case model.WireGuard:
+		peers, _ := settings["peers"].([]any)
+		for i, peer := range peers {
+			if peer, ok := peer.(map[string]any); ok && peer["privateKey"] == nil {
+				item.note("peer %d has no private key, so its client config can't be shown", i+1)
+			}
+		}
+	}
+}
+
+// normalizeImportClient gives an imported client the fields of panel clients, with a new email
+// or ID where it has none.
+func normalizeImportClient(item *XrayImportInbound, client map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(client)) {
+		switch {
+		case key == "level":
+			item.note("client levels are not supported")
+		case key == "alterId":
+			if id, _ := client[key].(float64); id != 0 {
+				item.note("alterId %v is not supported, clients use VMessAEAD", id)
+			}
+		case slices.Contains(clientKeys, key):
+			continue
+		default:
+			item.note("client field %s is not supported", key)
+		}
+		delete(client, key)
+	}
+	email, _ := client["email"].(string)
+	if email == "" {
+		client["email"] = strings.ToLower(random.Seq(8))
+	}
+	switch item.Protocol {
+	case model.VMESS:
+		if id, _ := client["id"].(string); id == "" {
+			client["id"] = uuid.NewString()
+			item.note("client %s had no ID and got a new one", client["email"])
+		}
+		if security, _ := client["security"].(string); security == "" {
+			client["security"] = "auto"
+		}
+	case model.VLESS:
+		if id, _ := client["id"].(string); id == "" {
+			client["id"] = uuid.NewString()
+			item.note("client %s had no ID and got a new one", client["email"])
+		}
+		if _, ok := client["flow"].(string); !ok {
+			client["flow"] = ""
+		}
+	case model.Trojan:
+		if password, _ := client["password"].(string); password == "" {
+			client["password"] = random.Seq(10)
+			item.note("client %s had no password and got a new one", client["email"])
+		}
+	case model.Shadowsocks:
+		if _, ok := client["method"].(string); !ok {
+			client["method"] = ""
+		}
+		if password, _ := client["password"].(string); password == "" {
+			method, _ := item.settings["method"].(string)
+			client["password"] = shadowsocksPassword(method)
+			item.note("client %s had no password and got a new one", client["email"])
+		}
+	}
+	if _, ok := client["comment"]; !ok {
+		client["comment"] = ""
+	}
+	client["limitIp"] = 0
+	client["totalGB"] = 0
+	client["expiryTime"] = 0
+	client["enable"] = true
+	client["tgId"] = 0
+	client["subId"] = random.Seq(16)
+	client["reset"] = 0
+}
+
+// normalizeImportStream fills in the transport and security settings the panel expects, and
+// derives the public key of REALITY inbounds.
+func normalizeImportStream(item *XrayImportInbound) {
+	stream := item.stream
+	if stream == nil {
+		stream = map[string]any{}
+		item.stream = stream
+	}
+	network, _ := stream["network"].(string)
+	switch network {
+	case "", "raw":
+		network = "tcp"
+	case "splithttp":
+		network = "xhttp"
+	case "tcp", "kcp", "ws", "grpc", "httpupgrade", "xhttp":
+	default:
+		item.note("transport %s is not supported, the inbound uses TCP", network)
+		network = "tcp"
+	}
+	stream["network"] = network
+	for from, to := range map[string]string{"rawSettings": "tcpSettings", "splithttpSettings": "xhttpSettings"} {
+		if value, ok := stream[from]; ok {
+			if _, ok := stream[to]; !ok {
+				stream[to] = value
+			}
+			delete(stream, from)
+		}
+	}
+
+	transport := subMap(stream, network+"Settings")
+	switch network {
+	case "tcp":
+		header := subMap(transport, "header")
+		setDefault(header, "type", "none")
+		if header["type"] == "http" {
+			request := subMap(header, "request")
+			if paths, _ := request["path"].([]any); len(paths) == 0 {
+				request["path"] = []any{"/"}
+			}
+			setDefault(request, "headers", map[string]any{})
+		}
+		setDefault(transport, "acceptProxyProtocol", false)
+	case "kcp":
+		setDefault(subMap(transport, "header"), "type", "none")
+		setDefault(transport, "seed", "")
+	case "ws", "httpupgrade":
+		setDefault(transport, "path", "/")
+		setDefault(transport, "host", "")
+		if network == "ws" {
+			setDefault(transport, "headers", map[string]any{})
+		}
+	case "grpc":
+		setDefault(transport, "serviceName", "")
+		setDefault(transport, "authority", "")
+		setDefault(transport, "multiMode", false)
+	case "xhttp":
+		setDefault(transport, "path", "/")
+		setDefault(transport, "host", "")
+		setDefault(transport, "mode", "auto")
+	}
+
+	security, _ := stream["security"].(string)
+	switch security {
+	case "", "none":
+		stream["security"] = "none"
+	case "tls":
+		tlsSettings := subMap(stream, "tlsSettings")
+		setDefault(tlsSettings, "serverName", "")
+		setDefault(tlsSettings, "certificates", []any{})
+		setDefault(tlsSettings, "alpn", []any{})
+		tlsClient := subMap(tlsSettings, "settings")
+		setDefault(tlsClient, "allowInsecure", false)
+		setDefault(tlsClient, "fingerprint", "chrome")
+	case "reality":
+		normalizeImportReality(item, subMap(stream, "realitySettings"))
+	default:
+		item.note("security %s is not supported, the inbound uses none", security)
+		stream["security"] = "none"
+	}
+}
+
+// normalizeImportReality fills in the REALITY settings of the panel. The public key is derived
+// from the private key, and both are new if there is no valid private key.
+func normalizeImportReality(item *XrayImportInbound, reality map[string]any) {
+	if dest, ok := reality["dest"]; ok {
+		setDefault(reality, "target", dest)
+		delete(reality, "dest")
+	}
+	setDefault(reality, "show", false)
+	setDefault(reality, "xver", 0)
+	setDefault(reality, "target", "")
+	if target, _ := reality["target"].(string); target == "" {
+		item.note("REALITY has no target")
+	}
+	if names, _ := reality["serverNames"].([]any); len(names) == 0 {
+		host, _, err := net.SplitHostPort(fmt.Sprint(reality["target"]))
+		if err != nil || host == "" {
+			host = "www.google.com"
+		}
+		reality["serverNames"] = []any{host}
+		item.note("REALITY has no server names, %s is used", host)
+	}
+	if ids, _ := reality["shortIds"].([]any); len(ids) == 0 {
+		reality["shortIds"] = []any{""}
+	}
+
+	client := subMap(reality, "settings")
+	privateKey, _ := reality["privateKey"].(string)
+	publicKey, err := realityPublicKey(privateKey)
+	if err != nil {
+		privateKey, publicKey, err = newRealityKeys()
+		if err != nil {
+			item.Error = fmt.Sprint("unable to generate REALITY keys: ", err)
+			return
+		}
+		reality["privateKey"] = privateKey
+		item.note("REALITY got new keys, clients need the new public key")
+	}
+	client["publicKey"] = publicKey
+	setDefault(client, "fingerprint", "chrome")
+	setDefault(client, "serverName", "")
+	setDefault(client, "spiderX", "/")
+}
+
+// realityPublicKey derives the public key of a REALITY private key, both in base64 URL encoding.
+func realityPublicKey(privateKey string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return "", err
+	}
+	private, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes()), nil
+}
+
+// newRealityKeys generates a REALITY key pair.
+func newRealityKeys() (string, string, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(private.Bytes()),
+		base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes()), nil
+}
+
+// importSniffing returns the sniffing settings of the panel with the imported ones applied.
+func importSniffing(imported map[string]any) string {
+	sniffing := map[string]any{
+		"enabled":      false,
+		"destOverride": []any{"http", "tls", "quic", "fakedns"},
+		"metadataOnly": false,
+		"routeOnly":    false,
+	}
+	maps.Copy(sniffing, imported)
+	data, _ := json.MarshalIndent(sniffing, "", "  ")
+	return string(data)
+}
+
+// importClients returns the clients in the settings of an imported inbound.
+func importClients(settings map[string]any) []map[string]any {
+	items, _ := settings["clients"].([]any)
+	clients := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if client, ok := item.(map[string]any); ok {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// streamProtocol reports whether inbounds of the protocol have stream settings in the panel.
+func streamProtocol(protocol model.Protocol) bool {
+	switch protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+		return true
+	}
+	return false
+}
+
+// xrayPort returns the port of an Xray inbound, which can be a number or a numeric string.
+// Ranges and environment variables have no single port.
+func xrayPort(value any) (int, bool) {
+	var port int
+	switch value := value.(type) {
+	case float64:
+		port = int(value)
+		if float64(port) != value {
+			return 0, false
+		}
+	case string:
+		var err error
+		if port, err = strconv.Atoi(value); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return port, port > 0 && port <= 65535
+}
+
+// subMap returns the object under a key, adding an empty one if there is none.
+func subMap(parent map[string]any, key string) map[string]any {
+	child, ok := parent[key].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		parent[key] = child
+	}
+	return child
+}
+
+// setDefault sets a key that is missing or null.
+func setDefault(m map[string]any, key string, value any) {
+	if m[key] == nil {
+		m[key] = value
+	}
+}
+
+// streamJson returns stream settings as JSON, to compare them.
+func streamJson(stream map[string]any) string {
+	data, _ := json.Marshal(stream)
+	return string(data)
+}
+
+// shareLink is an inbound described by a share link, with the link's user as its only client.
+type shareLink struct {
+	protocol  model.Protocol
+	port      int
+	remark    string
+	settings  map[string]any
+	stream    map[string]any
+	needsCert bool
+	notes     []string
+}
+
+// parseShareLink reads a vless, vmess, trojan or ss share link.
+func parseShareLink(link string) (*shareLink, error) {
+	parts, err := splitShareLink(link)
+	if err != nil {
+		return nil, err
+	}
+	query := parts.query
+	parsed := &shareLink{port: parts.port, remark: parts.remark}
+	client := map[string]any{"comment": parts.remark}
+	switch parts.scheme {
+	case "vless":
+		parsed.protocol = model.VLESS
+		client["id"] = parts.user
+		client["flow"] = query.Get("flow")
+		if encryption := query.Get("encryption"); encryption != "" && encryption != "none" {
+			parsed.notes = append(parsed.notes, "VLESS encryption needs the server's decryption key, so it is off")
+		}
+		parsed.settings = map[string]any{"clients": []any{client}, "decryption": "none"}
+	case "vmess":
+		parsed.protocol = model.VMESS
+		security := query.Get("scy")
+		if security == "" {
+			security = "auto"
+		}
+		if aid := query.Get("aid"); aid != "" && aid != "0" {
+			parsed.notes = append(parsed.notes, "alterId is not supported, clients use VMessAEAD")
+		}
+		client["id"] = parts.user
+		client["security"] = security
+		parsed.settings = map[string]any{"clients": []any{client}}
+	case "trojan":
+		parsed.protocol = model.Trojan
+		client["password"] = parts.user
+		parsed.settings = map[string]any{"clients": []any{client}}
+	case "ss":
+		return shadowsocksShareLink(parts)
+	default:
+		return nil, fmt.Errorf("%s links are not supported", parts.scheme)
+	}
+	parsed.stream = linkStream(parsed, query.Get("type"), query.Get("security"), linkParams{
+		host:        query.Get("host"),
+		path:        query.Get("path"),
+		headerType:  query.Get("headerType"),
+		serviceName: query.Get("serviceName"),
+		mode:        query.Get("mode"),
+		seed:        query.Get("seed"),
+		sni:         query.Get("sni"),
+		alpn:        query.Get("alpn"),
+		fingerprint: query.Get("fp"),
+		shortId:     query.Get("sid"),
+		spiderX:     query.Get("spx"),
+	})
+	return parsed, nil
+}
+
+// shadowsocksShareLink builds the inbound of an ss link, whose user is the method and password.
+func shadowsocksShareLink(parts *linkParts) (*shareLink, error) {
+	userInfo := strings.SplitN(parts.user, ":", 3)
+	if len(userInfo) < 2 || userInfo[0] == "" || userInfo[1] == "" {
+		return nil, fmt.Errorf("the link has no method and password")
+	}
+	client := map[string]any{"method": "", "password": userInfo[1], "comment": parts.remark}
+	settings := map[string]any{"method": userInfo[0], "network": "tcp,udp"}
+	if len(userInfo) == 3 {
+		// 2022 links carry the server key before the user key
+		settings["password"] = userInfo[1]
+		client["password"] = userInfo[2]
+	}
+	settings["clients"] = []any{client}
+	return &shareLink{
+		protocol: model.Shadowsocks,
+		port:     parts.port,
+		remark:   parts.remark,
+		settings: settings,
+		stream:   map[string]any{"network": "tcp", "security": "none"},
+	}, nil
+}
+
+// linkParams are the transport and security parameters of a share link.
+type linkParams struct {
+	host, path, headerType, serviceName, mode, seed string
+	sni, alpn, fingerprint, shortId, spiderX        string
+}
+
+// linkStream builds the stream settings a share link describes. The server side of TLS and
+// REALITY isn't in a link: TLS needs a certificate and REALITY gets new keys.
+func linkStream(parsed *shareLink, network, security string, params linkParams) map[string]any {
+	stream := map[string]any{"network": network}
+	switch network {
+	case "", "tcp", "raw":
+		tcp := map[string]any{"header": map[string]any{"type": "none"}}
+		if params.headerType == "http" {
+			path := params.path
+			if path == "" {
+				path = "/"
+			}
+			request := map[string]any{"path": []any{path}, "headers": map[string]any{}}
+			if params.host != "" {
+				request["headers"] = map[string]any{"Host": []any{params.host}}
+			}
+			tcp["header"] = map[string]any{"type": "http", "request": request}
+		}
+		stream["tcpSettings"] = tcp
+	case "kcp":
+		headerType := params.headerType
+		if headerType == "" {
+			headerType = "none"
+		}
+		stream["kcpSettings"] = map[string]any{"header": map[string]any{"type": headerType}, "seed": params.seed}
+	case "ws", "httpupgrade":
+		stream[network+"Settings"] = map[string]any{"path": params.path, "host": params.host}
+	case "grpc":
+		stream["grpcSettings"] = map[string]any{"serviceName": params.serviceName, "multiMode": params.mode == "multi"}
+	case "xhttp", "splithttp":
+		stream[network+"Settings"] = map[string]any{"path": params.path, "host": params.host, "mode": params.mode}
+	}
+
+	alpn := []any{}
+	for item := range strings.SplitSeq(params.alpn, ",") {
+		if item != "" {
+			alpn = append(alpn, item)
+		}
+	}
+	switch security {
+	case "tls":
+		stream["security"] = "tls"
+		stream["tlsSettings"] = map[string]any{
+			"serverName":   params.sni,
+			"alpn":         alpn,
+			"certificates": []any{},
+			"settings":     map[string]any{"fingerprint": params.fingerprint},
+		}
+		parsed.needsCert = true
+	case "reality":
+		stream["security"] = "reality"
+		var serverNames []any
+		target := ""
+		if params.sni != "" {
+			serverNames = []any{params.sni}
+			target = net.JoinHostPort(params.sni, "443")
+		}
+		stream["realitySettings"] = map[string]any{
+			"target":      target,
+			"serverNames": serverNames,
+			"shortIds":    []any{params.shortId},
+			"settings":    map[string]any{"fingerprint": params.fingerprint, "spiderX": params.spiderX},
+		}
+	default:
+		stream["security"] = "none"
+	}
+	return stream
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/netutil"
 	"github.com/mhsanaei/3x-ui/v2/web/controller"
 	"github.com/mhsanaei/3x-ui/v2/web/job"
 	"github.com/mhsanaei/3x-ui/v2/web/locale"
@@ -99,9 +100,10 @@ type Server struct {
 	panel *controller.XUIController
 	api   *controller.APIController
 
-	xrayService    service.XrayService
-	settingService service.SettingService
-	tgbotService   service.Tgbot
+	xrayService      service.XrayService
+	settingService   service.SettingService
+	tgbotService     service.Tgbot
+	broadcastService service.BroadcastService
 
 	cron *cron.Cron
 
@@ -187,15 +189,41 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		engine.Use(middleware.DomainValidatorMiddleware(webDomain))
 	}
 
-	secret, err := s.settingService.GetSecret()
+	trustedProxies, err := s.settingService.GetTrustedProxies()
 	if err != nil {
 		return nil, err
 	}
+	trustedNets, err := netutil.ParseCIDRList(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	engine.Use(middleware.ClientIPMiddleware(trustedNets))
 
 	basePath, err := s.settingService.GetBasePath()
 	if err != nil {
 		return nil, err
 	}
+	// Telegram posts the bot updates here when the webhook is served on the panel,
+	// so this route is registered ahead of the IP allowlist
+	engine.POST(basePath+service.TgWebhookPath+":secret", gin.WrapH(s.tgbotService.WebhookHandler(service.TgWebhookPanel)))
+
+	allowedIps, err := s.settingService.GetPanelAllowedIps()
+	if err != nil {
+		return nil, err
+	}
+	allowedNets, err := netutil.ParseCIDRList(allowedIps)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowedNets) > 0 {
+		engine.Use(middleware.IPAllowlistMiddleware(allowedNets))
+	}
+
+	secret, err := s.settingService.GetSecret()
+	if err != nil {
+		return nil, err
+	}
+
 	engine.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{basePath + "panel/api/"})))
 	assetsBasePath := basePath + "assets/"
 
@@ -296,6 +324,8 @@ func (s *Server) startTask() {
 			if err != nil {
 				logger.Error("restart xray failed:", err)
 			}
+		} else if err := s.xrayService.SyncSingBox(); err != nil {
+			logger.Warning("sync sing-box failed:", err)
 		}
 	})
 
@@ -305,6 +335,12 @@ func (s *Server) startTask() {
 		s.cron.AddJob("@every 10s", job.NewXrayTrafficJob())
 	}()
 
+	// Collect traffic from the nodes and push them changed inbounds
+	s.cron.AddJob("@every 10s", job.NewNodeSyncJob())
+
+	// Refresh the outbounds of remote subscriptions that are due
+	s.cron.AddJob("@every 1m", job.NewOutboundSubscriptionJob())
+
 	// check client ips from log file every 10 sec
 	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
 
@@ -330,32 +366,43 @@ func (s *Server) startTask() {
 		s.cron.AddJob(runtime, j)
 	}
 
-	// Make a traffic condition every day, 8:30
-	var entry cron.EntryID
-	isTgbotenabled, err := s.settingService.GetTgbotEnabled()
-	if (err == nil) && (isTgbotenabled) {
-		runtime, err := s.settingService.GetTgbotRuntime()
+	// Send reports to the notification channels, every day by default
+	runtime, err := s.settingService.GetTgbotRuntime()
+	if err != nil || runtime == "" {
+		logger.Errorf("Add NewStatsNotifyJob error[%s], Runtime[%s] invalid, will run default", err, runtime)
+		runtime = "@daily"
+	}
+	logger.Infof("Notification report run at %s", runtime)
+	_, err = s.cron.AddJob(runtime, job.NewStatsNotifyJob())
+	if err != nil {
+		logger.Warning("Add NewStatsNotifyJob error", err)
+		return
+	}
+
+	// Evaluate alert rules
+	s.cron.AddJob("@every 10s", job.NewAlertJob())
+
+	// Warn clients before they expire or use up their traffic
+	s.cron.AddJob("@every 5m", job.NewClientWarningJob())
+
+	// Write scheduled backups of the database
+	if backupEnabled, _ := s.settingService.GetBackupEnable(); backupEnabled {
+		runtime, err := s.settingService.GetBackupCron()
 		if err != nil || runtime == "" {
-			logger.Errorf("Add NewStatsNotifyJob error[%s], Runtime[%s] invalid, will run default", err, runtime)
 			runtime = "@daily"
 		}
-		logger.Infof("Tg notify enabled,run at %s", runtime)
-		_, err = s.cron.AddJob(runtime, job.NewStatsNotifyJob())
-		if err != nil {
-			logger.Warning("Add NewStatsNotifyJob error", err)
-			return
+		if _, err := s.cron.AddJob(runtime, job.NewBackupJob()); err != nil {
+			logger.Warning("Add NewBackupJob error", err)
 		}
+	}
 
+	// Send the broadcasts queued for the Telegram bot
+	s.broadcastService.StartSender()
+
+	isTgbotenabled, err := s.settingService.GetTgbotEnabled()
+	if (err == nil) && (isTgbotenabled) {
 		// check for Telegram bot callback query hash storage reset
 		s.cron.AddJob("@every 2m", job.NewCheckHashStorageJob())
-
-		// Check CPU load and alarm to TgBot if threshold passes
-		cpuThreshold, err := s.settingService.GetTgCpu()
-		if (err == nil) && (cpuThreshold > 0) {
-			s.cron.AddJob("@every 10s", job.NewCheckCpuJob())
-		}
-	} else {
-		s.cron.Remove(entry)
 	}
 }
 
@@ -0,0 +1,36 @@
+package xray
+
+// Name returns the name of the proxy core.
+func (p *Process) Name() string {
+	return "xray"
+}
+
+// AddUser adds a user to an inbound of the running Xray process through its API.
+func (p *Process) AddUser(protocol string, inboundTag string, user map[string]any) error {
+	var api XrayAPI
+	if err := api.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer api.Close()
+	return api.AddUser(protocol, inboundTag, user)
+}
+
+// RemoveUser removes a user from an inbound of the running Xray process through its API.
+func (p *Process) RemoveUser(inboundTag string, email string) error {
+	var api XrayAPI
+	if err := api.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer api.Close()
+	return api.RemoveUser(inboundTag, email)
+}
+
+// GetTraffic queries the traffic statistics of the running Xray process.
+func (p *Process) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
+	var api XrayAPI
+	if err := api.Init(p.GetAPIPort()); err != nil {
+		return nil, nil, err
+	}
+	defer api.Close()
+	return api.GetTraffic(reset)
+}